@@ -0,0 +1,315 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btc
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Params are the address encodings of a bitcoin network.
+type Params struct {
+	Name             string
+	Bech32HRP        string
+	PubKeyHashAddrID byte
+	PrivateKeyID     byte
+}
+
+var (
+	MainNetParams = Params{Name: "mainnet", Bech32HRP: "bc", PubKeyHashAddrID: 0x00, PrivateKeyID: 0x80}
+	TestNetParams = Params{Name: "testnet3", Bech32HRP: "tb", PubKeyHashAddrID: 0x6f, PrivateKeyID: 0xef}
+	RegTestParams = Params{Name: "regtest", Bech32HRP: "bcrt", PubKeyHashAddrID: 0x6f, PrivateKeyID: 0xef}
+)
+
+// NetParams looks up the network by its bitcoind name.
+func NetParams(name string) (*Params, error) {
+	switch name {
+	case "mainnet", "main", "":
+		return &MainNetParams, nil
+	case "testnet3", "testnet", "test":
+		return &TestNetParams, nil
+	case "regtest":
+		return &RegTestParams, nil
+	default:
+		return nil, errors.Errorf("unknown bitcoin network: %v", name)
+	}
+}
+
+// Hash160 is RIPEMD160(SHA256(b)).
+func Hash160(b []byte) [20]byte {
+	sha := sha256.Sum256(b)
+	h := ripemd160.New()
+	h.Write(sha[:]) //nolint:errcheck
+
+	var pkh [20]byte
+	copy(pkh[:], h.Sum(nil))
+	return pkh
+}
+
+// PubKeyHash is the hash160 of the compressed public key of key.
+func PubKeyHash(key *ecdsa.PrivateKey) [20]byte {
+	return Hash160(crypto.CompressPubkey(&key.PublicKey))
+}
+
+// WitnessPubKeyHashAddress encodes pkh as a bech32 P2WPKH address.
+func (p *Params) WitnessPubKeyHashAddress(pkh [20]byte) string {
+	addr, _ := encodeSegWitAddress(p.Bech32HRP, 0, pkh[:])
+	return addr
+}
+
+// WitnessScriptHashAddress encodes the P2WSH address of script.
+func (p *Params) WitnessScriptHashAddress(script []byte) string {
+	hash := sha256.Sum256(script)
+	addr, _ := encodeSegWitAddress(p.Bech32HRP, 0, hash[:])
+	return addr
+}
+
+// DecodePubKeyHash returns the public key hash of a P2WPKH or P2PKH address.
+func (p *Params) DecodePubKeyHash(address string) ([20]byte, error) {
+	var pkh [20]byte
+
+	if strings.HasPrefix(strings.ToLower(address), p.Bech32HRP+"1") {
+		version, program, err := decodeSegWitAddress(p.Bech32HRP, address)
+		if err != nil {
+			return pkh, errors.Wrapf(err, "decode address %v", address)
+		}
+		if version != 0 || len(program) != 20 {
+			return pkh, errors.Errorf("address %v is not P2WPKH", address)
+		}
+		copy(pkh[:], program)
+		return pkh, nil
+	}
+
+	payload, version, err := base58CheckDecode(address)
+	if err != nil {
+		return pkh, errors.Wrapf(err, "decode address %v", address)
+	}
+	if version != p.PubKeyHashAddrID || len(payload) != 20 {
+		return pkh, errors.Errorf("address %v is not P2PKH on %v", address, p.Name)
+	}
+	copy(pkh[:], payload)
+
+	return pkh, nil
+}
+
+// DecodeWIF parses a wallet import format private key such as the output of
+// bitcoind's dumpprivkey.
+func (p *Params) DecodeWIF(wif string) (*ecdsa.PrivateKey, error) {
+	payload, version, err := base58CheckDecode(wif)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode WIF")
+	}
+
+	if version != p.PrivateKeyID {
+		return nil, errors.Errorf("WIF is not a %v key", p.Name)
+	}
+
+	switch {
+	case len(payload) == 33 && payload[32] == 0x01:
+		payload = payload[:32]
+	case len(payload) != 32:
+		return nil, errors.New("malformed WIF")
+	}
+
+	return crypto.ToECDSA(payload)
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Gen = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range hrp {
+		out = append(out, byte(c>>5))
+	}
+	out = append(out, 0)
+	for _, c := range hrp {
+		out = append(out, byte(c&31))
+	}
+	return out
+}
+
+// Bech32Encode encodes the 5-bit groups data with hrp and a bech32 checksum.
+func Bech32Encode(hrp string, data []byte) string {
+	values := append(bech32HRPExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range data {
+		sb.WriteByte(bech32Charset[d])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// Bech32Decode checks the bech32 checksum of s and returns its hrp and 5-bit
+// data groups. Unlike segwit addresses it does not limit the length, so it
+// also accepts BOLT11 invoices.
+func Bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+
+	hrp := s[:pos]
+	data := make([]byte, 0, len(s)-pos-1)
+	for _, c := range s[pos+1:] {
+		d := strings.IndexRune(bech32Charset, c)
+		if d < 0 {
+			return "", nil, errors.Errorf("invalid character %q", c)
+		}
+		data = append(data, byte(d))
+	}
+
+	if bech32Polymod(append(bech32HRPExpand(hrp), data...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+
+	return hrp, data[:len(data)-6], nil
+}
+
+// ConvertBits regroups a byte slice from fromBits to toBits wide integers.
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var (
+		acc  uint32
+		bits uint
+		out  []byte
+		max  = uint32(1)<<toBits - 1
+	)
+
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&max))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&max))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&max != 0 {
+		return nil, errors.New("invalid padding")
+	}
+
+	return out, nil
+}
+
+func encodeSegWitAddress(hrp string, version byte, program []byte) (string, error) {
+	data, err := ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return Bech32Encode(hrp, append([]byte{version}, data...)), nil
+}
+
+func decodeSegWitAddress(hrp, address string) (byte, []byte, error) {
+	if len(address) > 90 {
+		return 0, nil, errors.New("address too long")
+	}
+
+	gotHRP, data, err := Bech32Decode(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if gotHRP != hrp {
+		return 0, nil, errors.Errorf("unexpected hrp %v", gotHRP)
+	}
+	if len(data) < 1 || data[0] > 16 {
+		return 0, nil, errors.New("invalid witness version")
+	}
+
+	program, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return 0, nil, errors.New("invalid witness program length")
+	}
+
+	return data[0], program, nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58CheckDecode(s string) ([]byte, byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		d := strings.IndexRune(base58Alphabet, c)
+		if d < 0 {
+			return nil, 0, errors.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+
+	decoded := n.Bytes()
+	for _, c := range s {
+		if c != '1' {
+			break
+		}
+		decoded = append([]byte{0}, decoded...)
+	}
+
+	if len(decoded) < 5 {
+		return nil, 0, errors.New("base58 string too short")
+	}
+
+	body, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	first := sha256.Sum256(body)
+	second := sha256.Sum256(first[:])
+	for i := range checksum {
+		if checksum[i] != second[i] {
+			return nil, 0, errors.New("invalid base58 checksum")
+		}
+	}
+
+	return body[1:], body[0], nil
+}
@@ -0,0 +1,80 @@
+package btc
+
+import (
+	"encoding/hex"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSegWitAddress(t *testing.T) {
+	Convey("BIP173 test vectors", t, func() {
+		vectors := []struct {
+			address  string
+			hrp      string
+			pkScript string
+		}{
+			{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "bc", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+			{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "tb", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		}
+
+		for _, v := range vectors {
+			version, program, err := decodeSegWitAddress(v.hrp, v.address)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 0)
+			So(hex.EncodeToString(program), ShouldEqual, v.pkScript[4:])
+
+			address, err := encodeSegWitAddress(v.hrp, version, program)
+			So(err, ShouldBeNil)
+			So(address, ShouldEqual, toLower(v.address))
+		}
+	})
+
+	Convey("invalid addresses", t, func() {
+		_, _, err := decodeSegWitAddress("bc", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5")
+		So(err, ShouldNotBeNil)
+
+		_, _, err = decodeSegWitAddress("bc", "BC1QW508d6QEJxTDG4y5R3ZArVARY0C5XW7KV8F3T4")
+		So(err, ShouldNotBeNil)
+
+		_, _, err = decodeSegWitAddress("tb", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("P2WSH address of a script", t, func() {
+		script, _ := hex.DecodeString("2103a8e6e8b3b0e0ce2ab0fdbd1fb8eb8a1063c8ab4e4f3b0a82ba7ee6c9ae7e1b9fac")
+		address := TestNetParams.WitnessScriptHashAddress(script)
+
+		_, program, err := decodeSegWitAddress("tb", address)
+		So(err, ShouldBeNil)
+		So(hex.EncodeToString(WitnessScriptHash(script)), ShouldEqual, "0020"+hex.EncodeToString(program))
+	})
+}
+
+func TestDecodeKeys(t *testing.T) {
+	Convey("WIF and P2PKH address of the same key", t, func() {
+		//private key 1
+		key, err := MainNetParams.DecodeWIF("KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn")
+		So(err, ShouldBeNil)
+		So(key.D.Int64(), ShouldEqual, 1)
+
+		pkh, err := MainNetParams.DecodePubKeyHash("1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH")
+		So(err, ShouldBeNil)
+		So(pkh, ShouldResemble, PubKeyHash(key))
+
+		So(MainNetParams.WitnessPubKeyHashAddress(pkh), ShouldEqual, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4")
+
+		_, err = TestNetParams.DecodeWIF("KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func toLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,108 @@
+package btc
+
+import (
+	"context"
+	"crypto/sha256"
+	"flag"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	regtestURL      = flag.String("regtest", "", "bitcoind regtest JSON-RPC url, e.g. http://127.0.0.1:18443")
+	regtestUser     = flag.String("regtestuser", "", "bitcoind regtest rpc user")
+	regtestPassword = flag.String("regtestpassword", "", "bitcoind regtest rpc password")
+)
+
+// go test ./btc -regtest http://127.0.0.1:18443 -regtestuser user -regtestpassword password
+func TestRegtestSwap(t *testing.T) {
+	if *regtestURL == "" {
+		t.Skip("no -regtest node")
+	}
+
+	var (
+		ctx    = context.Background()
+		rpc    = NewRPCClient(*regtestURL, *regtestUser, *regtestPassword)
+		client = &Client{RPC: rpc, Net: &RegTestParams, FeeRate: 2}
+	)
+
+	newKey := func() ([20]byte, string) {
+		var address, wif string
+		TMust(t, rpc.Call(ctx, &address, "getnewaddress", "", "bech32"))
+		TMust(t, rpc.Call(ctx, &wif, "dumpprivkey", address))
+		pkh, err := RegTestParams.DecodePubKeyHash(address)
+		TMust(t, err)
+		return pkh, wif
+	}
+
+	mine := func(n int) {
+		var address string
+		TMust(t, rpc.Call(ctx, &address, "getnewaddress"))
+		TMust(t, rpc.Call(ctx, nil, "generatetoaddress", n, address))
+	}
+
+	mine(101)
+
+	Convey("regtest swap", t, func() {
+		senderPKH, senderWIF := newKey()
+		receiverPKH, receiverWIF := newKey()
+		secret := [32]byte{0xaa}
+
+		Convey("redeem with the secret", func() {
+			htlc := &HTLC{
+				Hashlock:    sha256.Sum256(secret[:]),
+				ReceiverPKH: receiverPKH,
+				SenderPKH:   senderPKH,
+				LockTime:    time.Now().Add(time.Hour).Unix(),
+			}
+
+			contract, err := client.Lock(ctx, htlc, 100000)
+			So(err, ShouldBeNil)
+			mine(1)
+
+			audit, err := client.Audit(ctx, contract)
+			So(err, ShouldBeNil)
+			So(audit, ShouldResemble, htlc)
+
+			key, err := RegTestParams.DecodeWIF(receiverWIF)
+			So(err, ShouldBeNil)
+			redeemTx, err := client.Redeem(ctx, contract, secret, key)
+			So(err, ShouldBeNil)
+			mine(1)
+
+			spend, err := client.FindSpend(ctx, contract)
+			So(err, ShouldBeNil)
+			So(spend.TxHash(), ShouldEqual, redeemTx.TxHash())
+
+			extracted, ok := ExtractSecret(spend, contract)
+			So(ok, ShouldBeTrue)
+			So(extracted, ShouldEqual, secret)
+		})
+
+		Convey("refund after the locktime", func() {
+			htlc := &HTLC{
+				Hashlock:    sha256.Sum256(secret[:]),
+				ReceiverPKH: receiverPKH,
+				SenderPKH:   senderPKH,
+				LockTime:    time.Now().Add(-time.Hour).Unix(),
+			}
+
+			contract, err := client.Lock(ctx, htlc, 100000)
+			So(err, ShouldBeNil)
+			mine(1)
+
+			key, err := RegTestParams.DecodeWIF(senderWIF)
+			So(err, ShouldBeNil)
+			_, err = client.Refund(ctx, contract, key)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TMust(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
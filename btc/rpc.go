@@ -0,0 +1,247 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// RPCClient talks to the JSON-RPC interface of bitcoind or btcd.
+type RPCClient struct {
+	URL      string
+	User     string
+	Password string
+
+	http *http.Client
+	id   uint64
+}
+
+func NewRPCClient(url, user, password string) *RPCClient {
+	return &RPCClient{URL: url, User: user, Password: password, http: new(http.Client)}
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// Call invokes method and decodes the result into result unless it is nil.
+func (c *RPCClient) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	body, err := json.Marshal(&rpcRequest{
+		JSONRPC: "1.0",
+		ID:      atomic.AddUint64(&c.id, 1),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "encode %v request", method)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "new %v request", method)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "call %v", method)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read %v response", method)
+	}
+
+	var response rpcResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return errors.Errorf("call %v: %v %s", method, resp.Status, data)
+	}
+
+	if response.Error != nil {
+		return errors.Wrapf(response.Error, "call %v", method)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return errors.Wrapf(json.Unmarshal(response.Result, result), "decode %v result", method)
+}
+
+// GetBlockCount returns the height of the best chain.
+func (c *RPCClient) GetBlockCount(ctx context.Context) (int64, error) {
+	var height int64
+	err := c.Call(ctx, &height, "getblockcount")
+	return height, err
+}
+
+// GetRawTransaction fetches and decodes a transaction by txid.
+func (c *RPCClient) GetRawTransaction(ctx context.Context, txid string) (*Tx, error) {
+	var raw string
+	if err := c.Call(ctx, &raw, "getrawtransaction", txid, false); err != nil {
+		return nil, err
+	}
+
+	return decodeTxHex(raw)
+}
+
+// GetConfirmations returns the confirmations of txid, 0 while in the mempool.
+func (c *RPCClient) GetConfirmations(ctx context.Context, txid string) (int64, error) {
+	var verbose struct {
+		Confirmations int64 `json:"confirmations"`
+	}
+	err := c.Call(ctx, &verbose, "getrawtransaction", txid, true)
+	return verbose.Confirmations, err
+}
+
+// SendRawTransaction broadcasts tx and returns its txid.
+func (c *RPCClient) SendRawTransaction(ctx context.Context, tx *Tx) (string, error) {
+	var txid string
+	err := c.Call(ctx, &txid, "sendrawtransaction", hex.EncodeToString(tx.Serialize(true)))
+	return txid, err
+}
+
+// SendToAddress funds address from the node wallet and returns the txid.
+func (c *RPCClient) SendToAddress(ctx context.Context, address string, amount int64) (string, error) {
+	var txid string
+	err := c.Call(ctx, &txid, "sendtoaddress", address, json.Number(FormatBTC(amount)))
+	return txid, err
+}
+
+// DumpPrivKey exports the key of a node wallet address.
+func (c *RPCClient) DumpPrivKey(ctx context.Context, address string) (string, error) {
+	var wif string
+	err := c.Call(ctx, &wif, "dumpprivkey", address)
+	return wif, err
+}
+
+// EstimateFeeRate returns the fee rate in satoshi per vbyte to confirm
+// within blocks.
+func (c *RPCClient) EstimateFeeRate(ctx context.Context, blocks int) (int64, error) {
+	var estimate struct {
+		FeeRate float64  `json:"feerate"`
+		Errors  []string `json:"errors"`
+	}
+	if err := c.Call(ctx, &estimate, "estimatesmartfee", blocks); err != nil {
+		return 0, err
+	}
+
+	if estimate.FeeRate <= 0 {
+		return 0, errors.Errorf("estimatesmartfee: %v", estimate.Errors)
+	}
+
+	//BTC/kvB => sat/vB
+	return int64(estimate.FeeRate*1e8/1000 + 0.5), nil
+}
+
+// FormatBTC renders satoshis as a decimal BTC amount.
+func FormatBTC(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, amount/1e8, amount%1e8)
+}
+
+func decodeTxHex(raw string) (*Tx, error) {
+	b, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode tx hex")
+	}
+
+	return DeserializeTx(b)
+}
+
+// GetBlockTransactions returns the transactions of the block at height.
+func (c *RPCClient) GetBlockTransactions(ctx context.Context, height int64) ([]*Tx, error) {
+	var hash string
+	if err := c.Call(ctx, &hash, "getblockhash", height); err != nil {
+		return nil, err
+	}
+
+	var block struct {
+		Tx []struct {
+			Hex string `json:"hex"`
+		} `json:"tx"`
+	}
+	if err := c.Call(ctx, &block, "getblock", hash, 2); err != nil {
+		return nil, err
+	}
+
+	txs := make([]*Tx, 0, len(block.Tx))
+	for _, raw := range block.Tx {
+		tx, err := decodeTxHex(raw.Hex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "block %v", hash)
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+// GetMempoolTransactions returns the unconfirmed transactions of the node.
+func (c *RPCClient) GetMempoolTransactions(ctx context.Context) ([]*Tx, error) {
+	var txids []string
+	if err := c.Call(ctx, &txids, "getrawmempool"); err != nil {
+		return nil, err
+	}
+
+	txs := make([]*Tx, 0, len(txids))
+	for _, txid := range txids {
+		tx, err := c.GetRawTransaction(ctx, txid)
+		if err != nil {
+			//evicted or mined since getrawmempool
+			continue
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	OP_0                   = 0x00
+	OP_PUSHDATA1           = 0x4c
+	OP_PUSHDATA2           = 0x4d
+	OP_1                   = 0x51
+	OP_16                  = 0x60
+	OP_IF                  = 0x63
+	OP_ELSE                = 0x67
+	OP_ENDIF               = 0x68
+	OP_DROP                = 0x75
+	OP_DUP                 = 0x76
+	OP_SIZE                = 0x82
+	OP_EQUALVERIFY         = 0x88
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_CHECKSIG            = 0xac
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+)
+
+// the preimage size enforced by the HTLC, same as the EVM contract
+const secretSize = 32
+
+type scriptBuilder struct {
+	buf bytes.Buffer
+}
+
+func (b *scriptBuilder) op(ops ...byte) *scriptBuilder {
+	b.buf.Write(ops)
+	return b
+}
+
+func (b *scriptBuilder) data(data []byte) *scriptBuilder {
+	switch n := len(data); {
+	case n == 0:
+		b.buf.WriteByte(OP_0)
+	case n == 1 && data[0] >= 1 && data[0] <= 16:
+		b.buf.WriteByte(OP_1 - 1 + data[0])
+	case n < OP_PUSHDATA1:
+		b.buf.WriteByte(byte(n))
+		b.buf.Write(data)
+	case n <= 0xff:
+		b.buf.WriteByte(OP_PUSHDATA1)
+		b.buf.WriteByte(byte(n))
+		b.buf.Write(data)
+	default:
+		b.buf.WriteByte(OP_PUSHDATA2)
+		b.buf.WriteByte(byte(n))
+		b.buf.WriteByte(byte(n >> 8))
+		b.buf.Write(data)
+	}
+	return b
+}
+
+// scriptNum encodes n as a minimally encoded little-endian script number.
+func scriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var result []byte
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// parseScriptNum decodes a little-endian script number of up to 5 bytes,
+// which is what CHECKLOCKTIMEVERIFY accepts.
+func parseScriptNum(b []byte) (int64, error) {
+	if len(b) > 5 {
+		return 0, errors.Errorf("script number overflow: %d bytes", len(b))
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, v := range b {
+		n |= int64(v) << uint(8*i)
+	}
+
+	if b[len(b)-1]&0x80 != 0 {
+		n &= ^(int64(0x80) << uint(8*(len(b)-1)))
+		n = -n
+	}
+
+	return n, nil
+}
+
+// HTLC holds the terms of a BIP199 hashed timelock script.
+type HTLC struct {
+	Hashlock    [32]byte
+	ReceiverPKH [20]byte
+	SenderPKH   [20]byte
+	LockTime    int64
+}
+
+// Script builds the HTLC script:
+//
+//	OP_IF
+//		OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <hashlock> OP_EQUALVERIFY
+//		OP_DUP OP_HASH160 <receiver pkh>
+//	OP_ELSE
+//		<locktime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//		OP_DUP OP_HASH160 <sender pkh>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func (c *HTLC) Script() []byte {
+	b := new(scriptBuilder)
+
+	b.op(OP_IF, OP_SIZE).data([]byte{secretSize}).op(OP_EQUALVERIFY)
+	b.op(OP_SHA256).data(c.Hashlock[:]).op(OP_EQUALVERIFY)
+	b.op(OP_DUP, OP_HASH160).data(c.ReceiverPKH[:])
+	b.op(OP_ELSE)
+	b.data(scriptNum(c.LockTime)).op(OP_CHECKLOCKTIMEVERIFY, OP_DROP)
+	b.op(OP_DUP, OP_HASH160).data(c.SenderPKH[:])
+	b.op(OP_ENDIF)
+	b.op(OP_EQUALVERIFY, OP_CHECKSIG)
+
+	return b.buf.Bytes()
+}
+
+type scriptToken struct {
+	op   byte
+	data []byte
+}
+
+func tokenize(script []byte) ([]scriptToken, error) {
+	var tokens []scriptToken
+
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+
+		var n int
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errors.New("truncated OP_PUSHDATA1")
+			}
+			n = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errors.New("truncated OP_PUSHDATA2")
+			}
+			n = int(script[i]) | int(script[i+1])<<8
+			i += 2
+		default:
+			tokens = append(tokens, scriptToken{op: op})
+			continue
+		}
+
+		if i+n > len(script) {
+			return nil, errors.Errorf("push of %d bytes exceeds script", n)
+		}
+		tokens = append(tokens, scriptToken{op: op, data: script[i : i+n]})
+		i += n
+	}
+
+	return tokens, nil
+}
+
+// ParseHTLC extracts the terms of script, failing unless it is exactly the
+// template built by HTLC.Script.
+func ParseHTLC(script []byte) (*HTLC, error) {
+	tokens, err := tokenize(script)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) != 20 {
+		return nil, errors.Errorf("not an atomic swap script: %d opcodes", len(tokens))
+	}
+
+	isOp := func(i int, op byte) bool { return tokens[i].op == op && tokens[i].data == nil }
+	isPush := func(i int, size int) bool { return tokens[i].data != nil && len(tokens[i].data) == size }
+
+	ok := isOp(0, OP_IF) && isOp(1, OP_SIZE) &&
+		isPush(2, 1) && tokens[2].data[0] == secretSize &&
+		isOp(3, OP_EQUALVERIFY) && isOp(4, OP_SHA256) && isPush(5, 32) && isOp(6, OP_EQUALVERIFY) &&
+		isOp(7, OP_DUP) && isOp(8, OP_HASH160) && isPush(9, 20) &&
+		isOp(10, OP_ELSE) && isOp(12, OP_CHECKLOCKTIMEVERIFY) && isOp(13, OP_DROP) &&
+		isOp(14, OP_DUP) && isOp(15, OP_HASH160) && isPush(16, 20) &&
+		isOp(17, OP_ENDIF) && isOp(18, OP_EQUALVERIFY) && isOp(19, OP_CHECKSIG)
+	if !ok {
+		return nil, errors.New("not an atomic swap script")
+	}
+
+	//the builder pushes the locktimes 0 to 16 as OP_0 to OP_16
+	var lockTime int64
+	switch op := tokens[11].op; {
+	case tokens[11].data != nil:
+		if lockTime, err = parseScriptNum(tokens[11].data); err != nil {
+			return nil, errors.Wrap(err, "parse locktime")
+		}
+	case op == OP_0:
+	case op >= OP_1 && op <= OP_16:
+		lockTime = int64(op - OP_1 + 1)
+	default:
+		return nil, errors.New("not an atomic swap script")
+	}
+
+	//nLockTime is unsigned 32 bits, CHECKLOCKTIMEVERIFY fails on anything else
+	if lockTime < 0 || lockTime > math.MaxUint32 {
+		return nil, errors.Errorf("locktime %d out of range", lockTime)
+	}
+
+	c := &HTLC{LockTime: lockTime}
+	copy(c.Hashlock[:], tokens[5].data)
+	copy(c.ReceiverPKH[:], tokens[9].data)
+	copy(c.SenderPKH[:], tokens[16].data)
+
+	return c, nil
+}
+
+// WitnessScriptHash returns the P2WSH output script paying to script.
+func WitnessScriptHash(script []byte) []byte {
+	hash := sha256.Sum256(script)
+	return new(scriptBuilder).op(OP_0).data(hash[:]).buf.Bytes()
+}
+
+// PubKeyHashScript returns the P2WPKH output script paying to pkh.
+func PubKeyHashScript(pkh [20]byte) []byte {
+	return new(scriptBuilder).op(OP_0).data(pkh[:]).buf.Bytes()
+}
+
+// RedeemWitness is the witness spending the hashlock branch of script.
+func RedeemWitness(sig, pubKey, secret, script []byte) [][]byte {
+	return [][]byte{sig, pubKey, secret, {0x01}, script}
+}
+
+// RefundWitness is the witness spending the timelock branch of script.
+func RefundWitness(sig, pubKey, script []byte) [][]byte {
+	return [][]byte{sig, pubKey, {}, script}
+}
@@ -0,0 +1,78 @@
+package btc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHTLCScript(t *testing.T) {
+	Convey("HTLC script round trip", t, func() {
+		htlc := &HTLC{LockTime: 1577836800}
+		htlc.Hashlock = sha256.Sum256([]byte("secret"))
+		copy(htlc.ReceiverPKH[:], bytes20(0x11))
+		copy(htlc.SenderPKH[:], bytes20(0x22))
+
+		script := htlc.Script()
+		So(hex.EncodeToString(script[:6]), ShouldEqual, "6382012088a8")
+		So(len(script), ShouldEqual, 97)
+
+		parsed, err := ParseHTLC(script)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, htlc)
+
+		Convey("a tampered script is rejected", func() {
+			tampered := append([]byte{}, script...)
+			tampered[len(tampered)-1] = OP_CHECKLOCKTIMEVERIFY
+			_, err := ParseHTLC(tampered)
+			So(err, ShouldNotBeNil)
+
+			_, err = ParseHTLC(script[:50])
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("the locktimes pushed as small integer opcodes round trip", t, func() {
+		for _, lockTime := range []int64{0, 1, 16, 17} {
+			htlc := &HTLC{LockTime: lockTime}
+			parsed, err := ParseHTLC(htlc.Script())
+			So(err, ShouldBeNil)
+			So(parsed.LockTime, ShouldEqual, lockTime)
+		}
+
+		//a locktime nLockTime can not hold is never spendable by the refund
+		_, err := ParseHTLC((&HTLC{LockTime: 1<<32 - 1}).Script())
+		So(err, ShouldBeNil)
+		for _, lockTime := range []int64{-1, -1577836800, 1 << 32} {
+			_, err := ParseHTLC((&HTLC{LockTime: lockTime}).Script())
+			So(err, ShouldNotBeNil)
+		}
+
+		script := (&HTLC{}).Script()
+		So(script[64], ShouldEqual, OP_0)
+		script[64] = OP_IF
+		_, err = ParseHTLC(script)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("script numbers", t, func() {
+		for _, n := range []int64{1, 127, 128, 255, 256, 32767, 32768, 1577836800, 2147483648, -1, -128} {
+			got, err := parseScriptNum(scriptNum(n))
+			So(err, ShouldBeNil)
+			So(got, ShouldEqual, n)
+		}
+
+		So(hex.EncodeToString(scriptNum(128)), ShouldEqual, "8000")
+		So(hex.EncodeToString(scriptNum(-1)), ShouldEqual, "81")
+	})
+}
+
+func bytes20(b byte) []byte {
+	out := make([]byte, 20)
+	for i := range out {
+		out[i] = b
+	}
+	return out
+}
@@ -0,0 +1,289 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+// the dust limit of a P2WPKH output at the default relay fee
+const dustLimit = 294
+
+// Contract is a funded HTLC output together with its witness script, which
+// the counterparty needs because the P2WSH output only commits to its hash.
+type Contract struct {
+	TxID   string
+	Vout   uint32
+	Value  int64
+	Script []byte
+}
+
+// ID encodes the contract as <txid>:<vout>:<script hex>.
+func (c *Contract) ID() string {
+	return fmt.Sprintf("%s:%d:%x", c.TxID, c.Vout, c.Script)
+}
+
+// ParseContractID decodes the output of Contract.ID. Value is unknown until
+// the contract is audited.
+func ParseContractID(id string) (*Contract, error) {
+	parts := strings.Split(id, ":")
+	if len(parts) != 3 {
+		return nil, errors.Errorf("invalid contract id: %v", id)
+	}
+
+	if _, err := NewHashFromStr(parts[0]); err != nil {
+		return nil, err
+	}
+
+	vout, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid contract vout: %v", parts[1])
+	}
+
+	script, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid contract script")
+	}
+
+	return &Contract{TxID: parts[0], Vout: uint32(vout), Script: script}, nil
+}
+
+func (c *Contract) outPoint() OutPoint {
+	hash, _ := NewHashFromStr(c.TxID)
+	return OutPoint{Hash: hash, Index: c.Vout}
+}
+
+// Client creates and spends HTLCs through a node wallet.
+type Client struct {
+	RPC *RPCClient
+	Net *Params
+	// FeeRate in satoshi per vbyte, estimated by the node when zero
+	FeeRate int64
+}
+
+// Lock funds the HTLC with amount satoshis from the node wallet.
+func (c *Client) Lock(ctx context.Context, htlc *HTLC, amount int64) (*Contract, error) {
+	script := htlc.Script()
+	address := c.Net.WitnessScriptHashAddress(script)
+
+	txid, err := c.RPC.SendToAddress(ctx, address, amount)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fund %v", address)
+	}
+
+	tx, err := c.RPC.GetRawTransaction(ctx, txid)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get funding tx %v", txid)
+	}
+
+	pkScript := WitnessScriptHash(script)
+	for i, out := range tx.TxOut {
+		if bytes.Equal(out.PkScript, pkScript) && out.Value == amount {
+			return &Contract{TxID: txid, Vout: uint32(i), Value: amount, Script: script}, nil
+		}
+	}
+
+	return nil, errors.Errorf("funding tx %v does not pay %v", txid, address)
+}
+
+// Audit checks that the contract output exists and pays to the P2WSH of its
+// script, and returns the terms. Value is filled in from the chain.
+func (c *Client) Audit(ctx context.Context, contract *Contract) (*HTLC, error) {
+	htlc, err := ParseHTLC(contract.Script)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.RPC.GetRawTransaction(ctx, contract.TxID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get contract tx %v", contract.TxID)
+	}
+
+	if int(contract.Vout) >= len(tx.TxOut) {
+		return nil, errors.Errorf("contract tx %v has no output %d", contract.TxID, contract.Vout)
+	}
+
+	out := tx.TxOut[contract.Vout]
+	if !bytes.Equal(out.PkScript, WitnessScriptHash(contract.Script)) {
+		return nil, errors.Errorf("output %v:%d does not pay to the contract script", contract.TxID, contract.Vout)
+	}
+
+	contract.Value = out.Value
+
+	return htlc, nil
+}
+
+// Redeem spends the contract to the receiver with the preimage of the hashlock.
+func (c *Client) Redeem(ctx context.Context, contract *Contract, secret [32]byte, key *ecdsa.PrivateKey) (*Tx, error) {
+	htlc, err := c.Audit(ctx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if sha256.Sum256(secret[:]) != htlc.Hashlock {
+		return nil, errors.New("secret does not match the hashlock")
+	}
+
+	if PubKeyHash(key) != htlc.ReceiverPKH {
+		return nil, errors.New("key is not the receiver of the contract")
+	}
+
+	pubKey := crypto.CompressPubkey(&key.PublicKey)
+
+	return c.spend(ctx, contract, key, 0, MaxTxInSequenceNum, func(sig []byte) [][]byte {
+		return RedeemWitness(sig, pubKey, secret[:], contract.Script)
+	})
+}
+
+// Refund spends the contract back to the sender once the locktime passed.
+func (c *Client) Refund(ctx context.Context, contract *Contract, key *ecdsa.PrivateKey) (*Tx, error) {
+	htlc, err := c.Audit(ctx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if PubKeyHash(key) != htlc.SenderPKH {
+		return nil, errors.New("key is not the sender of the contract")
+	}
+
+	pubKey := crypto.CompressPubkey(&key.PublicKey)
+
+	return c.spend(ctx, contract, key, uint32(htlc.LockTime), lockTimeSequenceNum, func(sig []byte) [][]byte {
+		return RefundWitness(sig, pubKey, contract.Script)
+	})
+}
+
+func (c *Client) spend(ctx context.Context, contract *Contract, key *ecdsa.PrivateKey,
+	lockTime uint32, sequence uint32, witness func(sig []byte) [][]byte) (*Tx, error) {
+	feeRate := c.FeeRate
+	if feeRate == 0 {
+		rate, err := c.RPC.EstimateFeeRate(ctx, 6)
+		if err != nil {
+			return nil, errors.Wrap(err, "estimate fee rate")
+		}
+		feeRate = rate
+	}
+
+	tx := &Tx{
+		Version: 2,
+		TxIn: []*TxIn{{
+			PreviousOutPoint: contract.outPoint(),
+			Sequence:         sequence,
+		}},
+		TxOut: []*TxOut{{
+			Value:    contract.Value,
+			PkScript: PubKeyHashScript(PubKeyHash(key)),
+		}},
+		LockTime: lockTime,
+	}
+
+	//size the fee with a maximum length signature
+	tx.TxIn[0].Witness = witness(make([]byte, 73))
+	fee := feeRate * tx.VirtualSize()
+	if contract.Value-fee < dustLimit {
+		return nil, errors.Errorf("contract value %d can not pay the fee %d", contract.Value, fee)
+	}
+	tx.TxOut[0].Value = contract.Value - fee
+
+	sig, err := Sign(tx.WitnessSigHash(0, contract.Script, contract.Value), key)
+	if err != nil {
+		return nil, err
+	}
+	tx.TxIn[0].Witness = witness(sig)
+
+	if _, err := c.RPC.SendRawTransaction(ctx, tx); err != nil {
+		return nil, errors.Wrap(err, "broadcast")
+	}
+
+	return tx, nil
+}
+
+// ExtractSecret returns the preimage revealed by tx if it redeems contract.
+func ExtractSecret(tx *Tx, contract *Contract) ([32]byte, bool) {
+	var secret [32]byte
+	outPoint := contract.outPoint()
+
+	for _, in := range tx.TxIn {
+		if in.PreviousOutPoint != outPoint {
+			continue
+		}
+		if len(in.Witness) == 5 && len(in.Witness[2]) == 32 && bytes.Equal(in.Witness[4], contract.Script) {
+			copy(secret[:], in.Witness[2])
+			return secret, true
+		}
+	}
+
+	return secret, false
+}
+
+// FindSpend looks for the transaction spending contract in the mempool and in
+// the blocks since it was mined.
+func (c *Client) FindSpend(ctx context.Context, contract *Contract) (*Tx, error) {
+	outPoint := contract.outPoint()
+	spends := func(tx *Tx) bool {
+		for _, in := range tx.TxIn {
+			if in.PreviousOutPoint == outPoint {
+				return true
+			}
+		}
+		return false
+	}
+
+	mempool, err := c.RPC.GetMempoolTransactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range mempool {
+		if spends(tx) {
+			return tx, nil
+		}
+	}
+
+	confirmations, err := c.RPC.GetConfirmations(ctx, contract.TxID)
+	if err != nil {
+		return nil, err
+	}
+	if confirmations == 0 {
+		return nil, nil
+	}
+
+	tip, err := c.RPC.GetBlockCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for height := tip - confirmations + 1; height <= tip; height++ {
+		txs, err := c.RPC.GetBlockTransactions(ctx, height)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range txs {
+			if spends(tx) {
+				return tx, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
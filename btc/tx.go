@@ -0,0 +1,396 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+const (
+	SigHashAll = 0x01
+
+	// MaxTxInSequenceNum disables both relative locktime and nLockTime.
+	MaxTxInSequenceNum = 0xffffffff
+	// the sequence that enables nLockTime, required by CHECKLOCKTIMEVERIFY
+	lockTimeSequenceNum = MaxTxInSequenceNum - 1
+)
+
+// Hash is a double sha256 in internal byte order.
+type Hash [32]byte
+
+func doubleSha256(b []byte) Hash {
+	first := sha256.Sum256(b)
+	return sha256.Sum256(first[:])
+}
+
+// String returns the byte-reversed hex used by bitcoind for txids.
+func (h Hash) String() string {
+	for i := 0; i < len(h)/2; i++ {
+		h[i], h[len(h)-1-i] = h[len(h)-1-i], h[i]
+	}
+	return hex.EncodeToString(h[:])
+}
+
+// NewHashFromStr parses a byte-reversed hex txid.
+func NewHashFromStr(s string) (Hash, error) {
+	var h Hash
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, errors.Errorf("invalid txid: %v", s)
+	}
+	for i := range b {
+		h[i] = b[len(b)-1-i]
+	}
+
+	return h, nil
+}
+
+type OutPoint struct {
+	Hash  Hash
+	Index uint32
+}
+
+type TxIn struct {
+	PreviousOutPoint OutPoint
+	SignatureScript  []byte
+	Witness          [][]byte
+	Sequence         uint32
+}
+
+type TxOut struct {
+	Value    int64
+	PkScript []byte
+}
+
+// Tx is a bitcoin transaction in the segwit serialization of BIP144.
+type Tx struct {
+	Version  int32
+	TxIn     []*TxIn
+	TxOut    []*TxOut
+	LockTime uint32
+}
+
+func (tx *Tx) hasWitness() bool {
+	for _, in := range tx.TxIn {
+		if len(in.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Serialize encodes tx, including witnesses when witness is set.
+func (tx *Tx) Serialize(witness bool) []byte {
+	var buf bytes.Buffer
+	witness = witness && tx.hasWitness()
+
+	writeUint32(&buf, uint32(tx.Version))
+	if witness {
+		buf.Write([]byte{0x00, 0x01})
+	}
+
+	writeVarInt(&buf, uint64(len(tx.TxIn)))
+	for _, in := range tx.TxIn {
+		writeOutPoint(&buf, &in.PreviousOutPoint)
+		writeVarBytes(&buf, in.SignatureScript)
+		writeUint32(&buf, in.Sequence)
+	}
+
+	writeVarInt(&buf, uint64(len(tx.TxOut)))
+	for _, out := range tx.TxOut {
+		writeTxOut(&buf, out)
+	}
+
+	if witness {
+		for _, in := range tx.TxIn {
+			writeVarInt(&buf, uint64(len(in.Witness)))
+			for _, item := range in.Witness {
+				writeVarBytes(&buf, item)
+			}
+		}
+	}
+
+	writeUint32(&buf, tx.LockTime)
+
+	return buf.Bytes()
+}
+
+// TxHash is the txid, which never commits to the witnesses.
+func (tx *Tx) TxHash() Hash {
+	return doubleSha256(tx.Serialize(false))
+}
+
+// VirtualSize is the BIP141 size used for fee rates.
+func (tx *Tx) VirtualSize() int64 {
+	base := int64(len(tx.Serialize(false)))
+	total := int64(len(tx.Serialize(true)))
+	return (base*3 + total + 3) / 4
+}
+
+// DeserializeTx decodes a transaction with or without witnesses.
+func DeserializeTx(b []byte) (*Tx, error) {
+	r := bytes.NewReader(b)
+	tx := new(Tx)
+
+	version, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	tx.Version = int32(version)
+
+	count, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	witness := false
+	if count == 0 {
+		flag, err := r.ReadByte()
+		if err != nil || flag != 0x01 {
+			return nil, errors.New("invalid witness flag")
+		}
+		witness = true
+		if count, err = readVarInt(r); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := uint64(0); i < count; i++ {
+		in := new(TxIn)
+		if _, err := io.ReadFull(r, in.PreviousOutPoint.Hash[:]); err != nil {
+			return nil, errors.Wrap(err, "read outpoint")
+		}
+		if in.PreviousOutPoint.Index, err = readUint32(r); err != nil {
+			return nil, err
+		}
+		if in.SignatureScript, err = readVarBytes(r); err != nil {
+			return nil, err
+		}
+		if in.Sequence, err = readUint32(r); err != nil {
+			return nil, err
+		}
+		tx.TxIn = append(tx.TxIn, in)
+	}
+
+	if count, err = readVarInt(r); err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		out := new(TxOut)
+		value, err := readUint64(r)
+		if err != nil {
+			return nil, err
+		}
+		out.Value = int64(value)
+		if out.PkScript, err = readVarBytes(r); err != nil {
+			return nil, err
+		}
+		tx.TxOut = append(tx.TxOut, out)
+	}
+
+	if witness {
+		for _, in := range tx.TxIn {
+			items, err := readVarInt(r)
+			if err != nil {
+				return nil, err
+			}
+			for j := uint64(0); j < items; j++ {
+				item, err := readVarBytes(r)
+				if err != nil {
+					return nil, err
+				}
+				in.Witness = append(in.Witness, item)
+			}
+		}
+	}
+
+	if tx.LockTime, err = readUint32(r); err != nil {
+		return nil, err
+	}
+
+	if r.Len() != 0 {
+		return nil, errors.Errorf("%d trailing bytes after transaction", r.Len())
+	}
+
+	return tx, nil
+}
+
+// WitnessSigHash computes the BIP143 SIGHASH_ALL digest for input idx
+// spending amount from a P2WSH output with the given witness script.
+func (tx *Tx) WitnessSigHash(idx int, script []byte, amount int64) Hash {
+	var prevouts, sequences, outputs bytes.Buffer
+
+	for _, in := range tx.TxIn {
+		writeOutPoint(&prevouts, &in.PreviousOutPoint)
+		writeUint32(&sequences, in.Sequence)
+	}
+	for _, out := range tx.TxOut {
+		writeTxOut(&outputs, out)
+	}
+
+	hashPrevouts := doubleSha256(prevouts.Bytes())
+	hashSequence := doubleSha256(sequences.Bytes())
+	hashOutputs := doubleSha256(outputs.Bytes())
+
+	var buf bytes.Buffer
+	in := tx.TxIn[idx]
+
+	writeUint32(&buf, uint32(tx.Version))
+	buf.Write(hashPrevouts[:])
+	buf.Write(hashSequence[:])
+	writeOutPoint(&buf, &in.PreviousOutPoint)
+	writeVarBytes(&buf, script)
+	writeUint64(&buf, uint64(amount))
+	writeUint32(&buf, in.Sequence)
+	buf.Write(hashOutputs[:])
+	writeUint32(&buf, tx.LockTime)
+	writeUint32(&buf, SigHashAll)
+
+	return doubleSha256(buf.Bytes())
+}
+
+// Sign produces a DER signature with the SIGHASH_ALL flag appended, as
+// expected in a witness.
+func Sign(hash Hash, key *ecdsa.PrivateKey) ([]byte, error) {
+	sig, err := crypto.Sign(hash[:], key)
+	if err != nil {
+		return nil, errors.Wrap(err, "sign")
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:64])
+
+	return append(derSignature(r, s), SigHashAll), nil
+}
+
+func derSignature(r, s *big.Int) []byte {
+	encodeInt := func(n *big.Int) []byte {
+		b := n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0x00}, b...)
+		}
+		return append([]byte{0x02, byte(len(b))}, b...)
+	}
+
+	body := append(encodeInt(r), encodeInt(s)...)
+	return append([]byte{0x30, byte(len(body))}, body...)
+}
+
+func writeUint32(w *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func writeUint64(w *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.Write(b[:])
+}
+
+func writeVarInt(w *bytes.Buffer, v uint64) {
+	switch {
+	case v < 0xfd:
+		w.WriteByte(byte(v))
+	case v <= 0xffff:
+		w.WriteByte(0xfd)
+		w.Write([]byte{byte(v), byte(v >> 8)})
+	case v <= 0xffffffff:
+		w.WriteByte(0xfe)
+		writeUint32(w, uint32(v))
+	default:
+		w.WriteByte(0xff)
+		writeUint64(w, v)
+	}
+}
+
+func writeVarBytes(w *bytes.Buffer, b []byte) {
+	writeVarInt(w, uint64(len(b)))
+	w.Write(b)
+}
+
+func writeOutPoint(w *bytes.Buffer, op *OutPoint) {
+	w.Write(op.Hash[:])
+	writeUint32(w, op.Index)
+}
+
+func writeTxOut(w *bytes.Buffer, out *TxOut) {
+	writeUint64(w, uint64(out.Value))
+	writeVarBytes(w, out.PkScript)
+}
+
+func readUint32(r *bytes.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, errors.Wrap(err, "read uint32")
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func readUint64(r *bytes.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, errors.Wrap(err, "read uint64")
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func readVarInt(r *bytes.Reader) (uint64, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, errors.Wrap(err, "read varint")
+	}
+
+	switch prefix {
+	case 0xfd:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, errors.Wrap(err, "read varint")
+		}
+		return uint64(binary.LittleEndian.Uint16(b[:])), nil
+	case 0xfe:
+		v, err := readUint32(r)
+		return uint64(v), err
+	case 0xff:
+		return readUint64(r)
+	default:
+		return uint64(prefix), nil
+	}
+}
+
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, errors.Errorf("var bytes of %d exceeds remaining %d", n, r.Len())
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errors.Wrap(err, "read var bytes")
+	}
+	return b, nil
+}
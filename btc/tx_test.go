@@ -0,0 +1,133 @@
+package btc
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWitnessSigHash(t *testing.T) {
+	Convey("BIP143 native P2WPKH example", t, func() {
+		raw, _ := hex.DecodeString("0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f" +
+			"0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a" +
+			"0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d59" +
+			"88ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000")
+
+		tx, err := DeserializeTx(raw)
+		So(err, ShouldBeNil)
+		So(tx.Serialize(true), ShouldResemble, raw)
+		So(len(tx.TxIn), ShouldEqual, 2)
+		So(tx.LockTime, ShouldEqual, 17)
+
+		scriptCode, _ := hex.DecodeString("76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac")
+		sigHash := tx.WitnessSigHash(1, scriptCode, 600000000)
+
+		So(hex.EncodeToString(sigHash[:]), ShouldEqual, "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670")
+	})
+
+	Convey("BIP143 native P2WPKH example, signed", t, func() {
+		raw, _ := hex.DecodeString("01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f" +
+			"00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be" +
+			"022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b8" +
+			"04cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206" +
+			"000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a914" +
+			"3bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610" +
+			"b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d4" +
+			"3c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07" +
+			"aeee635711000000")
+
+		tx, err := DeserializeTx(raw)
+		So(err, ShouldBeNil)
+		So(tx.Serialize(true), ShouldResemble, raw)
+		So(tx.TxIn[0].Witness, ShouldBeEmpty)
+		So(tx.TxIn[1].Witness, ShouldHaveLength, 2)
+
+		//the witness of the second input signs the digest of the example
+		scriptCode, _ := hex.DecodeString("76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac")
+		sigHash := tx.WitnessSigHash(1, scriptCode, 600000000)
+
+		sig, pubKey := tx.TxIn[1].Witness[0], tx.TxIn[1].Witness[1]
+		So(sig[len(sig)-1], ShouldEqual, SigHashAll)
+		So(crypto.VerifySignature(pubKey, sigHash[:], derToCompact(sig[:len(sig)-1])), ShouldBeTrue)
+
+		//the txid does not commit to the witnesses
+		stripped := *tx
+		stripped.TxIn = []*TxIn{{PreviousOutPoint: tx.TxIn[0].PreviousOutPoint, SignatureScript: tx.TxIn[0].SignatureScript,
+			Sequence: tx.TxIn[0].Sequence}, {PreviousOutPoint: tx.TxIn[1].PreviousOutPoint, Sequence: tx.TxIn[1].Sequence}}
+		So(stripped.Serialize(true), ShouldResemble, tx.Serialize(false))
+	})
+}
+
+func TestTxHash(t *testing.T) {
+	Convey("the genesis coinbase serializes to its txid", t, func() {
+		raw, _ := hex.DecodeString("01000000010000000000000000000000000000000000000000000000000000000000000000" +
+			"ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c" +
+			"6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffff" +
+			"ffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea" +
+			"1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000")
+
+		tx, err := DeserializeTx(raw)
+		So(err, ShouldBeNil)
+		So(tx.Serialize(false), ShouldResemble, raw)
+		So(tx.Serialize(true), ShouldResemble, raw)
+		So(tx.TxOut[0].Value, ShouldEqual, 5000000000)
+		So(tx.TxHash().String(), ShouldEqual, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+		So(tx.VirtualSize(), ShouldEqual, len(raw))
+	})
+}
+
+// derToCompact converts a DER signature to the 64 bytes of r and s.
+func derToCompact(der []byte) []byte {
+	rLen := int(der[3])
+	r := new(big.Int).SetBytes(der[4 : 4+rLen])
+	s := new(big.Int).SetBytes(der[6+rLen:])
+
+	compact := make([]byte, 64)
+	copy(compact[32-len(r.Bytes()):32], r.Bytes())
+	copy(compact[64-len(s.Bytes()):], s.Bytes())
+	return compact
+}
+
+func TestSpendTx(t *testing.T) {
+	Convey("witness tx serialization round trip and secret extraction", t, func() {
+		key, _ := crypto.GenerateKey()
+		htlc := &HTLC{LockTime: 1577836800, ReceiverPKH: PubKeyHash(key)}
+		contract := &Contract{
+			TxID:   "9f96ade4b41d5433f4eda31e1738ec2b36f6e7d1420d94a6af99801a88f7f7ff",
+			Value:  100000,
+			Script: htlc.Script(),
+		}
+
+		secret := [32]byte{1, 2, 3}
+		tx := &Tx{
+			Version:  2,
+			TxIn:     []*TxIn{{PreviousOutPoint: contract.outPoint(), Sequence: MaxTxInSequenceNum}},
+			TxOut:    []*TxOut{{Value: 90000, PkScript: PubKeyHashScript(htlc.ReceiverPKH)}},
+			LockTime: 0,
+		}
+
+		sig, err := Sign(tx.WitnessSigHash(0, contract.Script, contract.Value), key)
+		So(err, ShouldBeNil)
+		So(sig[0], ShouldEqual, 0x30)
+		So(sig[len(sig)-1], ShouldEqual, SigHashAll)
+
+		tx.TxIn[0].Witness = RedeemWitness(sig, crypto.CompressPubkey(&key.PublicKey), secret[:], contract.Script)
+
+		decoded, err := DeserializeTx(tx.Serialize(true))
+		So(err, ShouldBeNil)
+		So(decoded.TxHash(), ShouldEqual, tx.TxHash())
+		So(tx.VirtualSize(), ShouldBeLessThan, len(tx.Serialize(true)))
+
+		extracted, ok := ExtractSecret(decoded, contract)
+		So(ok, ShouldBeTrue)
+		So(extracted, ShouldEqual, secret)
+
+		id, err := ParseContractID(contract.ID())
+		So(err, ShouldBeNil)
+		So(id.Script, ShouldResemble, contract.Script)
+		So(id.outPoint(), ShouldResemble, contract.outPoint())
+	})
+}
@@ -15,9 +15,11 @@ package main
 
 import (
 	"context"
+	"log"
 
 	"github.com/icodezjb/atomicswap/cmd"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/spf13/cobra"
 )
 
@@ -27,12 +29,36 @@ func init() {
 		"key",
 		"",
 		"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+
+	deployCmd.Flags().StringVar(
+		&variant,
+		"variant",
+		"",
+		"the contract variant to deploy: \"\" for HashedTimelock, \"v2\" for HashedTimelockV2, \"multi\" for HashedTimelockMulti, \"fee\" for HashedTimelockFee, \"erc721\" or \"erc1155\" for the NFT HashedTimelocks")
+
+	deployCmd.Flags().StringVar(
+		&feeRecipient,
+		"fee-recipient",
+		"",
+		"the receiver of the fees of the fee variant")
+
+	deployCmd.Flags().Int64Var(
+		&feeBps,
+		"fee-bps",
+		0,
+		"the fee of the fee variant in basis points of the amount withdrawn, at most 1000")
 }
 
-var privateKey string
+var (
+	privateKey string
+	variant    string
+	//deployCmd, setFeeCmd
+	feeRecipient string
+	feeBps       int64
+)
 
 var deployCmd = &cobra.Command{
-	Use:   "deploy",
+	Use:   "deploy [--variant <v2 | multi | fee | erc721 | erc1155>] [--fee-recipient <address> --fee-bps <bps>]",
 	Short: "deploy the atomicswap contract",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		return h.Config.ParseConfig(h.ConfigPath)
@@ -44,7 +70,20 @@ var deployCmd = &cobra.Command{
 
 		cmd.Must(h.Config.Unlock(privateKey))
 
-		cmd.Must(h.DeployContract(context.Background()))
+		switch variant {
+		case "":
+			cmd.Must(h.DeployContract(context.Background()))
+		case "v2":
+			cmd.Must(h.DeployContractV2(context.Background()))
+		case "multi":
+			cmd.Must(h.DeployMultiContract(context.Background()))
+		case "fee":
+			cmd.Must(h.DeployContractFee(context.Background(), common.HexToAddress(feeRecipient), feeBps))
+		case cmd.AssetERC721, cmd.AssetERC1155:
+			cmd.Must(h.DeployNFTContract(context.Background(), variant))
+		default:
+			log.Fatalf("unknown contract variant: %v", variant)
+		}
 	},
 	SilenceUsage:  true,
 	SilenceErrors: true,
@@ -0,0 +1,113 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	setFeeCmd.Flags().StringVar(
+		&feeRecipient,
+		"recipient",
+		"",
+		"the receiver of the fees")
+
+	setFeeCmd.Flags().Int64Var(
+		&feeBps,
+		"bps",
+		0,
+		"the fee in basis points of the amount withdrawn, at most 1000")
+
+	feesCmd.Flags().BoolVar(
+		&collectFees,
+		"collect",
+		false,
+		"send the accrued fees to the fee recipient")
+
+	for _, c := range []*cobra.Command{setFeeCmd, feesCmd} {
+		c.Flags().StringVar(
+			&privateKey,
+			"key",
+			"",
+			"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+	}
+
+	_ = setFeeCmd.MarkFlagRequired("recipient")
+	_ = setFeeCmd.MarkFlagRequired("bps")
+}
+
+var collectFees bool
+
+var setFeeCmd = &cobra.Command{
+	Use:   "setfee --recipient <address> --bps <basis points> [--key <private key>]",
+	Short: "change the fee of the HashedTimelockFee contract for the contracts created from now on",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		cmd.Must(h.Config.Connect(""))
+
+		cmd.Must(h.Config.ValidateAddress(feeRecipient))
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		txSigned, err := h.SetFee(context.Background(), common.HexToAddress(feeRecipient), feeBps)
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var feesCmd = &cobra.Command{
+	Use:   "fees [--collect [--key <private key>]]",
+	Short: "show the fee of the HashedTimelockFee contract and the fees not collected yet",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		cmd.Must(h.Config.Connect(""))
+
+		info, err := h.Fees(ctx)
+		cmd.Must(err)
+
+		log.Printf("Owner     = %s", info.Owner.String())
+		log.Printf("Recipient = %s", info.Recipient.String())
+		log.Printf("Fee       = %v bps", info.Bps)
+		log.Printf("Accrued   = %v", info.Accrued)
+
+		if !collectFees {
+			return
+		}
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		txSigned, err := h.CollectFees(ctx)
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
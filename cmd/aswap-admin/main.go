@@ -80,7 +80,8 @@ config-example.json
 `)
 
 	rootCmd.Example = "  aswap-admin deploy --config config.json\n" +
-		"  aswap-admin stat -c config-after-deployed.json"
+		"  aswap-admin stat -c config-after-deployed.json\n" +
+		"  aswap-admin deploy --variant fee --fee-recipient 0x... --fee-bps 30"
 }
 
 func main() {
@@ -88,6 +89,8 @@ func main() {
 
 	rootCmd.AddCommand(deployCmd)
 	rootCmd.AddCommand(statCmd)
+	rootCmd.AddCommand(setFeeCmd)
+	rootCmd.AddCommand(feesCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatalln(err)
@@ -0,0 +1,71 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	approveCmd.Flags().StringVar(
+		&privateKey,
+		"key",
+		"",
+		"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+
+	_ = approveCmd.MarkFlagRequired("asset")
+	_ = approveCmd.MarkFlagRequired("token")
+}
+
+var approveCmd = &cobra.Command{
+	Use:   "approve --asset <erc721 | erc1155> --token <token contract> [--token-id <id>] [--key <private key>]",
+	Short: "let the NFT contract of the config lock our token, before initiate or participant",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		cmd.Must(h.Config.Connect(""))
+
+		cmd.Must(useAsset())
+		if h.Asset == nil {
+			cmd.Must(errors.New("ETH needs no approval"))
+		}
+		if h.Asset.Type == cmd.AssetERC721 && h.Asset.TokenID == nil {
+			cmd.Must(errors.New("approve of an ERC721 token needs --token-id"))
+		}
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		approved, err := h.NFTApproved(ctx, h.Asset, common.HexToAddress(h.Config.Account))
+		cmd.Must(err)
+		if approved {
+			log.Println("already approved")
+			return
+		}
+
+		txSigned, err := h.ApproveNFT(ctx, h.Asset)
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+}
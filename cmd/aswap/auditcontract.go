@@ -16,11 +16,13 @@ package main
 import (
 	"context"
 	"log"
+	"math/big"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/icodezjb/atomicswap/cmd"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -35,13 +37,30 @@ func init() {
 		&otherContract,
 		"other",
 		"",
-		"contract address")
+		"contract address on the other chain, or the other chain name if it has no contract address")
+
+	auditContractCmd.Flags().BoolVar(
+		&verifiedAudit,
+		"verified",
+		false,
+		"prove the contract with the storage proofs of the node instead of trusting its answer")
+
+	auditContractCmd.Flags().StringVar(
+		&checkpoint,
+		"checkpoint",
+		"",
+		"the hash of a trusted block within 256 blocks of the head, the contract is proven with --verified at the head descending from it; at the latest block a quorum of the rpc endpoints agree on if not set")
 
 	_ = auditContractCmd.MarkFlagRequired("id")
 }
 
+var (
+	verifiedAudit bool
+	checkpoint    string
+)
+
 var auditContractCmd = &cobra.Command{
-	Use:   "auditcontract --id <contractId> [--other <contract address>]",
+	Use:   "auditcontract --id <contractId> [--other <contract address | chain name>] [--asset <erc721 | erc1155>] [--verified [--checkpoint <block hash>]]",
 	Short: "get the atomicswap pair details with the specified contractId",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		return h.Config.ParseConfig(h.ConfigPath)
@@ -49,27 +68,75 @@ var auditContractCmd = &cobra.Command{
 	Run: func(_ *cobra.Command, args []string) {
 		cmd.Must(h.Config.Connect(otherContract))
 
-		cmd.Must(h.Config.ValidateAddress(h.Config.Account))
-		cmd.Must(h.Config.ValidateAddress(h.Config.Chain.Contract))
+		cmd.Must(useAsset())
+
+		if verifiedAudit {
+			printContractDetails(verifiedContract())
+			return
+		}
 
 		log.Print("Call getContract ...")
 		log.Printf("contract address: %s", h.Config.Chain.Contract)
 
-		contractDetails := new(cmd.ContractDetails)
+		backend, err := h.Backend()
+		cmd.Must(err)
 
-		cmd.Must(h.AuditContract(context.Background(), contractDetails, common.HexToHash(contractId)))
+		contractDetails, err := backend.Audit(context.Background(), contractId)
+		cmd.Must(err)
 
 		printContractDetails(contractDetails)
 	},
 }
 
-func printContractDetails(d *cmd.ContractDetails) {
-	log.Printf("Sender     = %s", d.Sender.String())
-	log.Printf("Receiver   = %s", d.Receiver.String())
-	log.Printf("Amount     = %s (wei)", d.Amount)
+// verifiedContract proves the contract from the storage of a trusted block.
+func verifiedContract() *cmd.SwapContract {
+	if h.Config.Chain.Type != cmd.ChainEVM || (asset != "" && asset != "eth") {
+		cmd.Must(errors.New("--verified only works on ETH contracts of EVM chains"))
+	}
+
+	cmd.Must(h.Config.ValidateAddress(h.Config.Chain.Contract))
+
+	log.Print("Prove getContract ...")
+	log.Printf("contract address: %s", h.Config.Chain.Contract)
+
+	id := common.HexToHash(contractId)
+	d, block, err := h.VerifiedAudit(context.Background(), id, common.HexToHash(checkpoint))
+	cmd.Must(err)
+
+	log.Printf("proven at block %v (%s)", block.Number, block.Hash.Hex())
+
+	if d.Sender == (common.Address{}) {
+		cmd.Must(errors.Errorf("contractId %v does not exist", contractId))
+	}
+
+	return &cmd.SwapContract{
+		ID:        id.Hex(),
+		Sender:    d.Sender.String(),
+		Receiver:  d.Receiver.String(),
+		Amount:    d.Amount,
+		Hashlock:  d.Hashlock,
+		Timelock:  d.Timelock,
+		Withdrawn: d.Withdrawn,
+		Refunded:  d.Refunded,
+		Preimage:  d.Preimage,
+	}
+}
+
+func printContractDetails(d *cmd.SwapContract) {
+	log.Printf("Sender     = %s", d.Sender)
+	log.Printf("Receiver   = %s", d.Receiver)
+	log.Printf("Amount     = %s", d.Amount)
+	if d.Fee != nil {
+		log.Printf("Fee        = %s", d.Fee)
+		log.Printf("NetAmount  = %s (paid to the receiver)", new(big.Int).Sub(d.Amount, d.Fee))
+	}
 	log.Printf("TimeLock   = %s (%s)", d.Timelock, time.Unix(d.Timelock.Int64(), 0))
 	log.Printf("SecretHash = %s", hexutil.Encode(d.Hashlock[:]))
 	log.Printf("Withdrawn  = %t", d.Withdrawn)
 	log.Printf("Refunded   = %t", d.Refunded)
 	log.Printf("Secret     = %s", hexutil.Encode(d.Preimage[:]))
+	if d.Token != "" {
+		log.Printf("Token      = %s", d.Token)
+		log.Printf("TokenId    = %s", d.TokenID)
+	}
 }
@@ -0,0 +1,104 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{cancelRequestCmd, cancelExecuteCmd} {
+		c.Flags().StringVar(
+			&contractId,
+			"id",
+			"",
+			"the contractId of the atomicswap pair")
+
+		c.Flags().StringVar(
+			&privateKey,
+			"key",
+			"",
+			"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+
+		_ = c.MarkFlagRequired("id")
+	}
+
+	cancelExecuteCmd.Flags().StringVar(
+		&cancelSignature,
+		"signature",
+		"",
+		"the cancel signature of the receiver, from 'cancel request'")
+
+	_ = cancelExecuteCmd.MarkFlagRequired("signature")
+
+	cancelCmd.AddCommand(cancelRequestCmd)
+	cancelCmd.AddCommand(cancelExecuteCmd)
+}
+
+var cancelSignature string
+
+var cancelCmd = &cobra.Command{
+	Use:   "cancel",
+	Short: "call off a swap by mutual agreement and refund before the time lock (HashedTimelockV2 only)",
+	Long: `The receiver of a contract signs its cancellation with 'cancel request' and
+hands the signature to the sender, who refunds the contract at once with
+'cancel execute' instead of waiting for the time lock.`,
+}
+
+var cancelRequestCmd = &cobra.Command{
+	Use:   "request --id <contractId> [--key <private key>]",
+	Short: "sign, as the receiver, the cancellation of a contract",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		cmd.Must(h.Config.Connect(""))
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		sig, err := h.CancelSignature(context.Background(), common.HexToHash(contractId))
+		cmd.Must(err)
+
+		log.Printf("cancel signature = %v", hexutil.Encode(sig))
+	},
+}
+
+var cancelExecuteCmd = &cobra.Command{
+	Use:   "execute --id <contractId> --signature <receiver signature> [--key <private key>]",
+	Short: "refund a contract at once with the cancel signature of the receiver",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		sig, err := hexutil.Decode(cancelSignature)
+		cmd.Must(errors.Wrap(err, "decode signature"))
+
+		cmd.Must(h.Config.Connect(""))
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		txSigned, err := h.Cancel(context.Background(), common.HexToHash(contractId), sig)
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+}
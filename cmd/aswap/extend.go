@@ -0,0 +1,105 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+	"math/big"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	extendCmd.Flags().StringVar(
+		&contractId,
+		"id",
+		"",
+		"the contractId of our contract")
+
+	extendCmd.Flags().Int64Var(
+		&extendUntil,
+		"until",
+		0,
+		"the new unix time of the timelock")
+
+	extendCmd.Flags().StringVar(
+		&counterId,
+		"counter-id",
+		"",
+		"as the participant, the contractId of the initiator contract on the other chain, which must be extended first")
+
+	extendCmd.Flags().StringVar(
+		&otherContract,
+		"other",
+		"",
+		"contract address on the other chain, with --counter-id")
+
+	extendCmd.Flags().StringVar(
+		&privateKey,
+		"key",
+		"",
+		"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+
+	_ = extendCmd.MarkFlagRequired("id")
+	_ = extendCmd.MarkFlagRequired("until")
+}
+
+var (
+	extendUntil int64
+	counterId   string
+)
+
+var extendCmd = &cobra.Command{
+	Use:   "extend --id <contractId> --until <unix time> [--counter-id <contractId> --other <contract address>] [--key <private key>]",
+	Short: "push back the timelock of our contract (HashedTimelockV2 only)",
+	Long: `Extend gives a stalled swap more time instead of refunding it. The initiator
+extends first; the participant then passes the initiator contract with
+--counter-id, and its timelock may go up to halfway to the new initiator
+timelock, as for participant.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		//connect to chain
+		cmd.Must(h.Config.Connect(""))
+
+		var counter *cmd.SwapContract
+		if counterId != "" {
+			other, err := h.OtherHandler(otherContract)
+			cmd.Must(err)
+
+			backend, err := other.Backend()
+			cmd.Must(err)
+
+			counter, err = backend.Audit(ctx, counterId)
+			cmd.Must(err)
+		} else {
+			log.Println("no --counter-id: as the participant, make sure the initiator contract was extended first")
+		}
+
+		//Unlock account
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		txSigned, err := h.Extend(ctx, common.HexToHash(contractId), big.NewInt(extendUntil), counter)
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	extractSecretCmd.Flags().StringVar(
+		&contractId,
+		"id",
+		"",
+		"the contractId of the atomicswap pair")
+
+	extractSecretCmd.Flags().StringVar(
+		&otherContract,
+		"other",
+		"",
+		"contract address on the other chain, or the other chain name if it has no contract address")
+
+	_ = extractSecretCmd.MarkFlagRequired("id")
+}
+
+var extractSecretCmd = &cobra.Command{
+	Use:   "extractsecret --id <contractId> [--other <contract address | chain name>] [--asset <erc721 | erc1155>]",
+	Short: "extract the secret revealed by the redeem of the contract",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		cmd.Must(h.Config.Connect(otherContract))
+
+		cmd.Must(useAsset())
+
+		backend, err := h.Backend()
+		cmd.Must(err)
+
+		secret, err := backend.ExtractSecret(context.Background(), contractId)
+		cmd.Must(err)
+
+		log.Printf("Secret = %s", hexutil.Encode(secret[:]))
+	},
+}
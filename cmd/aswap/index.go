@@ -0,0 +1,110 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{indexStatCmd, indexWatchCmd} {
+		c.Flags().StringVar(
+			&otherContract,
+			"other",
+			"",
+			"contract address on the other chain, to index the other chain")
+
+		c.Flags().Uint64Var(
+			&indexFrom,
+			"from-block",
+			0,
+			"the first block to index, before the first sync only")
+	}
+
+	indexCmd.AddCommand(indexStatCmd)
+	indexCmd.AddCommand(indexWatchCmd)
+}
+
+var indexFrom uint64
+
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "follow the HTLC events of the contract into the store",
+	Long: `The indexer reads the LogHTLCNew, LogHTLCWithdraw, LogHTLCRefund and
+LogHTLCExtend events of the contract of the chain into the store, and rolls
+them back when the blocks they are in leave the chain. Once a chain is
+indexed, the watches of the swaps and the watchtower read the HTLCs from the
+index.`,
+}
+
+var indexStatCmd = &cobra.Command{
+	Use:   "stat [--other <contract address>] [--from-block <block>]",
+	Short: "sync the index and count the HTLCs by status",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		indexer()
+
+		cmd.Must(h.StatContract(context.Background()))
+	},
+}
+
+var indexWatchCmd = &cobra.Command{
+	Use:   "watch [--other <contract address>] [--from-block <block>]",
+	Short: "follow the chain head and show the HTLCs as they change",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ix := indexer()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		changes := make(chan *cmd.IndexedHTLC)
+		go func() {
+			cmd.Must(ix.Follow(ctx, changes))
+		}()
+
+		for htlc := range changes {
+			log.Printf("%v: %v, block %v, sender = %v, receiver = %v, amount = %v, timelock = %v", htlc.ContractID.Hex(),
+				htlc.Status, htlc.Block, htlc.Sender, htlc.Receiver, htlc.Amount, htlc.Timelock)
+		}
+	},
+}
+
+// indexer returns the indexer of the connected chain, starting at
+// --from-block if it was not synced yet.
+func indexer() *cmd.Indexer {
+	cmd.Must(h.Config.Connect(otherContract))
+
+	ix, err := h.Indexer()
+	cmd.Must(err)
+
+	if indexFrom != 0 {
+		head, err := ix.Head()
+		cmd.Must(err)
+		if head == 0 {
+			cmd.Must(ix.SetStart(indexFrom))
+		}
+	}
+
+	return ix
+}
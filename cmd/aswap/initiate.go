@@ -20,9 +20,10 @@ import (
 	"time"
 
 	"github.com/icodezjb/atomicswap/cmd"
+	"github.com/icodezjb/atomicswap/lightning"
 
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -32,7 +33,7 @@ func init() {
 		"participant",
 		"p",
 		"",
-		"participant address")
+		"participant address on the chain")
 
 	initiateCmd.Flags().Int64VarP(
 		&initiateAmount,
@@ -45,7 +46,45 @@ func init() {
 		&privateKey,
 		"key",
 		"",
-		"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+		"the private key of the account without '0x' prefix (WIF on a btc chain). if specified, the keystore (btc node wallet) will no longer be used")
+
+	initiateCmd.Flags().StringVar(
+		&invoice,
+		"invoice",
+		"",
+		"lock against the payment hash of this BOLT11 lightning invoice instead of a new secret. --amount must match the invoice: in satoshis on a btc chain, at --invoice-rate on an EVM chain")
+
+	initiateCmd.Flags().Int64Var(
+		&invoiceRate,
+		"invoice-rate",
+		0,
+		"the wei locked per satoshi of the --invoice on an EVM chain")
+
+	initiateCmd.Flags().IntVar(
+		&tranches,
+		"tranches",
+		1,
+		"split the swap into this many sequential sub-swaps, each with its own secret and contract pair")
+
+	initiateCmd.Flags().Int64Var(
+		&otherAmount,
+		"other-amount",
+		-1,
+		"the amount the participant pays on the other chain in total, with --tranches")
+
+	initiateCmd.Flags().StringVar(
+		&otherContract,
+		"other",
+		"",
+		"contract address on the other chain, with --tranches")
+
+	initiateCmd.Flags().Int64Var(
+		&trancheLock,
+		"tranche-lock",
+		4*60*60,
+		"seconds each tranche of the initiator stays locked, with --tranches")
+
+	addWaitFlag(initiateCmd)
 
 	_ = initiateCmd.MarkFlagRequired("participant")
 	_ = initiateCmd.MarkFlagRequired("amount")
@@ -54,25 +93,50 @@ func init() {
 var (
 	participant    string
 	initiateAmount int64
+	invoice        string
+	invoiceRate    int64
+	tranches       int
+	otherAmount    int64
 )
 
 var initiateCmd = &cobra.Command{
-	Use:   "initiate --participant <participant address> --amount <amount> [--key <private key>]",
+	Use:   "initiate --participant <participant address> --amount <amount> [--invoice <bolt11> [--invoice-rate <wei per sat>]] [--tranches <N> --other-amount <amount> --other <contract address>] [--salt <salt>] [--asset <erc721 | erc1155> --token <token contract> --token-id <id>] [--key <private key>]",
 	Short: "performed by the initiator to create the first contract",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		return h.Config.ParseConfig(h.ConfigPath)
 	},
 	Run: func(_ *cobra.Command, args []string) {
-		//check contract address
-		cmd.Must(h.Config.ValidateAddress(h.Config.Contract))
-
-		//check participant address
-		cmd.Must(h.Config.ValidateAddress(participant))
+		if tranches > 1 {
+			initiateTranches()
+			return
+		}
 
 		timeLock := new(big.Int).SetInt64(time.Now().Unix() + lock48Hour)
-		hashPair := cmd.NewSecretHashPair()
-		log.Printf("\nSecret = %s\nSecret Hash = %s",
-			hexutil.Encode(hashPair.Secret[:]), hexutil.Encode(hashPair.Hash[:]))
+
+		var hashLock [32]byte
+		if invoice != "" {
+			if asset != "" && asset != "eth" {
+				cmd.Must(errors.New("--invoice has no rate for an --asset"))
+			}
+
+			inv, err := lightning.Decode(invoice)
+			cmd.Must(err)
+
+			cmd.Must(cmd.CheckInvoiceAmount(inv, big.NewInt(initiateAmount), cmd.InvoiceRate(h.Config.ChainType, invoiceRate)))
+
+			timeLock, err = cmd.InvoiceTimeLock(inv, time.Now(), timeLock)
+			cmd.Must(err)
+
+			hashLock = inv.PaymentHash
+			log.Printf("\nPayment Hash = %s\nInvoice Amount = %v msat\nInvoice Expiry = %v\nPayee = %s",
+				hexutil.Encode(inv.PaymentHash[:]), inv.MilliSat, inv.ExpiresAt(), hexutil.Encode(inv.Payee))
+		} else {
+			hashPair := cmd.NewSecretHashPair()
+			log.Printf("\nSecret = %s\nSecret Hash = %s",
+				hexutil.Encode(hashPair.Secret[:]), hexutil.Encode(hashPair.Hash[:]))
+
+			hashLock = hashPair.Hash
+		}
 
 		//connect to chain
 		cmd.Must(h.Config.Connect(""))
@@ -80,9 +144,42 @@ var initiateCmd = &cobra.Command{
 		//Unlock account
 		cmd.Must(h.Config.Unlock(privateKey))
 
-		txSigned, err := h.NewContract(context.Background(), common.HexToAddress(participant), initiateAmount, hashPair.Hash, timeLock)
+		cmd.Must(useSalt())
+
+		cmd.Must(useAsset())
+
+		backend, err := h.Backend()
+		cmd.Must(err)
+
+		contractId, txid, err := backend.Lock(context.Background(), participant, big.NewInt(initiateAmount), hashLock, timeLock)
 		cmd.Must(err)
 
-		log.Printf("%s(%s) txid: %s", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().String())
+		log.Printf("%s(%s) txid: %s", h.Config.Chain.Name, h.Config.Chain.ID, txid)
+		log.Printf("ContractId = %s", contractId)
 	},
 }
+
+func initiateTranches() {
+	if invoice != "" {
+		cmd.Must(errors.New("--invoice can not be split into tranches"))
+	}
+	if asset != "" && asset != "eth" {
+		cmd.Must(errors.New("--asset can not be split into tranches"))
+	}
+	if otherAmount <= 0 || otherContract == "" {
+		cmd.Must(errors.New("--tranches needs --other-amount and --other"))
+	}
+
+	cmd.Must(h.Config.Connect(""))
+
+	cmd.Must(h.Config.Unlock(privateKey))
+
+	swap, err := cmd.NewTrancheSwap(participant, h.Config.Account, big.NewInt(initiateAmount), big.NewInt(otherAmount),
+		tranches, time.Duration(trancheLock)*time.Second)
+	cmd.Must(err)
+	swap.OtherContract = otherContract
+
+	log.Printf("SwapId = %s", swap.ID)
+
+	runTranches(swap)
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	listCmd.Flags().StringVar(
+		&listRole,
+		"as",
+		"",
+		"list only the HTLCs the account is the sender or the receiver of")
+
+	listCmd.Flags().StringVar(
+		&listStatus,
+		"status",
+		"",
+		"list only the HTLCs with the status: open, withdrawn, refunded or expired")
+
+	listCmd.Flags().StringVar(
+		&chainName,
+		"chain",
+		"",
+		"list only the HTLCs on the chain, by chain name or chainID")
+
+	listCmd.Flags().StringVar(
+		&otherContract,
+		"other",
+		"",
+		"contract address on the other chain, to list the other chain too")
+}
+
+var (
+	listRole   string
+	listStatus string
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list [--as sender|receiver] [--status open|withdrawn|refunded|expired] [--chain <chain name or chainID>] [--other <contract address>]",
+	Short: "list the HTLCs of the account on the configured chains with their next action",
+	Long: `List the HTLCs the account sends or receives, on the chain and, with --other,
+on the other chain, with their amounts, timelocks and what the account may
+do next: redeem, refund, wait for the counterparty, or nothing. The HTLCs
+are read from the index of a chain followed by aswap index, from the logs of
+the contract otherwise.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		var contracts []string
+		switch {
+		case chainName != "":
+			other, err := h.Config.IsOtherChain(chainName)
+			cmd.Must(err)
+			if other && otherContract == "" {
+				cmd.Must(errors.Errorf("the contract on %v needs --other", chainName))
+			}
+			if other {
+				contracts = []string{otherContract}
+			} else {
+				contracts = []string{""}
+			}
+		case otherContract != "":
+			contracts = []string{"", otherContract}
+		default:
+			contracts = []string{""}
+		}
+
+		count := 0
+		for _, contract := range contracts {
+			cmd.Must(h.Config.Connect(contract))
+
+			htlcs, err := h.ListContracts(ctx, listRole, listStatus)
+			cmd.Must(err)
+
+			for _, htlc := range htlcs {
+				counterparty := htlc.Details.Receiver
+				if htlc.Role == cmd.RoleReceiver {
+					counterparty = htlc.Details.Sender
+				}
+
+				deadline := time.Unix(htlc.Details.Timelock.Int64(), 0)
+				log.Printf("%v(%v) %v: %v as %v, amount = %v, counterparty = %v, timelock = %v (%v), next = %v",
+					htlc.Chain, htlc.ChainID, htlc.ContractID.Hex(), htlc.Status, htlc.Role, htlc.Details.Amount,
+					counterparty.String(), htlc.Details.Timelock, deadline.Format(time.RFC3339), htlc.Action)
+			}
+			count += len(htlcs)
+		}
+
+		log.Printf("%v HTLCs of %v", count, h.Config.Account)
+	},
+}
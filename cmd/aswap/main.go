@@ -15,9 +15,12 @@ package main
 
 import (
 	"log"
+	"math/big"
 
 	"github.com/icodezjb/atomicswap/cmd"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -28,20 +31,67 @@ var (
 
 	rootCmd = &cobra.Command{
 		Use:   "aswap",
-		Short: "atomic swap between two different blockchains which based on EVM",
+		Short: "atomic swap between two different blockchains which based on EVM or bitcoin",
 	}
 
-	//auditContractCmd, redeemCmd
+	//auditContractCmd, redeemCmd, refundCmd, extractSecretCmd
 	contractId string
-	//auditContractCmd, redeemCmd, getContractIdCmd
+	//auditContractCmd, redeemCmd, getContractIdCmd, extractSecretCmd
 	otherContract string
 	//initiateCmd, participantCmd, redeemCmd, refundCmd
 	privateKey string
+	//initiateCmd, participantCmd
+	salt string
+	//redeemCmd, refundCmd
+	operator bool
+	//initiateCmd, participantCmd, auditContractCmd, redeemCmd, refundCmd, extractSecretCmd, approveCmd
+	asset string
+	//initiateCmd, participantCmd, approveCmd
+	token   string
+	tokenId string
 )
 
 func init() {
 	h.Config = new(cmd.Config)
 
+	for _, c := range []*cobra.Command{initiateCmd, participantCmd} {
+		c.Flags().StringVar(
+			&salt,
+			"salt",
+			"",
+			"the salt of the contract id on a HashedTimelockV2 chain. if not specified, a random salt is used")
+	}
+
+	for _, c := range []*cobra.Command{redeemCmd, refundCmd} {
+		c.Flags().BoolVar(
+			&operator,
+			"operator",
+			false,
+			"send from the operator account of the config on behalf of the contract owner (HashedTimelockV2 only), --key is then the operator key")
+	}
+
+	for _, c := range []*cobra.Command{initiateCmd, participantCmd, auditContractCmd, redeemCmd, refundCmd, extractSecretCmd, approveCmd} {
+		c.Flags().StringVar(
+			&asset,
+			"asset",
+			"eth",
+			"the asset of the contract: eth, erc721 or erc1155 (with the HashedTimelockERC721/ERC1155 contract of the config)")
+	}
+
+	for _, c := range []*cobra.Command{initiateCmd, participantCmd, approveCmd} {
+		c.Flags().StringVar(
+			&token,
+			"token",
+			"",
+			"the ERC721 or ERC1155 token contract, with --asset")
+
+		c.Flags().StringVar(
+			&tokenId,
+			"token-id",
+			"",
+			"the id of the token to lock, with --asset. --amount is then the number of ERC1155 tokens, or 1 for ERC721")
+	}
+
 	rootCmd.PersistentFlags().StringVarP(
 		&h.ConfigPath,
 		"config",
@@ -78,13 +128,36 @@ config-example.json
 {
     "chainID": 110,
     "chainName": "ETH1",
+    "chainType": "evm",
     "url": "http://127.0.0.1:8545",
-    "otherChainID": 111,
-    "otherChainName": "ETH2",
-    "otherURL": "http://127.0.0.1:7545",
+    "otherChainID": 0,
+    "otherChainName": "BTC",
+    "otherChainType": "btc",
+    "otherURL": "http://127.0.0.1:18443",
     "account": "0xffd79941b7085805f48ded97298694c6bb950e2c",
     "keystoreDir": "/absolute/path/",
-    "password": "password"
+    "password": "password",
+    "gas": {
+        "buffer": 1.2,
+        "percentile": 60,
+        "maxPrice": 100000000000,
+        "maxFee": 10000000000000000
+    },
+    "rpc": {
+        "urls": ["http://127.0.0.1:8546", "http://127.0.0.1:8547"],
+        "quorum": 2,
+        "maxLag": 5
+    },
+    "trustedContracts": {
+        "110": ["0x12D51a18385542d53acC27011aD27E57115b8e0b"]
+    },
+    "btc": {
+        "network": "regtest",
+        "rpcUser": "user",
+        "rpcPassword": "password",
+        "address": "bcrt1q...",
+        "feeRate": 0
+    }
 }
 `)
 
@@ -99,8 +172,73 @@ func main() {
 	rootCmd.AddCommand(auditContractCmd)
 	rootCmd.AddCommand(redeemCmd)
 	rootCmd.AddCommand(refundCmd)
+	rootCmd.AddCommand(cancelCmd)
+	rootCmd.AddCommand(extendCmd)
+	rootCmd.AddCommand(approveCmd)
+	rootCmd.AddCommand(verifyContractCmd)
+	rootCmd.AddCommand(extractSecretCmd)
+	rootCmd.AddCommand(multiswapCmd)
+	rootCmd.AddCommand(tranchesCmd)
+	rootCmd.AddCommand(watchtowerCmd)
+	rootCmd.AddCommand(speedupCmd)
+	rootCmd.AddCommand(canceltxCmd)
+	rootCmd.AddCommand(txCmd)
+	rootCmd.AddCommand(indexCmd)
+	rootCmd.AddCommand(listCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatalln(err)
 	}
 }
+
+// useSalt hands the --salt flag to the handler of the connected chain.
+func useSalt() error {
+	if salt == "" {
+		return nil
+	}
+
+	if !h.Config.Chain.IsV2() {
+		return errors.New("--salt needs a HashedTimelockV2 contract (\"contractVersion\": 2)")
+	}
+
+	s := common.HexToHash(salt)
+	h.Salt = (*[32]byte)(&s)
+
+	return nil
+}
+
+// useAsset hands the --asset, --token and --token-id flags to the handler.
+func useAsset() error {
+	if asset == "" || asset == "eth" {
+		return nil
+	}
+
+	if asset != cmd.AssetERC721 && asset != cmd.AssetERC1155 {
+		return errors.Errorf("unknown --asset %v, want eth, erc721 or erc1155", asset)
+	}
+
+	if operator {
+		return errors.New("--operator only works on ETH contracts")
+	}
+
+	a := &cmd.NFTAsset{Type: asset}
+
+	if token != "" {
+		if err := h.Config.ValidateAddress(token); err != nil {
+			return errors.Wrap(err, "--token")
+		}
+		a.Token = common.HexToAddress(token)
+	}
+
+	if tokenId != "" {
+		id, ok := new(big.Int).SetString(tokenId, 0)
+		if !ok || id.Sign() < 0 {
+			return errors.Errorf("invalid --token-id %v", tokenId)
+		}
+		a.TokenID = id
+	}
+
+	h.Asset = a
+
+	return nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	multiswapCmd.PersistentFlags().StringVar(
+		&ringPath,
+		"ring",
+		"ring.json",
+		"the ring file shared by all participants")
+
+	multiswapPlanCmd.Flags().Int64Var(
+		&ringDuration,
+		"duration",
+		lock48Hour/2,
+		"seconds from now until the last leg expires")
+
+	multiswapPlanCmd.Flags().Int64Var(
+		&ringDelta,
+		"delta",
+		3600,
+		"seconds between the timelocks of two successive legs")
+
+	for _, c := range []*cobra.Command{multiswapLockCmd, multiswapRedeemCmd, multiswapRefundCmd} {
+		c.Flags().IntVar(
+			&legIndex,
+			"leg",
+			-1,
+			"index of the leg in the ring file")
+
+		c.Flags().StringVar(
+			&privateKey,
+			"key",
+			"",
+			"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+
+		_ = c.MarkFlagRequired("leg")
+	}
+
+	multiswapRedeemCmd.Flags().StringVar(
+		&ringSecrets,
+		"secrets",
+		"",
+		"comma separated preimages of all the ring hashlocks, in order. if not specified, they are read from the redeem of the next leg")
+
+	multiswapCmd.AddCommand(multiswapSecretCmd)
+	multiswapCmd.AddCommand(multiswapPlanCmd)
+	multiswapCmd.AddCommand(multiswapAuditCmd)
+	multiswapCmd.AddCommand(multiswapLockCmd)
+	multiswapCmd.AddCommand(multiswapRedeemCmd)
+	multiswapCmd.AddCommand(multiswapRefundCmd)
+}
+
+var (
+	ringPath     string
+	ringDuration int64
+	ringDelta    int64
+	ringSecrets  string
+	legIndex     int
+)
+
+var multiswapCmd = &cobra.Command{
+	Use:   "multiswap",
+	Short: "N-party ring swap (A pays B on chain1, B pays C on chain2, C pays A on chain3) locked by one hashlock per participant",
+	Long: `N-party ring swap on HashedTimelockMulti contracts.
+
+1) every participant runs "multiswap secret" and shares the secret hash
+2) the participants agree on the ring file: the hashlocks and, for every leg,
+   the chain ID, contract, sender, receiver and amount. The receiver of a leg
+   is the sender of the next one, the sender of the first leg is the leader.
+   Every participant reaches the chains through its own config: the chain
+   of a leg must be its own or its other chain, and the contract its
+   multiContract or in its trustedContracts
+3) "multiswap plan" sets the timelocks, decreasing along the legs
+4) the senders run "multiswap lock" in leg order, each after the previous leg
+5) once "multiswap audit" passes for every leg, the participants hand their
+   secrets to the leader, who redeems the last leg with all of them
+6) every other participant runs "multiswap redeem" on its incoming leg, which
+   reads the secrets from the redeem of its outgoing leg`,
+}
+
+var multiswapSecretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "generate the secret and the secret hash of a participant",
+	Run: func(_ *cobra.Command, args []string) {
+		hashPair := cmd.NewSecretHashPair()
+		log.Printf("\nSecret = %s\nSecret Hash = %s",
+			hexutil.Encode(hashPair.Secret[:]), hexutil.Encode(hashPair.Hash[:]))
+	},
+}
+
+var multiswapPlanCmd = &cobra.Command{
+	Use:   "plan [--ring <ring file>] [--duration <seconds>] [--delta <seconds>]",
+	Short: "check the ring and set the timelocks of its legs",
+	Run: func(_ *cobra.Command, args []string) {
+		ring, err := cmd.LoadRing(ringPath)
+		cmd.Must(err)
+
+		finalTimeLock := big.NewInt(time.Now().Unix() + ringDuration)
+		ring, err = cmd.PlanRing(ring.Hashlocks, ring.Legs, finalTimeLock, time.Duration(ringDelta)*time.Second)
+		cmd.Must(err)
+
+		cmd.Must(ring.Save(ringPath))
+
+		for i, leg := range ring.Legs {
+			log.Printf("leg %d: %v pays %v %v on %v, timelock = %v", i, leg.Sender, leg.Receiver, leg.Amount, leg.ChainName,
+				time.Unix(leg.TimeLock.Int64(), 0))
+		}
+	},
+}
+
+var multiswapAuditCmd = &cobra.Command{
+	Use:   "audit [--ring <ring file>]",
+	Short: "audit every leg of the ring on its chain",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ring, err := cmd.LoadRing(ringPath)
+		cmd.Must(err)
+		cmd.Must(ring.Check())
+
+		for i, leg := range ring.Legs {
+			cmd.Must(h.Config.ConnectLeg(leg))
+
+			details, err := h.AuditLeg(context.Background(), ring, i)
+			cmd.Must(errors.Wrapf(err, "leg %d", i))
+
+			log.Printf("leg %d: ContractId = %s, Amount = %v, Timelock = %v, Withdrawn = %v, Refunded = %v",
+				i, leg.ContractID, details.Amount, time.Unix(details.Timelock.Int64(), 0), details.Withdrawn, details.Refunded)
+		}
+	},
+}
+
+var multiswapLockCmd = &cobra.Command{
+	Use:   "lock --leg <index> [--ring <ring file>] [--key <private key>]",
+	Short: "lock a leg as its sender, after auditing the previous leg",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ring, err := loadRingLeg()
+		cmd.Must(err)
+
+		if legIndex > 0 {
+			cmd.Must(h.Config.ConnectLeg(ring.Legs[legIndex-1]))
+
+			_, err := h.AuditLeg(context.Background(), ring, legIndex-1)
+			cmd.Must(err)
+		}
+
+		cmd.Must(h.Config.ConnectLeg(ring.Legs[legIndex]))
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		txSigned, err := h.LockLeg(context.Background(), ring, legIndex)
+		cmd.Must(err)
+
+		cmd.Must(ring.Save(ringPath))
+
+		log.Printf("%s(%s) txid: %s", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+		log.Printf("ContractId = %s", ring.Legs[legIndex].ContractID)
+	},
+}
+
+var multiswapRedeemCmd = &cobra.Command{
+	Use:   "redeem --leg <index> [--secrets <secret,...>] [--ring <ring file>] [--key <private key>]",
+	Short: "redeem a leg as its receiver with the preimages of all the hashlocks",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ring, err := loadRingLeg()
+		cmd.Must(err)
+
+		var secrets [][32]byte
+		if ringSecrets != "" {
+			for _, s := range strings.Split(ringSecrets, ",") {
+				secrets = append(secrets, common.HexToHash(strings.TrimSpace(s)))
+			}
+		} else {
+			next := (legIndex + 1) % len(ring.Legs)
+			cmd.Must(h.Config.ConnectLeg(ring.Legs[next]))
+
+			secrets, err = h.LegSecrets(context.Background(), ring, next)
+			cmd.Must(err)
+		}
+
+		cmd.Must(h.Config.ConnectLeg(ring.Legs[legIndex]))
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		txSigned, err := h.RedeemLeg(context.Background(), ring, legIndex, secrets)
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+}
+
+var multiswapRefundCmd = &cobra.Command{
+	Use:   "refund --leg <index> [--ring <ring file>] [--key <private key>]",
+	Short: "refund a leg as its sender after its timelock",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ring, err := loadRingLeg()
+		cmd.Must(err)
+
+		cmd.Must(h.Config.ConnectLeg(ring.Legs[legIndex]))
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		txSigned, err := h.RefundLeg(context.Background(), ring, legIndex)
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+}
+
+func loadRingLeg() (*cmd.Ring, error) {
+	ring, err := cmd.LoadRing(ringPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if legIndex < 0 || legIndex >= len(ring.Legs) {
+		return nil, errors.Errorf("leg %d out of range [0, %d)", legIndex, len(ring.Legs))
+	}
+
+	return ring, nil
+}
@@ -31,7 +31,7 @@ func init() {
 		"initiator",
 		"i",
 		"",
-		"initiator address")
+		"initiator address on the chain")
 
 	participantCmd.Flags().Int64VarP(
 		&participateAmount,
@@ -57,7 +57,7 @@ func init() {
 		&privateKey,
 		"key",
 		"",
-		"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+		"the private key of the account without '0x' prefix (WIF on a btc chain). if specified, the keystore (btc node wallet) will no longer be used")
 
 	_ = participantCmd.MarkFlagRequired("initiator")
 	_ = participantCmd.MarkFlagRequired("amount")
@@ -73,18 +73,12 @@ var (
 )
 
 var participantCmd = &cobra.Command{
-	Use:   "participant --initiator <initiator address> --amount <amount> --time <unix time> --hash <secret hash> [--key <private key>]",
+	Use:   "participant --initiator <initiator address> --amount <amount> --time <unix time> --hash <secret hash> [--salt <salt>] [--asset <erc721 | erc1155> --token <token contract> --token-id <id>] [--key <private key>]",
 	Short: "performed by the participant to create the second contract",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		return h.Config.ParseConfig(h.ConfigPath)
 	},
 	Run: func(_ *cobra.Command, args []string) {
-		//check contract address
-		cmd.Must(h.Config.ValidateAddress(h.Config.Contract))
-
-		//check participant address
-		cmd.Must(h.Config.ValidateAddress(initiator))
-
 		//half of initiator timelock
 		timeLock := new(big.Int).SetInt64(time.Now().Unix() + (untilTime-time.Now().Unix())/2)
 		//TODO: check timelock
@@ -96,9 +90,17 @@ var participantCmd = &cobra.Command{
 		//Unlock account
 		cmd.Must(h.Config.Unlock(privateKey))
 
-		txSigned, err := h.NewContract(context.Background(), common.HexToAddress(initiator), participateAmount, secretHash, timeLock)
+		cmd.Must(useSalt())
+
+		cmd.Must(useAsset())
+
+		backend, err := h.Backend()
+		cmd.Must(err)
+
+		contractId, txid, err := backend.Lock(context.Background(), initiator, big.NewInt(participateAmount), secretHash, timeLock)
 		cmd.Must(err)
 
-		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().String())
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txid)
+		log.Printf("ContractId = %s", contractId)
 	},
 }
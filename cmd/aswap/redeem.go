@@ -18,6 +18,7 @@ import (
 	"log"
 
 	"github.com/icodezjb/atomicswap/cmd"
+	"github.com/icodezjb/atomicswap/lightning"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/spf13/cobra"
@@ -40,13 +41,19 @@ func init() {
 		&otherContract,
 		"other",
 		"",
-		"contract address")
+		"contract address on the other chain, or the other chain name if it has no contract address")
 
 	redeemCmd.Flags().StringVar(
 		&privateKey,
 		"key",
 		"",
-		"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+		"the private key of the account without '0x' prefix (WIF on a btc chain). if specified, the keystore (btc node wallet) will no longer be used")
+
+	redeemCmd.Flags().StringVar(
+		&invoice,
+		"invoice",
+		"",
+		"the BOLT11 lightning invoice the contract is locked against, the secret is then the preimage of its payment")
 
 	_ = redeemCmd.MarkFlagRequired("id")
 	_ = redeemCmd.MarkFlagRequired("secret")
@@ -56,7 +63,7 @@ func init() {
 var secret string
 
 var redeemCmd = &cobra.Command{
-	Use:   "redeem --id <contractId> --secret <secret> --other <contract address> [--key <private key>]",
+	Use:   "redeem --id <contractId> --secret <secret> --other <contract address | chain name> [--invoice <bolt11>] [--asset <erc721 | erc1155>] [--operator] [--key <private key>]",
 	Short: "redeem once they know secret which is the preimage of the hashlock AND the time lock has no expired ",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		return h.Config.ParseConfig(h.ConfigPath)
@@ -64,16 +71,36 @@ var redeemCmd = &cobra.Command{
 	Run: func(_ *cobra.Command, args []string) {
 		cmd.Must(h.Config.Connect(otherContract))
 
-		cmd.Must(h.Config.ValidateAddress(h.Config.Chain.Contract))
+		cmd.Must(useAsset())
 
-		cmd.Must(h.Config.Unlock(privateKey))
+		if operator {
+			op, err := h.OperatorHandler(privateKey)
+			cmd.Must(err)
+
+			txSigned, err := op.RedeemFor(context.Background(), common.HexToHash(contractId), common.HexToHash(secret))
+			cmd.Must(err)
 
-		contractId := common.HexToHash(contractId)
-		secret := common.HexToHash(secret)
+			log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+			return
+		}
 
-		txSigned, err := h.Redeem(context.Background(), contractId, secret)
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		backend, err := h.Backend()
 		cmd.Must(err)
 
-		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().String())
+		var txid string
+		if invoice != "" {
+			inv, err := lightning.Decode(invoice)
+			cmd.Must(err)
+
+			txid, err = cmd.RedeemInvoice(context.Background(), backend, contractId, inv, common.HexToHash(secret))
+			cmd.Must(err)
+		} else {
+			txid, err = backend.Redeem(context.Background(), contractId, common.HexToHash(secret))
+			cmd.Must(err)
+		}
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txid)
 	},
 }
@@ -15,11 +15,15 @@ package main
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
+	"strings"
 
 	"github.com/icodezjb/atomicswap/cmd"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -34,32 +38,115 @@ func init() {
 		&privateKey,
 		"key",
 		"",
-		"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+		"the private key of the account without '0x' prefix (WIF on a btc chain). if specified, the keystore (btc node wallet) will no longer be used")
 
-	_ = refundCmd.MarkFlagRequired("id")
+	refundCmd.Flags().StringVar(
+		&contractIdsFile,
+		"ids",
+		"",
+		"a file of contractIds, one per line, to refund the expired ones in a single transaction (HashedTimelockV2 only)")
 }
 
+var contractIdsFile string
+
 var refundCmd = &cobra.Command{
-	Use:   "refund --id <contractId> [--key <private key>]",
+	Use:   "refund --id <contractId> | --ids <file> [--asset <erc721 | erc1155>] [--operator] [--key <private key>]",
 	Short: "refund on the contract if there was no withdraw AND the time lock has expired",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		return h.Config.ParseConfig(h.ConfigPath)
 	},
 	Run: func(_ *cobra.Command, args []string) {
-		//check contract address
-		cmd.Must(h.Config.ValidateAddress(h.Config.Contract))
+		if (contractId == "") == (contractIdsFile == "") {
+			cmd.Must(errors.New("specify either --id or --ids"))
+		}
 
 		//connect to chain
 		cmd.Must(h.Config.Connect(""))
 
+		cmd.Must(useAsset())
+
+		if contractIdsFile != "" {
+			refundMany()
+			return
+		}
+
+		if operator {
+			op, err := h.OperatorHandler(privateKey)
+			cmd.Must(err)
+
+			txSigned, err := op.RefundFor(context.Background(), common.HexToHash(contractId))
+			cmd.Must(err)
+
+			log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+			return
+		}
+
 		//Unlock account
 		cmd.Must(h.Config.Unlock(privateKey))
 
-		contractId := common.HexToHash(contractId)
+		backend, err := h.Backend()
+		cmd.Must(err)
 
-		txSigned, err := h.Refund(context.Background(), contractId)
+		txid, err := backend.Refund(context.Background(), contractId)
 		cmd.Must(err)
 
-		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().String())
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txid)
 	},
 }
+
+// refundMany refunds the expired contracts of the --ids file.
+func refundMany() {
+	ids, err := readContractIds(contractIdsFile)
+	cmd.Must(err)
+
+	sender := &h
+	if operator {
+		sender, err = h.OperatorHandler(privateKey)
+		cmd.Must(err)
+	} else {
+		cmd.Must(h.Config.Unlock(privateKey))
+	}
+
+	ctx := context.Background()
+
+	refundable, err := sender.Refundable(ctx, ids)
+	cmd.Must(err)
+
+	if len(refundable) == 0 {
+		log.Println("no contract to refund")
+		return
+	}
+
+	log.Printf("refund %d of %d contracts", len(refundable), len(ids))
+
+	txs, err := sender.RefundMany(ctx, refundable)
+	for _, txSigned := range txs {
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	}
+	cmd.Must(err)
+}
+
+// readContractIds reads a file of contractIds, one per line. Blank lines and
+// lines starting with '#' are skipped.
+func readContractIds(path string) ([]common.Hash, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read contractIds")
+	}
+
+	var ids []common.Hash
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		b, err := hexutil.Decode(line)
+		if err != nil || len(b) != common.HashLength {
+			return nil, errors.Errorf("%v:%d: invalid contractId %v", path, i+1, line)
+		}
+		ids = append(ids, common.BytesToHash(b))
+	}
+
+	return ids, nil
+}
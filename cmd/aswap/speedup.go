@@ -0,0 +1,101 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{speedupCmd, canceltxCmd} {
+		c.Flags().StringVar(
+			&txid,
+			"txid",
+			"",
+			"the hash of the pending transaction")
+
+		c.Flags().StringVar(
+			&otherContract,
+			"other",
+			"",
+			"contract address on the other chain, if the transaction is on the other chain")
+
+		c.Flags().BoolVar(
+			&operator,
+			"operator",
+			false,
+			"the transaction is sent from the operator account of the config")
+
+		c.Flags().StringVar(
+			&privateKey,
+			"key",
+			"",
+			"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+
+		_ = c.MarkFlagRequired("txid")
+	}
+}
+
+var speedupCmd = &cobra.Command{
+	Use:   "speedup --txid <txid> [--other <contract address>] [--operator] [--key <private key>]",
+	Short: "resend a pending transaction with the same nonce and a higher fee",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		sender := replacer()
+
+		txSigned, err := sender.SpeedUp(context.Background(), common.HexToHash(txid))
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+}
+
+var canceltxCmd = &cobra.Command{
+	Use:   "canceltx --txid <txid> [--other <contract address>] [--operator] [--key <private key>]",
+	Short: "replace a pending transaction with a zero-value transfer to ourselves at a higher fee",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		sender := replacer()
+
+		txSigned, err := sender.CancelTx(context.Background(), common.HexToHash(txid))
+		cmd.Must(err)
+
+		log.Printf("%v(%v) txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, txSigned.Hash().Hex())
+	},
+}
+
+// replacer returns the handler of the account that sent the transaction.
+func replacer() *cmd.Handler {
+	cmd.Must(h.Config.Connect(otherContract))
+
+	if operator {
+		op, err := h.OperatorHandler(privateKey)
+		cmd.Must(err)
+		return op
+	}
+
+	cmd.Must(h.Config.Unlock(privateKey))
+
+	return &h
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	tranchesCmd.Flags().StringVar(
+		&resumeSwap,
+		"resume",
+		"",
+		"the id of the tranched swap to resume")
+
+	tranchesCmd.Flags().StringVar(
+		&privateKey,
+		"key",
+		"",
+		"the private key of the account without '0x' prefix. if specified, the keystore will no longer be used")
+
+	addWaitFlag(tranchesCmd)
+}
+
+var (
+	trancheLock int64
+	trancheWait int64
+	resumeSwap  string
+)
+
+func addWaitFlag(c *cobra.Command) {
+	c.Flags().Int64Var(
+		&trancheWait,
+		"wait",
+		60*60,
+		"seconds to wait for the participant to lock a tranche before aborting the swap")
+}
+
+var tranchesCmd = &cobra.Command{
+	Use:   "tranches [--resume <swapId> [--wait <seconds>]] [--key <private key>]",
+	Short: "list the tranched swaps of the store, or resume one of them as its initiator",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		store, err := h.Store()
+		cmd.Must(err)
+
+		if resumeSwap == "" {
+			swaps, err := cmd.TrancheSwaps(store)
+			cmd.Must(err)
+
+			for _, swap := range swaps {
+				settled, otherSettled := swap.Settled()
+				log.Printf("%s: %v, tranche %d/%d, settled %v/%v for %v/%v", swap.ID, swap.Status,
+					swap.Current+1, len(swap.Tranches), settled, swap.Amount, otherSettled, swap.OtherAmount)
+			}
+			return
+		}
+
+		swap, err := cmd.LoadTrancheSwap(store, resumeSwap)
+		cmd.Must(err)
+
+		cmd.Must(h.Config.Connect(""))
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		runTranches(swap)
+	},
+}
+
+func runTranches(swap *cmd.TrancheSwap) {
+	driver, err := h.NewTrancheDriver(swap)
+	cmd.Must(err)
+
+	driver.ParticipateTimeout = time.Duration(trancheWait) * time.Second
+	//leave the participant contract a tenth of our lock to redeem it
+	driver.RedeemMargin = time.Duration(swap.LockTime) * time.Second / 10
+
+	cmd.Must(driver.Run(context.Background()))
+
+	settled, otherSettled := swap.Settled()
+	log.Printf("swap %s %v, settled %v/%v for %v/%v", swap.ID, swap.Status,
+		settled, swap.Amount, otherSettled, swap.OtherAmount)
+}
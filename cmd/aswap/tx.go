@@ -0,0 +1,92 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{txListCmd, txStatusCmd} {
+		c.Flags().StringVar(
+			&otherContract,
+			"other",
+			"",
+			"contract address on the other chain, to show the transactions of the other chain")
+	}
+
+	txStatusCmd.Flags().StringVar(
+		&txid,
+		"txid",
+		"",
+		"the hash of the transaction")
+
+	_ = txStatusCmd.MarkFlagRequired("txid")
+
+	txCmd.AddCommand(txListCmd)
+	txCmd.AddCommand(txStatusCmd)
+}
+
+var txCmd = &cobra.Command{
+	Use:   "tx",
+	Short: "show the transactions of the journal",
+	Long: `Every transaction aswap signs is written to the journal of the store
+before it is sent. Checking the journal also sends again the transactions the
+node dropped, with the same nonce and fee.`,
+}
+
+var txListCmd = &cobra.Command{
+	Use:   "list [--other <contract address>]",
+	Short: "list the transactions of the journal on the chain, sending the dropped ones again",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		cmd.Must(h.Config.Connect(otherContract))
+
+		txs, err := h.JournalTxs(context.Background(), true)
+		cmd.Must(err)
+
+		for _, tx := range txs {
+			printJournalTx(tx)
+		}
+	},
+}
+
+var txStatusCmd = &cobra.Command{
+	Use:   "status --txid <txid> [--other <contract address>]",
+	Short: "show a transaction of the journal, sending it again if it was dropped",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		cmd.Must(h.Config.Connect(otherContract))
+
+		tx, err := h.TxStatus(context.Background(), common.HexToHash(txid))
+		cmd.Must(err)
+
+		printJournalTx(tx)
+	},
+}
+
+func printJournalTx(tx *cmd.JournalTx) {
+	log.Printf("%v: %v, from = %v, nonce = %v, block = %v, rebroadcasts = %v %v", tx.TxID, tx.Status,
+		tx.From, tx.Nonce, tx.Block, tx.Rebroadcasts, tx.Error)
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyContractCmd.Flags().StringVar(
+		&chainName,
+		"chain",
+		"",
+		"the chain name or chainID of the contract")
+
+	verifyContractCmd.Flags().StringVar(
+		&contractAddress,
+		"address",
+		"",
+		"contract address")
+
+	_ = verifyContractCmd.MarkFlagRequired("chain")
+	_ = verifyContractCmd.MarkFlagRequired("address")
+}
+
+var (
+	chainName       string
+	contractAddress string
+)
+
+var verifyContractCmd = &cobra.Command{
+	Use:   "verifycontract --chain <chain name or chainID> --address <contract address>",
+	Short: "verify that the contract address runs the HashedTimelock bytecode",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		cmd.Must(h.Config.ValidateAddress(contractAddress))
+
+		other, err := h.Config.IsOtherChain(chainName)
+		cmd.Must(err)
+
+		if other {
+			cmd.Must(h.Config.Connect(contractAddress))
+		} else {
+			cmd.Must(h.Config.Connect(""))
+		}
+
+		cmd.Must(h.VerifyContract(context.Background(), common.HexToAddress(contractAddress)))
+
+		log.Printf("%s(%s) contract %s is verified", h.Config.Chain.Name, h.Config.Chain.ID, contractAddress)
+	},
+}
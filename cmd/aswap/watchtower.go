@@ -0,0 +1,296 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/icodezjb/atomicswap/cmd"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	watchtowerServeCmd.Flags().StringVar(
+		&towerListen,
+		"listen",
+		"127.0.0.1:8700",
+		"the address of the registration API")
+
+	watchtowerServeCmd.Flags().Int64Var(
+		&towerMargin,
+		"margin",
+		3600,
+		"seconds before the timelock to send a pre-signed redeem")
+
+	watchtowerServeCmd.Flags().Uint64Var(
+		&towerBumpBlocks,
+		"bump-blocks",
+		3,
+		"blocks after which a redeem or refund of the operator account that is not mined is resent at a higher fee, 0 to never")
+
+	for _, c := range []*cobra.Command{watchtowerServeCmd, watchtowerRegisterCmd} {
+		c.Flags().StringVar(
+			&otherContract,
+			"other",
+			"",
+			"contract address on the other chain")
+
+		c.Flags().StringVar(
+			&privateKey,
+			"key",
+			"",
+			"the private key of the account without '0x' prefix (of the operator account for serve). if specified, the keystore will no longer be used")
+	}
+
+	for _, c := range []*cobra.Command{watchtowerRegisterCmd, watchtowerStatusCmd} {
+		c.Flags().StringVar(
+			&towerURL,
+			"url",
+			"http://127.0.0.1:8700",
+			"the registration API of the watchtower")
+
+		c.Flags().StringVar(
+			&towerToken,
+			"token",
+			"",
+			"the token of the registration API, printed by the watchtower")
+	}
+
+	watchtowerRegisterCmd.Flags().StringVar(
+		&towerRefundId,
+		"refund-id",
+		"",
+		"the contractId of our contract on the own chain, refunded once it expires")
+
+	watchtowerRegisterCmd.Flags().StringVar(
+		&towerRedeemId,
+		"redeem-id",
+		"",
+		"the contractId of the counterparty contract on the other chain, redeemed for us")
+
+	watchtowerRegisterCmd.Flags().StringVar(
+		&secret,
+		"secret",
+		"",
+		"the secret, to pre-sign the redeem if we are the initiator")
+
+	watchtowerStatusCmd.Flags().StringVar(
+		&towerId,
+		"id",
+		"",
+		"the registration id")
+
+	_ = watchtowerStatusCmd.MarkFlagRequired("id")
+
+	watchtowerCmd.AddCommand(watchtowerServeCmd)
+	watchtowerCmd.AddCommand(watchtowerRegisterCmd)
+	watchtowerCmd.AddCommand(watchtowerStatusCmd)
+}
+
+var (
+	towerListen     string
+	towerMargin     int64
+	towerBumpBlocks uint64
+	towerURL        string
+	towerRefundId   string
+	towerRedeemId   string
+	towerId         string
+	towerToken      string
+)
+
+var watchtowerCmd = &cobra.Command{
+	Use:   "watchtower",
+	Short: "guard the swaps of offline users: redeem and refund their contracts before the deadlines",
+	Long: `The watchtower watches the registered contracts on the chains of its config.
+
+A registration has up to two legs, named by the chain names of the watchtower
+config: the contract paying the user ("redeem"), redeemed as soon as the other
+leg reveals the secret or, with a pre-signed redeem, --margin before it
+expires; and the contract of the user ("refund"), refunded once it expires.
+
+On a HashedTimelock chain the user leaves pre-signed transactions, encrypted to
+the watchtower key. On a HashedTimelockV2 chain the watchtower can also send
+from the operator account of its config.
+
+Each leg is signed by the owner of its contract. The registration API wants the
+token the watchtower prints when it starts.`,
+}
+
+var watchtowerServeCmd = &cobra.Command{
+	Use:   "serve [--listen <address>] [--other <contract address>] [--margin <seconds>] [--bump-blocks <blocks>] [--key <operator private key>]",
+	Short: "run the watchtower and its registration API",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		cmd.Must(h.Config.Connect(""))
+
+		handlers := []*cmd.Handler{&h}
+		if otherContract != "" {
+			other, err := h.OtherHandler(otherContract)
+			cmd.Must(err)
+			handlers = append(handlers, other)
+		}
+
+		store, err := h.Store()
+		cmd.Must(err)
+
+		key, err := cmd.WatchtowerKey(store)
+		cmd.Must(err)
+
+		token, err := cmd.WatchtowerToken(store)
+		cmd.Must(err)
+
+		tower := &cmd.Watchtower{
+			Handlers:   make(map[string]*cmd.Handler),
+			Store:      store,
+			Key:        key,
+			Margin:     time.Duration(towerMargin) * time.Second,
+			BumpBlocks: towerBumpBlocks,
+			Token:      token,
+		}
+
+		for _, handler := range handlers {
+			if handler.Config.Chain.IsV2() && handler.Config.Operator != "" {
+				handler, err = handler.OperatorHandler(privateKey)
+				cmd.Must(err)
+			}
+			tower.Handlers[handler.Config.Chain.Name] = handler
+		}
+
+		go func() {
+			cmd.Must(tower.Run(context.Background()))
+		}()
+
+		log.Printf("watchtower listening on %v, public key = %v, token = %v", towerListen, hexutil.Encode(crypto.FromECDSAPub(&key.PublicKey)), token)
+		cmd.Must(tower.ListenAndServe(towerListen))
+	},
+}
+
+var watchtowerRegisterCmd = &cobra.Command{
+	Use:   "register [--refund-id <contractId>] [--redeem-id <contractId> --other <contract address> [--secret <secret>]] [--url <watchtower>] [--token <token>] [--key <private key>]",
+	Short: "register our contracts with pre-signed refund and redeem at a watchtower",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return h.Config.ParseConfig(h.ConfigPath)
+	},
+	Run: func(_ *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		var answer map[string]string
+		cmd.Must(towerRequest(http.MethodGet, "/pubkey", nil, &answer))
+
+		pub, err := crypto.UnmarshalPubkey(common.FromHex(answer["pubkey"]))
+		cmd.Must(errors.Wrap(err, "watchtower public key"))
+
+		cmd.Must(h.Config.Connect(""))
+
+		cmd.Must(h.Config.Unlock(privateKey))
+
+		r := new(cmd.Registration)
+
+		if towerRefundId != "" {
+			tx, err := h.PresignRefund(ctx, common.HexToHash(towerRefundId))
+			cmd.Must(err)
+
+			signedTx, err := cmd.EncryptForTower(pub, tx)
+			cmd.Must(err)
+
+			r.Refund = &cmd.TowerLeg{Chain: h.Config.ChainName, ContractID: towerRefundId, SignedTx: signedTx}
+			cmd.Must(h.SignTowerLeg(r.Refund))
+		}
+
+		if towerRedeemId != "" {
+			other, err := h.OtherHandler(otherContract)
+			cmd.Must(err)
+
+			r.Redeem = &cmd.TowerLeg{Chain: h.Config.OtherChainName, ContractID: towerRedeemId}
+
+			if secret != "" {
+				tx, err := other.PresignRedeem(ctx, common.HexToHash(towerRedeemId), common.HexToHash(secret))
+				cmd.Must(err)
+
+				r.Redeem.SignedTx, err = cmd.EncryptForTower(pub, tx)
+				cmd.Must(err)
+			}
+
+			cmd.Must(other.SignTowerLeg(r.Redeem))
+		}
+
+		cmd.Must(towerRequest(http.MethodPost, "/registrations", r, &answer))
+
+		log.Printf("RegistrationId = %v", answer["id"])
+	},
+}
+
+var watchtowerStatusCmd = &cobra.Command{
+	Use:   "status --id <registration id> [--url <watchtower>] [--token <token>]",
+	Short: "show the legs of a registration at a watchtower",
+	Run: func(_ *cobra.Command, args []string) {
+		r := new(cmd.Registration)
+		cmd.Must(towerRequest(http.MethodGet, "/registrations/"+towerId, nil, r))
+
+		for i, leg := range []*cmd.TowerLeg{r.Redeem, r.Refund} {
+			if leg == nil {
+				continue
+			}
+			log.Printf("%v: %v ContractId = %v, Status = %v, txid = %v %v", []string{"redeem", "refund"}[i],
+				leg.Chain, leg.ContractID, leg.Status, leg.TxID, leg.Error)
+		}
+	},
+}
+
+// towerRequest calls the watchtower API and decodes the answer into result.
+func towerRequest(method string, path string, body interface{}, result interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return errors.Wrap(err, "encode request")
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(towerURL, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if towerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+towerToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "call watchtower %v", towerURL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var answer map[string]string
+		_ = json.NewDecoder(resp.Body).Decode(&answer)
+		return errors.Errorf("watchtower: %v %v", resp.Status, answer["error"])
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(result), "decode answer")
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// chain types selected by "chainType" and "otherChainType" in the config
+const (
+	ChainEVM = "evm"
+	ChainBTC = "btc"
+)
+
+// HashedTimelock contract versions selected by "contractVersion" and
+// "otherContractVersion" in the config, 0 is HTLCVersion1
+const (
+	HTLCVersion1 = 1
+	//HashedTimelockV2, with a salt in the contract id
+	HTLCVersion2 = 2
+	//HashedTimelockFee, HashedTimelockV2 with a protocol fee on withdraw
+	HTLCVersionFee = 3
+)
+
+// event types of SwapEvent
+const (
+	EventNew      = "New"
+	EventWithdraw = "Withdraw"
+	EventRefund   = "Refund"
+)
+
+// how often WatchEvents polls the chain
+var watchInterval = 15 * time.Second
+
+// SwapContract is the chain independent view of a HTLC. Addresses and ids
+// are in the native text format of the chain.
+type SwapContract struct {
+	ID        string
+	Sender    string
+	Receiver  string
+	Amount    *big.Int
+	Hashlock  [32]byte
+	Timelock  *big.Int
+	Withdrawn bool
+	Refunded  bool
+	Preimage  [32]byte
+	//the ERC721 or ERC1155 token locked by a NFT HTLC, empty for the native
+	//asset of the chain
+	Token   string
+	TokenID *big.Int
+	//kept by a HashedTimelockFee on withdraw, the receiver gets Amount - Fee;
+	//nil if the contract takes no fee
+	Fee *big.Int
+}
+
+// SwapEvent is a state change of a HTLC seen on chain.
+type SwapEvent struct {
+	Type       string
+	ContractID string
+	TxID       string
+	//the revealed preimage of an EventWithdraw
+	Secret [32]byte
+}
+
+// ChainBackend is what the swap commands need from a chain family. The EVM
+// HashedTimelock contract and the bitcoin P2WSH script are the implementations.
+type ChainBackend interface {
+	// Lock creates a HTLC paying amount to receiver once the preimage of
+	// hashLock is revealed, refundable after timeLock.
+	Lock(ctx context.Context, receiver string, amount *big.Int, hashLock [32]byte, timeLock *big.Int) (contractId string, txid string, err error)
+	// Audit returns the current terms and state of the HTLC.
+	Audit(ctx context.Context, contractId string) (*SwapContract, error)
+	// Redeem claims the HTLC as the receiver with the preimage.
+	Redeem(ctx context.Context, contractId string, secret [32]byte) (txid string, err error)
+	// Refund reclaims the HTLC as the sender after the timelock.
+	Refund(ctx context.Context, contractId string) (txid string, err error)
+	// ExtractSecret returns the preimage revealed by the redeem of the HTLC.
+	ExtractSecret(ctx context.Context, contractId string) ([32]byte, error)
+	// WatchEvents sends the events of the HTLC to sink until it is redeemed or
+	// refunded, or ctx is done.
+	WatchEvents(ctx context.Context, contractId string, sink chan<- *SwapEvent) error
+	// Confirmations returns the number of blocks including and on top of the
+	// block that mined txid, 0 if it is pending.
+	Confirmations(ctx context.Context, txid string) (uint64, error)
+}
+
+// ContractFinder is implemented by the backends that can look up a HTLC by
+// its terms, so the initiator does not need the participant's contractId.
+type ContractFinder interface {
+	// FindContracts returns the ids of the HTLCs paying receiver locked by
+	// hashLock, oldest first.
+	FindContracts(ctx context.Context, receiver string, hashLock [32]byte) ([]string, error)
+}
+
+// LockFinder is implemented by the backends that can find a Lock of ours
+// sent before a crash, mined or still pending, so that it is not sent again.
+type LockFinder interface {
+	// FindLock returns the contractId and the txid of our Lock paying
+	// receiver locked by hashLock, or "" if we sent none. The txid is "" if
+	// only the contract is known.
+	FindLock(ctx context.Context, receiver string, hashLock [32]byte) (contractId string, txid string, err error)
+}
+
+// Backend returns the ChainBackend of the connected chain.
+func (h *Handler) Backend() (ChainBackend, error) {
+	if h.Config.Chain == nil {
+		return nil, errors.New("not connected")
+	}
+
+	switch h.Config.Chain.Type {
+	case ChainEVM:
+		if h.Asset != nil {
+			return &nftBackend{h: h, asset: h.Asset}, nil
+		}
+		return &evmBackend{h: h}, nil
+	case ChainBTC:
+		if h.Asset != nil {
+			return nil, errors.Errorf("%v tokens can not be swapped on a btc chain", h.Asset.Type)
+		}
+		return &btcBackend{h: h}, nil
+	default:
+		return nil, errors.Errorf("unknown chain type: %v", h.Config.Chain.Type)
+	}
+}
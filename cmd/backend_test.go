@@ -0,0 +1,460 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// simClient adds the head and block lookups the simulated backend lacks.
+type simClient struct {
+	*backends.SimulatedBackend
+}
+
+func (c simClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number == nil {
+		return c.Blockchain().CurrentHeader(), nil
+	}
+	return c.Blockchain().GetHeaderByNumber(number.Uint64()), nil
+}
+
+func (c simClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if number == nil {
+		return c.Blockchain().CurrentBlock(), nil
+	}
+	return c.Blockchain().GetBlockByNumber(number.Uint64()), nil
+}
+
+// testSimHandlers deploys the HashedTimelock on a simulated chain and returns
+// a handler for each key, all sharing the deployed contract.
+func testSimHandlers(t *testing.T, keys ...*ecdsa.PrivateKey) (*backends.SimulatedBackend, []*Handler) {
+	alloc := core.GenesisAlloc{}
+	for _, key := range keys {
+		alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: big.NewInt(1e18)}
+	}
+	sim := backends.NewSimulatedBackend(alloc, 8000000)
+
+	dir, err := ioutil.TempDir("", "aswap")
+	TMust(t, err)
+	defer os.RemoveAll(dir)
+
+	//the nonce manager and the journal of the handlers, in memory
+	store, err := OpenStore("")
+	TMust(t, err)
+
+	var handlers []*Handler
+	for _, key := range keys {
+		h := &Handler{ConfigPath: filepath.Join(dir, "config.json"), store: store}
+		h.Config = &Config{
+			ChainID:   params.AllEthashProtocolChanges.ChainID,
+			ChainName: "sim",
+			Account:   crypto.PubkeyToAddress(key.PublicKey).String(),
+			client:    simClient{sim},
+			key:       key,
+			test:      true,
+		}
+		h.Config.Chain = &chain{ID: h.Config.ChainID, Name: "sim", Type: ChainEVM}
+		handlers = append(handlers, h)
+	}
+
+	TMust(t, handlers[0].DeployContract(context.Background()))
+	sim.Commit()
+
+	for _, h := range handlers {
+		h.Config.Contract = handlers[0].Config.Contract
+		h.Config.Chain.Contract = handlers[0].Config.Contract
+	}
+
+	return sim, handlers
+}
+
+// skipUncompiled skips the test while a contract it runs is not compiled,
+// or fails it when ASWAP_REQUIRE_BIN is set, so a run after script/cmd.txt
+// can not pass by skipping.
+func skipUncompiled(t *testing.T, compiled bool, what string) {
+	t.Helper()
+	if compiled {
+		return
+	}
+	if os.Getenv("ASWAP_REQUIRE_BIN") != "" {
+		t.Fatalf("%v not compiled, see script/cmd.txt", what)
+	}
+	t.Skipf("%v not compiled, see script/cmd.txt", what)
+}
+
+// testDeployV2 deploys HashedTimelockV2 on sim as the contract of handlers.
+// The test is skipped while HashedTimeLockV2.sol is not compiled.
+func testDeployV2(t *testing.T, sim *backends.SimulatedBackend, handlers []*Handler) {
+	skipUncompiled(t, htlc.HTLCV2BIN != "", "HashedTimeLockV2.sol is")
+
+	address, err := handlers[0].deploy(context.Background(), htlc.HTLCV2BIN)
+	TMust(t, err)
+	sim.Commit()
+
+	for _, h := range handlers {
+		h.Config.Contract, h.Config.ContractVersion = address, HTLCVersion2
+		h.Config.Chain.Contract, h.Config.Chain.Version = address, HTLCVersion2
+	}
+}
+
+func TestEVMBackend(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	var (
+		ctx      = context.Background()
+		hashPair = testHashPair()
+		timeLock = big.NewInt(time.Now().Unix() + 3600)
+	)
+
+	sender, err := hs[0].Backend()
+	TMust(t, err)
+	receiver, err := hs[1].Backend()
+	TMust(t, err)
+
+	var (
+		contractId string
+		txid       string
+	)
+
+	Convey("evm backend on a simulated chain", t, func() {
+		Convey("[1] lock and confirm", func() {
+			contractId, txid, err = sender.Lock(ctx, hs[1].Config.Account, big.NewInt(1000), hashPair.Hash, timeLock)
+			So(err, ShouldBeNil)
+
+			confirmations, err := sender.Confirmations(ctx, txid)
+			So(err, ShouldBeNil)
+			So(confirmations, ShouldEqual, 0)
+
+			sim.Commit()
+
+			confirmations, err = sender.Confirmations(ctx, txid)
+			So(err, ShouldBeNil)
+			So(confirmations, ShouldEqual, 1)
+		})
+
+		Convey("[2] contractId should be the one of LogHTLCNew", func() {
+			event, err := hs[0].GetContractId(ctx, common.HexToHash(txid))
+			So(err, ShouldBeNil)
+			So(contractId, ShouldEqual, hexutil.Encode(event.ContractId[:]))
+		})
+
+		Convey("[3] receiver audit the contract", func() {
+			c, err := receiver.Audit(ctx, contractId)
+			So(err, ShouldBeNil)
+			So(c.Sender, ShouldEqual, hs[0].Config.Account)
+			So(c.Receiver, ShouldEqual, hs[1].Config.Account)
+			So(c.Amount.Int64(), ShouldEqual, 1000)
+			So(c.Hashlock, ShouldEqual, hashPair.Hash)
+			So(c.Withdrawn, ShouldBeFalse)
+
+			_, err = sender.ExtractSecret(ctx, contractId)
+			So(err, ShouldNotBeNil)
+
+			_, err = receiver.Audit(ctx, hexutil.Encode(make([]byte, 32)))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("[4] receiver redeem and sender extract the secret", func() {
+			_, err := receiver.Redeem(ctx, contractId, hashPair.Secret)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			secret, err := sender.ExtractSecret(ctx, contractId)
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, hashPair.Secret)
+		})
+
+		Convey("[5] watch the events of the contract", func() {
+			watchInterval = 10 * time.Millisecond
+			events := make(chan *SwapEvent, 2)
+			So(sender.WatchEvents(ctx, contractId, events), ShouldBeNil)
+
+			So((<-events).Type, ShouldEqual, EventNew)
+			withdraw := <-events
+			So(withdraw.Type, ShouldEqual, EventWithdraw)
+			So(withdraw.Secret, ShouldEqual, hashPair.Secret)
+		})
+	})
+}
+
+func TestEVMBackendV2(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+	testDeployV2(t, sim, hs)
+
+	var (
+		ctx      = context.Background()
+		hashPair = testHashPair()
+		timeLock = big.NewInt(time.Now().Unix() + 3600)
+		receiver = common.HexToAddress(hs[1].Config.Account)
+	)
+
+	sender, err := hs[0].Backend()
+	TMust(t, err)
+	receiverBackend, err := hs[1].Backend()
+	TMust(t, err)
+
+	Convey("HashedTimelockV2 on a simulated chain", t, func() {
+		Convey("the same terms lock twice under two salts", func() {
+			first, _, err := sender.Lock(ctx, hs[1].Config.Account, big.NewInt(1000), hashPair.Hash, timeLock)
+			So(err, ShouldBeNil)
+			second, _, err := sender.Lock(ctx, hs[1].Config.Account, big.NewInt(1000), hashPair.Hash, timeLock)
+			So(err, ShouldBeNil)
+			So(first, ShouldNotEqual, second)
+			sim.Commit()
+
+			c, err := receiverBackend.Audit(ctx, first)
+			So(err, ShouldBeNil)
+			So(c.Receiver, ShouldEqual, hs[1].Config.Account)
+			So(c.Amount.Int64(), ShouldEqual, 1000)
+
+			//the withdraw logs the preimage
+			_, err = receiverBackend.Redeem(ctx, first, hashPair.Secret)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			watchInterval = 10 * time.Millisecond
+			events := make(chan *SwapEvent, 2)
+			So(sender.WatchEvents(ctx, first, events), ShouldBeNil)
+			So((<-events).Type, ShouldEqual, EventNew)
+			withdraw := <-events
+			So(withdraw.Type, ShouldEqual, EventWithdraw)
+			So(withdraw.Secret, ShouldEqual, hashPair.Secret)
+
+			//extended, then called off by the receiver
+			id := common.HexToHash(second)
+			until := new(big.Int).Add(timeLock, big.NewInt(3600))
+			_, err = hs[0].Extend(ctx, id, until, nil)
+			So(err, ShouldBeNil)
+			sim.Commit()
+			c, err = receiverBackend.Audit(ctx, second)
+			So(err, ShouldBeNil)
+			So(c.Timelock, ShouldResemble, until)
+
+			signature, err := hs[1].CancelSignature(ctx, id)
+			So(err, ShouldBeNil)
+			_, err = hs[0].Cancel(ctx, id, signature)
+			So(err, ShouldBeNil)
+			sim.Commit()
+			c, err = receiverBackend.Audit(ctx, second)
+			So(err, ShouldBeNil)
+			So(c.Refunded, ShouldBeTrue)
+		})
+
+		Convey("a batch locks, then refunds once expired", func() {
+			ids, _, err := hs[0].NewContracts(ctx, []*BatchContract{
+				{Receiver: receiver, Amount: big.NewInt(1000), Hashlock: hashPair.Hash, Timelock: timeLock},
+				{Receiver: receiver, Amount: big.NewInt(2000), Hashlock: hashPair.Hash, Timelock: timeLock},
+			})
+			So(err, ShouldBeNil)
+			So(ids, ShouldHaveLength, 2)
+			sim.Commit()
+
+			refundable, err := hs[0].Refundable(ctx, ids)
+			So(err, ShouldBeNil)
+			So(refundable, ShouldBeEmpty)
+
+			So(sim.AdjustTime(2*time.Hour), ShouldBeNil)
+			sim.Commit()
+
+			_, err = hs[0].RefundMany(ctx, ids)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			for _, id := range ids {
+				c, err := sender.Audit(ctx, id.Hex())
+				So(err, ShouldBeNil)
+				So(c.Refunded, ShouldBeTrue)
+			}
+		})
+	})
+}
+
+func TestLockCollision(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	var (
+		ctx      = context.Background()
+		hashPair = testHashPair()
+		timeLock = big.NewInt(time.Now().Unix() + 3600)
+	)
+
+	sender, err := hs[0].Backend()
+	TMust(t, err)
+
+	Convey("a second contract with the same terms is refused before sending", t, func() {
+		_, _, err := sender.Lock(ctx, hs[1].Config.Account, big.NewInt(1000), hashPair.Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		_, _, err = sender.Lock(ctx, hs[1].Config.Account, big.NewInt(1000), hashPair.Hash, timeLock)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "already exists")
+	})
+}
+
+func TestEVMBackendRefund(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	var (
+		ctx      = context.Background()
+		timeLock = big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+	)
+
+	sender, err := hs[0].Backend()
+	TMust(t, err)
+
+	Convey("refund verifies the contract before sending", t, func() {
+		contractId, _, err := sender.Lock(ctx, hs[1].Config.Account, big.NewInt(1000), testHashPair().Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		So(sim.AdjustTime(2*time.Hour), ShouldBeNil)
+		sim.Commit()
+
+		//an account without the HashedTimelock code
+		contract := hs[0].Config.Chain.Contract
+		hs[0].Config.Chain.Contract = hs[1].Config.Account
+		_, err = sender.Refund(ctx, contractId)
+		So(err, ShouldNotBeNil)
+
+		hs[0].Config.Chain.Contract = contract
+		_, err = sender.Refund(ctx, contractId)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		c, err := sender.Audit(ctx, contractId)
+		So(err, ShouldBeNil)
+		So(c.Refunded, ShouldBeTrue)
+	})
+}
+
+func TestHTLCV2(t *testing.T) {
+	var (
+		sender   = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		receiver = common.HexToAddress("0x2000000000000000000000000000000000000002")
+		amount   = big.NewInt(1000)
+		hashPair = testHashPair()
+		timeLock = big.NewInt(1600000000)
+	)
+
+	Convey("the salt makes the contract id of the same terms unique", t, func() {
+		parsedABI, err := abi.JSON(strings.NewReader(htlc.HTLCV2ABI))
+		So(err, ShouldBeNil)
+
+		_, err = parsedABI.Pack("newContract", receiver, hashPair.Hash, timeLock, [32]byte{1})
+		So(err, ShouldBeNil)
+
+		id := saltedContractID(sender, receiver, amount, hashPair.Hash, timeLock, [32]byte{1})
+		So(id, ShouldNotEqual, saltedContractID(sender, receiver, amount, hashPair.Hash, timeLock, [32]byte{2}))
+		So(id, ShouldNotEqual, contractID(sender, receiver, amount, hashPair.Hash, timeLock))
+	})
+
+	Convey("use the chosen salt once, then random ones", t, func() {
+		h := &Handler{Config: &Config{}, Salt: &[32]byte{7}}
+
+		salt, err := h.nextSalt()
+		So(err, ShouldBeNil)
+		So(salt, ShouldEqual, [32]byte{7})
+
+		first, err := h.nextSalt()
+		So(err, ShouldBeNil)
+		second, err := h.nextSalt()
+		So(err, ShouldBeNil)
+		So(first, ShouldNotEqual, [32]byte{7})
+		So(first, ShouldNotEqual, second)
+	})
+
+	Convey("deploy refuses without bytecode", t, func() {
+		if htlc.HTLCV2BIN != "" {
+			return
+		}
+		h := &Handler{Config: &Config{}}
+		So(h.DeployContractV2(context.Background()), ShouldNotBeNil)
+	})
+}
+
+func TestTransactTo(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	var (
+		ctx      = context.Background()
+		hashPair = testHashPair()
+		timeLock = big.NewInt(time.Now().Unix() + 3600)
+		sender   = common.HexToAddress(hs[0].Config.Account)
+		receiver = common.HexToAddress(hs[1].Config.Account)
+	)
+
+	//a second HashedTimelock, not the contract of the chain
+	address, err := hs[0].deploy(ctx, htlc.HTLCBIN)
+	TMust(t, err)
+	sim.Commit()
+	other := common.HexToAddress(address)
+
+	Convey("estimate and send to the given contract, not the one of the chain", t, func() {
+		_, err := hs[0].transactTo(ctx, other, htlc.HTLCABI, 1000, "newContract", receiver, hashPair.Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		id := contractID(sender, receiver, big.NewInt(1000), hashPair.Hash, timeLock)
+		_, err = hs[1].transactTo(ctx, other, htlc.HTLCABI, 0, "withdraw", id, hashPair.Secret)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		details := new(ContractDetails)
+		So(hs[1].callTo(ctx, other, htlc.HTLCABI, details, "getContract", id), ShouldBeNil)
+		So(details.Withdrawn, ShouldBeTrue)
+
+		//the contract of the chain has no such HTLC
+		So(hs[1].call(ctx, htlc.HTLCABI, details, "getContract", id), ShouldBeNil)
+		So(details.Sender, ShouldEqual, common.Address{})
+	})
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"log"
+	"math/big"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// the most HTLCs one transaction creates, or withdraws or refunds, within
+// gasLimit
+const (
+	maxBatchNew    = 12
+	maxBatchSettle = 50
+)
+
+// BatchContract is the terms of one of the HTLCs of NewContracts.
+type BatchContract struct {
+	Receiver common.Address
+	Amount   *big.Int
+	Hashlock [32]byte
+	Timelock *big.Int
+}
+
+// NewContracts creates the HTLCs of contracts on the HashedTimelockV2 of the
+// connected chain, maxBatchNew per transaction, and returns their ids in
+// order. On an error, the ids and transactions are those of the batches sent
+// before.
+func (h *Handler) NewContracts(ctx context.Context, contracts []*BatchContract) ([]common.Hash, []Transaction, error) {
+	if err := h.requireV2("newContracts"); err != nil {
+		return nil, nil, err
+	}
+
+	if len(contracts) == 0 {
+		return nil, nil, errors.New("no contracts to create")
+	}
+
+	var (
+		sender = common.HexToAddress(h.Config.Account)
+		ids    []common.Hash
+		txs    []Transaction
+	)
+
+	for start := 0; start < len(contracts); start += maxBatchNew {
+		end := start + maxBatchNew
+		if end > len(contracts) {
+			end = len(contracts)
+		}
+
+		var (
+			receivers []common.Address
+			amounts   []*big.Int
+			hashLocks [][32]byte
+			timeLocks []*big.Int
+			salts     [][32]byte
+			batchIds  []common.Hash
+			total     = new(big.Int)
+		)
+
+		for _, c := range contracts[start:end] {
+			if c.Amount.Sign() <= 0 {
+				return ids, txs, errors.Errorf("amount %v must be > 0", c.Amount)
+			}
+
+			salt, err := h.nextSalt()
+			if err != nil {
+				return ids, txs, err
+			}
+
+			receivers = append(receivers, c.Receiver)
+			amounts = append(amounts, c.Amount)
+			hashLocks = append(hashLocks, c.Hashlock)
+			timeLocks = append(timeLocks, c.Timelock)
+			salts = append(salts, salt)
+			batchIds = append(batchIds, saltedContractID(sender, c.Receiver, c.Amount, c.Hashlock, c.Timelock, salt))
+			total.Add(total, c.Amount)
+		}
+
+		if !total.IsInt64() {
+			return ids, txs, errors.Errorf("total amount %v out of range", total)
+		}
+
+		log.Printf("Create %d contracts of %v in total", len(batchIds), total)
+
+		txSigned, err := h.transact(ctx, htlc.HTLCV2ABI, total.Int64(), "newContracts", receivers, amounts, hashLocks, timeLocks, salts)
+		if err != nil {
+			return ids, txs, err
+		}
+
+		ids = append(ids, batchIds...)
+		txs = append(txs, txSigned)
+	}
+
+	return ids, txs, nil
+}
+
+// RedeemMany withdraws contractIds with the secrets in order, maxBatchSettle
+// per transaction. It checks every secret first so that the sender does not
+// pay for a revert.
+func (h *Handler) RedeemMany(ctx context.Context, contractIds []common.Hash, secrets []common.Hash) ([]Transaction, error) {
+	if err := h.requireV2("withdrawMany"); err != nil {
+		return nil, err
+	}
+
+	if len(contractIds) != len(secrets) {
+		return nil, errors.Errorf("%d contractIds but %d secrets", len(contractIds), len(secrets))
+	}
+
+	for i, id := range contractIds {
+		if _, err := h.checkRedeem(ctx, id, secrets[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var txs []Transaction
+	for start := 0; start < len(contractIds); start += maxBatchSettle {
+		end := start + maxBatchSettle
+		if end > len(contractIds) {
+			end = len(contractIds)
+		}
+
+		txSigned, err := h.transact(ctx, htlc.HTLCV2ABI, 0, "withdrawMany", contractIds[start:end], secrets[start:end])
+		if err != nil {
+			return txs, err
+		}
+		txs = append(txs, txSigned)
+	}
+
+	return txs, nil
+}
+
+// RefundMany refunds contractIds, maxBatchSettle per transaction. They must
+// all be refundable, see Refundable.
+func (h *Handler) RefundMany(ctx context.Context, contractIds []common.Hash) ([]Transaction, error) {
+	if err := h.requireV2("refundMany"); err != nil {
+		return nil, err
+	}
+
+	refundable, err := h.Refundable(ctx, contractIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(refundable) != len(contractIds) {
+		return nil, errors.Errorf("%d of the %d contracts can not be refunded", len(contractIds)-len(refundable), len(contractIds))
+	}
+
+	var txs []Transaction
+	for start := 0; start < len(contractIds); start += maxBatchSettle {
+		end := start + maxBatchSettle
+		if end > len(contractIds) {
+			end = len(contractIds)
+		}
+
+		txSigned, err := h.transact(ctx, htlc.HTLCV2ABI, 0, "refundMany", contractIds[start:end])
+		if err != nil {
+			return txs, err
+		}
+		txs = append(txs, txSigned)
+	}
+
+	return txs, nil
+}
+
+// Refundable returns the contractIds that are open and expired, in order,
+// and logs why the others are left out.
+func (h *Handler) Refundable(ctx context.Context, contractIds []common.Hash) ([]common.Hash, error) {
+	head, err := h.Config.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get head")
+	}
+
+	var refundable []common.Hash
+	for _, id := range contractIds {
+		details, err := h.openContract(ctx, id)
+		if err != nil {
+			log.Printf("skip %v: %v", id.Hex(), err)
+			continue
+		}
+
+		if head.Time < details.Timelock.Uint64() {
+			log.Printf("skip %v: locked until %v", id.Hex(), details.Timelock)
+			continue
+		}
+
+		refundable = append(refundable, id)
+	}
+
+	return refundable, nil
+}
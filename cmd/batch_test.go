@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatchPack(t *testing.T) {
+	parsedABI, err := abi.JSON(strings.NewReader(htlc.HTLCV2ABI))
+	TMust(t, err)
+
+	Convey("the batch calls take arrays of the HTLC terms", t, func() {
+		_, err := parsedABI.Pack("newContracts",
+			[]common.Address{common.HexToAddress("0x01")}, []*big.Int{big.NewInt(1)},
+			[][32]byte{{2}}, []*big.Int{big.NewInt(3)}, [][32]byte{{4}})
+		So(err, ShouldBeNil)
+
+		_, err = parsedABI.Pack("withdrawMany", []common.Hash{{1}}, []common.Hash{{2}})
+		So(err, ShouldBeNil)
+
+		_, err = parsedABI.Pack("refundMany", []common.Hash{{1}, {2}})
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestBatchChecks(t *testing.T) {
+	ctx := context.Background()
+
+	newHandler := func(version int) *Handler {
+		return &Handler{Config: &Config{
+			Account: common.HexToAddress("0x01").String(),
+			Chain:   &chain{Contract: "0x00000000000000000000000000000000000000c1", Version: version},
+		}}
+	}
+
+	Convey("refuse a HashedTimelock chain", t, func() {
+		h := newHandler(HTLCVersion1)
+
+		_, _, err := h.NewContracts(ctx, []*BatchContract{{Amount: big.NewInt(1)}})
+		So(err, ShouldNotBeNil)
+
+		_, err = h.RedeemMany(ctx, []common.Hash{{1}}, []common.Hash{{2}})
+		So(err, ShouldNotBeNil)
+
+		_, err = h.RefundMany(ctx, []common.Hash{{1}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("refuse an empty batch, a zero amount and unpaired secrets", t, func() {
+		h := newHandler(HTLCVersion2)
+
+		_, _, err := h.NewContracts(ctx, nil)
+		So(err, ShouldNotBeNil)
+
+		_, _, err = h.NewContracts(ctx, []*BatchContract{{Amount: big.NewInt(0)}})
+		So(err, ShouldNotBeNil)
+
+		_, err = h.RedeemMany(ctx, []common.Hash{{1}, {2}}, []common.Hash{{3}})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRefundable(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	ctx := context.Background()
+
+	sender, err := hs[0].Backend()
+	TMust(t, err)
+
+	lock := func(hashLock [32]byte, lockTime int64) common.Hash {
+		contractId, _, err := sender.Lock(ctx, hs[1].Config.Account, big.NewInt(1000), hashLock, big.NewInt(int64(sim.Blockchain().CurrentHeader().Time)+lockTime))
+		TMust(t, err)
+		sim.Commit()
+		return common.HexToHash(contractId)
+	}
+
+	pair := testHashPair()
+	expired := lock(NewSecretHashPair().Hash, 600)
+	locked := lock(NewSecretHashPair().Hash, 7200)
+	redeemed := lock(pair.Hash, 900)
+
+	_, err = hs[1].Redeem(ctx, redeemed, pair.Secret)
+	TMust(t, err)
+	sim.Commit()
+
+	TMust(t, sim.AdjustTime(time.Hour))
+	sim.Commit()
+
+	Convey("only the open and expired contracts are refundable", t, func() {
+		ids, err := hs[0].Refundable(ctx, []common.Hash{expired, locked, redeemed, {1}})
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []common.Hash{expired})
+	})
+}
+
+func TestNewContractsOverflow(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	sim, handlers := testSimHandlers(t, senderKey)
+	defer sim.Close()
+	testDeployV2(t, sim, handlers)
+
+	var (
+		ctx      = context.Background()
+		h        = handlers[0]
+		receiver = common.HexToAddress("0x02")
+		timeLock = big.NewInt(time.Now().Unix() + 3600)
+		max      = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	)
+
+	Convey("newContracts refuses amounts whose sum wraps around", t, func() {
+		//2^256 - 1 + 2 wraps to the 1 wei sent
+		_, err := h.transact(ctx, htlc.HTLCV2ABI, 1, "newContracts",
+			[]common.Address{receiver, receiver}, []*big.Int{max, big.NewInt(2)},
+			[][32]byte{{1}, {2}}, []*big.Int{timeLock, timeLock}, [][32]byte{{3}, {4}})
+		So(err, ShouldNotBeNil)
+
+		//the same amounts without the wrap
+		_, err = h.transact(ctx, htlc.HTLCV2ABI, 3, "newContracts",
+			[]common.Address{receiver, receiver}, []*big.Int{big.NewInt(1), big.NewInt(2)},
+			[][32]byte{{1}, {2}}, []*big.Int{timeLock, timeLock}, [][32]byte{{3}, {4}})
+		So(err, ShouldBeNil)
+		sim.Commit()
+	})
+}
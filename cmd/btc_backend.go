@@ -0,0 +1,330 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/icodezjb/atomicswap/btc"
+
+	"github.com/pkg/errors"
+)
+
+// BTCConfig is the wallet of the bitcoind (or btcd with btcwallet) node
+// serving a chain of type "btc". The node is reached at the url of the chain.
+type BTCConfig struct {
+	Network  string `json:"network"`
+	User     string `json:"rpcUser"`
+	Password string `json:"rpcPassword"`
+	//the wallet address that refunds and redeems are paid to
+	Address string `json:"address"`
+	//sat/vbyte, estimated by the node if 0
+	FeeRate int64 `json:"feeRate"`
+	//confirmations of the funding tx before a contract passes the audit, 1 if 0
+	MinConf int64 `json:"minConf"`
+}
+
+func (c *BTCConfig) minConf() int64 {
+	if c.MinConf <= 0 {
+		return 1
+	}
+	return c.MinConf
+}
+
+func (c *Config) connectBTC() error {
+	if c.BTC == nil {
+		return errors.New("no btc section in config")
+	}
+
+	net, err := btc.NetParams(c.BTC.Network)
+	if err != nil {
+		return err
+	}
+
+	c.btc = &btc.Client{
+		RPC:     btc.NewRPCClient(c.Chain.URL, c.BTC.User, c.BTC.Password),
+		Net:     net,
+		FeeRate: c.BTC.FeeRate,
+	}
+
+	return nil
+}
+
+// unlockBTC loads the key of the configured address, either from wif or
+// exported from the node wallet.
+func (c *Config) unlockBTC(wif string) error {
+	if wif == "" {
+		var err error
+		if wif, err = c.btc.RPC.DumpPrivKey(context.Background(), c.BTC.Address); err != nil {
+			return errors.Wrapf(err, "dump key of %v", c.BTC.Address)
+		}
+	}
+
+	key, err := c.btc.Net.DecodeWIF(wif)
+	if err != nil {
+		return err
+	}
+
+	pkh, err := c.btc.Net.DecodePubKeyHash(c.BTC.Address)
+	if err != nil {
+		return err
+	}
+
+	if btc.PubKeyHash(key) != pkh {
+		return errors.Errorf("mismatch WIF key and address (%s)", c.BTC.Address)
+	}
+
+	c.btcKey = key
+
+	return nil
+}
+
+// btcBackend drives P2WSH HTLCs through the node wallet.
+type btcBackend struct {
+	h *Handler
+}
+
+func (b *btcBackend) client() *btc.Client {
+	return b.h.Config.btc
+}
+
+func (b *btcBackend) key() (*ecdsa.PrivateKey, error) {
+	if b.h.Config.btcKey == nil {
+		return nil, errors.New("btc key is locked")
+	}
+	return b.h.Config.btcKey, nil
+}
+
+func (b *btcBackend) Lock(ctx context.Context, receiver string, amount *big.Int, hashLock [32]byte, timeLock *big.Int) (string, string, error) {
+	net := b.client().Net
+
+	receiverPKH, err := net.DecodePubKeyHash(receiver)
+	if err != nil {
+		return "", "", err
+	}
+
+	senderPKH, err := net.DecodePubKeyHash(b.h.Config.BTC.Address)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !amount.IsInt64() {
+		return "", "", errors.Errorf("amount %v out of range", amount)
+	}
+
+	htlc := &btc.HTLC{
+		Hashlock:    hashLock,
+		ReceiverPKH: receiverPKH,
+		SenderPKH:   senderPKH,
+		LockTime:    timeLock.Int64(),
+	}
+
+	log.Println("Fund HTLC ...")
+	log.Printf("contract address = %v", net.WitnessScriptHashAddress(htlc.Script()))
+
+	b.h.Config.promptConfirm("Fund")
+
+	contract, err := b.client().Lock(ctx, htlc, amount.Int64())
+	if err != nil {
+		return "", "", err
+	}
+
+	return contract.ID(), contract.TxID, nil
+}
+
+func (b *btcBackend) Audit(ctx context.Context, contractId string) (*SwapContract, error) {
+	contract, err := btc.ParseContractID(contractId)
+	if err != nil {
+		return nil, err
+	}
+
+	htlc, err := b.client().Audit(ctx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	//an unconfirmed funding tx can still be double spent after the secret is out
+	confirmations, err := b.client().RPC.GetConfirmations(ctx, contract.TxID)
+	if err != nil {
+		return nil, err
+	}
+	if minConf := b.h.Config.BTC.minConf(); confirmations < minConf {
+		return nil, errors.Errorf("funding tx %v has %d confirmations, %d required", contract.TxID, confirmations, minConf)
+	}
+
+	net := b.client().Net
+	c := &SwapContract{
+		ID:       contractId,
+		Sender:   net.WitnessPubKeyHashAddress(htlc.SenderPKH),
+		Receiver: net.WitnessPubKeyHashAddress(htlc.ReceiverPKH),
+		Amount:   big.NewInt(contract.Value),
+		Hashlock: htlc.Hashlock,
+		Timelock: big.NewInt(htlc.LockTime),
+	}
+
+	spend, err := b.client().FindSpend(ctx, contract)
+	if err != nil {
+		return nil, err
+	}
+
+	if spend != nil {
+		c.Preimage, c.Withdrawn = btc.ExtractSecret(spend, contract)
+		c.Refunded = !c.Withdrawn
+	}
+
+	return c, nil
+}
+
+func (b *btcBackend) Redeem(ctx context.Context, contractId string, secret [32]byte) (string, error) {
+	contract, err := btc.ParseContractID(contractId)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := b.key()
+	if err != nil {
+		return "", err
+	}
+
+	log.Println("Redeem HTLC ...")
+
+	b.h.Config.promptConfirm("Redeem")
+
+	tx, err := b.client().Redeem(ctx, contract, secret, key)
+	if err != nil {
+		return "", err
+	}
+
+	return tx.TxHash().String(), nil
+}
+
+func (b *btcBackend) Refund(ctx context.Context, contractId string) (string, error) {
+	contract, err := btc.ParseContractID(contractId)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := b.key()
+	if err != nil {
+		return "", err
+	}
+
+	log.Println("Refund HTLC ...")
+
+	b.h.Config.promptConfirm("Refund")
+
+	tx, err := b.client().Refund(ctx, contract, key)
+	if err != nil {
+		return "", err
+	}
+
+	return tx.TxHash().String(), nil
+}
+
+func (b *btcBackend) ExtractSecret(ctx context.Context, contractId string) ([32]byte, error) {
+	var secret [32]byte
+
+	contract, err := btc.ParseContractID(contractId)
+	if err != nil {
+		return secret, err
+	}
+
+	tx, err := b.client().FindSpend(ctx, contract)
+	if err != nil {
+		return secret, err
+	}
+
+	if tx == nil {
+		return secret, errors.Errorf("contractId %v is not redeemed yet", contractId)
+	}
+
+	secret, ok := btc.ExtractSecret(tx, contract)
+	if !ok {
+		return secret, errors.Errorf("contractId %v is refunded by %v", contractId, tx.TxHash())
+	}
+
+	return secret, nil
+}
+
+func (b *btcBackend) WatchEvents(ctx context.Context, contractId string, sink chan<- *SwapEvent) error {
+	contract, err := btc.ParseContractID(contractId)
+	if err != nil {
+		return err
+	}
+
+	send := func(event *SwapEvent) error {
+		select {
+		case sink <- event:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	funded := false
+	for {
+		if !funded {
+			confirmations, err := b.client().RPC.GetConfirmations(ctx, contract.TxID)
+			if err != nil {
+				return err
+			}
+
+			if confirmations > 0 {
+				funded = true
+				if err := send(&SwapEvent{Type: EventNew, ContractID: contractId, TxID: contract.TxID}); err != nil {
+					return err
+				}
+			}
+		}
+
+		spend, err := b.client().FindSpend(ctx, contract)
+		if err != nil {
+			return err
+		}
+
+		if spend != nil {
+			event := &SwapEvent{Type: EventRefund, ContractID: contractId, TxID: spend.TxHash().String()}
+
+			if secret, ok := btc.ExtractSecret(spend, contract); ok {
+				event.Type = EventWithdraw
+				event.Secret = secret
+			}
+
+			return send(event)
+		}
+
+		select {
+		case <-time.After(watchInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *btcBackend) Confirmations(ctx context.Context, txid string) (uint64, error) {
+	confirmations, err := b.client().RPC.GetConfirmations(ctx, txid)
+	if err != nil {
+		return 0, err
+	}
+
+	if confirmations < 0 {
+		return 0, nil
+	}
+
+	return uint64(confirmations), nil
+}
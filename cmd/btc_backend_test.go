@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/icodezjb/atomicswap/btc"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testBitcoind answers the calls of an audit for a single funding tx, with
+// an empty mempool and empty blocks.
+type testBitcoind struct {
+	funding       *btc.Tx
+	confirmations int64
+}
+
+func (b *testBitcoind) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var call struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	_ = json.NewDecoder(req.Body).Decode(&call)
+
+	var result interface{}
+	switch call.Method {
+	case "getrawtransaction":
+		if string(call.Params[1]) == "true" {
+			result = map[string]int64{"confirmations": b.confirmations}
+		} else {
+			result = hex.EncodeToString(b.funding.Serialize(true))
+		}
+	case "getrawmempool":
+		result = []string{}
+	case "getblockcount":
+		result = 100
+	case "getblockhash":
+		result = "00"
+	case "getblock":
+		result = map[string][]string{"tx": {}}
+	}
+
+	_ = json.NewEncoder(rw).Encode(map[string]interface{}{"result": result})
+}
+
+func TestBTCAuditConfirmations(t *testing.T) {
+	secret := [32]byte{0xaa}
+	htlc := &btc.HTLC{Hashlock: sha256.Sum256(secret[:]), LockTime: 1577836800}
+	htlc.ReceiverPKH[0] = 0x11
+	htlc.SenderPKH[0] = 0x22
+	script := htlc.Script()
+
+	node := &testBitcoind{funding: &btc.Tx{
+		Version: 2,
+		TxIn:    []*btc.TxIn{{Sequence: btc.MaxTxInSequenceNum}},
+		TxOut:   []*btc.TxOut{{Value: 50000, PkScript: btc.WitnessScriptHash(script)}},
+	}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	config := &Config{
+		BTC: &BTCConfig{},
+		btc: &btc.Client{RPC: btc.NewRPCClient(server.URL, "", ""), Net: &btc.RegTestParams},
+	}
+	backend := &btcBackend{h: &Handler{Config: config}}
+
+	contractId := (&btc.Contract{TxID: node.funding.TxHash().String(), Script: script}).ID()
+	ctx := context.Background()
+
+	Convey("a contract is audited once its funding tx has the confirmations", t, func() {
+		node.confirmations = 0
+		_, err := backend.Audit(ctx, contractId)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "0 confirmations, 1 required")
+
+		node.confirmations = 1
+		c, err := backend.Audit(ctx, contractId)
+		So(err, ShouldBeNil)
+		So(c.Amount.Int64(), ShouldEqual, 50000)
+
+		config.BTC.MinConf = 6
+		_, err = backend.Audit(ctx, contractId)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "1 confirmations, 6 required")
+
+		node.confirmations = 6
+		_, err = backend.Audit(ctx, contractId)
+		So(err, ShouldBeNil)
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"log"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+// CancelHash returns the eth_sign digest the receiver of contractId signs to
+// let the HashedTimelockV2 at contract refund it before the timelock.
+func CancelHash(contract common.Address, contractId common.Hash) []byte {
+	message := crypto.Keccak256(contract.Bytes(), contractId.Bytes(), []byte("cancel"))
+	return accounts.TextHash(message)
+}
+
+// RecoverCanceller returns the account that signed the cancellation of
+// contractId with signature.
+func RecoverCanceller(contract common.Address, contractId common.Hash, signature []byte) (common.Address, error) {
+	if len(signature) != crypto.SignatureLength {
+		return common.Address{}, errors.Errorf("cancel signature of %d bytes, want %d", len(signature), crypto.SignatureLength)
+	}
+
+	//accept eth_sign signatures with v = 27/28 as well
+	sig := common.CopyBytes(signature)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	pub, err := crypto.SigToPub(CancelHash(contract, contractId), sig)
+	if err != nil {
+		return common.Address{}, errors.Wrap(err, "recover cancel signature")
+	}
+
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// CancelSignature signs, as the receiver of contractId, the agreement to
+// call off the swap, which the sender then submits with Cancel.
+func (h *Handler) CancelSignature(ctx context.Context, contractId common.Hash) ([]byte, error) {
+	if err := h.requireV2("cancel"); err != nil {
+		return nil, err
+	}
+
+	details, err := h.openContract(ctx, contractId)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.Receiver != common.HexToAddress(h.Config.Account) {
+		return nil, errors.Errorf("account %v is not the receiver %v of %v", h.Config.Account, details.Receiver.String(), contractId.Hex())
+	}
+
+	return h.signCancel(contractId)
+}
+
+func (h *Handler) signCancel(contractId common.Hash) ([]byte, error) {
+	sig, err := h.signHash(CancelHash(common.HexToAddress(h.Config.Chain.Contract), contractId))
+	if err != nil {
+		return nil, errors.Wrapf(err, "account=%v sign cancel", h.Config.Account)
+	}
+
+	//the contract ecrecover wants v = 27/28
+	sig[crypto.RecoveryIDOffset] += 27
+
+	return sig, nil
+}
+
+// Cancel refunds contractId to its sender before the timelock, with the
+// signature of the receiver from CancelSignature. It checks the signature
+// first so that the sender does not pay for a revert.
+func (h *Handler) Cancel(ctx context.Context, contractId common.Hash, signature []byte) (Transaction, error) {
+	if err := h.requireV2("cancel"); err != nil {
+		return nil, err
+	}
+
+	details, err := h.openContract(ctx, contractId)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := RecoverCanceller(common.HexToAddress(h.Config.Chain.Contract), contractId, signature)
+	if err != nil {
+		return nil, err
+	}
+	if signer != details.Receiver {
+		return nil, errors.Errorf("cancel of %v signed by %v, not by the receiver %v", contractId.Hex(), signer.String(), details.Receiver.String())
+	}
+
+	log.Printf("Cancel %v back to %v", contractId.Hex(), details.Sender.String())
+
+	var r, s [32]byte
+	copy(r[:], signature[:32])
+	copy(s[:], signature[32:64])
+	v := signature[crypto.RecoveryIDOffset]
+	if v < 27 {
+		v += 27
+	}
+
+	return h.transact(ctx, htlc.HTLCV2ABI, 0, "cancel", contractId, v, r, s)
+}
+
+// requireV2 fails unless the contract of the connected chain is a
+// HashedTimelockV2.
+func (h *Handler) requireV2(feature string) error {
+	if h.Config.Chain == nil {
+		return errors.New("not connected")
+	}
+
+	if !h.Config.Chain.IsV2() {
+		return errors.Errorf("contract %v is not a HashedTimelockV2, which %v needs", h.Config.Chain.Contract, feature)
+	}
+
+	return nil
+}
+
+// signHash signs hash with the unlocked account.
+func (h *Handler) signHash(hash []byte) ([]byte, error) {
+	switch {
+	case h.Config.key != nil:
+		return crypto.Sign(hash, h.Config.key)
+	case h.Config.ks != nil:
+		return h.Config.ks.SignHashWithPassphrase(
+			accounts.Account{Address: common.HexToAddress(h.Config.Account)},
+			h.Config.Password,
+			hash)
+	default:
+		return nil, errors.New("account is locked")
+	}
+}
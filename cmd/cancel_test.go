@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCancelSignature(t *testing.T) {
+	receiverKey, _ := crypto.GenerateKey()
+	receiver := crypto.PubkeyToAddress(receiverKey.PublicKey)
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	contractId := common.HexToHash("0x1234")
+
+	h := &Handler{Config: &Config{
+		Account: receiver.String(),
+		Chain:   &chain{Contract: contract.String(), Version: HTLCVersion2},
+		key:     receiverKey,
+	}}
+
+	Convey("the signature recovers to the receiver of that contract only", t, func() {
+		sig, err := h.signCancel(contractId)
+		So(err, ShouldBeNil)
+		So(sig[crypto.RecoveryIDOffset], ShouldBeIn, []byte{27, 28})
+
+		signer, err := RecoverCanceller(contract, contractId, sig)
+		So(err, ShouldBeNil)
+		So(signer, ShouldEqual, receiver)
+
+		signer, err = RecoverCanceller(contract, common.HexToHash("0x1235"), sig)
+		So(err, ShouldBeNil)
+		So(signer, ShouldNotEqual, receiver)
+
+		signer, err = RecoverCanceller(common.HexToAddress("0xc2"), contractId, sig)
+		So(err, ShouldBeNil)
+		So(signer, ShouldNotEqual, receiver)
+
+		_, err = RecoverCanceller(contract, contractId, sig[:64])
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("the contract takes the signature as v, r and s", t, func() {
+		parsedABI, err := abi.JSON(strings.NewReader(htlc.HTLCV2ABI))
+		So(err, ShouldBeNil)
+
+		sig, _ := h.signCancel(contractId)
+		var r, s [32]byte
+		copy(r[:], sig[:32])
+		copy(s[:], sig[32:64])
+
+		_, err = parsedABI.Pack("cancel", contractId, sig[64], r, s)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("refuse a HashedTimelock chain", t, func() {
+		v1 := &Handler{Config: &Config{Account: receiver.String(), Chain: &chain{Contract: contract.String()}, key: receiverKey}}
+
+		_, err := v1.CancelSignature(context.Background(), contractId)
+		So(err, ShouldNotBeNil)
+
+		_, err = v1.Cancel(context.Background(), contractId, make([]byte, 65))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCancelSim(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	watcherKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey, watcherKey)
+	defer sim.Close()
+	testDeployV2(t, sim, hs)
+
+	var (
+		ctx      = context.Background()
+		hashPair = testHashPair()
+		timeLock = big.NewInt(time.Now().Unix() + 3600)
+		receiver = common.HexToAddress(hs[1].Config.Account)
+	)
+
+	backend, err := hs[0].Backend()
+	TMust(t, err)
+
+	Convey("a cancelled contract cannot be withdrawn", t, func() {
+		contractId, _, err := backend.Lock(ctx, receiver.String(), big.NewInt(1000), hashPair.Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+		id := common.HexToHash(contractId)
+
+		signature, err := hs[1].CancelSignature(ctx, id)
+		So(err, ShouldBeNil)
+		_, err = hs[0].Cancel(ctx, id, signature)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		before, err := sim.BalanceAt(ctx, receiver, nil)
+		So(err, ShouldBeNil)
+
+		//withdraw straight on the contract, past the checks of RedeemFor
+		_, err = hs[2].transact(ctx, htlc.HTLCV2ABI, 0, "withdraw", id, hashPair.Secret)
+		So(err, ShouldNotBeNil)
+		_, err = hs[2].transact(ctx, htlc.HTLCV2ABI, 0, "withdrawMany", [][32]byte{id}, [][32]byte{hashPair.Secret})
+		So(err, ShouldNotBeNil)
+		sim.Commit()
+
+		after, err := sim.BalanceAt(ctx, receiver, nil)
+		So(err, ShouldBeNil)
+		So(after, ShouldResemble, before)
+
+		c, err := backend.Audit(ctx, contractId)
+		So(err, ShouldBeNil)
+		So(c.Refunded, ShouldBeTrue)
+		So(c.Withdrawn, ShouldBeFalse)
+	})
+
+	Convey("refuse a contract of the zero receiver", t, func() {
+		_, err := hs[0].transact(ctx, htlc.HTLCV2ABI, 1000, "newContract", common.Address{}, hashPair.Hash, timeLock, [32]byte{1})
+		So(err, ShouldNotBeNil)
+	})
+}
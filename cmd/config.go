@@ -27,12 +27,16 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/icodezjb/atomicswap/btc"
+
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/pkg/errors"
 )
 
@@ -46,25 +50,76 @@ func init() {
 type chain struct {
 	ID       *big.Int
 	Name     string
+	Type     string
 	URL      string
 	Contract string
+	//HashedTimelock version of Contract
+	Version int
+	Gas     *GasPolicy
+	RPC     *RPCPolicy
+}
+
+// IsV2 tells if Contract has the interface of HashedTimelockV2, which
+// HashedTimelockFee extends.
+func (c *chain) IsV2() bool {
+	return c.Version == HTLCVersion2 || c.Version == HTLCVersionFee
+}
+
+// ethClient is the part of ethclient.Client used by the handler, so that a
+// simulated backend can stand in for a node.
+type ethClient interface {
+	bind.ContractBackend
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, bool, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+}
+
+// rpcClient is the raw JSON-RPC of a node, for the methods ethclient lacks.
+type rpcClient interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
 }
 
 type Config struct {
 	ChainID        *big.Int `json:"chainID"`
 	ChainName      string   `json:"chainName"`
+	ChainType      string   `json:"chainType,omitempty"`
 	URL            string   `json:"url"`
 	OtherChainID   *big.Int `json:"otherChainID"`
 	OtherChainName string   `json:"otherChainName"`
+	OtherChainType string   `json:"otherChainType,omitempty"`
 	OtherURL       string   `json:"otherURL"`
 	Account        string   `json:"account"`
 	Contract       string   `json:"contract"`
-	KeyStore       string   `json:"keystoreDir"`
-	Password       string   `json:"password"`
-	Chain          *chain   `json:"-"`
-	client         *ethclient.Client
-	ks             *keystore.KeyStore
-	key            *ecdsa.PrivateKey
+	MultiContract  string   `json:"multiContract,omitempty"`
+	//HashedTimelockERC721 and HashedTimelockERC1155
+	ERC721Contract  string `json:"erc721Contract,omitempty"`
+	ERC1155Contract string `json:"erc1155Contract,omitempty"`
+	//HashedTimelock versions of contract and of the contract on the other chain
+	ContractVersion      int    `json:"contractVersion,omitempty"`
+	OtherContractVersion int    `json:"otherContractVersion,omitempty"`
+	KeyStore             string `json:"keystoreDir"`
+	Password             string `json:"password"`
+	Store                string `json:"store,omitempty"`
+	//account that redeems and refunds HashedTimelockV2 contracts for their owners
+	Operator string `json:"operator,omitempty"`
+	//gas policies of the own chain and of the other chain
+	Gas      *GasPolicy `json:"gas,omitempty"`
+	OtherGas *GasPolicy `json:"otherGas,omitempty"`
+	//endpoints of the own chain and of the other chain besides url and otherURL
+	RPC      *RPCPolicy `json:"rpc,omitempty"`
+	OtherRPC *RPCPolicy `json:"otherRPC,omitempty"`
+	//chainID => allowlist of the deployed HashedTimelock contracts
+	TrustedContracts map[string][]string `json:"trustedContracts,omitempty"`
+	BTC              *BTCConfig          `json:"btc,omitempty"`
+	Chain            *chain              `json:"-"`
+	client           ethClient
+	btc              *btc.Client
+	btcKey           *ecdsa.PrivateKey
+	rpc              rpcClient
+	ks               *keystore.KeyStore
+	key              *ecdsa.PrivateKey
 
 	//only for test
 	test bool
@@ -106,6 +161,17 @@ type ContractDetails struct {
 	Preimage  [32]byte
 }
 
+type MultiContractDetails struct {
+	Sender    common.Address
+	Receiver  common.Address
+	Amount    *big.Int
+	Hashlocks [][32]byte
+	Timelock  *big.Int
+	Withdrawn bool
+	Refunded  bool
+	Preimages [][32]byte
+}
+
 func (c *Config) ParseConfig(cfgPath string) error {
 	configFile, err := os.Open(cfgPath)
 	defer configFile.Close() //nolint:staticcheck
@@ -126,34 +192,119 @@ func (c *Config) ParseConfig(cfgPath string) error {
 	return nil
 }
 
+// Connect connects to the own chain, or to the other chain if otherContract
+// is set. otherContract is the contract address for an EVM chain and is
+// otherwise only a selector, e.g. the chain name.
 func (c *Config) Connect(otherContract string) error {
-	c.Chain = &chain{
+	if otherContract != "" {
+		return c.connect(c.otherChain(otherContract))
+	}
+
+	return c.connect(c.ownChain(c.Contract))
+}
+
+// ownChain is the own chain with contract.
+func (c *Config) ownChain(contract string) *chain {
+	return &chain{
 		ID:       c.ChainID,
 		Name:     c.ChainName,
+		Type:     c.ChainType,
 		URL:      c.URL,
-		Contract: c.Contract,
+		Contract: contract,
+		Version:  c.ContractVersion,
+		Gas:      c.Gas,
+		RPC:      c.RPC,
 	}
+}
 
-	if otherContract != "" {
-		c.Chain = &chain{
-			ID:       c.OtherChainID,
-			Name:     c.OtherChainName,
-			URL:      c.OtherURL,
-			Contract: otherContract,
-		}
+// otherChain is the other chain with contract.
+func (c *Config) otherChain(contract string) *chain {
+	return &chain{
+		ID:       c.OtherChainID,
+		Name:     c.OtherChainName,
+		Type:     c.OtherChainType,
+		URL:      c.OtherURL,
+		Contract: contract,
+		Version:  c.OtherContractVersion,
+		Gas:      c.OtherGas,
+		RPC:      c.OtherRPC,
+	}
+}
+
+// connect checks the policies of ch and connects to it.
+func (c *Config) connect(ch *chain) error {
+	c.Chain = ch
+
+	if err := c.Chain.Gas.validate(); err != nil {
+		return errors.Wrapf(err, "gas policy of %v", c.Chain.Name)
+	}
+
+	if err := c.Chain.RPC.validate(); err != nil {
+		return errors.Wrapf(err, "rpc policy of %v", c.Chain.Name)
+	}
+
+	return c.dial()
+}
+
+// dial connects to c.Chain.
+func (c *Config) dial() error {
+	switch c.Chain.Type {
+	case "", ChainEVM:
+		c.Chain.Type = ChainEVM
+	case ChainBTC:
+		c.Chain.Contract = ""
+		return c.connectBTC()
+	default:
+		return errors.Errorf("unknown chain type: %v", c.Chain.Type)
 	}
 
-	client, err := ethclient.Dial(c.Chain.URL)
+	if c.Chain.RPC.multiEndpoint() {
+		return c.dialEndpoints()
+	}
+
+	client, err := rpc.Dial(c.Chain.URL)
 	if err != nil {
 		return errors.Wrapf(err, "connect to %v", c.Chain.URL)
 	}
 
-	c.client = client
+	c.rpc = client
+	c.client = ethclient.NewClient(client)
 
 	return nil
 }
 
+// IsOtherChain reports whether the chain name or chainID refers to the other chain.
+func (c *Config) IsOtherChain(chain string) (bool, error) {
+	switch {
+	case chain == c.ChainName || (c.ChainID != nil && chain == c.ChainID.String()):
+		return false, nil
+	case chain == c.OtherChainName || (c.OtherChainID != nil && chain == c.OtherChainID.String()):
+		return true, nil
+	default:
+		return false, errors.Errorf("unknown chain: %v", chain)
+	}
+}
+
+func (c *Config) trusted(contract common.Address) bool {
+	allowlist, ok := c.TrustedContracts[c.Chain.ID.String()]
+	if !ok {
+		return true
+	}
+
+	for _, address := range allowlist {
+		if common.HexToAddress(address) == contract {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *Config) Unlock(privateKey string) error {
+	if c.Chain != nil && c.Chain.Type == ChainBTC {
+		return c.unlockBTC(privateKey)
+	}
+
 	switch {
 	case privateKey != "":
 		key, err := crypto.HexToECDSA(privateKey)
@@ -210,33 +361,53 @@ func (c *Config) rotate(cfgPath string) error {
 	return os.Rename(cfgPath+".new", cfgPath)
 }
 
-func (c *Config) makeAuth(ctx context.Context, value int64) (*bind.TransactOpts, error) {
+// txAuth is a bind.TransactOpts that also prices dynamic fee transactions,
+// whose max fee per gas is GasPrice.
+type txAuth struct {
+	*bind.TransactOpts
+	//max priority fee per gas and base fee of a dynamic fee transaction
+	GasTipCap *big.Int
+	BaseFee   *big.Int
+	//Nonce is the one of a replaced transaction, not one of the nonce manager
+	keepNonce bool
+}
+
+// makeAuth returns the options of a transaction from the account, without
+// its nonce: sendTx takes it from the nonce manager.
+func (c *Config) makeAuth(ctx context.Context, value int64) (*txAuth, error) {
 	fromAccount := accounts.Account{Address: common.HexToAddress(c.Account)}
-	nonce, err := c.client.PendingNonceAt(ctx, fromAccount.Address)
-	if err != nil {
-		return nil, errors.Wrapf(err, "account=%v get nonce", c.Account)
-	}
 
-	gasPrice, err := c.client.SuggestGasPrice(ctx)
+	opts, err := bind.NewKeyStoreTransactor(c.ks, fromAccount)
 	if err != nil {
-		return nil, errors.Wrapf(err, "account=%v get gasPrice", c.Account)
+		return nil, errors.Wrapf(err, "account=%v get keystore transactor", c.Account)
 	}
 
-	auth, err := bind.NewKeyStoreTransactor(c.ks, fromAccount)
-	if err != nil {
-		return nil, errors.Wrapf(err, "account=%v get keystore transactor", c.Account)
+	auth := &txAuth{TransactOpts: opts}
+
+	if c.Chain.Gas.dynamic() {
+		if auth.BaseFee, auth.GasTipCap, auth.GasPrice, err = c.dynamicFees(ctx); err != nil {
+			return nil, err
+		}
+	} else if auth.GasPrice, err = c.gasPrice(ctx); err != nil {
+		return nil, err
 	}
 
-	auth.Nonce = big.NewInt(int64(nonce))
 	auth.Value = big.NewInt(value) //in wei
 	auth.GasLimit = gasLimit
 
-	gasPriceInt, _ := big.NewInt(0).SetString(gasPrice.String(), 10)
-	auth.GasPrice = gasPriceInt
-
 	return auth, nil
 }
 
+// pendingNonce returns the pending nonce of the account on the node.
+func (c *Config) pendingNonce(ctx context.Context) (*big.Int, error) {
+	nonce, err := c.client.PendingNonceAt(ctx, common.HexToAddress(c.Account))
+	if err != nil {
+		return nil, errors.Wrapf(err, "account=%v get nonce", c.Account)
+	}
+
+	return new(big.Int).SetUint64(nonce), nil
+}
+
 func (c *Config) promptConfirm(prefix string) {
 	log.Printf("? Confirm to %v the contract on %v(chainID = %v)? [y/N]", prefix, c.Chain.Name, c.Chain.ID)
 
@@ -0,0 +1,298 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/pkg/errors"
+)
+
+// transaction types of a gas policy
+const (
+	TxLegacy     = "legacy"
+	TxDynamicFee = "dynamic"
+)
+
+// EIP-2718 type of DynamicFeeTx
+const dynamicFeeTxType = 0x02
+
+// the median priority fee of the recent blocks, unless the policy has a
+// percentile
+const defaultTipPercentile = 50
+
+// Transaction is a signed transaction sent by the handler: a legacy
+// *types.Transaction or a *DynamicFeeTx.
+type Transaction interface {
+	Hash() common.Hash
+	Nonce() uint64
+	To() *common.Address
+	Value() *big.Int
+	Gas() uint64
+	//the max fee per gas of a DynamicFeeTx
+	GasPrice() *big.Int
+	Data() []byte
+}
+
+// DynamicFeeTx is a signed EIP-1559 transaction, which the core types of
+// go-ethereum v1.9 do not know. It has an empty access list.
+type DynamicFeeTx struct {
+	chainID   *big.Int
+	nonce     uint64
+	gasTipCap *big.Int
+	gasFeeCap *big.Int
+	gas       uint64
+	to        *common.Address
+	value     *big.Int
+	data      []byte
+
+	v    uint64
+	r, s *big.Int
+}
+
+func (tx *DynamicFeeTx) Nonce() uint64        { return tx.nonce }
+func (tx *DynamicFeeTx) To() *common.Address  { return tx.to }
+func (tx *DynamicFeeTx) Value() *big.Int      { return tx.value }
+func (tx *DynamicFeeTx) Gas() uint64          { return tx.gas }
+func (tx *DynamicFeeTx) GasPrice() *big.Int   { return tx.gasFeeCap }
+func (tx *DynamicFeeTx) GasFeeCap() *big.Int  { return tx.gasFeeCap }
+func (tx *DynamicFeeTx) GasTipCap() *big.Int  { return tx.gasTipCap }
+func (tx *DynamicFeeTx) Data() []byte         { return tx.data }
+func (tx *DynamicFeeTx) ChainID() *big.Int    { return tx.chainID }
+func (tx *DynamicFeeTx) Hash() common.Hash    { return crypto.Keccak256Hash(tx.MarshalBinary()) }
+func (tx *DynamicFeeTx) SigHash() common.Hash { return crypto.Keccak256Hash(tx.encode(false)) }
+
+// MarshalBinary returns the signed transaction for eth_sendRawTransaction.
+func (tx *DynamicFeeTx) MarshalBinary() []byte {
+	return tx.encode(true)
+}
+
+// encode returns the type byte followed by the RLP list of the fields, with
+// the signature or without for the signing hash.
+func (tx *DynamicFeeTx) encode(signed bool) []byte {
+	to := []byte{}
+	if tx.to != nil {
+		to = tx.to.Bytes()
+	}
+
+	fields := []interface{}{
+		tx.chainID,
+		tx.nonce,
+		tx.gasTipCap,
+		tx.gasFeeCap,
+		tx.gas,
+		to,
+		tx.value,
+		tx.data,
+		[]interface{}{},
+	}
+	if signed {
+		fields = append(fields, tx.v, tx.r, tx.s)
+	}
+
+	//the fields are all encodable
+	payload, _ := rlp.EncodeToBytes(fields)
+
+	return append([]byte{dynamicFeeTxType}, payload...)
+}
+
+// withSignature sets the [R || S || V] signature of SigHash, V being 0 or 1.
+func (tx *DynamicFeeTx) withSignature(sig []byte) (*DynamicFeeTx, error) {
+	if len(sig) != crypto.SignatureLength {
+		return nil, errors.Errorf("signature of %d bytes, want %d", len(sig), crypto.SignatureLength)
+	}
+
+	signed := *tx
+	signed.r = new(big.Int).SetBytes(sig[:32])
+	signed.s = new(big.Int).SetBytes(sig[32:64])
+	signed.v = uint64(sig[crypto.RecoveryIDOffset])
+
+	return &signed, nil
+}
+
+// Sender recovers the account that signed tx.
+func (tx *DynamicFeeTx) Sender() (common.Address, error) {
+	if tx.r == nil {
+		return common.Address{}, errors.New("unsigned transaction")
+	}
+
+	sig := make([]byte, crypto.SignatureLength)
+	copy(sig[32-len(tx.r.Bytes()):32], tx.r.Bytes())
+	copy(sig[64-len(tx.s.Bytes()):64], tx.s.Bytes())
+	sig[crypto.RecoveryIDOffset] = byte(tx.v)
+
+	pub, err := crypto.SigToPub(tx.SigHash().Bytes(), sig)
+	if err != nil {
+		return common.Address{}, errors.Wrap(err, "recover sender")
+	}
+
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// decodeTx decodes a signed transaction encoded for eth_sendRawTransaction,
+// a legacy one or a DynamicFeeTx.
+func decodeTx(raw []byte) (Transaction, error) {
+	if len(raw) == 0 || raw[0] != dynamicFeeTxType {
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(raw, tx); err != nil {
+			return nil, errors.Wrap(err, "decode tx")
+		}
+		return tx, nil
+	}
+
+	var fields struct {
+		ChainID    *big.Int
+		Nonce      uint64
+		GasTipCap  *big.Int
+		GasFeeCap  *big.Int
+		Gas        uint64
+		To         []byte
+		Value      *big.Int
+		Data       []byte
+		AccessList rlp.RawValue
+		V          uint64
+		R, S       *big.Int
+	}
+	if err := rlp.DecodeBytes(raw[1:], &fields); err != nil {
+		return nil, errors.Wrap(err, "decode dynamic fee tx")
+	}
+
+	tx := &DynamicFeeTx{
+		chainID:   fields.ChainID,
+		nonce:     fields.Nonce,
+		gasTipCap: fields.GasTipCap,
+		gasFeeCap: fields.GasFeeCap,
+		gas:       fields.Gas,
+		value:     fields.Value,
+		data:      fields.Data,
+		v:         fields.V,
+		r:         fields.R,
+		s:         fields.S,
+	}
+	if len(fields.To) > 0 {
+		to := common.BytesToAddress(fields.To)
+		tx.to = &to
+	}
+
+	return tx, nil
+}
+
+// signDynamicFeeTx is signTx for a dynamic fee chain.
+func (h *Handler) signDynamicFeeTx(auth *txAuth, data []byte, contract *common.Address) (*DynamicFeeTx, error) {
+	tx := &DynamicFeeTx{
+		chainID:   h.Config.Chain.ID,
+		nonce:     auth.Nonce.Uint64(),
+		gasTipCap: auth.GasTipCap,
+		gasFeeCap: auth.GasPrice,
+		gas:       auth.GasLimit,
+		to:        contract,
+		value:     auth.Value,
+		data:      data,
+	}
+
+	var (
+		sig []byte
+		err error
+	)
+
+	switch {
+	case h.Config.key != nil:
+		sig, err = crypto.Sign(tx.SigHash().Bytes(), h.Config.key)
+	case h.Config.ks != nil:
+		sig, err = h.Config.ks.SignHashWithPassphrase(
+			accounts.Account{Address: common.HexToAddress(h.Config.Account)},
+			h.Config.Password,
+			tx.SigHash().Bytes())
+	default:
+		return nil, errors.New("unexpected sendTx error")
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "account=%v sign tx ", h.Config.Account)
+	}
+
+	return tx.withSignature(sig)
+}
+
+type feeHistory struct {
+	BaseFee []*hexutil.Big   `json:"baseFeePerGas"`
+	Reward  [][]*hexutil.Big `json:"reward"`
+}
+
+// dynamicFees returns the base fee of the next block, and the max priority
+// fee and max fee per gas for it by the gas policy of c.Chain: the policy
+// priority fee or the percentile of the priority fees paid in the recent
+// blocks, and twice the base fee plus the priority fee.
+func (c *Config) dynamicFees(ctx context.Context) (baseFee, gasTipCap, gasFeeCap *big.Int, err error) {
+	if c.rpc == nil {
+		return nil, nil, nil, errors.Errorf("no JSON-RPC client for %v", c.Chain.Name)
+	}
+
+	p := c.Chain.Gas
+
+	blocks, percentile := p.Blocks, p.Percentile
+	if blocks == 0 {
+		blocks = defaultGasBlocks
+	}
+	if percentile == 0 {
+		percentile = defaultTipPercentile
+	}
+
+	var history feeHistory
+	if err := c.rpc.CallContext(ctx, &history, "eth_feeHistory", hexutil.Uint(blocks), "latest", []float64{float64(percentile)}); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "eth_feeHistory")
+	}
+
+	//baseFeePerGas ends with the base fee of the next block
+	if len(history.BaseFee) == 0 {
+		return nil, nil, nil, errors.Errorf("%v has no base fee, use legacy transactions", c.Chain.Name)
+	}
+	baseFee = history.BaseFee[len(history.BaseFee)-1].ToInt()
+
+	switch {
+	case p.PriorityFee != nil:
+		gasTipCap = new(big.Int).Set(p.PriorityFee)
+	default:
+		var rewards []*big.Int
+		for _, reward := range history.Reward {
+			if len(reward) > 0 {
+				rewards = append(rewards, reward[0].ToInt())
+			}
+		}
+
+		if gasTipCap = gasPricePercentile(rewards, defaultTipPercentile); gasTipCap == nil {
+			var tip hexutil.Big
+			if err := c.rpc.CallContext(ctx, &tip, "eth_maxPriorityFeePerGas"); err != nil {
+				return nil, nil, nil, errors.Wrap(err, "eth_maxPriorityFeePerGas")
+			}
+			gasTipCap = tip.ToInt()
+		}
+	}
+
+	gasFeeCap = new(big.Int).Mul(baseFee, big.NewInt(2))
+	gasFeeCap = p.clamp(gasFeeCap.Add(gasFeeCap, gasTipCap))
+
+	if gasTipCap.Cmp(gasFeeCap) > 0 {
+		gasTipCap = new(big.Int).Set(gasFeeCap)
+	}
+
+	return baseFee, gasTipCap, gasFeeCap, nil
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testRPC answers the fee history, a transaction and blocks by number, and
+// keeps the raw transactions sent.
+type testRPC struct {
+	history string
+	tx      string
+	blocks  map[string]string
+	sent    []hexutil.Bytes
+}
+
+func (r *testRPC) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	switch method {
+	case "eth_feeHistory":
+		return json.Unmarshal([]byte(r.history), result)
+	case "eth_maxPriorityFeePerGas":
+		return json.Unmarshal([]byte(`"0x5"`), result)
+	case "eth_getTransactionByHash":
+		return json.Unmarshal([]byte(r.tx), result)
+	case "eth_getBlockByNumber":
+		block, ok := r.blocks[args[0].(string)]
+		if !ok {
+			block = "null"
+		}
+		return json.Unmarshal([]byte(block), result)
+	case "eth_sendRawTransaction":
+		r.sent = append(r.sent, args[0].(hexutil.Bytes))
+		return nil
+	}
+	return errors.Errorf("unexpected %v", method)
+}
+
+func TestDynamicFeeTx(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("a DynamicFeeTx encodes and hashes as EIP-1559", t, func() {
+		key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		to := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+
+		//vectors of the London signer of go-ethereum v1.10
+		for _, v := range []struct {
+			to      *common.Address
+			sigHash string
+			raw     string
+			hash    string
+		}{
+			{&to,
+				"0x12f54ea4634b0798ef93561f1e79a7ed9f2b6ea19b854925985116c50c984947",
+				"0x02f870050784773594008506fc23ac00830186a09400000000000000000000000000000000000000c18203e882deadc080a06e6fb4dd71166d1f05f10f84fbef63d7bf7a4cb1280566dca4d8598fa9b7617fa061008337d422487336d6d561396a5d3962174d78cf1b360cd9b117f0b25446ea",
+				"0x3eb63f8e6fbd375be3e0b8d7167cc80fb1e30ce0cab56b2e09be2d84edce8997"},
+			{nil,
+				"0x1454709e1aa9d0c61e2cc280ec21e176ef123e40d2b638e01a658cc1676f29a6",
+				"0x02f85c050784773594008506fc23ac00830186a0808203e882deadc001a0a628b2c7fc7a6f0745c6e4d00a709ecfc7ff72a1f6d0aef9f7199ee555153466a020b77030f537df1e4c74ef218837a5b191f6f2a164c258653db8761dcc050bd8",
+				"0x620aeadf5facdd0ceb8f191ea63873653ecb185317c046e513ae3c429a5c5f0d"},
+		} {
+			tx := &DynamicFeeTx{
+				chainID:   big.NewInt(5),
+				nonce:     7,
+				gasTipCap: big.NewInt(2e9),
+				gasFeeCap: big.NewInt(30e9),
+				gas:       100000,
+				to:        v.to,
+				value:     big.NewInt(1000),
+				data:      []byte{0xde, 0xad},
+			}
+			So(tx.SigHash().Hex(), ShouldEqual, v.sigHash)
+
+			sig, err := crypto.Sign(tx.SigHash().Bytes(), key)
+			So(err, ShouldBeNil)
+			signed, err := tx.withSignature(sig)
+			So(err, ShouldBeNil)
+
+			So(hexutil.Encode(signed.MarshalBinary()), ShouldEqual, v.raw)
+			So(signed.Hash().Hex(), ShouldEqual, v.hash)
+
+			sender, err := signed.Sender()
+			So(err, ShouldBeNil)
+			So(sender, ShouldEqual, crypto.PubkeyToAddress(key.PublicKey))
+		}
+	})
+
+	Convey("a DynamicFeeTx of mainnet encodes and hashes as EIP-1559", t, func() {
+		usdt := common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7")
+		to := common.HexToAddress("0x3535353535353535353535353535353535353535")
+		transfer := hexutil.MustDecode("0xa9059cbb000000000000000000000000353535353535353535353535353535353535353500000000000000000000000000000000000000000000000000000000017d7840")
+		threeEther, _ := new(big.Int).SetString("3000000000000000000", 10)
+
+		//chain id 1, signed by the London signer of go-ethereum v1.10.26 with
+		//the key 4c0883a6...3f362318 of 0x2c7536E3605D9C16a7a3D7b1898e529396a65c23
+		for _, v := range []struct {
+			tx      *DynamicFeeTx
+			sigHash string
+			sig     string
+			raw     string
+			hash    string
+		}{
+			{&DynamicFeeTx{chainID: big.NewInt(1), nonce: 1337, gasTipCap: big.NewInt(1500000000), gasFeeCap: big.NewInt(42718390625),
+				gas: 65000, to: &usdt, value: big.NewInt(0), data: transfer},
+				"0xbcf973368b92e87ff77c2a19cc9c34ef8c95236cc742b5962b6d06f8bf8058ec",
+				"0x92baa346f4108047ef281f0fd3c060f31b5b357bbdf996c9b40a3abfa6d61a2036741b4af42905dd09945082ae77648c3f4515b2cd8d62da0acc9d4097dd3d0a01",
+				"0x02f8b2018205398459682f008509f236e96182fde894dac17f958d2ee523a2206206994597c13d831ec780b844a9059cbb000000000000000000000000353535353535353535353535353535353535353500000000000000000000000000000000000000000000000000000000017d7840c001a092baa346f4108047ef281f0fd3c060f31b5b357bbdf996c9b40a3abfa6d61a20a036741b4af42905dd09945082ae77648c3f4515b2cd8d62da0acc9d4097dd3d0a",
+				"0x628337d821d2ad30f2a576aac82a49abfca109b368e370b370ccfecaec6daa52"},
+			{&DynamicFeeTx{chainID: big.NewInt(1), nonce: 0, gasTipCap: big.NewInt(0), gasFeeCap: big.NewInt(20e9),
+				gas: 21000, to: &to, value: threeEther},
+				"0x26f96f39c83d0bb9632e8a22de4420bcd3c87d033266a6fef4ae9befa3678029",
+				"0x684e128800f106003744ad9219ad65a520d8921697281363f4bef51d6318c93c7039ae4ef009c77c8c326595ed4ef1ecc564879077ae2d9683d95540ae82face00",
+				"0x02f86f0180808504a817c8008252089435353535353535353535353535353535353535358829a2241af62c000080c080a0684e128800f106003744ad9219ad65a520d8921697281363f4bef51d6318c93ca07039ae4ef009c77c8c326595ed4ef1ecc564879077ae2d9683d95540ae82face",
+				"0x35a606af40e9bed2bfc49a60d949583e6084c12cef83b9c31c5defccad982513"},
+		} {
+			So(v.tx.SigHash().Hex(), ShouldEqual, v.sigHash)
+
+			signed, err := v.tx.withSignature(hexutil.MustDecode(v.sig))
+			So(err, ShouldBeNil)
+			So(hexutil.Encode(signed.MarshalBinary()), ShouldEqual, v.raw)
+			So(signed.Hash().Hex(), ShouldEqual, v.hash)
+
+			sender, err := signed.Sender()
+			So(err, ShouldBeNil)
+			So(sender.Hex(), ShouldEqual, "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23")
+
+			//the journal decodes it back
+			decoded, err := decodeTx(hexutil.MustDecode(v.raw))
+			So(err, ShouldBeNil)
+			So(decoded.Hash(), ShouldEqual, signed.Hash())
+			So(decoded.To(), ShouldResemble, v.tx.To())
+		}
+	})
+
+	Convey("the dynamic fees come from the fee history", t, func() {
+		rpc := &testRPC{history: `{"oldestBlock":"0x10","baseFeePerGas":["0x64","0x6e","0x78"],"gasUsedRatio":[0.5,0.6],"reward":[["0xa"],["0x14"],["0x1e"]]}`}
+		c := &Config{Chain: &chain{Name: "test", Gas: &GasPolicy{TxType: TxDynamicFee}}, rpc: rpc}
+
+		baseFee, tip, feeCap, err := c.dynamicFees(ctx)
+		So(err, ShouldBeNil)
+		So(baseFee.Int64(), ShouldEqual, 120)
+		So(tip.Int64(), ShouldEqual, 20)
+		So(feeCap.Int64(), ShouldEqual, 2*120+20)
+
+		c.Chain.Gas = &GasPolicy{TxType: TxDynamicFee, PriorityFee: big.NewInt(3), MaxPrice: big.NewInt(200)}
+		_, tip, feeCap, err = c.dynamicFees(ctx)
+		So(err, ShouldBeNil)
+		So(tip.Int64(), ShouldEqual, 3)
+		So(feeCap.Int64(), ShouldEqual, 200)
+
+		rpc.history = `{"oldestBlock":"0x10","baseFeePerGas":["0x64"],"gasUsedRatio":[],"reward":[]}`
+		c.Chain.Gas = &GasPolicy{TxType: TxDynamicFee}
+		_, tip, _, err = c.dynamicFees(ctx)
+		So(err, ShouldBeNil)
+		So(tip.Int64(), ShouldEqual, 5)
+
+		rpc.history = `{"oldestBlock":"0x10","baseFeePerGas":[],"gasUsedRatio":[],"reward":[]}`
+		_, _, _, err = c.dynamicFees(ctx)
+		So(err, ShouldNotBeNil)
+
+		So((&GasPolicy{TxType: "blob"}).validate(), ShouldNotBeNil)
+		So((&GasPolicy{TxType: TxDynamicFee, Price: big.NewInt(1)}).validate(), ShouldNotBeNil)
+		So((&GasPolicy{PriorityFee: big.NewInt(1)}).validate(), ShouldNotBeNil)
+	})
+
+	Convey("a dynamic fee chain sends signed type-2 transactions", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		rpc := &testRPC{history: `{"oldestBlock":"0x1","baseFeePerGas":["0x1","0x1"],"gasUsedRatio":[0.1],"reward":[["0x2"]]}`}
+		sender := handlers[0]
+		sender.Config.rpc = rpc
+		sender.Config.Chain.Gas = &GasPolicy{TxType: TxDynamicFee, Buffer: 1.2}
+
+		receiver := crypto.PubkeyToAddress(receiverKey.PublicKey)
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		tx, err := sender.NewContract(ctx, receiver, 1000, NewSecretHashPair().Hash, timeLock)
+		So(err, ShouldBeNil)
+		So(rpc.sent, ShouldHaveLength, 1)
+
+		dynamic, ok := tx.(*DynamicFeeTx)
+		So(ok, ShouldBeTrue)
+		So(dynamic.GasTipCap().Int64(), ShouldEqual, 2)
+		So(dynamic.GasFeeCap().Int64(), ShouldEqual, 4)
+		So(dynamic.ChainID(), ShouldResemble, sender.Config.Chain.ID)
+		So(dynamic.Value().Int64(), ShouldEqual, 1000)
+		So(dynamic.Gas(), ShouldBeLessThan, gasLimit)
+		So(crypto.Keccak256Hash(rpc.sent[0]), ShouldEqual, tx.Hash())
+
+		from, err := dynamic.Sender()
+		So(err, ShouldBeNil)
+		So(from, ShouldEqual, crypto.PubkeyToAddress(senderKey.PublicKey))
+
+		//the watchtower gets legacy transactions at the max fee per gas
+		presigned, err := sender.PresignRefund(ctx, common.HexToHash("0x01"))
+		So(err, ShouldBeNil)
+		So(presigned.GasPrice().Int64(), ShouldEqual, 4)
+	})
+}
@@ -0,0 +1,504 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+const (
+	//an endpoint more blocks than this behind the highest head is stale
+	defaultMaxLag = 5
+	//the heads of the endpoints are checked again after this long
+	headCheckInterval = 15 * time.Second
+	//time an endpoint gets to answer a head check
+	headCheckTimeout = 5 * time.Second
+)
+
+// RPCPolicy spreads the calls to a chain over several endpoints.
+type RPCPolicy struct {
+	//endpoints besides the url of the chain, tried after it in order
+	URLs []string `json:"urls,omitempty"`
+	//the reads an audit relies on, contract calls, code and receipts, must
+	//agree across this many endpoints, 0 for a single one
+	Quorum int `json:"quorum,omitempty"`
+	//defaultMaxLag if 0
+	MaxLag uint64 `json:"maxLag,omitempty"`
+}
+
+func (p *RPCPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+
+	switch {
+	case p.Quorum < 0:
+		return errors.Errorf("negative quorum %v", p.Quorum)
+	case p.Quorum > len(p.URLs)+1:
+		return errors.Errorf("quorum %v of %v endpoints", p.Quorum, len(p.URLs)+1)
+	}
+
+	return nil
+}
+
+// multiEndpoint tells if the calls to the chain go over several endpoints.
+func (p *RPCPolicy) multiEndpoint() bool {
+	return p != nil && (len(p.URLs) > 0 || p.Quorum > 1)
+}
+
+func (p *RPCPolicy) maxLag() uint64 {
+	if p == nil || p.MaxLag == 0 {
+		return defaultMaxLag
+	}
+	return p.MaxLag
+}
+
+// endpoint is a node of the chain.
+type endpoint struct {
+	url    string
+	client ethClient
+	rpc    rpcClient
+	//head at the last check, healthy if the endpoint answered it and is not
+	//stale
+	head    uint64
+	healthy bool
+}
+
+// multiClient is the client of a chain with several endpoints. A call goes
+// to the first healthy endpoint and fails over to the next one when the
+// endpoint does not answer; a quorum read goes to all the healthy endpoints
+// and needs quorum of them to agree, so that a single lying or lagging node
+// cannot make aswap act.
+type multiClient struct {
+	endpoints []*endpoint
+	quorum    int
+	maxLag    uint64
+
+	mu      sync.Mutex
+	checked time.Time
+}
+
+// dialEndpoints connects to the endpoints of c.Chain.
+func (c *Config) dialEndpoints() error {
+	m := &multiClient{quorum: c.Chain.RPC.Quorum, maxLag: c.Chain.RPC.maxLag()}
+
+	for _, url := range append([]string{c.Chain.URL}, c.Chain.RPC.URLs...) {
+		client, err := rpc.Dial(url)
+		if err != nil {
+			return errors.Wrapf(err, "connect to %v", url)
+		}
+		m.endpoints = append(m.endpoints, &endpoint{url: url, client: ethclient.NewClient(client), rpc: client, healthy: true})
+	}
+
+	c.rpc, c.client = m, m
+
+	return nil
+}
+
+// endpointFailed tells if err is a failure of the endpoint rather than its
+// answer, an error of the JSON-RPC or not found.
+func endpointFailed(err error) bool {
+	if err == nil || err == ethereum.NotFound {
+		return false
+	}
+
+	_, answer := errors.Cause(err).(rpc.Error)
+
+	return !answer
+}
+
+// check refreshes the heads of the endpoints if they are older than
+// headCheckInterval.
+func (m *multiClient) check(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.checked) < headCheckInterval {
+		return
+	}
+	m.checked = time.Now()
+
+	var wg sync.WaitGroup
+	for _, e := range m.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, headCheckTimeout)
+			defer cancel()
+
+			header, err := e.client.HeaderByNumber(ctx, nil)
+			if err != nil {
+				log.Printf("endpoint %v: %v", e.url, err)
+				e.healthy = false
+				return
+			}
+			e.head, e.healthy = header.Number.Uint64(), true
+		}(e)
+	}
+	wg.Wait()
+
+	var best uint64
+	for _, e := range m.endpoints {
+		if e.healthy && e.head > best {
+			best = e.head
+		}
+	}
+	for _, e := range m.endpoints {
+		if e.healthy && e.head+m.maxLag < best {
+			log.Printf("endpoint %v is stale: head %v, highest head %v", e.url, e.head, best)
+			e.healthy = false
+		}
+	}
+}
+
+// split returns the healthy endpoints and the others, in the order of the
+// config.
+func (m *multiClient) split(ctx context.Context) (healthy, down []*endpoint) {
+	m.check(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.endpoints {
+		if e.healthy {
+			healthy = append(healthy, e)
+		} else {
+			down = append(down, e)
+		}
+	}
+
+	return healthy, down
+}
+
+func (m *multiClient) setHealthy(e *endpoint, healthy bool) {
+	m.mu.Lock()
+	e.healthy = healthy
+	m.mu.Unlock()
+}
+
+// do calls call on the endpoints in turn until one answers.
+func (m *multiClient) do(ctx context.Context, call func(e *endpoint) error) error {
+	healthy, down := m.split(ctx)
+
+	var err error
+	for _, e := range append(healthy, down...) {
+		if err = call(e); !endpointFailed(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("endpoint %v: %v, failing over", e.url, err)
+		m.setHealthy(e, false)
+	}
+
+	return err
+}
+
+// read is the answer of an endpoint to a quorum read.
+type read struct {
+	result interface{}
+	err    error
+}
+
+// quorumRead calls call on the healthy endpoints and returns the answer of
+// at least quorum of them. Without a quorum, it is a call that fails over.
+func (m *multiClient) quorumRead(ctx context.Context, what string, call func(e *endpoint) (interface{}, error)) (interface{}, error) {
+	if m.quorum < 2 {
+		var result interface{}
+		err := m.do(ctx, func(e *endpoint) (err error) {
+			result, err = call(e)
+			return err
+		})
+		return result, err
+	}
+
+	endpoints, _ := m.split(ctx)
+	if len(endpoints) < m.quorum {
+		return nil, errors.Errorf("%v: %v healthy endpoints for a quorum of %v", what, len(endpoints), m.quorum)
+	}
+
+	reads := make([]read, len(endpoints))
+	var wg sync.WaitGroup
+	for i, e := range endpoints {
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+			result, err := call(e)
+			reads[i] = read{result, err}
+		}(i, e)
+	}
+	wg.Wait()
+
+	votes := make(map[string]int)
+	for i, r := range reads {
+		if endpointFailed(r.err) {
+			log.Printf("endpoint %v: %v", endpoints[i].url, r.err)
+			m.setHealthy(endpoints[i], false)
+			continue
+		}
+
+		key := answerKey(r)
+		if votes[key]++; votes[key] >= m.quorum {
+			return r.result, r.err
+		}
+	}
+
+	return nil, errors.Errorf("%v: no %v of %v endpoints agree", what, m.quorum, len(endpoints))
+}
+
+// answerKey returns the answer r as a string to compare it with the answers
+// of the other endpoints.
+func answerKey(r read) string {
+	if r.err != nil {
+		return "error: " + r.err.Error()
+	}
+
+	encoded, err := json.Marshal(r.result)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+
+	return string(encoded)
+}
+
+// pinned returns blockNumber, or the lowest head of the healthy endpoints if
+// it is nil, so that the endpoints of a quorum read answer for the same
+// block.
+func (m *multiClient) pinned(ctx context.Context, blockNumber *big.Int) *big.Int {
+	if blockNumber != nil || m.quorum < 2 {
+		return blockNumber
+	}
+
+	m.check(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var lowest *big.Int
+	for _, e := range m.endpoints {
+		if e.healthy && (lowest == nil || e.head < lowest.Uint64()) {
+			lowest = new(big.Int).SetUint64(e.head)
+		}
+	}
+
+	return lowest
+}
+
+func (m *multiClient) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	blockNumber = m.pinned(ctx, blockNumber)
+
+	code, err := m.quorumRead(ctx, "code of "+contract.Hex(), func(e *endpoint) (interface{}, error) {
+		return e.client.CodeAt(ctx, contract, blockNumber)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return code.([]byte), nil
+}
+
+func (m *multiClient) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	blockNumber = m.pinned(ctx, blockNumber)
+
+	output, err := m.quorumRead(ctx, "contract call", func(e *endpoint) (interface{}, error) {
+		return e.client.CallContract(ctx, call, blockNumber)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return output.([]byte), nil
+}
+
+func (m *multiClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	receipt, err := m.quorumRead(ctx, "receipt of "+txHash.Hex(), func(e *endpoint) (interface{}, error) {
+		return e.client.TransactionReceipt(ctx, txHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return receipt.(*types.Receipt), nil
+}
+
+func (m *multiClient) PendingCodeAt(ctx context.Context, account common.Address) (code []byte, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		code, err = e.client.PendingCodeAt(ctx, account)
+		return err
+	})
+	return code, err
+}
+
+func (m *multiClient) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		nonce, err = e.client.PendingNonceAt(ctx, account)
+		return err
+	})
+	return nonce, err
+}
+
+func (m *multiClient) SuggestGasPrice(ctx context.Context) (price *big.Int, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		price, err = e.client.SuggestGasPrice(ctx)
+		return err
+	})
+	return price, err
+}
+
+func (m *multiClient) EstimateGas(ctx context.Context, call ethereum.CallMsg) (gas uint64, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		gas, err = e.client.EstimateGas(ctx, call)
+		return err
+	})
+	return gas, err
+}
+
+func (m *multiClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return m.send(ctx, tx.Hash(), func(e *endpoint) error {
+		return e.client.SendTransaction(ctx, tx)
+	})
+}
+
+// send sends the transaction hash through call, failing over. After a
+// failover the next endpoint may already have it, from the endpoint that
+// failed on the way or from the network: that is a success, and so is a
+// taken nonce when the endpoint knows the transaction.
+func (m *multiClient) send(ctx context.Context, hash common.Hash, call func(e *endpoint) error) error {
+	return m.do(ctx, func(e *endpoint) error {
+		err := call(e)
+		if err == nil || endpointFailed(err) {
+			return err
+		}
+
+		msg := strings.ToLower(errors.Cause(err).Error())
+		switch {
+		case strings.Contains(msg, "already known") || strings.Contains(msg, "known transaction"):
+			return nil
+		case strings.Contains(msg, "nonce too low") && e.hasTx(ctx, hash):
+			return nil
+		}
+
+		return err
+	})
+}
+
+// hasTx tells if the endpoint knows the transaction hash, pending or mined.
+// It reads it raw when it can, as a typed transaction does not decode.
+func (e *endpoint) hasTx(ctx context.Context, hash common.Hash) bool {
+	if e.rpc == nil {
+		_, _, err := e.client.TransactionByHash(ctx, hash)
+		return err == nil
+	}
+
+	var tx json.RawMessage
+	err := e.rpc.CallContext(ctx, &tx, "eth_getTransactionByHash", hash)
+	return err == nil && len(tx) > 0 && string(tx) != "null"
+}
+
+func (m *multiClient) FilterLogs(ctx context.Context, query ethereum.FilterQuery) (logs []types.Log, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		logs, err = e.client.FilterLogs(ctx, query)
+		return err
+	})
+	return logs, err
+}
+
+func (m *multiClient) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (sub ethereum.Subscription, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		sub, err = e.client.SubscribeFilterLogs(ctx, query, ch)
+		return err
+	})
+	return sub, err
+}
+
+func (m *multiClient) TransactionByHash(ctx context.Context, txHash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		tx, isPending, err = e.client.TransactionByHash(ctx, txHash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+func (m *multiClient) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (balance *big.Int, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		balance, err = e.client.BalanceAt(ctx, account, blockNumber)
+		return err
+	})
+	return balance, err
+}
+
+func (m *multiClient) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		header, err = e.client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (m *multiClient) BlockByNumber(ctx context.Context, number *big.Int) (block *types.Block, err error) {
+	err = m.do(ctx, func(e *endpoint) error {
+		block, err = e.client.BlockByNumber(ctx, number)
+		return err
+	})
+	return block, err
+}
+
+func (m *multiClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	call := func(e *endpoint) error {
+		return e.rpc.CallContext(ctx, result, method, args...)
+	}
+
+	if raw, ok := sendRawArg(method, args); ok {
+		hash := crypto.Keccak256Hash(raw)
+		if err := m.send(ctx, hash, call); err != nil {
+			return err
+		}
+
+		//a known transaction answers no hash
+		if h, ok := result.(*common.Hash); ok {
+			*h = hash
+		}
+		return nil
+	}
+
+	return m.do(ctx, call)
+}
+
+// sendRawArg returns the signed transaction of an eth_sendRawTransaction.
+func sendRawArg(method string, args []interface{}) ([]byte, bool) {
+	if method != "eth_sendRawTransaction" || len(args) != 1 {
+		return nil, false
+	}
+
+	raw, ok := args[0].(hexutil.Bytes)
+	return raw, ok
+}
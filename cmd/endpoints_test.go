@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testEndpoint stands in for a node that is down, lags or lies.
+type testEndpoint struct {
+	ethClient
+	down  bool
+	head  *big.Int
+	code  []byte
+	calls int
+	//takes the sent transaction but the answer is lost
+	lost bool
+	//answers the sent transactions with this error
+	answer string
+}
+
+// testRPCError is an error answered by a node.
+type testRPCError string
+
+func (e testRPCError) Error() string  { return string(e) }
+func (e testRPCError) ErrorCode() int { return -32000 }
+
+func (e *testEndpoint) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	e.calls++
+	switch {
+	case e.down:
+		return errors.New("connection refused")
+	case e.lost:
+		_ = e.ethClient.SendTransaction(ctx, tx)
+		return errors.New("connection reset by peer")
+	case e.answer != "":
+		return testRPCError(e.answer)
+	}
+	return e.ethClient.SendTransaction(ctx, tx)
+}
+
+func (e *testEndpoint) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if e.down {
+		return nil, errors.New("connection refused")
+	}
+	if e.head != nil {
+		return &types.Header{Number: e.head}, nil
+	}
+	return e.ethClient.HeaderByNumber(ctx, number)
+}
+
+func (e *testEndpoint) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	e.calls++
+	if e.down {
+		return nil, errors.New("connection refused")
+	}
+	if e.code != nil {
+		return e.code, nil
+	}
+	return e.ethClient.CodeAt(ctx, contract, blockNumber)
+}
+
+func (e *testEndpoint) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	e.calls++
+	if e.down {
+		return 0, errors.New("connection refused")
+	}
+	return e.ethClient.PendingNonceAt(ctx, account)
+}
+
+func TestEndpoints(t *testing.T) {
+	ctx := context.Background()
+
+	key, _ := crypto.GenerateKey()
+	sim, handlers := testSimHandlers(t, key)
+	defer sim.Close()
+
+	contract := common.HexToAddress(handlers[0].Config.Contract)
+	code, err := sim.CodeAt(ctx, contract, nil)
+	TMust(t, err)
+
+	newClient := func(quorum int, endpoints ...*testEndpoint) *multiClient {
+		m := &multiClient{quorum: quorum, maxLag: defaultMaxLag}
+		for i, e := range endpoints {
+			e.ethClient = simClient{sim}
+			m.endpoints = append(m.endpoints, &endpoint{url: string(rune('a' + i)), client: e, healthy: true})
+		}
+		return m
+	}
+
+	Convey("calls fail over from an endpoint that is down", t, func() {
+		down, up := &testEndpoint{}, &testEndpoint{}
+		m := newClient(0, down, up)
+		down.down = true
+
+		got, err := m.CodeAt(ctx, contract, nil)
+		So(err, ShouldBeNil)
+		So(got, ShouldResemble, code)
+		So(down.calls, ShouldEqual, 0)
+		So(m.endpoints[0].healthy, ShouldBeFalse)
+
+		//the head check missed a failure
+		m.endpoints[0].healthy = true
+		nonce, err := m.PendingNonceAt(ctx, crypto.PubkeyToAddress(key.PublicKey))
+		So(err, ShouldBeNil)
+		So(nonce, ShouldEqual, 1)
+		So(down.calls, ShouldEqual, 1)
+		So(up.calls, ShouldEqual, 2)
+		So(m.endpoints[0].healthy, ShouldBeFalse)
+
+		//all down
+		up.down = true
+		_, err = m.PendingNonceAt(ctx, crypto.PubkeyToAddress(key.PublicKey))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("an endpoint with a stale head is skipped", t, func() {
+		stale, up := &testEndpoint{head: big.NewInt(0)}, &testEndpoint{head: big.NewInt(defaultMaxLag + 1)}
+		m := newClient(0, stale, up)
+
+		_, err := m.CodeAt(ctx, contract, nil)
+		So(err, ShouldBeNil)
+		So(stale.calls, ShouldEqual, 0)
+		So(up.calls, ShouldEqual, 1)
+
+		up.head = big.NewInt(defaultMaxLag)
+		m.checked = m.checked.Add(-headCheckInterval)
+		_, err = m.CodeAt(ctx, contract, nil)
+		So(err, ShouldBeNil)
+		So(stale.calls, ShouldEqual, 1)
+	})
+
+	Convey("quorum reads need the endpoints to agree", t, func() {
+		liar := &testEndpoint{code: []byte{0x60, 0x00}}
+		m := newClient(2, liar, &testEndpoint{}, &testEndpoint{})
+
+		got, err := m.CodeAt(ctx, contract, nil)
+		So(err, ShouldBeNil)
+		So(got, ShouldResemble, code)
+		So(liar.calls, ShouldEqual, 1)
+
+		m = newClient(2, liar, &testEndpoint{})
+		_, err = m.CodeAt(ctx, contract, nil)
+		So(err, ShouldNotBeNil)
+
+		//too few endpoints are healthy
+		down := &testEndpoint{down: true}
+		m = newClient(2, down, &testEndpoint{})
+		_, err = m.CodeAt(ctx, contract, nil)
+		So(err, ShouldNotBeNil)
+
+		//the contract is audited over the endpoints
+		m = newClient(2, &testEndpoint{}, &testEndpoint{})
+		handlers[0].Config.client = m
+		defer func() { handlers[0].Config.client = simClient{sim} }()
+
+		tx, err := handlers[0].NewContract(ctx, common.HexToAddress("0x01"), 1000, NewSecretHashPair().Hash,
+			big.NewInt(int64(sim.Blockchain().CurrentHeader().Time)+3600))
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		receipt, err := m.TransactionReceipt(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+		So(receipt.Status, ShouldEqual, types.ReceiptStatusSuccessful)
+
+		receipt, err = m.TransactionReceipt(ctx, common.HexToHash("0x01"))
+		So(err, ShouldBeNil)
+		So(receipt, ShouldBeNil)
+	})
+
+	Convey("a transaction the next endpoint already has is sent", t, func() {
+		from := crypto.PubkeyToAddress(key.PublicKey)
+		signTx := func() *types.Transaction {
+			nonce, err := sim.PendingNonceAt(ctx, from)
+			TMust(t, err)
+			tx, err := types.SignTx(types.NewTransaction(nonce, common.HexToAddress("0x01"), big.NewInt(1), 21000, big.NewInt(1), nil),
+				types.NewEIP155Signer(handlers[0].Config.Chain.ID), key)
+			TMust(t, err)
+			return tx
+		}
+
+		lost, known := &testEndpoint{lost: true}, &testEndpoint{answer: "already known"}
+		m := newClient(0, lost, known)
+		tx := signTx()
+		So(m.SendTransaction(ctx, tx), ShouldBeNil)
+		So(lost.calls, ShouldEqual, 1)
+		So(known.calls, ShouldEqual, 1)
+
+		//mined meanwhile
+		sim.Commit()
+		m = newClient(0, &testEndpoint{down: true}, &testEndpoint{answer: "nonce too low"})
+		So(m.SendTransaction(ctx, tx), ShouldBeNil)
+
+		//another transaction took the nonce
+		m = newClient(0, &testEndpoint{answer: "nonce too low"})
+		err := m.SendTransaction(ctx, signTx())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "nonce too low")
+	})
+
+	Convey("the rpc policy is validated", t, func() {
+		So((&RPCPolicy{URLs: []string{"http://b"}, Quorum: 2}).validate(), ShouldBeNil)
+		So((&RPCPolicy{URLs: []string{"http://b"}, Quorum: 3}).validate(), ShouldNotBeNil)
+		So((&RPCPolicy{Quorum: -1}).validate(), ShouldNotBeNil)
+
+		c := &Config{ChainID: big.NewInt(1), ChainName: "eth", URL: "http://127.0.0.1:8545",
+			RPC: &RPCPolicy{URLs: []string{"http://127.0.0.1:8546"}, Quorum: 2}}
+		So(c.Connect(""), ShouldBeNil)
+		So(c.client.(*multiClient).endpoints, ShouldHaveLength, 2)
+
+		c.RPC.Quorum = 3
+		So(c.Connect(""), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,242 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"math/big"
+	"strings"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// EventExtend is the type of a LogHTLCExtend of HashedTimelockV2, which moves
+// the timelock. It is not a SwapEvent, the watches do not read it.
+const EventExtend = "Extend"
+
+// HTLCEvent is a decoded LogHTLCNew, LogHTLCWithdraw, LogHTLCRefund or
+// LogHTLCExtend of a HashedTimelock contract.
+type HTLCEvent struct {
+	Type       string
+	ContractID common.Hash
+	//LogHTLCNew, and LogHTLCRefund of HashedTimelockV2
+	Sender common.Address
+	//LogHTLCNew, and LogHTLCWithdraw of HashedTimelockV2
+	Receiver common.Address
+	Amount   *big.Int
+	//LogHTLCNew only
+	Hashlock [32]byte
+	//LogHTLCNew, and the new timelock of LogHTLCExtend
+	Timelock *big.Int
+	//LogHTLCWithdraw of HashedTimelockV2
+	Preimage [32]byte
+	//false for the withdraw and refund events of HashedTimelock, which log
+	//the contractId only
+	Detailed bool
+
+	TxHash      common.Hash
+	BlockNumber uint64
+}
+
+// HTLCEventDecoder decodes the events of one HashedTimelock version.
+type HTLCEventDecoder struct {
+	abi     abi.ABI
+	version int
+	types   map[common.Hash]string
+}
+
+// NewHTLCEventDecoder returns the event decoder of the HashedTimelock version.
+func NewHTLCEventDecoder(version int) (*HTLCEventDecoder, error) {
+	//HashedTimelockFee logs the events of HashedTimelockV2
+	contractABI := htlc.HTLCABI
+	if version == HTLCVersion2 || version == HTLCVersionFee {
+		contractABI = htlc.HTLCV2ABI
+		version = HTLCVersion2
+	}
+
+	parsedABI, err := abi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse ABI")
+	}
+
+	d := &HTLCEventDecoder{
+		abi:     parsedABI,
+		version: version,
+		types: map[common.Hash]string{
+			parsedABI.Events["LogHTLCNew"].ID():      EventNew,
+			parsedABI.Events["LogHTLCWithdraw"].ID(): EventWithdraw,
+			parsedABI.Events["LogHTLCRefund"].ID():   EventRefund,
+		},
+	}
+	if extend, ok := parsedABI.Events["LogHTLCExtend"]; ok {
+		d.types[extend.ID()] = EventExtend
+	}
+
+	return d, nil
+}
+
+// EventDecoder returns the event decoder of the contract of the connected
+// chain.
+func (h *Handler) EventDecoder() (*HTLCEventDecoder, error) {
+	return NewHTLCEventDecoder(h.Config.Chain.Version)
+}
+
+// Topics returns the event ids of the swap events, new, withdraw and refund,
+// for the first topic of a log filter.
+func (d *HTLCEventDecoder) Topics() []common.Hash {
+	return []common.Hash{
+		d.abi.Events["LogHTLCNew"].ID(),
+		d.abi.Events["LogHTLCWithdraw"].ID(),
+		d.abi.Events["LogHTLCRefund"].ID(),
+	}
+}
+
+// AllTopics returns the event ids of all the events the decoder knows,
+// LogHTLCExtend too if the contract logs it.
+func (d *HTLCEventDecoder) AllTopics() []common.Hash {
+	topics := d.Topics()
+	if extend, ok := d.abi.Events["LogHTLCExtend"]; ok {
+		topics = append(topics, extend.ID())
+	}
+	return topics
+}
+
+// Topic returns the event id of the event type.
+func (d *HTLCEventDecoder) Topic(eventType string) common.Hash {
+	for id, t := range d.types {
+		if t == eventType {
+			return id
+		}
+	}
+	return common.Hash{}
+}
+
+// Decode decodes a log of the contract.
+func (d *HTLCEventDecoder) Decode(l types.Log) (*HTLCEvent, error) {
+	if len(l.Topics) < 2 {
+		return nil, errors.Errorf("log %v/%v is not a HTLC event", l.TxHash.Hex(), l.Index)
+	}
+
+	eventType, ok := d.types[l.Topics[0]]
+	if !ok {
+		return nil, errors.Errorf("log %v/%v is not a HTLC event", l.TxHash.Hex(), l.Index)
+	}
+
+	event := &HTLCEvent{
+		Type:        eventType,
+		ContractID:  l.Topics[1],
+		TxHash:      l.TxHash,
+		BlockNumber: l.BlockNumber,
+	}
+
+	var err error
+	switch {
+	case eventType == EventNew:
+		err = d.decodeNew(event, l)
+	case d.version == HTLCVersion2 && eventType == EventWithdraw:
+		err = d.decodeWithdraw(event, l)
+	case d.version == HTLCVersion2 && eventType == EventRefund:
+		err = d.decodeRefund(event, l)
+	case eventType == EventExtend:
+		err = d.decodeExtend(event, l)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode %v log of %v", eventType, l.TxHash.Hex())
+	}
+
+	return event, nil
+}
+
+func (d *HTLCEventDecoder) decodeNew(event *HTLCEvent, l types.Log) error {
+	if len(l.Topics) != 4 {
+		return errors.Errorf("%d topics", len(l.Topics))
+	}
+
+	var data struct {
+		Amount   *big.Int
+		Hashlock [32]byte
+		Timelock *big.Int
+	}
+	if err := d.abi.Unpack(&data, "LogHTLCNew", l.Data); err != nil {
+		return err
+	}
+
+	event.Sender = common.BytesToAddress(l.Topics[2].Bytes())
+	event.Receiver = common.BytesToAddress(l.Topics[3].Bytes())
+	event.Amount, event.Hashlock, event.Timelock = data.Amount, data.Hashlock, data.Timelock
+	event.Detailed = true
+
+	return nil
+}
+
+func (d *HTLCEventDecoder) decodeWithdraw(event *HTLCEvent, l types.Log) error {
+	if len(l.Topics) != 3 {
+		return errors.Errorf("%d topics", len(l.Topics))
+	}
+
+	var data struct {
+		Amount   *big.Int
+		Preimage [32]byte
+	}
+	if err := d.abi.Unpack(&data, "LogHTLCWithdraw", l.Data); err != nil {
+		return err
+	}
+
+	event.Receiver = common.BytesToAddress(l.Topics[2].Bytes())
+	event.Amount, event.Preimage = data.Amount, data.Preimage
+	event.Detailed = true
+
+	return nil
+}
+
+func (d *HTLCEventDecoder) decodeRefund(event *HTLCEvent, l types.Log) error {
+	if len(l.Topics) != 3 {
+		return errors.Errorf("%d topics", len(l.Topics))
+	}
+
+	var data struct {
+		Amount *big.Int
+	}
+	if err := d.abi.Unpack(&data, "LogHTLCRefund", l.Data); err != nil {
+		return err
+	}
+
+	event.Sender = common.BytesToAddress(l.Topics[2].Bytes())
+	event.Amount = data.Amount
+	event.Detailed = true
+
+	return nil
+}
+
+func (d *HTLCEventDecoder) decodeExtend(event *HTLCEvent, l types.Log) error {
+	if len(l.Topics) != 2 {
+		return errors.Errorf("%d topics", len(l.Topics))
+	}
+
+	var data struct {
+		Timelock *big.Int
+	}
+	if err := d.abi.Unpack(&data, "LogHTLCExtend", l.Data); err != nil {
+		return err
+	}
+
+	event.Timelock = data.Timelock
+	event.Detailed = true
+
+	return nil
+}
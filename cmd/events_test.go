@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testEventLog packs a log of the event as the contract would emit it.
+func testEventLog(t *testing.T, contractABI string, name string, topics []common.Hash, data ...interface{}) types.Log {
+	parsedABI, err := abi.JSON(strings.NewReader(contractABI))
+	TMust(t, err)
+
+	event := parsedABI.Events[name]
+	packed, err := event.Inputs.NonIndexed().Pack(data...)
+	TMust(t, err)
+
+	return types.Log{
+		Topics:      append([]common.Hash{event.ID()}, topics...),
+		Data:        packed,
+		TxHash:      common.HexToHash("0x7a"),
+		BlockNumber: 7,
+	}
+}
+
+func TestHTLCEventDecoder(t *testing.T) {
+	var (
+		id       = common.HexToHash("0x01")
+		sender   = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		receiver = common.HexToAddress("0x2000000000000000000000000000000000000002")
+		hashPair = testHashPair()
+	)
+
+	for _, version := range []int{HTLCVersion1, HTLCVersion2} {
+		contractABI := htlc.HTLCABI
+		if version == HTLCVersion2 {
+			contractABI = htlc.HTLCV2ABI
+		}
+
+		decoder, err := NewHTLCEventDecoder(version)
+		TMust(t, err)
+
+		Convey("decode LogHTLCNew of both versions", t, func() {
+			l := testEventLog(t, contractABI, "LogHTLCNew",
+				[]common.Hash{id, common.BytesToHash(sender.Bytes()), common.BytesToHash(receiver.Bytes())},
+				big.NewInt(1000), hashPair.Hash, big.NewInt(1600000000))
+
+			event, err := decoder.Decode(l)
+			So(err, ShouldBeNil)
+			So(event.Type, ShouldEqual, EventNew)
+			So(event.ContractID, ShouldEqual, id)
+			So(event.Sender, ShouldEqual, sender)
+			So(event.Receiver, ShouldEqual, receiver)
+			So(event.Amount.Int64(), ShouldEqual, 1000)
+			So(event.Hashlock, ShouldEqual, hashPair.Hash)
+			So(event.Timelock.Int64(), ShouldEqual, 1600000000)
+			So(event.TxHash, ShouldEqual, l.TxHash)
+			So(event.BlockNumber, ShouldEqual, 7)
+		})
+	}
+
+	Convey("HashedTimelock logs only the contractId on withdraw and refund", t, func() {
+		decoder, err := NewHTLCEventDecoder(HTLCVersion1)
+		So(err, ShouldBeNil)
+
+		event, err := decoder.Decode(testEventLog(t, htlc.HTLCABI, "LogHTLCWithdraw", []common.Hash{id}))
+		So(err, ShouldBeNil)
+		So(event.Type, ShouldEqual, EventWithdraw)
+		So(event.Detailed, ShouldBeFalse)
+
+		event, err = decoder.Decode(testEventLog(t, htlc.HTLCABI, "LogHTLCRefund", []common.Hash{id}))
+		So(err, ShouldBeNil)
+		So(event.Type, ShouldEqual, EventRefund)
+		So(event.Detailed, ShouldBeFalse)
+	})
+
+	Convey("HashedTimelockV2 logs the preimage and the amounts", t, func() {
+		decoder, err := NewHTLCEventDecoder(HTLCVersion2)
+		So(err, ShouldBeNil)
+
+		event, err := decoder.Decode(testEventLog(t, htlc.HTLCV2ABI, "LogHTLCWithdraw",
+			[]common.Hash{id, common.BytesToHash(receiver.Bytes())}, big.NewInt(1000), hashPair.Secret))
+		So(err, ShouldBeNil)
+		So(event.Type, ShouldEqual, EventWithdraw)
+		So(event.Detailed, ShouldBeTrue)
+		So(event.Receiver, ShouldEqual, receiver)
+		So(event.Amount.Int64(), ShouldEqual, 1000)
+		So(event.Preimage, ShouldEqual, hashPair.Secret)
+
+		event, err = decoder.Decode(testEventLog(t, htlc.HTLCV2ABI, "LogHTLCRefund",
+			[]common.Hash{id, common.BytesToHash(sender.Bytes())}, big.NewInt(1000)))
+		So(err, ShouldBeNil)
+		So(event.Type, ShouldEqual, EventRefund)
+		So(event.Sender, ShouldEqual, sender)
+		So(event.Amount.Int64(), ShouldEqual, 1000)
+
+		//a log of the other version is not ours
+		_, err = decoder.Decode(testEventLog(t, htlc.HTLCABI, "LogHTLCWithdraw", []common.Hash{id}))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("HashedTimelockV2 logs the new timelock on extend", t, func() {
+		decoder, err := NewHTLCEventDecoder(HTLCVersion2)
+		So(err, ShouldBeNil)
+		So(decoder.Topics(), ShouldHaveLength, 3)
+		So(decoder.AllTopics(), ShouldHaveLength, 4)
+
+		event, err := decoder.Decode(testEventLog(t, htlc.HTLCV2ABI, "LogHTLCExtend", []common.Hash{id}, big.NewInt(1600003600)))
+		So(err, ShouldBeNil)
+		So(event.Type, ShouldEqual, EventExtend)
+		So(event.ContractID, ShouldEqual, id)
+		So(event.Timelock.Int64(), ShouldEqual, 1600003600)
+
+		decoder, err = NewHTLCEventDecoder(HTLCVersion1)
+		So(err, ShouldBeNil)
+		So(decoder.AllTopics(), ShouldHaveLength, 3)
+	})
+}
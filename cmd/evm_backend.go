@@ -0,0 +1,528 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"math/big"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// evmBackend drives the HashedTimelock contract through the Handler.
+type evmBackend struct {
+	h *Handler
+}
+
+// contractID mirrors the id derivation of HashedTimelock.newContract.
+func contractID(sender, receiver common.Address, amount *big.Int, hashLock [32]byte, timeLock *big.Int) common.Hash {
+	var packed []byte
+	packed = append(packed, sender.Bytes()...)
+	packed = append(packed, receiver.Bytes()...)
+	packed = append(packed, common.LeftPadBytes(amount.Bytes(), 32)...)
+	packed = append(packed, hashLock[:]...)
+	packed = append(packed, common.LeftPadBytes(timeLock.Bytes(), 32)...)
+
+	return sha256.Sum256(packed)
+}
+
+// saltedContractID mirrors the id derivation of HashedTimelockV2.newContract.
+func saltedContractID(sender, receiver common.Address, amount *big.Int, hashLock [32]byte, timeLock *big.Int, salt [32]byte) common.Hash {
+	var packed []byte
+	packed = append(packed, sender.Bytes()...)
+	packed = append(packed, receiver.Bytes()...)
+	packed = append(packed, common.LeftPadBytes(amount.Bytes(), 32)...)
+	packed = append(packed, hashLock[:]...)
+	packed = append(packed, common.LeftPadBytes(timeLock.Bytes(), 32)...)
+	packed = append(packed, salt[:]...)
+
+	return sha256.Sum256(packed)
+}
+
+func (b *evmBackend) Lock(ctx context.Context, receiver string, amount *big.Int, hashLock [32]byte, timeLock *big.Int) (string, string, error) {
+	cfg := b.h.Config
+
+	if err := cfg.ValidateAddress(cfg.Chain.Contract); err != nil {
+		return "", "", err
+	}
+
+	if err := cfg.ValidateAddress(receiver); err != nil {
+		return "", "", err
+	}
+
+	//make sure our own contract is the genuine HashedTimelock
+	if err := b.h.VerifyContract(ctx, common.HexToAddress(cfg.Chain.Contract)); err != nil {
+		return "", "", err
+	}
+
+	if !amount.IsInt64() {
+		return "", "", errors.Errorf("amount %v out of range", amount)
+	}
+
+	var (
+		sender = common.HexToAddress(cfg.Account)
+		to     = common.HexToAddress(receiver)
+		id     common.Hash
+		salt   [32]byte
+		err    error
+	)
+	if cfg.Chain.IsV2() {
+		if salt, err = b.h.nextSalt(); err != nil {
+			return "", "", err
+		}
+		id = saltedContractID(sender, to, amount, hashLock, timeLock, salt)
+	} else {
+		id = contractID(sender, to, amount, hashLock, timeLock)
+	}
+
+	//newContract reverts on an id in use, say why before paying for it
+	details := new(ContractDetails)
+	if err := b.h.AuditContract(ctx, details, id); err != nil {
+		return "", "", err
+	}
+	if details.Sender != (common.Address{}) {
+		if cfg.Chain.IsV2() {
+			return "", "", errors.Errorf("contract %v already exists, use another salt", id.Hex())
+		}
+		return "", "", errors.Errorf("contract %v with the same receiver, amount, hashlock and timelock already exists, "+
+			"change one of them or use HashedTimelockV2", id.Hex())
+	}
+
+	var txSigned Transaction
+	if cfg.Chain.IsV2() {
+		txSigned, err = b.h.NewContractV2(ctx, to, amount.Int64(), hashLock, timeLock, salt)
+	} else {
+		txSigned, err = b.h.NewContract(ctx, to, amount.Int64(), hashLock, timeLock)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return id.Hex(), txSigned.Hash().Hex(), nil
+}
+
+func (b *evmBackend) Audit(ctx context.Context, contractId string) (*SwapContract, error) {
+	if err := b.h.Config.ValidateAddress(b.h.Config.Chain.Contract); err != nil {
+		return nil, err
+	}
+
+	id, err := parseContractId(contractId)
+	if err != nil {
+		return nil, err
+	}
+
+	details := new(ContractDetails)
+	if err := b.h.AuditContract(ctx, details, id); err != nil {
+		return nil, err
+	}
+
+	if details.Sender == (common.Address{}) {
+		return nil, errors.Errorf("contractId %v does not exist", contractId)
+	}
+
+	fee, err := b.h.ContractFee(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SwapContract{
+		ID:        id.Hex(),
+		Sender:    details.Sender.String(),
+		Receiver:  details.Receiver.String(),
+		Amount:    details.Amount,
+		Hashlock:  details.Hashlock,
+		Timelock:  details.Timelock,
+		Withdrawn: details.Withdrawn,
+		Refunded:  details.Refunded,
+		Preimage:  details.Preimage,
+		Fee:       fee,
+	}, nil
+}
+
+func (b *evmBackend) Redeem(ctx context.Context, contractId string, secret [32]byte) (string, error) {
+	cfg := b.h.Config
+
+	if err := cfg.ValidateAddress(cfg.Chain.Contract); err != nil {
+		return "", err
+	}
+
+	if err := b.h.VerifyContract(ctx, common.HexToAddress(cfg.Chain.Contract)); err != nil {
+		return "", err
+	}
+
+	id, err := parseContractId(contractId)
+	if err != nil {
+		return "", err
+	}
+
+	txSigned, err := b.h.Redeem(ctx, id, secret)
+	if err != nil {
+		return "", err
+	}
+
+	return txSigned.Hash().Hex(), nil
+}
+
+func (b *evmBackend) Refund(ctx context.Context, contractId string) (string, error) {
+	cfg := b.h.Config
+
+	if err := cfg.ValidateAddress(cfg.Chain.Contract); err != nil {
+		return "", err
+	}
+
+	if err := b.h.VerifyContract(ctx, common.HexToAddress(cfg.Chain.Contract)); err != nil {
+		return "", err
+	}
+
+	id, err := parseContractId(contractId)
+	if err != nil {
+		return "", err
+	}
+
+	txSigned, err := b.h.Refund(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	return txSigned.Hash().Hex(), nil
+}
+
+func (b *evmBackend) ExtractSecret(ctx context.Context, contractId string) ([32]byte, error) {
+	c, err := b.Audit(ctx, contractId)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	if !c.Withdrawn {
+		return [32]byte{}, errors.Errorf("contractId %v is not redeemed yet", contractId)
+	}
+
+	return c.Preimage, nil
+}
+
+func (b *evmBackend) WatchEvents(ctx context.Context, contractId string, sink chan<- *SwapEvent) error {
+	id, err := parseContractId(contractId)
+	if err != nil {
+		return err
+	}
+
+	ix, err := b.h.syncedIndexer()
+	if err != nil {
+		return err
+	}
+	if ix != nil {
+		if indexed, err := b.watchIndex(ctx, ix, id, sink); indexed || err != nil {
+			return err
+		}
+	}
+
+	decoder, err := b.h.EventDecoder()
+	if err != nil {
+		return err
+	}
+
+	query := ethereum.FilterQuery{
+		FromBlock: new(big.Int),
+		Addresses: []common.Address{common.HexToAddress(b.h.Config.Chain.Contract)},
+		Topics:    [][]common.Hash{decoder.Topics(), {id}},
+	}
+
+	return watchLogs(ctx, b.h.Config.client, query, sink, func(l types.Log) (*SwapEvent, error) {
+		decoded, err := decoder.Decode(l)
+		if err != nil {
+			return nil, err
+		}
+
+		event := &SwapEvent{
+			Type:       decoded.Type,
+			ContractID: id.Hex(),
+			TxID:       l.TxHash.Hex(),
+			Secret:     decoded.Preimage,
+		}
+
+		//HashedTimelock does not log the preimage
+		if event.Type == EventWithdraw && !decoded.Detailed {
+			if event.Secret, err = b.ExtractSecret(ctx, contractId); err != nil {
+				return nil, err
+			}
+		}
+
+		return event, nil
+	})
+}
+
+// watchIndex follows the HTLC id through the index of the chain, syncing it
+// every watchInterval. It reports false if the HTLC was created before the
+// index starts, which leaves it to the logs.
+func (b *evmBackend) watchIndex(ctx context.Context, ix *Indexer, id common.Hash, sink chan<- *SwapEvent) (bool, error) {
+	created := false
+	for {
+		if _, err := ix.Sync(ctx); err != nil {
+			return true, errors.Wrap(err, "sync index")
+		}
+
+		htlc, ok, err := ix.Lookup(id)
+		if err != nil {
+			return true, err
+		}
+
+		if !ok && !created {
+			details := new(ContractDetails)
+			if err := b.h.AuditContract(ctx, details, id); err != nil {
+				return true, err
+			}
+			if details.Sender != (common.Address{}) {
+				return false, nil
+			}
+		}
+
+		var events []*SwapEvent
+		if ok && !created {
+			events = append(events, &SwapEvent{Type: EventNew, ContractID: id.Hex(), TxID: htlc.TxID.Hex()})
+			created = true
+		}
+		if ok && htlc.Status != HTLCOpen {
+			event := &SwapEvent{Type: EventRefund, ContractID: id.Hex(), TxID: htlc.EndTxID.Hex()}
+			if htlc.Status == HTLCWithdrawn {
+				event.Type, event.Secret = EventWithdraw, htlc.Preimage
+			}
+
+			//HashedTimelock does not log the preimage
+			if event.Type == EventWithdraw && event.Secret == ([32]byte{}) {
+				if event.Secret, err = b.ExtractSecret(ctx, id.Hex()); err != nil {
+					return true, err
+				}
+			}
+			events = append(events, event)
+		}
+
+		for _, event := range events {
+			select {
+			case sink <- event:
+			case <-ctx.Done():
+				return true, ctx.Err()
+			}
+
+			if event.Type != EventNew {
+				return true, nil
+			}
+		}
+
+		select {
+		case <-time.After(watchInterval):
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+}
+
+// watchLogs polls the logs of query from its FromBlock on and sends the events
+// decode makes of them to sink, until a withdraw or refund event is sent or
+// ctx is done.
+func watchLogs(ctx context.Context, client ethClient, query ethereum.FilterQuery, sink chan<- *SwapEvent, decode func(types.Log) (*SwapEvent, error)) error {
+	for {
+		head, err := client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return errors.Wrap(err, "get head")
+		}
+
+		if head.Number.Cmp(query.FromBlock) >= 0 {
+			query.ToBlock = head.Number
+
+			logs, err := client.FilterLogs(ctx, query)
+			if err != nil {
+				return errors.Wrap(err, "filter logs")
+			}
+
+			for _, l := range logs {
+				event, err := decode(l)
+				if err != nil {
+					return err
+				}
+
+				select {
+				case sink <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				if event.Type != EventNew {
+					return nil
+				}
+			}
+
+			query.FromBlock = new(big.Int).Add(head.Number, big.NewInt(1))
+		}
+
+		select {
+		case <-time.After(watchInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *evmBackend) FindContracts(ctx context.Context, receiver string, hashLock [32]byte) ([]string, error) {
+	if err := b.h.Config.ValidateAddress(receiver); err != nil {
+		return nil, err
+	}
+
+	decoder, err := b.h.EventDecoder()
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := b.h.Config.client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int),
+		Addresses: []common.Address{common.HexToAddress(b.h.Config.Chain.Contract)},
+		Topics: [][]common.Hash{
+			{decoder.Topic(EventNew)},
+			nil,
+			nil,
+			{common.BytesToHash(common.HexToAddress(receiver).Bytes())},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "filter logs")
+	}
+
+	var ids []string
+	for _, l := range logs {
+		event, err := decoder.Decode(l)
+		if err != nil {
+			return nil, err
+		}
+
+		if event.Hashlock == hashLock {
+			ids = append(ids, event.ContractID.Hex())
+		}
+	}
+
+	return ids, nil
+}
+
+func (b *evmBackend) FindLock(ctx context.Context, receiver string, hashLock [32]byte) (string, string, error) {
+	cfg := b.h.Config
+	if err := cfg.ValidateAddress(receiver); err != nil {
+		return "", "", err
+	}
+
+	var (
+		sender      = common.HexToAddress(cfg.Account)
+		to          = common.HexToAddress(receiver)
+		contract    = common.HexToAddress(cfg.Chain.Contract)
+		contractABI = htlc.HTLCABI
+	)
+	if cfg.Chain.IsV2() {
+		contractABI = htlc.HTLCV2ABI
+	}
+
+	//the journal has our newContract from its signing on, mined or not
+	var txs []*JournalTx
+	store, err := b.h.localStore()
+	if err != nil {
+		return "", "", err
+	}
+	if store != nil {
+		if txs, err = b.h.JournalTxs(ctx, false); err != nil {
+			return "", "", err
+		}
+	}
+
+	for _, e := range txs {
+		if e.Status == TxFailed || e.Status == TxRejected || e.Status == TxReplaced {
+			continue
+		}
+		if common.HexToAddress(e.From) != sender || e.To == "" || common.HexToAddress(e.To) != contract {
+			continue
+		}
+
+		tx, err := decodeTx(e.Raw)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "journal tx %v", e.TxID)
+		}
+
+		method, args, err := decodeCall(contractABI, tx.Data())
+		if err != nil || method != "newContract" {
+			continue
+		}
+
+		if args[0].(common.Address) != to || args[1].([32]byte) != hashLock {
+			continue
+		}
+
+		id := contractID(sender, to, tx.Value(), hashLock, args[2].(*big.Int))
+		if cfg.Chain.IsV2() {
+			id = saltedContractID(sender, to, tx.Value(), hashLock, args[2].(*big.Int), args[3].([32]byte))
+		}
+
+		return id.Hex(), e.TxID, nil
+	}
+
+	//without a journal, only a mined contract tells
+	ids, err := b.FindContracts(ctx, receiver, hashLock)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, id := range ids {
+		c, err := b.Audit(ctx, id)
+		if err != nil {
+			return "", "", err
+		}
+		if common.HexToAddress(c.Sender) == sender {
+			return id, "", nil
+		}
+	}
+
+	return "", "", nil
+}
+
+func (b *evmBackend) Confirmations(ctx context.Context, txid string) (uint64, error) {
+	receipt, err := b.h.Config.client.TransactionReceipt(ctx, common.HexToHash(txid))
+	if err == ethereum.NotFound || (err == nil && receipt == nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "get txid=%v receipt", txid)
+	}
+
+	head, err := b.h.Config.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "get head")
+	}
+
+	if head.Number.Cmp(receipt.BlockNumber) < 0 {
+		return 0, nil
+	}
+
+	return new(big.Int).Sub(head.Number, receipt.BlockNumber).Uint64() + 1, nil
+}
+
+func parseContractId(contractId string) (common.Hash, error) {
+	b, err := hexutil.Decode(contractId)
+	if err != nil || len(b) != common.HashLength {
+		return common.Hash{}, errors.Errorf("invalid contractId: %v", contractId)
+	}
+
+	return common.BytesToHash(b), nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"log"
+	"math/big"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// Extend moves the timelock of our contractId to until. If counter is not
+// nil, contractId is the participant leg and counter the initiator leg that
+// pays us on the other chain, which must have been extended first; Audit of
+// the other chain backend returns it.
+func (h *Handler) Extend(ctx context.Context, contractId common.Hash, until *big.Int, counter *SwapContract) (Transaction, error) {
+	if err := h.requireV2("extend"); err != nil {
+		return nil, err
+	}
+
+	details, err := h.openContract(ctx, contractId)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.Sender != common.HexToAddress(h.Config.Account) {
+		return nil, errors.Errorf("account %v is not the sender %v of %v", h.Config.Account, details.Sender.String(), contractId.Hex())
+	}
+
+	head, err := h.Config.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get head")
+	}
+
+	if err := checkExtension(int64(head.Time), until, details, counter); err != nil {
+		return nil, errors.Wrapf(err, "extend %v", contractId.Hex())
+	}
+
+	log.Printf("Extend %v from %v to %v", contractId.Hex(), details.Timelock, until)
+
+	return h.transact(ctx, htlc.HTLCV2ABI, 0, "extend", contractId, until)
+}
+
+// checkExtension checks that until extends own. With the counterparty leg,
+// the participant rule still holds afterwards: own expires no later than
+// halfway between now and the counterparty timelock, so that we can redeem
+// it after the initiator reveals the secret on our leg.
+func checkExtension(now int64, until *big.Int, own *ContractDetails, counter *SwapContract) error {
+	if until.Cmp(own.Timelock) <= 0 {
+		return errors.Errorf("timelock %v can only be extended, not to %v", own.Timelock, until)
+	}
+
+	if until.Int64() <= now {
+		return errors.Errorf("timelock %v is in the past", until)
+	}
+
+	if counter == nil {
+		return nil
+	}
+
+	switch {
+	case counter.Hashlock != own.Hashlock:
+		return errors.Errorf("counterparty hashlock %x is not ours %x", counter.Hashlock, own.Hashlock)
+	case counter.Withdrawn || counter.Refunded:
+		return errors.New("counterparty contract is closed")
+	}
+
+	//half of the counterparty timelock, as in participant
+	limit := now + (counter.Timelock.Int64()-now)/2
+	if until.Int64() > limit {
+		return errors.Errorf("counterparty timelock %v allows extending to %v at most, extend the counterparty contract first", counter.Timelock, limit)
+	}
+
+	return nil
+}
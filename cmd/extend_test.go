@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckExtension(t *testing.T) {
+	const now = 1000000
+	hashLock := [32]byte{1}
+
+	own := &ContractDetails{Hashlock: hashLock, Timelock: big.NewInt(now + 3600)}
+
+	Convey("only later timelocks in the future", t, func() {
+		So(checkExtension(now, big.NewInt(now+7200), own, nil), ShouldBeNil)
+		So(checkExtension(now, big.NewInt(now+3600), own, nil), ShouldNotBeNil)
+		So(checkExtension(now, big.NewInt(now+1800), own, nil), ShouldNotBeNil)
+		So(checkExtension(now+8000, big.NewInt(now+7200), own, nil), ShouldNotBeNil)
+	})
+
+	Convey("the counterparty leg is extended first", t, func() {
+		counter := &SwapContract{Hashlock: hashLock, Timelock: big.NewInt(now + 7200)}
+
+		//still the old initiator timelock: at most now+3600
+		So(checkExtension(now, big.NewInt(now+3601), own, counter), ShouldNotBeNil)
+
+		counter.Timelock = big.NewInt(now + 14400)
+		So(checkExtension(now, big.NewInt(now+7200), own, counter), ShouldBeNil)
+		So(checkExtension(now, big.NewInt(now+7201), own, counter), ShouldNotBeNil)
+	})
+
+	Convey("refuse a foreign or closed counterparty contract", t, func() {
+		counter := &SwapContract{Hashlock: [32]byte{2}, Timelock: big.NewInt(now + 14400)}
+		So(checkExtension(now, big.NewInt(now+7200), own, counter), ShouldNotBeNil)
+
+		counter = &SwapContract{Hashlock: hashLock, Timelock: big.NewInt(now + 14400), Refunded: true}
+		So(checkExtension(now, big.NewInt(now+7200), own, counter), ShouldNotBeNil)
+	})
+
+	Convey("refuse a HashedTimelock chain", t, func() {
+		h := &Handler{Config: &Config{Chain: &chain{Contract: "0x00000000000000000000000000000000000000c1"}}}
+		_, err := h.Extend(context.Background(), common.HexToHash("0x01"), big.NewInt(now+7200), nil)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strings"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// MaxFeeBps is the highest fee HashedTimelockFee accepts, in basis points.
+const MaxFeeBps = 1000
+
+// FeeInfo is the fee setting of a HashedTimelockFee contract.
+type FeeInfo struct {
+	Owner     common.Address
+	Recipient common.Address
+	Bps       *big.Int
+	//withdrawn fees not collected by Recipient yet
+	Accrued *big.Int
+}
+
+// DeployContractFee deploys HashedTimelockFee as the contract of the own
+// chain, with the fee of bps basis points going to recipient.
+func (h *Handler) DeployContractFee(ctx context.Context, recipient common.Address, bps int64) error {
+	if htlc.HTLCFeeBIN == "" {
+		return errNotCompiled("HashedTimelockFee")
+	}
+
+	if err := checkFee(recipient, bps); err != nil {
+		return err
+	}
+
+	parsedABI, err := abi.JSON(strings.NewReader(htlc.HTLCFeeABI))
+	if err != nil {
+		return errors.Wrap(err, "parse ABI")
+	}
+
+	args, err := parsedABI.Pack("", recipient, big.NewInt(bps))
+	if err != nil {
+		return errors.Wrap(err, "pack constructor")
+	}
+
+	address, err := h.deploy(ctx, htlc.HTLCFeeBIN+hexutil.Encode(args)[2:])
+	if err != nil {
+		return err
+	}
+
+	h.Config.Contract = address
+	h.Config.ContractVersion = HTLCVersionFee
+
+	return h.Config.rotate(h.ConfigPath)
+}
+
+// Fees returns the fee setting of the contract of the connected chain.
+func (h *Handler) Fees(ctx context.Context) (*FeeInfo, error) {
+	if err := h.requireFee(); err != nil {
+		return nil, err
+	}
+
+	info := new(FeeInfo)
+	for method, result := range map[string]interface{}{
+		"owner":        &info.Owner,
+		"feeRecipient": &info.Recipient,
+		"feeBps":       &info.Bps,
+		"accruedFees":  &info.Accrued,
+	} {
+		if err := h.call(ctx, htlc.HTLCFeeABI, result, method); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// SetFee changes the fee of the HTLCs created from now on, as the owner of
+// the contract.
+func (h *Handler) SetFee(ctx context.Context, recipient common.Address, bps int64) (Transaction, error) {
+	info, err := h.Fees(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Owner != common.HexToAddress(h.Config.Account) {
+		return nil, errors.Errorf("account %v is not the owner %v of the contract", h.Config.Account, info.Owner.String())
+	}
+
+	if err := checkFee(recipient, bps); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Set fee from %v to %v bps, recipient %v", info.Bps, bps, recipient.String())
+
+	return h.transact(ctx, htlc.HTLCFeeABI, 0, "setFee", recipient, big.NewInt(bps))
+}
+
+// CollectFees sends the accrued fees to the fee recipient.
+func (h *Handler) CollectFees(ctx context.Context) (Transaction, error) {
+	info, err := h.Fees(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Accrued.Sign() == 0 {
+		return nil, errors.New("no fees to collect")
+	}
+
+	log.Printf("Collect %v to %v", info.Accrued, info.Recipient.String())
+
+	return h.transact(ctx, htlc.HTLCFeeABI, 0, "collectFees")
+}
+
+// ContractFee returns the fee withdraw keeps from the amount of contractId,
+// nil if the contract of the connected chain takes no fee.
+func (h *Handler) ContractFee(ctx context.Context, contractId common.Hash) (*big.Int, error) {
+	if h.Config.Chain.Version != HTLCVersionFee {
+		return nil, nil
+	}
+
+	var fee *big.Int
+	if err := h.call(ctx, htlc.HTLCFeeABI, &fee, "feeOf", contractId); err != nil {
+		return nil, err
+	}
+
+	return fee, nil
+}
+
+func (h *Handler) requireFee() error {
+	if h.Config.Chain == nil {
+		return errors.New("not connected")
+	}
+
+	if h.Config.Chain.Version != HTLCVersionFee {
+		return errors.Errorf("contract %v is not a HashedTimelockFee (\"contractVersion\": %d)", h.Config.Chain.Contract, HTLCVersionFee)
+	}
+
+	return nil
+}
+
+func checkFee(recipient common.Address, bps int64) error {
+	switch {
+	case bps < 0 || bps > MaxFeeBps:
+		return errors.Errorf("fee %v bps out of range [0, %v]", bps, MaxFeeBps)
+	case bps > 0 && recipient == (common.Address{}):
+		return errors.New("a fee needs a fee recipient")
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHTLCFee(t *testing.T) {
+	ctx := context.Background()
+	recipient := common.HexToAddress("0x1000000000000000000000000000000000000001")
+
+	newHandler := func(version int) *Handler {
+		return &Handler{Config: &Config{
+			Account: common.HexToAddress("0x01").String(),
+			Chain:   &chain{Contract: "0x00000000000000000000000000000000000000c1", Version: version},
+		}}
+	}
+
+	Convey("the fee is at most MaxFeeBps and needs a recipient", t, func() {
+		So(checkFee(recipient, 30), ShouldBeNil)
+		So(checkFee(recipient, MaxFeeBps), ShouldBeNil)
+		So(checkFee(common.Address{}, 0), ShouldBeNil)
+		So(checkFee(recipient, MaxFeeBps+1), ShouldNotBeNil)
+		So(checkFee(recipient, -1), ShouldNotBeNil)
+		So(checkFee(common.Address{}, 30), ShouldNotBeNil)
+	})
+
+	Convey("HashedTimelockFee has the interface of HashedTimelockV2", t, func() {
+		So((&chain{}).IsV2(), ShouldBeFalse)
+		So((&chain{Version: HTLCVersion1}).IsV2(), ShouldBeFalse)
+		So((&chain{Version: HTLCVersion2}).IsV2(), ShouldBeTrue)
+		So((&chain{Version: HTLCVersionFee}).IsV2(), ShouldBeTrue)
+
+		v2, err := abi.JSON(strings.NewReader(htlc.HTLCV2ABI))
+		So(err, ShouldBeNil)
+		fee, err := abi.JSON(strings.NewReader(htlc.HTLCFeeABI))
+		So(err, ShouldBeNil)
+
+		for name, method := range v2.Methods {
+			So(fee.Methods[name].Sig(), ShouldEqual, method.Sig())
+		}
+		for name, event := range v2.Events {
+			So(fee.Events[name].ID(), ShouldEqual, event.ID())
+		}
+
+		_, err = fee.Pack("", recipient, big.NewInt(30))
+		So(err, ShouldBeNil)
+	})
+
+	Convey("the decoder reads the detailed events of HashedTimelockV2", t, func() {
+		decoder, err := NewHTLCEventDecoder(HTLCVersionFee)
+		So(err, ShouldBeNil)
+
+		hashPair := testHashPair()
+		event, err := decoder.Decode(testEventLog(t, htlc.HTLCFeeABI, "LogHTLCWithdraw",
+			[]common.Hash{common.HexToHash("0x01"), common.BytesToHash(recipient.Bytes())}, big.NewInt(997), hashPair.Secret))
+		So(err, ShouldBeNil)
+		So(event.Detailed, ShouldBeTrue)
+		So(event.Amount.Int64(), ShouldEqual, 997)
+	})
+
+	Convey("only a HashedTimelockFee chain has fees", t, func() {
+		fee, err := newHandler(HTLCVersion2).ContractFee(ctx, common.HexToHash("0x01"))
+		So(err, ShouldBeNil)
+		So(fee, ShouldBeNil)
+
+		_, err = newHandler(HTLCVersion2).Fees(ctx)
+		So(err, ShouldNotBeNil)
+
+		_, err = newHandler(HTLCVersion1).SetFee(ctx, recipient, 30)
+		So(err, ShouldNotBeNil)
+
+		So(newHandler(HTLCVersionFee).DeployContractFee(ctx, recipient, 30), ShouldNotBeNil)
+	})
+}
+
+func TestHTLCFeeSim(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	skipUncompiled(t, htlc.HTLCFeeBIN != "", "HashedTimeLockFee.sol is")
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	var (
+		ctx       = context.Background()
+		sender    = handlers[0]
+		receiver  = handlers[1]
+		recipient = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		timeLock  = big.NewInt(time.Now().Unix() + 3600)
+	)
+
+	parsedABI, err := abi.JSON(strings.NewReader(htlc.HTLCFeeABI))
+	TMust(t, err)
+	args, err := parsedABI.Pack("", recipient, big.NewInt(30))
+	TMust(t, err)
+	address, err := sender.deploy(ctx, htlc.HTLCFeeBIN+hexutil.Encode(args)[2:])
+	TMust(t, err)
+	sim.Commit()
+	for _, h := range handlers {
+		h.Config.Chain.Contract, h.Config.Chain.Version = address, HTLCVersionFee
+	}
+
+	Convey("withdraw keeps the fee fixed at creation", t, func() {
+		pair := NewSecretHashPair()
+		tx, err := sender.NewContractV2(ctx, common.HexToAddress(receiver.Config.Account), 10000, pair.Hash, timeLock, [32]byte{1})
+		So(err, ShouldBeNil)
+		sim.Commit()
+		event, err := sender.GetContractId(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+
+		fee, err := sender.ContractFee(ctx, event.ContractId)
+		So(err, ShouldBeNil)
+		So(fee.Int64(), ShouldEqual, 30)
+
+		_, err = receiver.Redeem(ctx, event.ContractId, pair.Secret)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		info, err := sender.Fees(ctx)
+		So(err, ShouldBeNil)
+		So(info.Accrued.Int64(), ShouldEqual, 30)
+	})
+
+	Convey("newContracts refuses amounts whose sum wraps around", t, func() {
+		max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+		receiver := common.HexToAddress("0x02")
+		_, err := sender.transact(ctx, htlc.HTLCFeeABI, 1, "newContracts",
+			[]common.Address{receiver, receiver}, []*big.Int{max, big.NewInt(2)},
+			[][32]byte{{1}, {2}}, []*big.Int{timeLock, timeLock}, [][32]byte{{3}, {4}})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// the blocks the percentile gas price looks back on by default
+const defaultGasBlocks = 20
+
+// GasPolicy prices the transactions on a chain. Without a policy, or with
+// the zero policy, the gas price is the SuggestGasPrice of the node and the
+// gas limit is gasLimit.
+type GasPolicy struct {
+	//TxLegacy, the default, or TxDynamicFee
+	TxType string `json:"txType,omitempty"`
+	//gas limit = estimated gas * Buffer, e.g. 1.2
+	Buffer float64 `json:"buffer,omitempty"`
+	//fixed gas price in wei of legacy transactions
+	Price *big.Int `json:"price,omitempty"`
+	//fixed max priority fee per gas in wei of dynamic fee transactions
+	PriorityFee *big.Int `json:"priorityFee,omitempty"`
+	//percentile of the gas prices, or of the priority fees, paid in the last
+	//Blocks blocks, 1-100
+	Percentile int `json:"percentile,omitempty"`
+	Blocks     int `json:"blocks,omitempty"`
+	//bounds of the gas price, or of the max fee per gas, in wei
+	MinPrice *big.Int `json:"minPrice,omitempty"`
+	MaxPrice *big.Int `json:"maxPrice,omitempty"`
+	//ceiling in wei of the fee, gas limit * gas price, of a transaction
+	MaxFee *big.Int `json:"maxFee,omitempty"`
+}
+
+func (p *GasPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+
+	switch {
+	case p.TxType != "" && p.TxType != TxLegacy && p.TxType != TxDynamicFee:
+		return errors.Errorf("unknown transaction type: %v", p.TxType)
+	case p.dynamic() && p.Price != nil:
+		return errors.New("fixed gas price of dynamic fee transactions, set the priority fee instead")
+	case !p.dynamic() && p.PriorityFee != nil:
+		return errors.New("priority fee of legacy transactions, set the gas price instead")
+	case p.Buffer != 0 && p.Buffer < 1:
+		return errors.Errorf("gas buffer %v is less than 1", p.Buffer)
+	case p.Percentile < 0 || p.Percentile > 100:
+		return errors.Errorf("gas price percentile %v is not in 1-100", p.Percentile)
+	case p.Blocks < 0:
+		return errors.Errorf("negative gas price blocks %v", p.Blocks)
+	case p.Price != nil && p.Percentile != 0:
+		return errors.New("gas price is both fixed and a percentile")
+	case p.MinPrice != nil && p.MaxPrice != nil && p.MinPrice.Cmp(p.MaxPrice) > 0:
+		return errors.Errorf("min gas price %v is more than max gas price %v", p.MinPrice, p.MaxPrice)
+	}
+
+	return nil
+}
+
+// dynamic tells if the policy sends EIP-1559 dynamic fee transactions.
+func (p *GasPolicy) dynamic() bool {
+	return p != nil && p.TxType == TxDynamicFee
+}
+
+// limit returns the gas limit of a transaction estimated to use estimate gas.
+func (p *GasPolicy) limit(estimate uint64) uint64 {
+	if p == nil || p.Buffer == 0 {
+		return gasLimit
+	}
+
+	return uint64(float64(estimate) * p.Buffer)
+}
+
+// clamp bounds price by MinPrice and MaxPrice.
+func (p *GasPolicy) clamp(price *big.Int) *big.Int {
+	switch {
+	case p == nil:
+	case p.MinPrice != nil && price.Cmp(p.MinPrice) < 0:
+		return new(big.Int).Set(p.MinPrice)
+	case p.MaxPrice != nil && price.Cmp(p.MaxPrice) > 0:
+		return new(big.Int).Set(p.MaxPrice)
+	}
+
+	return price
+}
+
+// checkMaxFee refuses a transaction that may cost more than MaxFee.
+func (p *GasPolicy) checkMaxFee(gas uint64, price *big.Int) error {
+	if p == nil || p.MaxFee == nil {
+		return nil
+	}
+
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(gas), price)
+	if fee.Cmp(p.MaxFee) > 0 {
+		return errors.Errorf("fee = gas(%v) * gasPrice(%v) = %v exceeds the max fee %v", gas, price, fee, p.MaxFee)
+	}
+
+	return nil
+}
+
+// gasPrice prices a transaction on c.Chain by its gas policy.
+func (c *Config) gasPrice(ctx context.Context) (*big.Int, error) {
+	p := c.Chain.Gas
+
+	var (
+		price *big.Int
+		err   error
+	)
+
+	switch {
+	case p == nil:
+	case p.Price != nil:
+		price = new(big.Int).Set(p.Price)
+	case p.Percentile != 0:
+		if price, err = c.percentileGasPrice(ctx, p.Percentile, p.Blocks); err != nil {
+			return nil, err
+		}
+	}
+
+	//no policy, or no transactions in the recent blocks
+	if price == nil {
+		if price, err = c.client.SuggestGasPrice(ctx); err != nil {
+			return nil, errors.Wrapf(err, "account=%v get gasPrice", c.Account)
+		}
+	}
+
+	return p.clamp(price), nil
+}
+
+// percentileGasPrice returns the percentile of the gas prices of the
+// transactions in the last blocks blocks, or nil if they have none.
+func (c *Config) percentileGasPrice(ctx context.Context, percentile int, blocks int) (*big.Int, error) {
+	if blocks == 0 {
+		blocks = defaultGasBlocks
+	}
+
+	head, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get head")
+	}
+
+	var prices []*big.Int
+	for number := new(big.Int).Set(head.Number); number.Sign() >= 0 && blocks > 0; blocks-- {
+		blockPrices, err := c.blockGasPrices(ctx, number)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get block %v", number)
+		}
+		prices = append(prices, blockPrices...)
+
+		number.Sub(number, big.NewInt(1))
+	}
+
+	return gasPricePercentile(prices, percentile), nil
+}
+
+// rpcBlockTx is the price of a transaction in a block read raw.
+type rpcBlockTx struct {
+	GasPrice          *hexutil.Big `json:"gasPrice"`
+	EffectiveGasPrice *hexutil.Big `json:"effectiveGasPrice"`
+}
+
+// blockGasPrices returns the gas prices paid by the transactions of block
+// number. The block is read raw, as ethclient v1.9 does not decode the typed
+// transactions of a block after Berlin; without JSON-RPC, e.g. on the
+// simulated chain, it is read through the client.
+func (c *Config) blockGasPrices(ctx context.Context, number *big.Int) ([]*big.Int, error) {
+	var prices []*big.Int
+
+	if c.rpc == nil {
+		block, err := c.client.BlockByNumber(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range block.Transactions() {
+			prices = append(prices, tx.GasPrice())
+		}
+		return prices, nil
+	}
+
+	var block *struct {
+		Transactions []rpcBlockTx `json:"transactions"`
+	}
+	if err := c.rpc.CallContext(ctx, &block, "eth_getBlockByNumber", hexutil.EncodeBig(number), true); err != nil {
+		return nil, errors.Wrap(err, "eth_getBlockByNumber")
+	}
+	if block == nil {
+		return nil, ethereum.NotFound
+	}
+
+	for _, tx := range block.Transactions {
+		switch {
+		case tx.EffectiveGasPrice != nil:
+			prices = append(prices, tx.EffectiveGasPrice.ToInt())
+		case tx.GasPrice != nil:
+			prices = append(prices, tx.GasPrice.ToInt())
+		}
+	}
+	return prices, nil
+}
+
+// gasPricePercentile returns the percentile of prices, nil if it is empty.
+func gasPricePercentile(prices []*big.Int, percentile int) *big.Int {
+	if len(prices) == 0 {
+		return nil
+	}
+
+	sorted := make([]*big.Int, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cmp(sorted[j]) < 0 })
+
+	return new(big.Int).Set(sorted[(len(sorted)-1)*percentile/100])
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGasPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("the gas price percentile of the recent transactions", t, func() {
+		prices := []*big.Int{big.NewInt(5), big.NewInt(1), big.NewInt(3), big.NewInt(2), big.NewInt(4)}
+
+		So(gasPricePercentile(nil, 50), ShouldBeNil)
+		So(gasPricePercentile(prices, 1).Int64(), ShouldEqual, 1)
+		So(gasPricePercentile(prices, 50).Int64(), ShouldEqual, 3)
+		So(gasPricePercentile(prices, 100).Int64(), ShouldEqual, 5)
+		So(prices[0].Int64(), ShouldEqual, 5)
+	})
+
+	Convey("a gas policy is validated, bounds the gas price and caps the fee", t, func() {
+		So((*GasPolicy)(nil).validate(), ShouldBeNil)
+		So((&GasPolicy{Buffer: 1.2, Percentile: 60, MinPrice: big.NewInt(1), MaxPrice: big.NewInt(2)}).validate(), ShouldBeNil)
+		So((&GasPolicy{Buffer: 0.5}).validate(), ShouldNotBeNil)
+		So((&GasPolicy{Percentile: 101}).validate(), ShouldNotBeNil)
+		So((&GasPolicy{Price: big.NewInt(1), Percentile: 50}).validate(), ShouldNotBeNil)
+		So((&GasPolicy{MinPrice: big.NewInt(2), MaxPrice: big.NewInt(1)}).validate(), ShouldNotBeNil)
+
+		So((*GasPolicy)(nil).limit(21000), ShouldEqual, gasLimit)
+		So((&GasPolicy{Buffer: 1.5}).limit(100000), ShouldEqual, 150000)
+
+		p := &GasPolicy{MinPrice: big.NewInt(10), MaxPrice: big.NewInt(20)}
+		So(p.clamp(big.NewInt(5)).Int64(), ShouldEqual, 10)
+		So(p.clamp(big.NewInt(15)).Int64(), ShouldEqual, 15)
+		So(p.clamp(big.NewInt(25)).Int64(), ShouldEqual, 20)
+
+		p = &GasPolicy{MaxFee: big.NewInt(1000)}
+		So(p.checkMaxFee(100, big.NewInt(10)), ShouldBeNil)
+		So(p.checkMaxFee(101, big.NewInt(10)), ShouldNotBeNil)
+		So((*GasPolicy)(nil).checkMaxFee(gasLimit, big.NewInt(1e18)), ShouldBeNil)
+	})
+
+	Convey("the blocks are read raw, with their typed transactions", t, func() {
+		key, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, key)
+		defer sim.Close()
+
+		c := handlers[0].Config
+		//the head is block 1, with the deployment of the contract
+		c.rpc = &testRPC{blocks: map[string]string{
+			"0x1": `{"number":"0x1","transactions":[
+				{"type":"0x2","maxFeePerGas":"0x77359400","maxPriorityFeePerGas":"0x3b9aca00","gasPrice":"0x3b9aca0e"},
+				{"type":"0x0","gasPrice":"0x4a817c800"},
+				{"type":"0x2","maxFeePerGas":"0x77359400","maxPriorityFeePerGas":"0x1","effectiveGasPrice":"0xf"}]}`,
+			"0x0": `{"number":"0x0","transactions":[]}`,
+		}}
+
+		price, err := c.percentileGasPrice(ctx, 100, 5)
+		So(err, ShouldBeNil)
+		So(price.Int64(), ShouldEqual, 20e9)
+
+		price, err = c.percentileGasPrice(ctx, 1, 5)
+		So(err, ShouldBeNil)
+		So(price.Int64(), ShouldEqual, 15)
+
+		price, err = c.percentileGasPrice(ctx, 50, 5)
+		So(err, ShouldBeNil)
+		So(price.Int64(), ShouldEqual, 1000000014)
+
+		//a block the node does not have
+		delete(c.rpc.(*testRPC).blocks, "0x0")
+		_, err = c.percentileGasPrice(ctx, 50, 5)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("newContract follows the gas policy of the chain", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender := handlers[0]
+		receiver := crypto.PubkeyToAddress(receiverKey.PublicKey)
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		newContract := func(policy *GasPolicy) (gas uint64, price *big.Int, err error) {
+			sender.Config.Chain.Gas = policy
+			tx, err := sender.NewContract(ctx, receiver, 1000, NewSecretHashPair().Hash, timeLock)
+			if err != nil {
+				return 0, nil, err
+			}
+			sim.Commit()
+			return tx.Gas(), tx.GasPrice(), nil
+		}
+
+		gas, _, err := newContract(nil)
+		So(err, ShouldBeNil)
+		So(gas, ShouldEqual, gasLimit)
+
+		gas, price, err := newContract(&GasPolicy{Buffer: 1.2, Price: big.NewInt(7)})
+		So(err, ShouldBeNil)
+		So(gas, ShouldBeLessThan, gasLimit)
+		So(price.Int64(), ShouldEqual, 7)
+
+		//the simulated chain prices its transactions at 1 wei and up
+		_, price, err = newContract(&GasPolicy{Percentile: 100, Blocks: 5})
+		So(err, ShouldBeNil)
+		So(price.Int64(), ShouldEqual, 7)
+
+		_, price, err = newContract(&GasPolicy{Percentile: 50, MinPrice: big.NewInt(3)})
+		So(err, ShouldBeNil)
+		So(price.Int64(), ShouldEqual, 3)
+
+		_, _, err = newContract(&GasPolicy{Buffer: 1.2, Price: big.NewInt(7), MaxFee: new(big.Int).SetUint64(7 * 21000)})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "exceeds the max fee")
+	})
+}
@@ -15,6 +15,7 @@ package cmd
 
 import (
 	"context"
+	"crypto/rand"
 	"log"
 	"math/big"
 	"strings"
@@ -28,27 +29,23 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/pkg/errors"
 )
 
 type Handler struct {
 	ConfigPath string
 	Config     *Config
+	//salt of the next HashedTimelockV2 contract, a random one if nil
+	Salt *[32]byte
+	//the ERC721 or ERC1155 token to swap instead of ETH
+	Asset *NFTAsset
+	store *Store
 }
 
-func (h *Handler) estimateGas(ctx context.Context, auth *bind.TransactOpts, txType string, input []byte) error {
-	contract := func() *common.Address {
-		switch {
-		case txType == "Deploy":
-			return nil
-		case h.Config.Contract != "":
-			tmpAddress := common.HexToAddress(h.Config.Chain.Contract)
-			return &tmpAddress
-		default:
-			return nil
-		}
-	}()
-
+// estimateGas sets the gas limit of auth for a transaction of input to
+// contract, nil to deploy a contract.
+func (h *Handler) estimateGas(ctx context.Context, auth *txAuth, txType string, contract *common.Address, input []byte) error {
 	estimateGas, err := h.Config.client.EstimateGas(ctx, ethereum.CallMsg{
 		From:     auth.From,
 		To:       contract,
@@ -63,18 +60,103 @@ func (h *Handler) estimateGas(ctx context.Context, auth *bind.TransactOpts, txTy
 
 	feeByWei := new(big.Int).Mul(new(big.Int).SetUint64(estimateGas), auth.GasPrice).String()
 
+	//gas limit and fee ceiling of the gas policy
+	auth.GasLimit = h.Config.Chain.Gas.limit(estimateGas)
+	if err := h.Config.Chain.Gas.checkMaxFee(auth.GasLimit, auth.GasPrice); err != nil {
+		return errors.Wrapf(err, "%v Contract", txType)
+	}
+
 	balance, err := h.Config.client.BalanceAt(ctx, auth.From, nil)
 	if err != nil {
 		return errors.Wrapf(err, "account=%v get balance", auth.From.String())
 	}
 
 	log.Printf("from = %v, balance = %v", auth.From.String(), balance)
+
+	if auth.GasTipCap != nil {
+		//the base fee is burnt, the priority fee goes to the miner
+		gasPrice := new(big.Int).Add(auth.BaseFee, auth.GasTipCap)
+		if gasPrice.Cmp(auth.GasPrice) > 0 {
+			gasPrice = auth.GasPrice
+		}
+
+		log.Printf("%v Contract fee = gas(%v) * (baseFee(%v) + priorityFee(%v)) = %v", txType, estimateGas, auth.BaseFee, auth.GasTipCap,
+			new(big.Int).Mul(new(big.Int).SetUint64(estimateGas), gasPrice))
+		log.Printf("%v Contract max fee = gas limit(%v) * maxFeePerGas(%v) = %v", txType, auth.GasLimit, auth.GasPrice,
+			new(big.Int).Mul(new(big.Int).SetUint64(auth.GasLimit), auth.GasPrice))
+
+		return nil
+	}
+
 	log.Printf("%v Contract fee = gas(%v) * gasPrice(%v) = %v", txType, estimateGas, auth.GasPrice.String(), feeByWei)
+	log.Printf("%v Contract gas limit = %v", txType, auth.GasLimit)
 
 	return nil
 }
 
-func (h *Handler) sendTx(ctx context.Context, auth *bind.TransactOpts, data []byte, contract *common.Address) (*types.Transaction, error) {
+// sendTx signs a transaction with the next nonce of the nonce manager, adds
+// it to the journal and sends it.
+func (h *Handler) sendTx(ctx context.Context, auth *txAuth, data []byte, contract *common.Address) (Transaction, error) {
+	done, err := h.reserveNonce(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	txSigned, raw, err := h.sign(auth, data, contract)
+	if err != nil {
+		done("")
+		return nil, err
+	}
+
+	//journal the transaction before it leaves, so that it is found after a crash
+	if err := h.journalTx(txSigned, common.HexToAddress(h.Config.Account), raw); err != nil {
+		done("")
+		return nil, err
+	}
+
+	if err := h.Config.broadcast(ctx, raw); err != nil {
+		h.journalSendFailed(txSigned.Hash(), err)
+		if !sendUncertain(err) {
+			done("")
+			return nil, errors.Wrapf(err, "account=%v send tx", h.Config.Account)
+		}
+
+		//the node may have it, else the journal sends it again: it holds its nonce
+		log.Printf("account=%v send tx %v: %v, kept pending in the journal", h.Config.Account, txSigned.Hash().Hex(), err)
+	}
+
+	done(txSigned.Hash().Hex())
+
+	return txSigned, nil
+}
+
+// sign signs a dynamic fee or a legacy transaction as auth says, and returns
+// it encoded for eth_sendRawTransaction too.
+func (h *Handler) sign(auth *txAuth, data []byte, contract *common.Address) (Transaction, []byte, error) {
+	if auth.GasTipCap != nil {
+		txSigned, err := h.signDynamicFeeTx(auth, data, contract)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return txSigned, txSigned.MarshalBinary(), nil
+	}
+
+	txSigned, err := h.signTx(auth, data, contract)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := rlp.EncodeToBytes(txSigned)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "encode tx")
+	}
+
+	return txSigned, raw, nil
+}
+
+// signTx signs a legacy transaction, with auth.GasPrice as gas price.
+func (h *Handler) signTx(auth *txAuth, data []byte, contract *common.Address) (*types.Transaction, error) {
 	var (
 		rawTx    *types.Transaction
 		txSigned *types.Transaction
@@ -105,27 +187,52 @@ func (h *Handler) sendTx(ctx context.Context, auth *bind.TransactOpts, data []by
 		return nil, errors.Wrapf(err, "account=%v sign tx ", h.Config.Account)
 	}
 
-	err = h.Config.client.SendTransaction(ctx, txSigned)
+	return txSigned, nil
+}
+
+func (h *Handler) DeployContract(ctx context.Context) error {
+	address, err := h.deploy(ctx, htlc.HTLCBIN)
 	if err != nil {
-		return nil, errors.Wrapf(err, "account=%v send tx", h.Config.Account)
+		return err
 	}
 
-	return txSigned, nil
+	//update contract address
+	h.Config.Contract = address
+
+	//update config
+	return h.Config.rotate(h.ConfigPath)
 }
 
-func (h *Handler) DeployContract(ctx context.Context) error {
-	auth, err := h.Config.makeAuth(ctx, 0)
+// DeployContractV2 deploys HashedTimelockV2 as the contract of the own chain.
+func (h *Handler) DeployContractV2(ctx context.Context) error {
+	if htlc.HTLCV2BIN == "" {
+		return errNotCompiled("HashedTimelockV2")
+	}
+
+	address, err := h.deploy(ctx, htlc.HTLCV2BIN)
 	if err != nil {
 		return err
 	}
 
+	h.Config.Contract = address
+	h.Config.ContractVersion = HTLCVersion2
+
+	return h.Config.rotate(h.ConfigPath)
+}
+
+func (h *Handler) deploy(ctx context.Context, bin string) (string, error) {
+	auth, err := h.Config.makeAuth(ctx, 0)
+	if err != nil {
+		return "", err
+	}
+
 	log.Println("Deploy contract...")
 
-	input := common.FromHex(htlc.HTLCBIN)
+	input := common.FromHex(bin)
 
 	//estimate deploy contract fee
-	if err := h.estimateGas(ctx, auth, "Deploy", input); err != nil {
-		return err
+	if err := h.estimateGas(ctx, auth, "Deploy", nil, input); err != nil {
+		return "", err
 	}
 
 	//deploy-contract prompt
@@ -134,25 +241,52 @@ func (h *Handler) DeployContract(ctx context.Context) error {
 	//send tx
 	txSigned, err := h.sendTx(ctx, auth, input, nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	//update contract address
-	h.Config.Contract = crypto.CreateAddress(auth.From, txSigned.Nonce()).String()
+	address := crypto.CreateAddress(auth.From, txSigned.Nonce()).String()
 
-	log.Printf("contract address = %v", h.Config.Contract)
+	log.Printf("contract address = %v", address)
 	log.Printf("transaction hash = %v", txSigned.Hash().String())
 
-	//update config
-	return h.Config.rotate(h.ConfigPath)
+	return address, nil
 }
 
+// StatContract syncs the index of the contract of the connected chain and
+// logs its HTLCs by status.
 func (h *Handler) StatContract(ctx context.Context) error {
-	//TODO
+	ix, err := h.Indexer()
+	if err != nil {
+		return err
+	}
+
+	if _, err := ix.Sync(ctx); err != nil {
+		return errors.Wrap(err, "sync index")
+	}
+
+	head, err := ix.Head()
+	if err != nil {
+		return err
+	}
+
+	stats, err := ix.Stats(&IndexQuery{})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%v(%v) contract %v indexed up to block %v", h.Config.Chain.Name, h.Config.Chain.ID, h.Config.Chain.Contract, head)
+	for _, status := range []string{HTLCOpen, HTLCWithdrawn, HTLCRefunded} {
+		amount := stats.Amount[status]
+		if amount == nil {
+			continue
+		}
+		log.Printf("%-9s %v HTLCs, amount = %v", status, stats.Count[status], amount)
+	}
+
 	return nil
 }
 
-func (h *Handler) NewContract(ctx context.Context, participant common.Address, amount int64, hashLock [32]byte, timeLock *big.Int) (*types.Transaction, error) {
+func (h *Handler) NewContract(ctx context.Context, participant common.Address, amount int64, hashLock [32]byte, timeLock *big.Int) (Transaction, error) {
 	auth, err := h.Config.makeAuth(ctx, amount)
 	if err != nil {
 		return nil, errors.Wrapf(err, "make auth %v", h.Config.Account)
@@ -170,8 +304,10 @@ func (h *Handler) NewContract(ctx context.Context, participant common.Address, a
 		return nil, errors.Wrap(err, "pack newContract")
 	}
 
+	contract := common.HexToAddress(h.Config.Contract)
+
 	//estimate call contract fee
-	if err = h.estimateGas(ctx, auth, "Call", input); err != nil {
+	if err = h.estimateGas(ctx, auth, "Call", &contract, input); err != nil {
 		return nil, err
 	}
 
@@ -179,11 +315,31 @@ func (h *Handler) NewContract(ctx context.Context, participant common.Address, a
 	h.Config.promptConfirm("Call")
 
 	//send tx
-	contract := common.HexToAddress(h.Config.Contract)
-
 	return h.sendTx(ctx, auth, input, &contract)
 }
 
+// NewContractV2 is NewContract on a HashedTimelockV2 contract, whose id also
+// covers salt.
+func (h *Handler) NewContractV2(ctx context.Context, participant common.Address, amount int64, hashLock [32]byte, timeLock *big.Int, salt [32]byte) (Transaction, error) {
+	return h.transact(ctx, htlc.HTLCV2ABI, amount, "newContract", participant, hashLock, timeLock, salt)
+}
+
+// nextSalt returns h.Salt once, then random salts.
+func (h *Handler) nextSalt() ([32]byte, error) {
+	var salt [32]byte
+
+	if h.Salt != nil {
+		salt, h.Salt = *h.Salt, nil
+		return salt, nil
+	}
+
+	if _, err := rand.Read(salt[:]); err != nil {
+		return salt, errors.Wrap(err, "generate salt")
+	}
+
+	return salt, nil
+}
+
 func (h *Handler) GetContractId(ctx context.Context, txID common.Hash) (*HtlcLogHTLCNew, error) {
 	receipt, err := h.Config.client.TransactionReceipt(ctx, txID)
 	if err != nil {
@@ -227,6 +383,12 @@ func (h *Handler) AuditContract(ctx context.Context, result interface{}, contrac
 	//Call
 	from := common.HexToAddress(h.Config.Account)
 	contract := common.HexToAddress(h.Config.Chain.Contract)
+
+	//refuse look-alike contracts whose getContract may lie
+	if err := h.VerifyContract(ctx, contract); err != nil {
+		return err
+	}
+
 	msg := ethereum.CallMsg{From: from, To: &contract, Data: input}
 	opts := bind.CallOpts{From: from}
 	var output []byte
@@ -252,7 +414,7 @@ func (h *Handler) AuditContract(ctx context.Context, result interface{}, contrac
 	return nil
 }
 
-func (h *Handler) Redeem(ctx context.Context, contractId common.Hash, secret common.Hash) (*types.Transaction, error) {
+func (h *Handler) Redeem(ctx context.Context, contractId common.Hash, secret common.Hash) (Transaction, error) {
 	auth, err := h.Config.makeAuth(ctx, 0)
 	if err != nil {
 		return nil, errors.Wrapf(err, "make auth %v", h.Config.Account)
@@ -270,8 +432,10 @@ func (h *Handler) Redeem(ctx context.Context, contractId common.Hash, secret com
 		return nil, errors.Wrap(err, "pack withdraw")
 	}
 
+	contract := common.HexToAddress(h.Config.Chain.Contract)
+
 	//estimate call contract fee
-	if err = h.estimateGas(ctx, auth, "Call", input); err != nil {
+	if err = h.estimateGas(ctx, auth, "Call", &contract, input); err != nil {
 		return nil, err
 	}
 
@@ -279,12 +443,10 @@ func (h *Handler) Redeem(ctx context.Context, contractId common.Hash, secret com
 	h.Config.promptConfirm("Call")
 
 	//send tx
-	contract := common.HexToAddress(h.Config.Chain.Contract)
-
 	return h.sendTx(ctx, auth, input, &contract)
 }
 
-func (h *Handler) Refund(ctx context.Context, contractId common.Hash) (*types.Transaction, error) {
+func (h *Handler) Refund(ctx context.Context, contractId common.Hash) (Transaction, error) {
 	auth, err := h.Config.makeAuth(ctx, 0)
 	if err != nil {
 		return nil, errors.Wrapf(err, "make auth %v", h.Config.Account)
@@ -302,8 +464,10 @@ func (h *Handler) Refund(ctx context.Context, contractId common.Hash) (*types.Tr
 		return nil, errors.Wrap(err, "pack refund")
 	}
 
+	contract := common.HexToAddress(h.Config.Chain.Contract)
+
 	//estimate call contract fee
-	if err = h.estimateGas(ctx, auth, "Call", input); err != nil {
+	if err = h.estimateGas(ctx, auth, "Call", &contract, input); err != nil {
 		return nil, err
 	}
 
@@ -311,7 +475,99 @@ func (h *Handler) Refund(ctx context.Context, contractId common.Hash) (*types.Tr
 	h.Config.promptConfirm("Call")
 
 	//send tx
-	contract := common.HexToAddress(h.Config.Chain.Contract)
+	return h.sendTx(ctx, auth, input, &contract)
+}
+
+// transact sends a call of method of the contractABI contract on the chain
+// the handler is connected to.
+func (h *Handler) transact(ctx context.Context, contractABI string, value int64, method string, args ...interface{}) (Transaction, error) {
+	return h.transactTo(ctx, common.HexToAddress(h.Config.Chain.Contract), contractABI, value, method, args...)
+}
 
+// transactTo is transact on the contractABI contract at address contract.
+func (h *Handler) transactTo(ctx context.Context, contract common.Address, contractABI string, value int64, method string, args ...interface{}) (Transaction, error) {
+	auth, err := h.Config.makeAuth(ctx, value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "make auth %v", h.Config.Account)
+	}
+
+	log.Printf("Call %v ...", method)
+
+	parsedABI, err := abi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse ABI")
+	}
+
+	input, err := parsedABI.Pack(method, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pack %v", method)
+	}
+
+	//estimate call contract fee
+	if err = h.estimateGas(ctx, auth, "Call", &contract, input); err != nil {
+		return nil, err
+	}
+
+	//call-contract prompt
+	h.Config.promptConfirm("Call")
+
+	//send tx
 	return h.sendTx(ctx, auth, input, &contract)
 }
+
+// call unpacks the result of the constant method of the contractABI contract
+// on the chain the handler is connected to.
+func (h *Handler) call(ctx context.Context, contractABI string, result interface{}, method string, args ...interface{}) error {
+	return h.callTo(ctx, common.HexToAddress(h.Config.Chain.Contract), contractABI, result, method, args...)
+}
+
+// callTo is call on the contractABI contract at address contract.
+func (h *Handler) callTo(ctx context.Context, contract common.Address, contractABI string, result interface{}, method string, args ...interface{}) error {
+	parsedABI, err := abi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		return errors.Wrap(err, "parse ABI")
+	}
+
+	input, err := parsedABI.Pack(method, args...)
+	if err != nil {
+		return errors.Wrapf(err, "pack %v", method)
+	}
+
+	from := common.HexToAddress(h.Config.Account)
+
+	output, err := h.Config.client.CallContract(ctx, ethereum.CallMsg{From: from, To: &contract, Data: input}, nil)
+	if err != nil {
+		return errors.Wrap(err, "call CallContract")
+	}
+
+	if err = parsedABI.Unpack(result, method, output); err != nil {
+		return errors.Wrap(err, "unpack result of contract call")
+	}
+
+	return nil
+}
+
+// decodeCall returns the method of the contractABI contract input calls and
+// its arguments.
+func decodeCall(contractABI string, input []byte) (string, []interface{}, error) {
+	parsedABI, err := abi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "parse ABI")
+	}
+
+	if len(input) < 4 {
+		return "", nil, errors.New("no method in the input")
+	}
+
+	method, err := parsedABI.MethodById(input[:4])
+	if err != nil {
+		return "", nil, errors.Wrap(err, "unknown method")
+	}
+
+	args, err := method.Inputs.UnpackValues(input[4:])
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "unpack %v", method.Name)
+	}
+
+	return method.Name, args, nil
+}
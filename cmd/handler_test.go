@@ -17,7 +17,6 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -68,7 +67,7 @@ func testHashPair() struct {
 	return hashPair
 }
 
-func testGetBalance(t *testing.T, ctx context.Context, client *ethclient.Client, account common.Address) *big.Int {
+func testGetBalance(t *testing.T, ctx context.Context, client ethClient, account common.Address) *big.Int {
 	balance, err := client.BalanceAt(ctx, account, nil)
 	if err != nil {
 		t.Fatalf("get balance: %v", err)
@@ -81,6 +80,9 @@ func testTransfer(t *testing.T, ctx context.Context, h *Handler, account common.
 	if err != nil {
 		t.Fatal(err)
 	}
+	if auth.Nonce, err = h.Config.pendingNonce(ctx); err != nil {
+		t.Fatal(err)
+	}
 
 	rawTx := types.NewTransaction(auth.Nonce.Uint64(), account, auth.Value, auth.GasLimit, auth.GasPrice, nil)
 	txSigned, err := h.Config.ks.SignTxWithPassphrase(
@@ -139,8 +141,8 @@ func TestHandlerAll_MainFlow(t *testing.T) {
 		timeLockOnChain1 = new(big.Int).SetInt64(time.Now().Unix() + 100)
 		timeLockOnChain2 = new(big.Int)
 
-		initiatorLockOnChain1Tx   *types.Transaction
-		participantLockOnChain2Tx *types.Transaction
+		initiatorLockOnChain1Tx   Transaction
+		participantLockOnChain2Tx Transaction
 		ContractIDOnChain1        [32]byte
 		ContractIDOnChain2        [32]byte
 	)
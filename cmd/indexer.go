@@ -0,0 +1,668 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// store buckets of the indexer
+const (
+	indexBucket = "index"
+	htlcBucket  = "htlcs"
+)
+
+// blocks deeper than this under the head are taken as final, the indexer
+// forgets their hashes
+const indexFinality = 128
+
+// blocks per log query
+var indexBatch uint64 = 5000
+
+// status of an IndexedHTLC
+const (
+	HTLCOpen      = "open"
+	HTLCWithdrawn = "withdrawn"
+	HTLCRefunded  = "refunded"
+)
+
+// IndexedHTLC is a HTLC of a HashedTimelock contract as its events tell.
+type IndexedHTLC struct {
+	ChainID    string      `json:"chainID"`
+	Chain      string      `json:"chain"`
+	Contract   string      `json:"contract"`
+	ContractID common.Hash `json:"contractId"`
+	Sender     string      `json:"sender"`
+	Receiver   string      `json:"receiver"`
+	Amount     *big.Int    `json:"amount"`
+	Hashlock   common.Hash `json:"hashlock"`
+	Timelock   *big.Int    `json:"timelock"`
+	Status     string      `json:"status"`
+	//logged by the withdraw of HashedTimelockV2 only
+	Preimage common.Hash `json:"preimage,omitempty"`
+	//block, time and transaction of the LogHTLCNew
+	Block   uint64      `json:"block"`
+	Created int64       `json:"created"`
+	TxID    common.Hash `json:"txid"`
+	//block and transaction of the LogHTLCWithdraw or LogHTLCRefund
+	EndBlock uint64       `json:"endBlock,omitempty"`
+	EndTxID  *common.Hash `json:"endTxid,omitempty"`
+	//the LogHTLCExtend of HashedTimelockV2, oldest first
+	Extends []IndexedExtend `json:"extends,omitempty"`
+}
+
+// IndexedExtend is a LogHTLCExtend, kept to roll the timelock back on a reorg.
+type IndexedExtend struct {
+	Block uint64      `json:"block"`
+	TxID  common.Hash `json:"txid"`
+	//the timelock before the extend
+	From *big.Int `json:"from"`
+}
+
+// indexedBlock is a block the indexer read, to detect a reorg.
+type indexedBlock struct {
+	Number uint64      `json:"number"`
+	Hash   common.Hash `json:"hash"`
+}
+
+// indexState is how far the indexer followed the chain.
+type indexState struct {
+	//first block to index, 0 by default
+	Start uint64 `json:"start"`
+	//last block indexed, 0 before the first sync
+	Head uint64 `json:"head"`
+	//the head and the blocks with events above the final ones, oldest first
+	Blocks []indexedBlock `json:"blocks,omitempty"`
+}
+
+// record adds block to the blocks of s.
+func (s *indexState) record(number uint64, hash common.Hash) {
+	if n := len(s.Blocks); n > 0 && s.Blocks[n-1].Number == number {
+		s.Blocks[n-1].Hash = hash
+		return
+	}
+	s.Blocks = append(s.Blocks, indexedBlock{number, hash})
+}
+
+// prune forgets the hashes of the final blocks, but the last one.
+func (s *indexState) prune(head uint64) {
+	for len(s.Blocks) > 1 && s.Blocks[0].Number+indexFinality < head {
+		s.Blocks = s.Blocks[1:]
+	}
+}
+
+// block returns the number and the hash of block number, of the head if
+// number is nil. The node gives the hash if it has a JSON-RPC, as the headers
+// of aswap do not hash the fields of the later forks.
+func (c *Config) block(ctx context.Context, number *big.Int) (*indexedBlock, error) {
+	if c.rpc == nil {
+		header, err := c.client.HeaderByNumber(ctx, number)
+		if err != nil || header == nil {
+			return nil, err
+		}
+		return &indexedBlock{header.Number.Uint64(), header.Hash()}, nil
+	}
+
+	arg := "latest"
+	if number != nil {
+		arg = hexutil.EncodeBig(number)
+	}
+
+	var block *struct {
+		Number hexutil.Uint64 `json:"number"`
+		Hash   common.Hash    `json:"hash"`
+	}
+	if err := c.rpc.CallContext(ctx, &block, "eth_getBlockByNumber", arg, false); err != nil || block == nil {
+		return nil, err
+	}
+
+	return &indexedBlock{uint64(block.Number), block.Hash}, nil
+}
+
+// IndexQuery selects indexed HTLCs, the zero fields select all of them.
+type IndexQuery struct {
+	ContractID common.Hash
+	Sender     string
+	Receiver   string
+	Hashlock   common.Hash
+	Status     string
+	//the HTLC was created at or after From and before To
+	From time.Time
+	To   time.Time
+}
+
+func (q *IndexQuery) match(htlc *IndexedHTLC) bool {
+	switch {
+	case q.ContractID != (common.Hash{}) && q.ContractID != htlc.ContractID:
+		return false
+	case q.Sender != "" && !strings.EqualFold(q.Sender, htlc.Sender):
+		return false
+	case q.Receiver != "" && !strings.EqualFold(q.Receiver, htlc.Receiver):
+		return false
+	case q.Hashlock != (common.Hash{}) && q.Hashlock != htlc.Hashlock:
+		return false
+	case q.Status != "" && q.Status != htlc.Status:
+		return false
+	case !q.From.IsZero() && htlc.Created < q.From.Unix():
+		return false
+	case !q.To.IsZero() && htlc.Created >= q.To.Unix():
+		return false
+	}
+	return true
+}
+
+// IndexStats sums up indexed HTLCs.
+type IndexStats struct {
+	//status => number of HTLCs
+	Count map[string]int
+	//status => amount
+	Amount map[string]*big.Int
+}
+
+// Indexer follows the events of the HashedTimelock contract of a chain into
+// the store, rolling them back when the blocks they are in leave the chain.
+type Indexer struct {
+	h        *Handler
+	store    *Store
+	decoder  *HTLCEventDecoder
+	contract common.Address
+	//chainID/contract, the prefix of the keys of the indexer
+	key string
+}
+
+// Indexer returns the indexer of the contract of the connected chain.
+func (h *Handler) Indexer() (*Indexer, error) {
+	if h.Config.Chain == nil || h.Config.Chain.Type != ChainEVM {
+		return nil, errors.New("the indexer follows the HashedTimelock contract of an EVM chain")
+	}
+
+	if err := h.Config.ValidateAddress(h.Config.Chain.Contract); err != nil {
+		return nil, err
+	}
+
+	store, err := h.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := h.EventDecoder()
+	if err != nil {
+		return nil, err
+	}
+
+	contract := common.HexToAddress(h.Config.Chain.Contract)
+
+	return &Indexer{
+		h:        h,
+		store:    store,
+		decoder:  decoder,
+		contract: contract,
+		key:      h.Config.Chain.ID.String() + "/" + contract.Hex(),
+	}, nil
+}
+
+// syncedIndexer returns the indexer of the connected chain if it was synced
+// before, nil if the chain is not indexed. The first sync reads the chain
+// from the start block, which is left to aswap index.
+func (h *Handler) syncedIndexer() (*Indexer, error) {
+	if h.Config.Chain == nil || h.Config.Chain.Type != ChainEVM {
+		return nil, nil
+	}
+
+	if store, err := h.localStore(); err != nil || store == nil {
+		return nil, err
+	}
+
+	ix, err := h.Indexer()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := ix.Head()
+	if err != nil || head == 0 {
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+func (ix *Indexer) htlcKey(contractId common.Hash) string {
+	return ix.key + "/" + contractId.Hex()
+}
+
+// SetStart sets the first block the indexer reads, before the first sync.
+func (ix *Indexer) SetStart(block uint64) error {
+	state := new(indexState)
+	return ix.store.Update(indexBucket, ix.key, state, func(exists bool) error {
+		if state.Head != 0 {
+			return errors.Errorf("the index of %v is at block %v already", ix.key, state.Head)
+		}
+		state.Start = block
+		return nil
+	})
+}
+
+// Head returns the last block indexed.
+func (ix *Indexer) Head() (uint64, error) {
+	state := new(indexState)
+	_, err := ix.store.Get(indexBucket, ix.key, state)
+
+	return state.Head, err
+}
+
+// Sync rolls back the events of the blocks that left the chain and indexes
+// the events up to the head. It returns the HTLCs that changed.
+func (ix *Indexer) Sync(ctx context.Context) ([]*IndexedHTLC, error) {
+	client := ix.h.Config.client
+
+	state := new(indexState)
+	if _, err := ix.store.Get(indexBucket, ix.key, state); err != nil {
+		return nil, err
+	}
+
+	changed := make(map[common.Hash]*IndexedHTLC)
+
+	if err := ix.rollback(ctx, state, changed); err != nil {
+		return nil, err
+	}
+
+	head, err := ix.h.Config.block(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get head")
+	}
+
+	from := state.Head + 1
+	if len(state.Blocks) == 0 {
+		from = state.Start
+	}
+
+	for from <= head.Number {
+		to := from + indexBatch - 1
+		if to > head.Number {
+			to = head.Number
+		}
+
+		logs, err := client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: []common.Address{ix.contract},
+			Topics:    [][]common.Hash{ix.decoder.AllTopics()},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "filter logs")
+		}
+
+		//the range is read from the node before the store is locked
+		var (
+			events []*HTLCEvent
+			blocks []indexedBlock
+		)
+		times := make(map[uint64]int64)
+		for _, l := range logs {
+			if l.Removed {
+				continue
+			}
+
+			event, err := ix.decoder.Decode(l)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+			blocks = append(blocks, indexedBlock{l.BlockNumber, l.BlockHash})
+
+			if _, ok := times[l.BlockNumber]; !ok && event.Type == EventNew {
+				header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(l.BlockNumber))
+				if err != nil {
+					return nil, errors.Wrapf(err, "get block %v", l.BlockNumber)
+				}
+				times[l.BlockNumber] = int64(header.Time)
+			}
+		}
+
+		last := head
+		if to < head.Number {
+			last, err = ix.h.Config.block(ctx, new(big.Int).SetUint64(to))
+			if err == nil && last == nil {
+				err = ethereum.NotFound
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "get block %v", to)
+			}
+		}
+
+		next := *state
+		next.Blocks = append([]indexedBlock(nil), state.Blocks...)
+		for _, b := range blocks {
+			next.record(b.Number, b.Hash)
+		}
+		next.Head = last.Number
+		next.record(last.Number, last.Hash)
+		next.prune(next.Head)
+
+		//the events of the range and the state are written at once, a sync
+		//cut short goes on from the last range written
+		ranged := make(map[common.Hash]*IndexedHTLC)
+		err = ix.store.Batch(func(b *Batch) error {
+			for _, event := range events {
+				htlc, err := ix.apply(b, event, times[event.BlockNumber])
+				if err != nil {
+					return err
+				}
+				if htlc != nil {
+					ranged[htlc.ContractID] = htlc
+				}
+			}
+			return b.Put(indexBucket, ix.key, &next)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		*state = next
+		for id, htlc := range ranged {
+			changed[id] = htlc
+		}
+
+		from = to + 1
+	}
+
+	htlcs := make([]*IndexedHTLC, 0, len(changed))
+	for _, htlc := range changed {
+		htlcs = append(htlcs, htlc)
+	}
+	sortHTLCs(htlcs)
+
+	return htlcs, nil
+}
+
+// rollback finds the last block of state still on the chain and undoes the
+// events above it.
+func (ix *Indexer) rollback(ctx context.Context, state *indexState, changed map[common.Hash]*IndexedHTLC) error {
+	if len(state.Blocks) == 0 {
+		return nil
+	}
+
+	fork := -1
+	for i := len(state.Blocks) - 1; i >= 0; i-- {
+		b := state.Blocks[i]
+
+		canonical, err := ix.h.Config.block(ctx, new(big.Int).SetUint64(b.Number))
+		if err != nil && err != ethereum.NotFound {
+			return errors.Wrapf(err, "get block %v", b.Number)
+		}
+		if canonical != nil && canonical.Hash == b.Hash {
+			fork = i
+			break
+		}
+	}
+
+	if fork == len(state.Blocks)-1 {
+		return nil
+	}
+
+	//below the oldest block known, the index starts over
+	var keep uint64
+	if fork >= 0 {
+		keep = state.Blocks[fork].Number
+	}
+	log.Printf("reorg on %v: rolling the index back from block %v to %v", ix.key, state.Head, keep)
+
+	next := *state
+	if fork < 0 {
+		next.Head, next.Blocks = 0, nil
+	} else {
+		next.Head, next.Blocks = keep, state.Blocks[:fork+1]
+	}
+
+	reverted := make(map[common.Hash]*IndexedHTLC)
+	var deleted []common.Hash
+	err := ix.store.Batch(func(b *Batch) error {
+		for _, key := range b.Keys(htlcBucket) {
+			if !strings.HasPrefix(key, ix.key+"/") {
+				continue
+			}
+
+			htlc := new(IndexedHTLC)
+			if _, err := b.Get(htlcBucket, key, htlc); err != nil {
+				return err
+			}
+
+			if fork < 0 || htlc.Block > keep {
+				b.Delete(htlcBucket, key)
+				deleted = append(deleted, htlc.ContractID)
+				continue
+			}
+
+			if !htlc.revert(keep) {
+				continue
+			}
+			if err := b.Put(htlcBucket, key, htlc); err != nil {
+				return err
+			}
+			reverted[htlc.ContractID] = htlc
+		}
+
+		return b.Put(indexBucket, ix.key, &next)
+	})
+	if err != nil {
+		return err
+	}
+
+	*state = next
+	for _, id := range deleted {
+		delete(changed, id)
+	}
+	for id, htlc := range reverted {
+		changed[id] = htlc
+	}
+
+	return nil
+}
+
+// revert undoes the events of htlc above block keep and reports whether it
+// changed.
+func (htlc *IndexedHTLC) revert(keep uint64) bool {
+	changed := false
+
+	if htlc.EndBlock > keep {
+		htlc.Status, htlc.Preimage, htlc.EndBlock, htlc.EndTxID = HTLCOpen, common.Hash{}, 0, nil
+		changed = true
+	}
+
+	for n := len(htlc.Extends); n > 0 && htlc.Extends[n-1].Block > keep; n-- {
+		htlc.Timelock = htlc.Extends[n-1].From
+		htlc.Extends = htlc.Extends[:n-1]
+		changed = true
+	}
+
+	return changed
+}
+
+// apply writes the change of event to its HTLC in b, nil if the HTLC is not
+// indexed.
+func (ix *Indexer) apply(b *Batch, event *HTLCEvent, created int64) (*IndexedHTLC, error) {
+	key := ix.htlcKey(event.ContractID)
+
+	htlc := new(IndexedHTLC)
+	ok, err := b.Get(htlcBucket, key, htlc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch event.Type {
+	case EventNew:
+		htlc = &IndexedHTLC{
+			ChainID:    ix.h.Config.Chain.ID.String(),
+			Chain:      ix.h.Config.Chain.Name,
+			Contract:   ix.contract.Hex(),
+			ContractID: event.ContractID,
+			Sender:     event.Sender.Hex(),
+			Receiver:   event.Receiver.Hex(),
+			Amount:     event.Amount,
+			Hashlock:   event.Hashlock,
+			Timelock:   event.Timelock,
+			Status:     HTLCOpen,
+			Block:      event.BlockNumber,
+			Created:    created,
+			TxID:       event.TxHash,
+		}
+	case EventWithdraw, EventRefund:
+		//a HTLC created before the start block
+		if !ok {
+			return nil, nil
+		}
+
+		htlc.Status = HTLCRefunded
+		if event.Type == EventWithdraw {
+			htlc.Status, htlc.Preimage = HTLCWithdrawn, event.Preimage
+		}
+		txid := event.TxHash
+		htlc.EndBlock, htlc.EndTxID = event.BlockNumber, &txid
+	case EventExtend:
+		if !ok {
+			return nil, nil
+		}
+		//read again by another sync
+		for _, extend := range htlc.Extends {
+			if extend.TxID == event.TxHash {
+				return htlc, nil
+			}
+		}
+
+		htlc.Extends = append(htlc.Extends, IndexedExtend{
+			Block: event.BlockNumber,
+			TxID:  event.TxHash,
+			From:  htlc.Timelock,
+		})
+		htlc.Timelock = event.Timelock
+	}
+
+	return htlc, b.Put(htlcBucket, key, htlc)
+}
+
+// Lookup returns the indexed HTLC contractId and whether it is indexed.
+func (ix *Indexer) Lookup(contractId common.Hash) (*IndexedHTLC, bool, error) {
+	htlc := new(IndexedHTLC)
+	ok, err := ix.store.Get(htlcBucket, ix.htlcKey(contractId), htlc)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	return htlc, true, nil
+}
+
+// details returns the state of htlc as AuditContract does. The preimage of a
+// HTLC withdrawn from HashedTimelock is not logged, it is zero.
+func (htlc *IndexedHTLC) details() *ContractDetails {
+	return &ContractDetails{
+		Sender:    common.HexToAddress(htlc.Sender),
+		Receiver:  common.HexToAddress(htlc.Receiver),
+		Amount:    htlc.Amount,
+		Hashlock:  htlc.Hashlock,
+		Timelock:  htlc.Timelock,
+		Withdrawn: htlc.Status == HTLCWithdrawn,
+		Refunded:  htlc.Status == HTLCRefunded,
+		Preimage:  htlc.Preimage,
+	}
+}
+
+// Query returns the indexed HTLCs q selects, oldest first.
+func (ix *Indexer) Query(q *IndexQuery) ([]*IndexedHTLC, error) {
+	keys, err := ix.store.Keys(htlcBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var htlcs []*IndexedHTLC
+	for _, key := range keys {
+		if !strings.HasPrefix(key, ix.key+"/") {
+			continue
+		}
+
+		htlc := new(IndexedHTLC)
+		if _, err := ix.store.Get(htlcBucket, key, htlc); err != nil {
+			return nil, err
+		}
+
+		if q.match(htlc) {
+			htlcs = append(htlcs, htlc)
+		}
+	}
+	sortHTLCs(htlcs)
+
+	return htlcs, nil
+}
+
+// Stats sums up the indexed HTLCs q selects.
+func (ix *Indexer) Stats(q *IndexQuery) (*IndexStats, error) {
+	htlcs, err := ix.Query(q)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &IndexStats{Count: make(map[string]int), Amount: make(map[string]*big.Int)}
+	for _, htlc := range htlcs {
+		stats.Count[htlc.Status]++
+		if stats.Amount[htlc.Status] == nil {
+			stats.Amount[htlc.Status] = new(big.Int)
+		}
+		stats.Amount[htlc.Status].Add(stats.Amount[htlc.Status], htlc.Amount)
+	}
+
+	return stats, nil
+}
+
+// Follow syncs the index every watchInterval and sends the HTLCs that
+// changed to sink, until ctx is done.
+func (ix *Indexer) Follow(ctx context.Context, sink chan<- *IndexedHTLC) error {
+	for {
+		htlcs, err := ix.Sync(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, htlc := range htlcs {
+			select {
+			case sink <- htlc:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case <-time.After(watchInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func sortHTLCs(htlcs []*IndexedHTLC) {
+	sort.SliceStable(htlcs, func(i, j int) bool {
+		if htlcs[i].Block != htlcs[j].Block {
+			return htlcs[i].Block < htlcs[j].Block
+		}
+		return htlcs[i].ContractID.Hex() < htlcs[j].ContractID.Hex()
+	})
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndexer(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("the indexer follows the HTLC events and rolls back reorgs", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender, receiver := handlers[0], handlers[1]
+		senderAddress := crypto.PubkeyToAddress(senderKey.PublicKey)
+		receiverAddress := crypto.PubkeyToAddress(receiverKey.PublicKey)
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		newContract := func(to common.Address, pair *SecretHashPair) common.Hash {
+			tx, err := sender.NewContract(ctx, to, 1000, pair.Hash, timeLock)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			event, err := sender.GetContractId(ctx, tx.Hash())
+			So(err, ShouldBeNil)
+			return event.ContractId
+		}
+
+		pair := NewSecretHashPair()
+		redeemed := newContract(receiverAddress, pair)
+		refunded := newContract(senderAddress, NewSecretHashPair())
+
+		ix, err := receiver.Indexer()
+		So(err, ShouldBeNil)
+
+		changed, err := ix.Sync(ctx)
+		So(err, ShouldBeNil)
+		So(changed, ShouldHaveLength, 2)
+		So(changed[0].ContractID, ShouldEqual, redeemed)
+		So(changed[0].Status, ShouldEqual, HTLCOpen)
+		So(changed[0].Sender, ShouldEqual, senderAddress.Hex())
+		So(changed[0].Amount.Int64(), ShouldEqual, 1000)
+		So(changed[0].Hashlock, ShouldEqual, common.Hash(pair.Hash))
+		So(changed[0].Created, ShouldEqual, int64(sim.Blockchain().GetHeaderByNumber(changed[0].Block).Time))
+
+		head, err := ix.Head()
+		So(err, ShouldBeNil)
+		So(head, ShouldEqual, sim.Blockchain().CurrentHeader().Number.Uint64())
+
+		//nothing new
+		changed, err = ix.Sync(ctx)
+		So(err, ShouldBeNil)
+		So(changed, ShouldBeEmpty)
+
+		_, err = receiver.Redeem(ctx, redeemed, pair.Secret)
+		So(err, ShouldBeNil)
+		sim.Commit()
+		So(sim.AdjustTime(2*time.Hour), ShouldBeNil)
+		sim.Commit()
+		_, err = sender.Refund(ctx, refunded)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		changed, err = ix.Sync(ctx)
+		So(err, ShouldBeNil)
+		So(changed, ShouldHaveLength, 2)
+
+		htlcs, err := ix.Query(&IndexQuery{Receiver: receiverAddress.Hex()})
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 1)
+		So(htlcs[0].Status, ShouldEqual, HTLCWithdrawn)
+		So(htlcs[0].EndTxID, ShouldNotBeNil)
+
+		htlcs, err = ix.Query(&IndexQuery{Sender: senderAddress.Hex(), Status: HTLCRefunded})
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 1)
+		So(htlcs[0].ContractID, ShouldEqual, refunded)
+
+		htlcs, err = ix.Query(&IndexQuery{Hashlock: pair.Hash})
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 1)
+		htlcs, err = ix.Query(&IndexQuery{ContractID: refunded})
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 1)
+		htlcs, err = ix.Query(&IndexQuery{From: time.Now().Add(24 * time.Hour)})
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldBeEmpty)
+
+		stats, err := ix.Stats(&IndexQuery{})
+		So(err, ShouldBeNil)
+		So(stats.Count[HTLCWithdrawn], ShouldEqual, 1)
+		So(stats.Count[HTLCRefunded], ShouldEqual, 1)
+		So(stats.Amount[HTLCRefunded].Int64(), ShouldEqual, 1000)
+
+		//the blocks of the withdraw and the refund left the chain
+		state := new(indexState)
+		_, err = ix.store.Get(indexBucket, ix.key, state)
+		So(err, ShouldBeNil)
+		n := len(state.Blocks)
+		So(n, ShouldBeGreaterThan, 2)
+		for i := n - 3; i < n; i++ {
+			state.Blocks[i].Hash = common.HexToHash("0x01")
+		}
+		So(ix.store.Put(indexBucket, ix.key, state), ShouldBeNil)
+
+		changed, err = ix.Sync(ctx)
+		So(err, ShouldBeNil)
+		So(changed, ShouldHaveLength, 2)
+		htlcs, err = ix.Query(&IndexQuery{})
+		So(err, ShouldBeNil)
+		So(htlcs[0].Status, ShouldEqual, HTLCWithdrawn)
+		So(htlcs[1].Status, ShouldEqual, HTLCRefunded)
+
+		//none of the blocks is left, the index starts over
+		So(ix.store.Put(htlcBucket, ix.htlcKey(common.HexToHash("0x02")), &IndexedHTLC{ContractID: common.HexToHash("0x02"), Status: HTLCOpen, Amount: new(big.Int)}), ShouldBeNil)
+		_, err = ix.store.Get(indexBucket, ix.key, state)
+		So(err, ShouldBeNil)
+		for i := range state.Blocks {
+			state.Blocks[i].Hash = common.HexToHash("0x01")
+		}
+		So(ix.store.Put(indexBucket, ix.key, state), ShouldBeNil)
+
+		_, err = ix.Sync(ctx)
+		So(err, ShouldBeNil)
+		htlcs, err = ix.Query(&IndexQuery{})
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 2)
+		So(htlcs[0].ContractID, ShouldEqual, redeemed)
+		So(htlcs[1].ContractID, ShouldEqual, refunded)
+	})
+	Convey("the index moves the timelock on extend and serves the watches", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender, receiver := handlers[0], handlers[1]
+		receiverAddress := crypto.PubkeyToAddress(receiverKey.PublicKey)
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		pair := NewSecretHashPair()
+		tx, err := sender.NewContract(ctx, receiverAddress, 1000, pair.Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+		created, err := sender.GetContractId(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+		id := created.ContractId
+
+		ix, err := receiver.Indexer()
+		So(err, ShouldBeNil)
+		_, err = ix.Sync(ctx)
+		So(err, ShouldBeNil)
+		head, err := ix.Head()
+		So(err, ShouldBeNil)
+
+		//HashedTimelock does not extend, the log of HashedTimelockV2 is applied
+		//as the sync would
+		extend := &HTLCEvent{
+			Type:        EventExtend,
+			ContractID:  id,
+			TxHash:      common.HexToHash("0x0e"),
+			BlockNumber: head,
+			Timelock:    new(big.Int).Add(timeLock, big.NewInt(3600)),
+		}
+		unknown := *extend
+		unknown.ContractID = common.HexToHash("0x03")
+		So(ix.store.Batch(func(b *Batch) error {
+			for _, event := range []*HTLCEvent{extend, extend, &unknown} {
+				if _, err := ix.apply(b, event, 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), ShouldBeNil)
+
+		htlc, ok, err := ix.Lookup(id)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(htlc.Timelock, ShouldResemble, extend.Timelock)
+		So(htlc.Extends, ShouldHaveLength, 1)
+		So(htlc.Extends[0].From, ShouldResemble, timeLock)
+
+		_, ok, err = ix.Lookup(unknown.ContractID)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		//the block of the extend left the chain
+		state := new(indexState)
+		_, err = ix.store.Get(indexBucket, ix.key, state)
+		So(err, ShouldBeNil)
+		state.Blocks[len(state.Blocks)-1].Hash = common.HexToHash("0x01")
+		So(ix.store.Put(indexBucket, ix.key, state), ShouldBeNil)
+
+		changed, err := ix.Sync(ctx)
+		So(err, ShouldBeNil)
+		So(changed, ShouldHaveLength, 1)
+		So(changed[0].Timelock, ShouldResemble, timeLock)
+		So(changed[0].Extends, ShouldBeEmpty)
+
+		So(receiver.StatContract(ctx), ShouldBeNil)
+
+		//a HTLC only the index knows: the watches read the index
+		indexed := common.HexToHash("0x04")
+		endTxID := common.HexToHash("0x06")
+		So(ix.store.Put(htlcBucket, ix.htlcKey(indexed), &IndexedHTLC{
+			ContractID: indexed,
+			Amount:     big.NewInt(1000),
+			Timelock:   timeLock,
+			Status:     HTLCWithdrawn,
+			Preimage:   pair.Secret,
+			Block:      head,
+			TxID:       common.HexToHash("0x05"),
+			EndTxID:    &endTxID,
+		}), ShouldBeNil)
+
+		backend, err := receiver.Backend()
+		So(err, ShouldBeNil)
+		events := make(chan *SwapEvent, 2)
+		So(backend.WatchEvents(ctx, indexed.Hex(), events), ShouldBeNil)
+		event := <-events
+		So(event.Type, ShouldEqual, EventNew)
+		So(event.TxID, ShouldEqual, common.HexToHash("0x05").Hex())
+		event = <-events
+		So(event.Type, ShouldEqual, EventWithdraw)
+		So(event.TxID, ShouldEqual, endTxID.Hex())
+		So(event.Secret, ShouldEqual, pair.Secret)
+
+		tower := &Watchtower{Handlers: map[string]*Handler{"sim": receiver}, Store: receiver.store}
+		tower.indexed = tower.syncIndexes(ctx)
+		details, err := tower.contract(ctx, receiver, &TowerLeg{Chain: "sim", ContractID: indexed.Hex()})
+		So(err, ShouldBeNil)
+		So(details.Withdrawn, ShouldBeTrue)
+		So(details.Preimage, ShouldEqual, pair.Secret)
+	})
+}
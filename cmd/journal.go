@@ -0,0 +1,295 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/pkg/errors"
+)
+
+// store bucket of the transaction journal
+const journalBucket = "txs"
+
+// status of a JournalTx
+const (
+	TxPending  = "pending"
+	TxMined    = "mined"
+	TxFailed   = "failed"
+	TxDropped  = "dropped"
+	TxReplaced = "replaced"
+	//the node refused it when it was sent
+	TxRejected = "rejected"
+	//the node could not tell, it is checked again
+	TxUnknown = "unknown"
+)
+
+// JournalTx is a signed transaction in the journal, written before it is
+// sent.
+type JournalTx struct {
+	TxID    string        `json:"txid"`
+	ChainID string        `json:"chainID"`
+	Chain   string        `json:"chain"`
+	From    string        `json:"from"`
+	Nonce   uint64        `json:"nonce"`
+	To      string        `json:"to,omitempty"`
+	Raw     hexutil.Bytes `json:"raw"`
+	Status  string        `json:"status"`
+	Block   uint64        `json:"block,omitempty"`
+	Sent    int64         `json:"sent"`
+	Error   string        `json:"error,omitempty"`
+	//times the dropped transaction was sent again
+	Rebroadcasts int `json:"rebroadcasts,omitempty"`
+}
+
+// closed tells if the status of e is final.
+func (e *JournalTx) closed() bool {
+	return e.Status == TxMined || e.Status == TxFailed || e.Status == TxReplaced || e.Status == TxRejected
+}
+
+// journalTx adds tx of from, signed and encoded as raw, to the journal as
+// pending.
+func (h *Handler) journalTx(tx Transaction, from common.Address, raw []byte) error {
+	store, err := h.localStore()
+	if err != nil || store == nil {
+		return err
+	}
+
+	e := &JournalTx{
+		TxID:    tx.Hash().Hex(),
+		ChainID: h.Config.Chain.ID.String(),
+		Chain:   h.Config.Chain.Name,
+		From:    from.Hex(),
+		Nonce:   tx.Nonce(),
+		Raw:     raw,
+		Status:  TxPending,
+		Sent:    time.Now().Unix(),
+	}
+	if tx.To() != nil {
+		e.To = tx.To().Hex()
+	}
+
+	return errors.Wrap(store.Put(journalBucket, e.TxID, e), "journal tx")
+}
+
+// sendUncertain tells if sending a transaction failed on the way to the node
+// rather than by its answer, so that the node may have it anyway.
+func sendUncertain(err error) bool {
+	switch cause := errors.Cause(err); cause {
+	case io.EOF, io.ErrUnexpectedEOF, context.DeadlineExceeded:
+		return true
+	default:
+		_, ok := cause.(net.Error)
+		return ok
+	}
+}
+
+// journalSendFailed records the error of sending txid: the transaction is
+// rejected if the node refused it, or else stays pending to be sent again.
+func (h *Handler) journalSendFailed(txid common.Hash, sendErr error) {
+	store, err := h.localStore()
+	if err != nil || store == nil {
+		return
+	}
+
+	e := new(JournalTx)
+	err = store.Update(journalBucket, txid.Hex(), e, func(exists bool) error {
+		if !exists {
+			return errors.New("not in the journal")
+		}
+		e.Error = "send: " + sendErr.Error()
+		if !sendUncertain(sendErr) {
+			e.Status = TxRejected
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("journal tx %v: %v", txid.Hex(), err)
+	}
+}
+
+// JournalTxs returns the journal of the connected chain, oldest first. With
+// refresh, it checks the status of the transactions that are not closed
+// first and sends the dropped ones again.
+func (h *Handler) JournalTxs(ctx context.Context, refresh bool) ([]*JournalTx, error) {
+	store, err := h.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := store.Keys(journalBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*JournalTx
+	for _, txid := range keys {
+		e := new(JournalTx)
+		if _, err := store.Get(journalBucket, txid, e); err != nil {
+			return nil, err
+		}
+		if e.ChainID != h.Config.Chain.ID.String() {
+			continue
+		}
+
+		if refresh && !e.closed() {
+			if e, err = h.refreshTx(ctx, store, txid); err != nil {
+				return nil, err
+			}
+		}
+
+		txs = append(txs, e)
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].Sent < txs[j].Sent })
+
+	return txs, nil
+}
+
+// TxStatus returns txid from the journal, its status checked on the chain.
+func (h *Handler) TxStatus(ctx context.Context, txid common.Hash) (*JournalTx, error) {
+	store, err := h.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	e := new(JournalTx)
+	ok, err := store.Get(journalBucket, txid.Hex(), e)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.Errorf("tx %v is not in the journal", txid.Hex())
+	}
+
+	if e.ChainID != h.Config.Chain.ID.String() {
+		return nil, errors.Errorf("tx %v is on chain %v(%v)", txid.Hex(), e.Chain, e.ChainID)
+	}
+
+	if e.closed() {
+		return e, nil
+	}
+
+	return h.refreshTx(ctx, store, txid.Hex())
+}
+
+// refreshTx checks txid on the chain and sends it again if the node dropped
+// it. The node is asked outside of the lock of the store, which only guards
+// writing the outcome.
+func (h *Handler) refreshTx(ctx context.Context, store *Store, txid string) (*JournalTx, error) {
+	e := new(JournalTx)
+	if _, err := store.Get(journalBucket, txid, e); err != nil {
+		return nil, err
+	}
+
+	status, block, err := h.txStatus(ctx, common.HexToHash(txid), common.HexToAddress(e.From), e.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	var sendErr error
+	if status == TxDropped {
+		if sendErr = h.Config.broadcast(ctx, e.Raw); sendErr == nil {
+			log.Printf("rebroadcast dropped tx %v, nonce = %v", txid, e.Nonce)
+		}
+	}
+
+	err = store.Update(journalBucket, txid, e, func(bool) error {
+		//another process closed it meanwhile
+		if e.closed() {
+			return nil
+		}
+
+		e.Status, e.Block = status, block
+		switch {
+		case status == TxDropped && sendErr != nil:
+			e.Error = "rebroadcast: " + sendErr.Error()
+		case status == TxDropped:
+			e.Status, e.Error = TxPending, ""
+			e.Rebroadcasts++
+		}
+
+		return nil
+	})
+
+	return e, err
+}
+
+// txStatus returns the status of txid of from with nonce on the chain, and
+// its block once mined.
+func (h *Handler) txStatus(ctx context.Context, txid common.Hash, from common.Address, nonce uint64) (string, uint64, error) {
+	receipt, err := h.Config.client.TransactionReceipt(ctx, txid)
+	switch {
+	case err == nil && receipt != nil && receipt.Status == types.ReceiptStatusFailed:
+		return TxFailed, receipt.BlockNumber.Uint64(), nil
+	case err == nil && receipt != nil:
+		return TxMined, receipt.BlockNumber.Uint64(), nil
+	case err != nil && errors.Cause(err) != ethereum.NotFound:
+		log.Printf("tx %v: get receipt: %v", txid.Hex(), err)
+		return TxUnknown, 0, nil
+	}
+
+	tx, err := h.lookupTx(ctx, txid)
+	switch {
+	case err == nil && !tx.mined:
+		return TxPending, 0, nil
+	//mined, but its receipt is not served yet
+	case err == nil:
+		return TxUnknown, 0, nil
+	case errors.Cause(err) != ethereum.NotFound:
+		log.Printf("tx %v: %v", txid.Hex(), err)
+		return TxUnknown, 0, nil
+	}
+
+	//another transaction took the nonce, mined or waiting in the mempool
+	pending, err := h.Config.client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "account=%v get nonce", from.Hex())
+	}
+	if pending > nonce {
+		return TxReplaced, 0, nil
+	}
+
+	return TxDropped, 0, nil
+}
+
+// broadcast sends a signed transaction encoded for eth_sendRawTransaction,
+// through the client if it is a legacy one.
+func (c *Config) broadcast(ctx context.Context, raw []byte) error {
+	if len(raw) > 0 && raw[0] == dynamicFeeTxType {
+		if c.rpc == nil {
+			return errors.Errorf("no JSON-RPC client for %v", c.Chain.Name)
+		}
+
+		var hash common.Hash
+		return errors.Wrap(c.rpc.CallContext(ctx, &hash, "eth_sendRawTransaction", hexutil.Bytes(raw)), "eth_sendRawTransaction")
+	}
+
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(raw, tx); err != nil {
+		return errors.Wrap(err, "decode tx")
+	}
+
+	return c.client.SendTransaction(ctx, tx)
+}
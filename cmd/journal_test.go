@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJournal(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("signed transactions are journaled and followed until mined", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender := handlers[0]
+		receiver := crypto.PubkeyToAddress(receiverKey.PublicKey)
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		tx, err := sender.NewContract(ctx, receiver, 1000, NewSecretHashPair().Hash, timeLock)
+		So(err, ShouldBeNil)
+
+		//the deployment and the new contract
+		txs, err := sender.JournalTxs(ctx, false)
+		So(err, ShouldBeNil)
+		So(txs, ShouldHaveLength, 2)
+
+		e := journalEntry(txs, tx)
+		So(e, ShouldNotBeNil)
+		So(e.Status, ShouldEqual, TxPending)
+		So(e.Nonce, ShouldEqual, tx.Nonce())
+		So(e.From, ShouldEqual, common.HexToAddress(sender.Config.Account).Hex())
+		So(e.To, ShouldEqual, common.HexToAddress(sender.Config.Chain.Contract).Hex())
+
+		//the node holds it in its mempool
+		e, err = sender.TxStatus(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+		So(e.Status, ShouldEqual, TxPending)
+		So(e.Rebroadcasts, ShouldEqual, 0)
+
+		sim.Commit()
+
+		txs, err = sender.JournalTxs(ctx, true)
+		So(err, ShouldBeNil)
+		e = journalEntry(txs, tx)
+		So(e.Status, ShouldEqual, TxMined)
+		So(e.Block, ShouldEqual, sim.Blockchain().CurrentHeader().Number.Uint64())
+
+		_, err = sender.TxStatus(ctx, common.HexToHash("0x01"))
+		So(err, ShouldNotBeNil)
+
+		//the journal is kept per chain
+		sender.Config.Chain.ID = big.NewInt(5)
+		txs, err = sender.JournalTxs(ctx, false)
+		sender.Config.Chain.ID = sender.Config.ChainID
+		So(err, ShouldBeNil)
+		So(txs, ShouldBeEmpty)
+	})
+
+	Convey("a dropped transaction is sent again and a replaced one is closed", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender := handlers[0]
+		receiver := crypto.PubkeyToAddress(receiverKey.PublicKey)
+
+		//signed and journaled, but lost before the node got it
+		transfer := func(value int64) Transaction {
+			auth, err := sender.Config.makeAuth(ctx, value)
+			So(err, ShouldBeNil)
+			auth.Nonce, err = sender.Config.pendingNonce(ctx)
+			So(err, ShouldBeNil)
+			auth.GasLimit = params.TxGas
+
+			tx, raw, err := sender.sign(auth, nil, &receiver)
+			So(err, ShouldBeNil)
+			So(sender.journalTx(tx, common.HexToAddress(sender.Config.Account), raw), ShouldBeNil)
+			return tx
+		}
+		dropped := transfer(1000)
+		replaced := transfer(2000)
+		So(replaced.Nonce(), ShouldEqual, dropped.Nonce())
+
+		e, err := sender.TxStatus(ctx, dropped.Hash())
+		So(err, ShouldBeNil)
+		So(e.Status, ShouldEqual, TxPending)
+		So(e.Rebroadcasts, ShouldEqual, 1)
+
+		sim.Commit()
+
+		txs, err := sender.JournalTxs(ctx, true)
+		So(err, ShouldBeNil)
+		So(txs, ShouldHaveLength, 3)
+		So(journalEntry(txs, dropped).Status, ShouldEqual, TxMined)
+		So(journalEntry(txs, replaced).Status, ShouldEqual, TxReplaced)
+
+		balance, err := sim.BalanceAt(ctx, receiver, nil)
+		So(err, ShouldBeNil)
+		So(balance.Int64(), ShouldEqual, 1e18+1000)
+	})
+
+	Convey("a transaction the node refused is marked rejected", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey)
+		defer sim.Close()
+
+		sender := handlers[0]
+		self := crypto.PubkeyToAddress(senderKey.PublicKey)
+
+		auth, err := sender.Config.makeAuth(ctx, 0)
+		So(err, ShouldBeNil)
+		auth.Nonce, err = sender.Config.pendingNonce(ctx)
+		So(err, ShouldBeNil)
+		auth.GasLimit = params.TxGas
+
+		tx, raw, err := sender.sign(auth, nil, &self)
+		So(err, ShouldBeNil)
+		So(sender.journalTx(tx, common.HexToAddress(sender.Config.Account), raw), ShouldBeNil)
+
+		sender.journalSendFailed(tx.Hash(), errors.New("nonce too low"))
+
+		e, err := sender.TxStatus(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+		So(e.Status, ShouldEqual, TxRejected)
+		So(e.Error, ShouldEqual, "send: nonce too low")
+	})
+
+	Convey("a transaction lost on the way to the node stays pending and is sent again", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender := handlers[0]
+		receiver := crypto.PubkeyToAddress(receiverKey.PublicKey)
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		sender.Config.client = offlineSend{simClient{sim}}
+		tx, err := sender.NewContract(ctx, receiver, 1000, NewSecretHashPair().Hash, timeLock)
+		So(err, ShouldBeNil)
+		sender.Config.client = simClient{sim}
+
+		txs, err := sender.JournalTxs(ctx, false)
+		So(err, ShouldBeNil)
+		e := journalEntry(txs, tx)
+		So(e.Status, ShouldEqual, TxPending)
+		So(e.Error, ShouldContainSubstring, "connection reset")
+
+		//the nonce stays taken
+		auth, err := sender.Config.makeAuth(ctx, 0)
+		So(err, ShouldBeNil)
+		done, err := sender.reserveNonce(ctx, auth)
+		So(err, ShouldBeNil)
+		So(auth.Nonce.Uint64(), ShouldEqual, tx.Nonce()+1)
+		done("")
+
+		txs, err = sender.JournalTxs(ctx, true)
+		So(err, ShouldBeNil)
+		e = journalEntry(txs, tx)
+		So(e.Status, ShouldEqual, TxPending)
+		So(e.Rebroadcasts, ShouldEqual, 1)
+		So(e.Error, ShouldBeEmpty)
+
+		next, err := sender.NewContract(ctx, receiver, 1000, NewSecretHashPair().Hash, timeLock)
+		So(err, ShouldBeNil)
+		So(next.Nonce(), ShouldEqual, tx.Nonce()+1)
+
+		sim.Commit()
+
+		txs, err = sender.JournalTxs(ctx, true)
+		So(err, ShouldBeNil)
+		So(journalEntry(txs, tx).Status, ShouldEqual, TxMined)
+		So(journalEntry(txs, next).Status, ShouldEqual, TxMined)
+	})
+
+	Convey("a mined transaction whose receipt the node fails to serve is unknown", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender := handlers[0]
+		receiver := crypto.PubkeyToAddress(receiverKey.PublicKey)
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		tx, err := sender.NewContract(ctx, receiver, 1000, NewSecretHashPair().Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		sender.Config.client = noReceipts{simClient{sim}}
+		e, err := sender.TxStatus(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+		So(e.Status, ShouldEqual, TxUnknown)
+
+		sender.Config.client = simClient{sim}
+		e, err = sender.TxStatus(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+		So(e.Status, ShouldEqual, TxMined)
+	})
+}
+
+// offlineSend loses the transactions on a broken connection.
+type offlineSend struct {
+	ethClient
+}
+
+func (offlineSend) SendTransaction(context.Context, *types.Transaction) error {
+	return &net.OpError{Op: "write", Net: "tcp", Err: errors.New("connection reset by peer")}
+}
+
+// noReceipts fails to serve the receipts.
+type noReceipts struct {
+	ethClient
+}
+
+func (noReceipts) TransactionReceipt(context.Context, common.Hash) (*types.Receipt, error) {
+	return nil, errors.New("upstream timeout")
+}
+
+func journalEntry(txs []*JournalTx, tx Transaction) *JournalTx {
+	for _, e := range txs {
+		if e.TxID == tx.Hash().Hex() {
+			return e
+		}
+	}
+	return nil
+}
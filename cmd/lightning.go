@@ -0,0 +1,103 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"math/big"
+	"time"
+
+	"github.com/icodezjb/atomicswap/lightning"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// how long the HTLC must stay locked after the invoice can no longer be settled
+var invoiceSafetyMargin = 6 * time.Hour
+
+// InvoiceTimeLock returns the timelock of a HTLC locked against invoice: the
+// later of minTimeLock and the settle deadline of the invoice plus a safety
+// margin, so the sender cannot refund while the payee may still settle the
+// Lightning payment.
+func InvoiceTimeLock(invoice *lightning.Invoice, now time.Time, minTimeLock *big.Int) (*big.Int, error) {
+	if !now.Before(invoice.ExpiresAt()) {
+		return nil, errors.Errorf("invoice expired at %v", invoice.ExpiresAt())
+	}
+
+	timeLock := big.NewInt(invoice.SettleDeadline().Add(invoiceSafetyMargin).Unix())
+	if timeLock.Cmp(minTimeLock) < 0 {
+		timeLock.Set(minTimeLock)
+	}
+
+	return timeLock, nil
+}
+
+// CheckInvoiceAmount fails unless amount, in the base unit of the own chain,
+// is what the invoice asks for at rate base units per satoshi: 1 on a chain
+// counted in satoshis, the agreed price of a satoshi in wei on an EVM chain.
+// An invoice without amount lets the payer choose.
+func CheckInvoiceAmount(invoice *lightning.Invoice, amount *big.Int, rate *big.Int) error {
+	if invoice.MilliSat == 0 {
+		return nil
+	}
+
+	if rate == nil || rate.Sign() <= 0 {
+		return errors.Errorf("invoice of %v msat: the amount is not in satoshis, give the price of a satoshi", invoice.MilliSat)
+	}
+
+	//amount / rate sat == invoice msat / 1000, without rounding
+	msat := new(big.Int).Mul(amount, big.NewInt(1000))
+	if msat.Cmp(new(big.Int).Mul(big.NewInt(invoice.MilliSat), rate)) != 0 {
+		return errors.Errorf("invoice amount %v msat at %v per sat does not match the amount %v", invoice.MilliSat, rate, amount)
+	}
+
+	return nil
+}
+
+// InvoiceRate is the rate of CheckInvoiceAmount on chainType: 1 on a chain
+// counted in satoshis, otherwise price, the base units paid per satoshi, or
+// nil if it is not given.
+func InvoiceRate(chainType string, price int64) *big.Int {
+	if chainType == ChainBTC {
+		return big.NewInt(1)
+	}
+
+	if price <= 0 {
+		return nil
+	}
+
+	return big.NewInt(price)
+}
+
+// RedeemInvoice redeems the HTLC locked against invoice with the preimage the
+// Lightning payment of the invoice revealed.
+func RedeemInvoice(ctx context.Context, backend ChainBackend, contractId string, invoice *lightning.Invoice, preimage [32]byte) (string, error) {
+	if sha256.Sum256(preimage[:]) != invoice.PaymentHash {
+		return "", errors.Errorf("preimage %v does not match the payment hash %v",
+			hexutil.Encode(preimage[:]), hexutil.Encode(invoice.PaymentHash[:]))
+	}
+
+	c, err := backend.Audit(ctx, contractId)
+	if err != nil {
+		return "", err
+	}
+
+	if c.Hashlock != invoice.PaymentHash {
+		return "", errors.Errorf("contractId %v is not locked against the invoice payment hash", contractId)
+	}
+
+	return backend.Redeem(ctx, contractId, preimage)
+}
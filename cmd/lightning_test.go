@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/icodezjb/atomicswap/lightning"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// regtest invoice of 1000 sat, expiry 3600, min_final_cltv_expiry 40
+const (
+	testInvoice         = "lnbcrt10u1p0qhcgqpp5430p5x56upsv04a0tddyfsnynha839afwr332hrcxxqp4l9f8x6sdqsv9ehwctsyp6x2um5xqrrsscqzpgqc85zdn92fy4yhnzcmr4v69nlhzaqsuzqapx6j2up9mr4ja443k9rew78k4qtj5qu08zglg8uc57fzu59tp7j24t98f5vjvudd80hugpcqwn4v"
+	testInvoicePreimage = "0xfda00acb945ebf13819fe62377dd0964fd294e15428fe32a38ce56bd755990ce"
+)
+
+func TestInvoiceSwap(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	invoice, err := lightning.Decode(testInvoice)
+	TMust(t, err)
+
+	sender, err := hs[0].Backend()
+	TMust(t, err)
+	receiver, err := hs[1].Backend()
+	TMust(t, err)
+
+	var (
+		ctx        = context.Background()
+		contractId string
+	)
+
+	Convey("swap against a lightning invoice on a simulated chain", t, func() {
+		Convey("[1] timelock outlives the invoice", func() {
+			now := invoice.Timestamp
+			minTimeLock := big.NewInt(now.Unix() + 60)
+
+			timeLock, err := InvoiceTimeLock(invoice, now, minTimeLock)
+			So(err, ShouldBeNil)
+			So(timeLock.Int64(), ShouldEqual, invoice.SettleDeadline().Add(invoiceSafetyMargin).Unix())
+			So(timeLock.Int64(), ShouldBeGreaterThan, invoice.ExpiresAt().Unix())
+
+			minTimeLock = big.NewInt(now.Unix() + 48*3600)
+			timeLock, err = InvoiceTimeLock(invoice, now, minTimeLock)
+			So(err, ShouldBeNil)
+			So(timeLock, ShouldResemble, minTimeLock)
+
+			_, err = InvoiceTimeLock(invoice, invoice.ExpiresAt(), minTimeLock)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("[2] lock against the payment hash", func() {
+			//1000 sat on a chain counted in satoshis
+			So(CheckInvoiceAmount(invoice, big.NewInt(1000), InvoiceRate(ChainBTC, 0)), ShouldBeNil)
+			So(CheckInvoiceAmount(invoice, big.NewInt(999), InvoiceRate(ChainBTC, 0)), ShouldNotBeNil)
+			So(CheckInvoiceAmount(&lightning.Invoice{}, big.NewInt(999), nil), ShouldBeNil)
+
+			//wei need the price of a satoshi
+			So(CheckInvoiceAmount(invoice, big.NewInt(1000), InvoiceRate(ChainEVM, 0)), ShouldNotBeNil)
+			So(CheckInvoiceAmount(invoice, big.NewInt(1000), InvoiceRate(ChainEVM, 10)), ShouldNotBeNil)
+			So(CheckInvoiceAmount(invoice, big.NewInt(10000), InvoiceRate(ChainEVM, 10)), ShouldBeNil)
+
+			now := time.Now()
+			timeLock, err := InvoiceTimeLock(invoice, invoice.Timestamp, big.NewInt(now.Unix()+3600))
+			So(err, ShouldBeNil)
+
+			contractId, _, err = sender.Lock(ctx, hs[1].Config.Account, big.NewInt(10000), invoice.PaymentHash, timeLock)
+			So(err, ShouldBeNil)
+			sim.Commit()
+		})
+
+		Convey("[3] redeem with the lightning preimage", func() {
+			_, err := RedeemInvoice(ctx, receiver, contractId, invoice, common.HexToHash("0x01"))
+			So(err, ShouldNotBeNil)
+
+			_, err = RedeemInvoice(ctx, receiver, contractId, invoice, common.HexToHash(testInvoicePreimage))
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			secret, err := sender.ExtractSecret(ctx, contractId)
+			So(err, ShouldBeNil)
+			So(common.Hash(secret), ShouldEqual, common.HexToHash(testInvoicePreimage))
+		})
+	})
+}
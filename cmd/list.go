@@ -0,0 +1,287 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// an open HTLC whose timelock passed
+const HTLCExpired = "expired"
+
+// role of the account in a HTLC
+const (
+	RoleSender   = "sender"
+	RoleReceiver = "receiver"
+)
+
+// next action of the account on a HTLC
+const (
+	//the receiver redeems with the secret before the timelock
+	ActionRedeem = "redeem"
+	//the sender refunds after the timelock
+	ActionRefund = "refund"
+	//the sender waits for the receiver to redeem until the timelock
+	ActionWait = "wait"
+	//the HTLC is closed, or expired for the receiver
+	ActionNone = "none"
+)
+
+// AccountHTLC is a HTLC the account sends or receives, as its events tell.
+type AccountHTLC struct {
+	Chain      string
+	ChainID    *big.Int
+	ContractID common.Hash
+	Role       string
+	Details    *ContractDetails
+	Status     string
+	Action     string
+	//block and transaction of the LogHTLCNew
+	Block uint64
+	TxID  common.Hash
+}
+
+// ListContracts returns the HTLCs of the account on the connected chain,
+// oldest first: the ones it sends or receives as role says, all of them if
+// role is empty, with the status if it is not empty. They are read from the
+// index if the chain is indexed, from the logs of the contract otherwise; the
+// preimage of a HashedTimelock withdraw is not logged, it is left zero.
+func (h *Handler) ListContracts(ctx context.Context, role string, status string) ([]*AccountHTLC, error) {
+	switch role {
+	case "", RoleSender, RoleReceiver:
+	default:
+		return nil, errors.Errorf("unknown role %v, want %v or %v", role, RoleSender, RoleReceiver)
+	}
+
+	switch status {
+	case "", HTLCOpen, HTLCWithdrawn, HTLCRefunded, HTLCExpired:
+	default:
+		return nil, errors.Errorf("unknown status %v, want %v, %v, %v or %v", status, HTLCOpen, HTLCWithdrawn, HTLCRefunded, HTLCExpired)
+	}
+
+	if h.Config.Chain.Type != ChainEVM {
+		return nil, errors.Errorf("%v is not an EVM chain", h.Config.Chain.Name)
+	}
+
+	if err := h.Config.ValidateAddress(h.Config.Chain.Contract); err != nil {
+		return nil, err
+	}
+
+	//the timelocks pass with the time of the chain
+	head, err := h.Config.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get head")
+	}
+	now := new(big.Int).SetUint64(head.Time)
+
+	account := common.HexToAddress(h.Config.Account)
+
+	ix, err := h.syncedIndexer()
+	if err != nil {
+		return nil, err
+	}
+	if ix != nil {
+		if _, err := ix.Sync(ctx); err != nil {
+			return nil, errors.Wrap(err, "sync index")
+		}
+	} else if ix, err = h.scanContracts(ctx, account, head.Number.Uint64()); err != nil {
+		return nil, err
+	}
+
+	var htlcs []*AccountHTLC
+	for _, r := range []string{RoleSender, RoleReceiver} {
+		if role != "" && role != r {
+			continue
+		}
+
+		q := &IndexQuery{Sender: account.Hex()}
+		if r == RoleReceiver {
+			q = &IndexQuery{Receiver: account.Hex()}
+		}
+
+		indexed, err := ix.Query(q)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, indexed := range indexed {
+			htlc := &AccountHTLC{
+				Chain:      h.Config.Chain.Name,
+				ChainID:    h.Config.Chain.ID,
+				ContractID: indexed.ContractID,
+				Role:       r,
+				Details:    indexed.details(),
+				Block:      indexed.Block,
+				TxID:       indexed.TxID,
+			}
+			htlc.Status, htlc.Action = htlcState(htlc.Details, r, now)
+
+			if status == "" || status == htlc.Status {
+				htlcs = append(htlcs, htlc)
+			}
+		}
+	}
+
+	sort.SliceStable(htlcs, func(i, j int) bool { return htlcs[i].Block < htlcs[j].Block })
+
+	return htlcs, nil
+}
+
+// scanContracts reads the HTLCs of account up to block head from the logs of
+// the contract, for a chain that is not indexed. They go to an index kept in
+// memory, which is returned.
+func (h *Handler) scanContracts(ctx context.Context, account common.Address, head uint64) (*Indexer, error) {
+	decoder, err := h.EventDecoder()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := OpenStore("")
+	if err != nil {
+		return nil, err
+	}
+
+	contract := common.HexToAddress(h.Config.Chain.Contract)
+	ix := &Indexer{
+		h:        h,
+		store:    store,
+		decoder:  decoder,
+		contract: contract,
+		key:      h.Config.Chain.ID.String() + "/" + contract.Hex(),
+	}
+
+	//LogHTLCNew indexes the sender and the receiver
+	topic := common.BytesToHash(account.Bytes())
+	var logs []types.Log
+	for _, topics := range [][][]common.Hash{
+		{{decoder.Topic(EventNew)}, nil, {topic}},
+		{{decoder.Topic(EventNew)}, nil, nil, {topic}},
+	} {
+		created, err := h.filterRanges(ctx, ethereum.FilterQuery{Addresses: []common.Address{contract}, Topics: topics}, 0, head)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, created...)
+	}
+	if len(logs) == 0 {
+		return ix, nil
+	}
+
+	//then the withdraws, refunds and extends of these HTLCs
+	from := logs[0].BlockNumber
+	ids := make([]common.Hash, 0, len(logs))
+	for _, l := range logs {
+		if l.BlockNumber < from {
+			from = l.BlockNumber
+		}
+		ids = append(ids, l.Topics[1])
+	}
+
+	var changes []common.Hash
+	for _, topic := range decoder.AllTopics() {
+		if topic != decoder.Topic(EventNew) {
+			changes = append(changes, topic)
+		}
+	}
+	changed, err := h.filterRanges(ctx, ethereum.FilterQuery{Addresses: []common.Address{contract}, Topics: [][]common.Hash{changes, ids}}, from, head)
+	if err != nil {
+		return nil, err
+	}
+	logs = append(logs, changed...)
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+
+	err = store.Batch(func(b *Batch) error {
+		for _, l := range logs {
+			event, err := decoder.Decode(l)
+			if err != nil {
+				return err
+			}
+			if _, err := ix.apply(b, event, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// filterRanges returns the logs of query from block from to block to, asking
+// the node for indexBatch blocks at a time.
+func (h *Handler) filterRanges(ctx context.Context, query ethereum.FilterQuery, from, to uint64) ([]types.Log, error) {
+	var logs []types.Log
+	for from <= to {
+		end := from + indexBatch - 1
+		if end > to {
+			end = to
+		}
+
+		query.FromBlock = new(big.Int).SetUint64(from)
+		query.ToBlock = new(big.Int).SetUint64(end)
+		ranged, err := h.Config.client.FilterLogs(ctx, query)
+		if err != nil {
+			return nil, errors.Wrap(err, "filter logs")
+		}
+		for _, l := range ranged {
+			if !l.Removed {
+				logs = append(logs, l)
+			}
+		}
+
+		from = end + 1
+	}
+
+	return logs, nil
+}
+
+// htlcState returns the status of the HTLC at the time now of the chain, and
+// what the account in role may do next.
+func htlcState(details *ContractDetails, role string, now *big.Int) (string, string) {
+	switch {
+	case details.Withdrawn:
+		return HTLCWithdrawn, ActionNone
+	case details.Refunded:
+		return HTLCRefunded, ActionNone
+	}
+
+	//withdraw needs the timelock ahead, refund behind
+	expired := details.Timelock.Cmp(now) <= 0
+
+	switch {
+	case expired && role == RoleSender:
+		return HTLCExpired, ActionRefund
+	case expired:
+		return HTLCExpired, ActionNone
+	case role == RoleSender:
+		return HTLCOpen, ActionWait
+	default:
+		return HTLCOpen, ActionRedeem
+	}
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestListContracts(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("the HTLCs of the account are listed with their next action", t, func() {
+		aliceKey, _ := crypto.GenerateKey()
+		bobKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, aliceKey, bobKey)
+		defer sim.Close()
+
+		alice, bob := handlers[0], handlers[1]
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		lock := func(h *Handler, to common.Address, pair *SecretHashPair) common.Hash {
+			tx, err := h.NewContract(ctx, to, 1000, pair.Hash, timeLock)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			event, err := h.GetContractId(ctx, tx.Hash())
+			So(err, ShouldBeNil)
+			return event.ContractId
+		}
+
+		redeemPair := NewSecretHashPair()
+		sent := lock(alice, crypto.PubkeyToAddress(bobKey.PublicKey), NewSecretHashPair())
+		received := lock(bob, crypto.PubkeyToAddress(aliceKey.PublicKey), NewSecretHashPair())
+		redeemed := lock(bob, crypto.PubkeyToAddress(aliceKey.PublicKey), redeemPair)
+
+		_, err := alice.Redeem(ctx, redeemed, redeemPair.Secret)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		htlcs, err := alice.ListContracts(ctx, "", "")
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 3)
+
+		So(htlcs[0].ContractID, ShouldEqual, sent)
+		So(htlcs[0].Role, ShouldEqual, RoleSender)
+		So(htlcs[0].Status, ShouldEqual, HTLCOpen)
+		So(htlcs[0].Action, ShouldEqual, ActionWait)
+		So(htlcs[0].Details.Amount.Int64(), ShouldEqual, 1000)
+		So(htlcs[0].Details.Timelock, ShouldResemble, timeLock)
+
+		So(htlcs[1].ContractID, ShouldEqual, received)
+		So(htlcs[1].Role, ShouldEqual, RoleReceiver)
+		So(htlcs[1].Action, ShouldEqual, ActionRedeem)
+
+		So(htlcs[2].ContractID, ShouldEqual, redeemed)
+		So(htlcs[2].Status, ShouldEqual, HTLCWithdrawn)
+		So(htlcs[2].Action, ShouldEqual, ActionNone)
+
+		htlcs, err = alice.ListContracts(ctx, RoleReceiver, HTLCOpen)
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 1)
+		So(htlcs[0].ContractID, ShouldEqual, received)
+
+		So(sim.AdjustTime(2*time.Hour), ShouldBeNil)
+		sim.Commit()
+
+		htlcs, err = alice.ListContracts(ctx, "", HTLCExpired)
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 2)
+		So(htlcs[0].Action, ShouldEqual, ActionRefund)
+		So(htlcs[1].Action, ShouldEqual, ActionNone)
+
+		htlcs, err = bob.ListContracts(ctx, RoleSender, "")
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 2)
+
+		_, err = alice.ListContracts(ctx, "owner", "")
+		So(err, ShouldNotBeNil)
+		_, err = alice.ListContracts(ctx, "", "closed")
+		So(err, ShouldNotBeNil)
+
+		//the logs are read a few blocks at a time
+		defer func(batch uint64) { indexBatch = batch }(indexBatch)
+		indexBatch = 2
+		counter := &logCounter{ethClient: simClient{sim}}
+		alice.Config.client = counter
+		htlcs, err = alice.ListContracts(ctx, "", "")
+		alice.Config.client = simClient{sim}
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 3)
+		So(htlcs[2].Status, ShouldEqual, HTLCWithdrawn)
+		So(counter.calls, ShouldBeGreaterThan, 2)
+		So(counter.widest, ShouldBeLessThanOrEqualTo, 2)
+
+		//once the chain is indexed, the index is read
+		ix, err := alice.Indexer()
+		So(err, ShouldBeNil)
+		_, err = ix.Sync(ctx)
+		So(err, ShouldBeNil)
+		head, err := ix.Head()
+		So(err, ShouldBeNil)
+
+		indexed := common.HexToHash("0x04")
+		So(ix.store.Put(htlcBucket, ix.htlcKey(indexed), &IndexedHTLC{
+			ContractID: indexed,
+			Sender:     crypto.PubkeyToAddress(aliceKey.PublicKey).Hex(),
+			Receiver:   crypto.PubkeyToAddress(bobKey.PublicKey).Hex(),
+			Amount:     big.NewInt(1000),
+			Timelock:   timeLock,
+			Status:     HTLCRefunded,
+			Block:      head,
+		}), ShouldBeNil)
+
+		htlcs, err = alice.ListContracts(ctx, RoleSender, HTLCRefunded)
+		So(err, ShouldBeNil)
+		So(htlcs, ShouldHaveLength, 1)
+		So(htlcs[0].ContractID, ShouldEqual, indexed)
+	})
+}
+
+// logCounter counts the log queries asked to the node and the widest block
+// range of them.
+type logCounter struct {
+	ethClient
+	calls  int
+	widest uint64
+}
+
+func (c *logCounter) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	c.calls++
+	if width := q.ToBlock.Uint64() - q.FromBlock.Uint64() + 1; width > c.widest {
+		c.widest = width
+	}
+	return c.ethClient.FilterLogs(ctx, q)
+}
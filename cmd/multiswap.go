@@ -0,0 +1,391 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// RingLeg is one HTLC of a ring trade: Sender pays Amount to Receiver on the
+// chain, locked by all the hashlocks of the ring.
+type RingLeg struct {
+	ChainName  string   `json:"chainName"`
+	ChainID    *big.Int `json:"chainID"`
+	Contract   string   `json:"contract"`
+	Sender     string   `json:"sender"`
+	Receiver   string   `json:"receiver"`
+	Amount     *big.Int `json:"amount"`
+	TimeLock   *big.Int `json:"timelock,omitempty"`
+	ContractID string   `json:"contractId,omitempty"`
+}
+
+// Ring is a N-party cyclic swap on HashedTimelockMulti contracts, shared by
+// all participants as a file. The receiver of leg i is the sender of leg
+// i+1 and the receiver of the last leg is the sender of the first, the
+// leader, who locks first.
+//
+// Every participant contributes one hashlock and every leg needs all the
+// preimages, so nothing moves until everyone reveals. The timelocks decrease
+// in lock order, so the leader's incoming last leg expires first: the other
+// participants hand their secrets to the leader only after auditing every
+// leg, and the leader, revealing last, redeems first. Each other participant
+// then has at least one delta to redeem its incoming leg with the preimages
+// published by the redeem of its outgoing leg.
+type Ring struct {
+	Hashlocks []common.Hash `json:"hashlocks"`
+	Legs      []*RingLeg    `json:"legs"`
+}
+
+// PlanRing checks that legs close a cycle and sets their timelocks so that
+// the last leg expires at finalTimeLock and every earlier one delta after its
+// successor.
+func PlanRing(hashLocks []common.Hash, legs []*RingLeg, finalTimeLock *big.Int, delta time.Duration) (*Ring, error) {
+	if len(legs) < 2 {
+		return nil, errors.New("a ring needs at least 2 legs")
+	}
+
+	if len(hashLocks) == 0 || len(hashLocks) > 16 {
+		return nil, errors.Errorf("a ring needs 1 to 16 hashlocks, got %d", len(hashLocks))
+	}
+
+	if delta < time.Minute {
+		return nil, errors.Errorf("timelock delta %v too short", delta)
+	}
+
+	for i, leg := range legs {
+		steps := int64(len(legs) - 1 - i)
+		leg.TimeLock = new(big.Int).Add(finalTimeLock, big.NewInt(steps*int64(delta/time.Second)))
+	}
+
+	ring := &Ring{Hashlocks: hashLocks, Legs: legs}
+
+	return ring, ring.Check()
+}
+
+// Check checks that the legs close a cycle with strictly decreasing timelocks.
+func (r *Ring) Check() error {
+	for i, leg := range r.Legs {
+		next := r.Legs[(i+1)%len(r.Legs)]
+		if !strings.EqualFold(leg.Receiver, next.Sender) {
+			return errors.Errorf("leg %d pays %v but leg %d is sent by %v", i, leg.Receiver, (i+1)%len(r.Legs), next.Sender)
+		}
+
+		if leg.Amount == nil || leg.Amount.Sign() <= 0 {
+			return errors.Errorf("leg %d has invalid amount %v", i, leg.Amount)
+		}
+
+		if leg.TimeLock == nil {
+			return errors.Errorf("leg %d has no timelock", i)
+		}
+
+		if i > 0 && leg.TimeLock.Cmp(r.Legs[i-1].TimeLock) >= 0 {
+			return errors.Errorf("leg %d expires no earlier than leg %d", i, i-1)
+		}
+	}
+
+	return nil
+}
+
+// LoadRing reads a ring file.
+func LoadRing(path string) (*Ring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read ring file")
+	}
+
+	ring := new(Ring)
+	if err := json.Unmarshal(data, ring); err != nil {
+		return nil, errors.Wrapf(err, "parse ring file (%s)", path)
+	}
+
+	return ring, nil
+}
+
+// Save writes the ring file, replacing it atomically.
+func (r *Ring) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "encode ring")
+	}
+
+	if err := ioutil.WriteFile(path+".new", data, 0644); err != nil {
+		return errors.Wrap(err, "write ring file")
+	}
+
+	return os.Rename(path+".new", path)
+}
+
+func (r *Ring) hashLocks() [][32]byte {
+	hashLocks := make([][32]byte, len(r.Hashlocks))
+	for i, h := range r.Hashlocks {
+		hashLocks[i] = h
+	}
+	return hashLocks
+}
+
+// CheckSecrets checks that secrets are the preimages of the ring hashlocks,
+// in order.
+func (r *Ring) CheckSecrets(secrets [][32]byte) error {
+	if len(secrets) != len(r.Hashlocks) {
+		return errors.Errorf("expect %d secrets, got %d", len(r.Hashlocks), len(secrets))
+	}
+
+	for i, secret := range secrets {
+		if common.Hash(sha256.Sum256(secret[:])) != r.Hashlocks[i] {
+			return errors.Errorf("secret %d does not match hashlock %v", i, r.Hashlocks[i].Hex())
+		}
+	}
+
+	return nil
+}
+
+// checkLeg compares the audited HTLC of leg i with the ring.
+func (r *Ring) checkLeg(i int, c *MultiContractDetails) error {
+	leg := r.Legs[i]
+
+	switch {
+	case c.Sender == (common.Address{}):
+		return errors.Errorf("leg %d contractId %v does not exist", i, leg.ContractID)
+	case c.Sender != common.HexToAddress(leg.Sender):
+		return errors.Errorf("leg %d is sent by %v, expect %v", i, c.Sender.String(), leg.Sender)
+	case c.Receiver != common.HexToAddress(leg.Receiver):
+		return errors.Errorf("leg %d pays %v, expect %v", i, c.Receiver.String(), leg.Receiver)
+	case c.Amount.Cmp(leg.Amount) < 0:
+		return errors.Errorf("leg %d locks %v, expect %v", i, c.Amount, leg.Amount)
+	case c.Timelock.Cmp(leg.TimeLock) < 0:
+		return errors.Errorf("leg %d expires at %v, expect %v", i, c.Timelock, leg.TimeLock)
+	case c.Refunded:
+		return errors.Errorf("leg %d is refunded", i)
+	case len(c.Hashlocks) != len(r.Hashlocks):
+		return errors.Errorf("leg %d has %d hashlocks, expect %d", i, len(c.Hashlocks), len(r.Hashlocks))
+	}
+
+	for j, hashLock := range c.Hashlocks {
+		if common.Hash(hashLock) != r.Hashlocks[j] {
+			return errors.Errorf("leg %d hashlock %d is %v, expect %v", i, j, common.Hash(hashLock).Hex(), r.Hashlocks[j].Hex())
+		}
+	}
+
+	return nil
+}
+
+// ConnectLeg connects to the chain of leg as the config has it. The ring
+// file is written by the counterparties, so only the chain ID and the
+// contract of leg are taken from it, and the contract must be our
+// HashedTimelockMulti or in the trusted contracts of the chain.
+func (c *Config) ConnectLeg(leg *RingLeg) error {
+	if leg.ChainID == nil {
+		return errors.New("the leg has no chain ID")
+	}
+
+	other, err := c.IsOtherChain(leg.ChainID.String())
+	if err != nil {
+		return errors.Wrap(err, "the chain of the leg is not configured")
+	}
+
+	ch := c.ownChain(leg.Contract)
+	if other {
+		ch = c.otherChain(leg.Contract)
+	}
+
+	if ch.Type != "" && ch.Type != ChainEVM {
+		return errors.Errorf("the leg is on %v, a %v chain, a ring needs EVM chains", ch.Name, ch.Type)
+	}
+
+	if err := c.ValidateAddress(leg.Contract); err != nil {
+		return errors.Wrap(err, "the contract of the leg")
+	}
+
+	if !c.ringContract(ch.ID, common.HexToAddress(leg.Contract), other) {
+		return errors.Errorf("contract %v of the leg on %v is neither our HashedTimelockMulti nor trusted", leg.Contract, ch.Name)
+	}
+
+	return c.connect(ch)
+}
+
+// ringContract tells if a ring leg may lock on contract on the chain of
+// chainID: the HashedTimelockMulti of the config on the own chain, or any
+// contract of its allowlist.
+func (c *Config) ringContract(chainID *big.Int, contract common.Address, other bool) bool {
+	if !other && c.MultiContract != "" && common.HexToAddress(c.MultiContract) == contract {
+		return true
+	}
+
+	for _, address := range c.TrustedContracts[chainID.String()] {
+		if common.HexToAddress(address) == contract {
+			return true
+		}
+	}
+
+	return false
+}
+
+// multiContractID mirrors the id derivation of HashedTimelockMulti.newContract.
+func multiContractID(sender, receiver common.Address, amount *big.Int, hashLocks [][32]byte, timeLock *big.Int) common.Hash {
+	var packed []byte
+	packed = append(packed, sender.Bytes()...)
+	packed = append(packed, receiver.Bytes()...)
+	packed = append(packed, common.LeftPadBytes(amount.Bytes(), 32)...)
+	for _, hashLock := range hashLocks {
+		packed = append(packed, hashLock[:]...)
+	}
+	packed = append(packed, common.LeftPadBytes(timeLock.Bytes(), 32)...)
+
+	return sha256.Sum256(packed)
+}
+
+// DeployMultiContract deploys HashedTimelockMulti on the own chain.
+func (h *Handler) DeployMultiContract(ctx context.Context) error {
+	if htlc.MultiHTLCBIN == "" {
+		return errNotCompiled("HashedTimelockMulti")
+	}
+
+	address, err := h.deploy(ctx, htlc.MultiHTLCBIN)
+	if err != nil {
+		return err
+	}
+
+	h.Config.MultiContract = address
+
+	return h.Config.rotate(h.ConfigPath)
+}
+
+// AuditMultiContract returns the HashedTimelockMulti HTLC contractId.
+func (h *Handler) AuditMultiContract(ctx context.Context, contractId common.Hash) (*MultiContractDetails, error) {
+	if err := h.VerifyMultiContract(ctx, common.HexToAddress(h.Config.Chain.Contract)); err != nil {
+		return nil, err
+	}
+
+	details := new(MultiContractDetails)
+	if err := h.call(ctx, htlc.MultiHTLCABI, details, "getContract", contractId); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// AuditLeg audits leg i of ring on the chain the handler is connected to.
+func (h *Handler) AuditLeg(ctx context.Context, ring *Ring, i int) (*MultiContractDetails, error) {
+	leg := ring.Legs[i]
+	if leg.ContractID == "" {
+		return nil, errors.Errorf("leg %d is not locked", i)
+	}
+
+	id, err := parseContractId(leg.ContractID)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := h.AuditMultiContract(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return details, ring.checkLeg(i, details)
+}
+
+// LockLeg locks leg i of ring as its sender and records the contractId. The
+// caller audits the incoming leg i-1 first, on its own chain.
+func (h *Handler) LockLeg(ctx context.Context, ring *Ring, i int) (Transaction, error) {
+	leg := ring.Legs[i]
+
+	if err := ring.Check(); err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(leg.Sender, h.Config.Account) {
+		return nil, errors.Errorf("leg %d is sent by %v, not by %v", i, leg.Sender, h.Config.Account)
+	}
+
+	if leg.ContractID != "" {
+		return nil, errors.Errorf("leg %d is already locked: %v", i, leg.ContractID)
+	}
+
+	if leg.ChainID.Cmp(h.Config.Chain.ID) != 0 {
+		return nil, errors.Errorf("leg %d is on chainID %v, connected to %v", i, leg.ChainID, h.Config.Chain.ID)
+	}
+
+	if err := h.VerifyMultiContract(ctx, common.HexToAddress(leg.Contract)); err != nil {
+		return nil, err
+	}
+
+	if !leg.Amount.IsInt64() {
+		return nil, errors.Errorf("amount %v out of range", leg.Amount)
+	}
+
+	txSigned, err := h.transact(ctx, htlc.MultiHTLCABI, leg.Amount.Int64(), "newContract",
+		common.HexToAddress(leg.Receiver), ring.hashLocks(), leg.TimeLock)
+	if err != nil {
+		return nil, err
+	}
+
+	leg.ContractID = multiContractID(common.HexToAddress(leg.Sender), common.HexToAddress(leg.Receiver),
+		leg.Amount, ring.hashLocks(), leg.TimeLock).Hex()
+
+	return txSigned, nil
+}
+
+// RedeemLeg withdraws leg i of ring as its receiver with the preimages of all
+// the hashlocks.
+func (h *Handler) RedeemLeg(ctx context.Context, ring *Ring, i int, secrets [][32]byte) (Transaction, error) {
+	if err := ring.CheckSecrets(secrets); err != nil {
+		return nil, err
+	}
+
+	details, err := h.AuditLeg(ctx, ring, i)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.Withdrawn {
+		return nil, errors.Errorf("leg %d is already redeemed", i)
+	}
+
+	return h.transact(ctx, htlc.MultiHTLCABI, 0, "withdraw", common.HexToHash(ring.Legs[i].ContractID), secrets)
+}
+
+// RefundLeg refunds leg i of ring as its sender after its timelock.
+func (h *Handler) RefundLeg(ctx context.Context, ring *Ring, i int) (Transaction, error) {
+	if _, err := h.AuditLeg(ctx, ring, i); err != nil {
+		return nil, err
+	}
+
+	return h.transact(ctx, htlc.MultiHTLCABI, 0, "refund", common.HexToHash(ring.Legs[i].ContractID))
+}
+
+// LegSecrets returns the preimages revealed by the redeem of leg i.
+func (h *Handler) LegSecrets(ctx context.Context, ring *Ring, i int) ([][32]byte, error) {
+	details, err := h.AuditLeg(ctx, ring, i)
+	if err != nil {
+		return nil, err
+	}
+
+	if !details.Withdrawn {
+		return nil, errors.Errorf("leg %d is not redeemed yet", i)
+	}
+
+	return details.Preimages, ring.CheckSecrets(details.Preimages)
+}
@@ -0,0 +1,367 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testRing(t *testing.T) (*Ring, []*SecretHashPair) {
+	accounts := []string{
+		"0x1000000000000000000000000000000000000001",
+		"0x2000000000000000000000000000000000000002",
+		"0x3000000000000000000000000000000000000003",
+	}
+
+	var (
+		pairs     []*SecretHashPair
+		hashLocks []common.Hash
+		legs      []*RingLeg
+	)
+	for i := range accounts {
+		pair := NewSecretHashPair()
+		pairs = append(pairs, pair)
+		hashLocks = append(hashLocks, pair.Hash)
+
+		legs = append(legs, &RingLeg{
+			ChainName: "chain" + string('1'+byte(i)),
+			ChainID:   big.NewInt(int64(i + 1)),
+			Contract:  "0x00000000000000000000000000000000000000c1",
+			Sender:    accounts[i],
+			Receiver:  accounts[(i+1)%len(accounts)],
+			Amount:    big.NewInt(int64(100 * (i + 1))),
+		})
+	}
+
+	ring, err := PlanRing(hashLocks, legs, big.NewInt(1600000000), time.Hour)
+	TMust(t, err)
+
+	return ring, pairs
+}
+
+func TestPlanRing(t *testing.T) {
+	Convey("plan a 3-party ring", t, func() {
+		ring, _ := testRing(t)
+		So(ring.Legs[2].TimeLock.Int64(), ShouldEqual, 1600000000)
+		So(ring.Legs[1].TimeLock.Int64(), ShouldEqual, 1600000000+3600)
+		So(ring.Legs[0].TimeLock.Int64(), ShouldEqual, 1600000000+7200)
+		So(ring.Check(), ShouldBeNil)
+	})
+
+	Convey("reject a broken cycle", t, func() {
+		ring, _ := testRing(t)
+		ring.Legs[2].Receiver = "0x4000000000000000000000000000000000000004"
+		_, err := PlanRing(ring.Hashlocks, ring.Legs, big.NewInt(1600000000), time.Hour)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("reject timelocks not decreasing in lock order", t, func() {
+		ring, _ := testRing(t)
+		ring.Legs[1].TimeLock = new(big.Int).Set(ring.Legs[0].TimeLock)
+		So(ring.Check(), ShouldNotBeNil)
+
+		h := &Handler{Config: &Config{Account: ring.Legs[1].Sender}}
+		_, err := h.LockLeg(context.Background(), ring, 1)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("reject bad plans", t, func() {
+		ring, _ := testRing(t)
+		_, err := PlanRing(ring.Hashlocks, ring.Legs[:1], big.NewInt(1600000000), time.Hour)
+		So(err, ShouldNotBeNil)
+
+		_, err = PlanRing(nil, ring.Legs, big.NewInt(1600000000), time.Hour)
+		So(err, ShouldNotBeNil)
+
+		_, err = PlanRing(ring.Hashlocks, ring.Legs, big.NewInt(1600000000), time.Second)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRingSecrets(t *testing.T) {
+	Convey("secrets must be the preimages in hashlock order", t, func() {
+		ring, pairs := testRing(t)
+
+		secrets := [][32]byte{pairs[0].Secret, pairs[1].Secret, pairs[2].Secret}
+		So(ring.CheckSecrets(secrets), ShouldBeNil)
+
+		So(ring.CheckSecrets(secrets[:2]), ShouldNotBeNil)
+		So(ring.CheckSecrets([][32]byte{pairs[1].Secret, pairs[0].Secret, pairs[2].Secret}), ShouldNotBeNil)
+	})
+}
+
+func TestRingCheckLeg(t *testing.T) {
+	ring, _ := testRing(t)
+	leg := ring.Legs[1]
+
+	audited := func() *MultiContractDetails {
+		return &MultiContractDetails{
+			Sender:    common.HexToAddress(leg.Sender),
+			Receiver:  common.HexToAddress(leg.Receiver),
+			Amount:    new(big.Int).Set(leg.Amount),
+			Hashlocks: ring.hashLocks(),
+			Timelock:  new(big.Int).Set(leg.TimeLock),
+		}
+	}
+
+	Convey("the audited leg matches the ring", t, func() {
+		So(ring.checkLeg(1, audited()), ShouldBeNil)
+	})
+
+	Convey("the audited leg does not match the ring", t, func() {
+		c := audited()
+		c.Amount.SetInt64(1)
+		So(ring.checkLeg(1, c), ShouldNotBeNil)
+
+		c = audited()
+		c.Timelock.Sub(c.Timelock, big.NewInt(1))
+		So(ring.checkLeg(1, c), ShouldNotBeNil)
+
+		c = audited()
+		c.Hashlocks = c.Hashlocks[:2]
+		So(ring.checkLeg(1, c), ShouldNotBeNil)
+
+		c = audited()
+		c.Hashlocks[2] = [32]byte{}
+		So(ring.checkLeg(1, c), ShouldNotBeNil)
+
+		c = audited()
+		c.Receiver = common.HexToAddress(leg.Sender)
+		So(ring.checkLeg(1, c), ShouldNotBeNil)
+
+		c = audited()
+		c.Refunded = true
+		So(ring.checkLeg(1, c), ShouldNotBeNil)
+
+		So(ring.checkLeg(1, &MultiContractDetails{}), ShouldNotBeNil)
+	})
+}
+
+func TestRingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aswap")
+	TMust(t, err)
+	defer os.RemoveAll(dir)
+
+	Convey("save and load the ring file", t, func() {
+		ring, _ := testRing(t)
+		ring.Legs[0].ContractID = common.HexToHash("0x01").Hex()
+
+		path := filepath.Join(dir, "ring.json")
+		So(ring.Save(path), ShouldBeNil)
+
+		loaded, err := LoadRing(path)
+		So(err, ShouldBeNil)
+		So(loaded, ShouldResemble, ring)
+	})
+}
+
+func TestConnectLeg(t *testing.T) {
+	var (
+		multi   = "0x00000000000000000000000000000000000000c1"
+		trusted = "0x00000000000000000000000000000000000000c2"
+	)
+
+	newConfig := func() *Config {
+		return &Config{
+			ChainID:          big.NewInt(1),
+			ChainName:        "chain1",
+			URL:              "http://127.0.0.1:8545",
+			OtherChainID:     big.NewInt(2),
+			OtherChainName:   "chain2",
+			OtherURL:         "http://127.0.0.1:8546",
+			MultiContract:    multi,
+			TrustedContracts: map[string][]string{"2": {trusted}},
+		}
+	}
+
+	Convey("reach the chain of a leg through the config", t, func() {
+		c := newConfig()
+		So(c.ConnectLeg(&RingLeg{ChainName: "evil", ChainID: big.NewInt(1), Contract: multi}), ShouldBeNil)
+		So(c.Chain.Name, ShouldEqual, "chain1")
+		So(c.Chain.URL, ShouldEqual, "http://127.0.0.1:8545")
+		So(c.Chain.Contract, ShouldEqual, multi)
+
+		So(c.ConnectLeg(&RingLeg{ChainID: big.NewInt(2), Contract: trusted}), ShouldBeNil)
+		So(c.Chain.URL, ShouldEqual, "http://127.0.0.1:8546")
+	})
+
+	Convey("refuse a leg on an unknown chain or an untrusted contract", t, func() {
+		c := newConfig()
+		So(c.ConnectLeg(&RingLeg{ChainID: big.NewInt(3), Contract: multi}), ShouldNotBeNil)
+		So(c.ConnectLeg(&RingLeg{Contract: multi}), ShouldNotBeNil)
+		So(c.ConnectLeg(&RingLeg{ChainID: big.NewInt(1), Contract: trusted}), ShouldNotBeNil)
+
+		//our HashedTimelockMulti is on the own chain only
+		err := c.ConnectLeg(&RingLeg{ChainID: big.NewInt(2), Contract: multi})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "nor trusted")
+	})
+}
+
+func TestMultiContractABI(t *testing.T) {
+	Convey("pack the HashedTimelockMulti calls", t, func() {
+		ring, pairs := testRing(t)
+		leg := ring.Legs[0]
+
+		parsedABI, err := abi.JSON(strings.NewReader(htlc.MultiHTLCABI))
+		So(err, ShouldBeNil)
+
+		_, err = parsedABI.Pack("newContract", common.HexToAddress(leg.Receiver), ring.hashLocks(), leg.TimeLock)
+		So(err, ShouldBeNil)
+
+		_, err = parsedABI.Pack("withdraw", common.HexToHash("0x01"), [][32]byte{pairs[0].Secret})
+		So(err, ShouldBeNil)
+
+		output, err := parsedABI.Methods["getContract"].Outputs.Pack(common.HexToAddress(leg.Sender), common.HexToAddress(leg.Receiver),
+			leg.Amount, ring.hashLocks(), leg.TimeLock, true, false, [][32]byte{pairs[0].Secret, pairs[1].Secret, pairs[2].Secret})
+		So(err, ShouldBeNil)
+
+		details := new(MultiContractDetails)
+		So(parsedABI.Unpack(details, "getContract", output), ShouldBeNil)
+		So(details.Hashlocks, ShouldResemble, ring.hashLocks())
+		So(details.Preimages[2], ShouldEqual, pairs[2].Secret)
+		So(ring.checkLeg(0, details), ShouldBeNil)
+
+		//the id covers every hashlock
+		id := multiContractID(common.HexToAddress(leg.Sender), common.HexToAddress(leg.Receiver), leg.Amount, ring.hashLocks(), leg.TimeLock)
+		other := multiContractID(common.HexToAddress(leg.Sender), common.HexToAddress(leg.Receiver), leg.Amount, ring.hashLocks()[:2], leg.TimeLock)
+		So(id, ShouldNotEqual, other)
+	})
+
+	Convey("deploy refuses without bytecode", t, func() {
+		if htlc.MultiHTLCBIN != "" {
+			return
+		}
+		h := &Handler{Config: &Config{}}
+		So(h.DeployMultiContract(context.Background()), ShouldNotBeNil)
+	})
+}
+
+func TestRingSim(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	skipUncompiled(t, htlc.MultiHTLCBIN != "", "HashedTimeLockMulti.sol is")
+
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < 3; i++ {
+		key, _ := crypto.GenerateKey()
+		keys = append(keys, key)
+	}
+	sim, hs := testSimHandlers(t, keys...)
+	defer sim.Close()
+
+	ctx := context.Background()
+
+	address, err := hs[0].deploy(ctx, htlc.MultiHTLCBIN)
+	TMust(t, err)
+	sim.Commit()
+	for _, h := range hs {
+		h.Config.Chain.Contract = address
+	}
+
+	plan := func() (*Ring, [][32]byte) {
+		var (
+			secrets   [][32]byte
+			hashLocks []common.Hash
+			legs      []*RingLeg
+		)
+		for i, h := range hs {
+			pair := NewSecretHashPair()
+			secrets = append(secrets, pair.Secret)
+			hashLocks = append(hashLocks, pair.Hash)
+
+			legs = append(legs, &RingLeg{
+				ChainName: "sim",
+				ChainID:   h.Config.Chain.ID,
+				Contract:  address,
+				Sender:    h.Config.Account,
+				Receiver:  hs[(i+1)%len(hs)].Config.Account,
+				Amount:    big.NewInt(int64(1000 * (i + 1))),
+			})
+		}
+
+		ring, err := PlanRing(hashLocks, legs, big.NewInt(time.Now().Unix()+3600), time.Hour)
+		TMust(t, err)
+		return ring, secrets
+	}
+
+	lockAll := func(ring *Ring) {
+		for i, h := range hs {
+			_, err := h.LockLeg(ctx, ring, i)
+			So(err, ShouldBeNil)
+			sim.Commit()
+		}
+	}
+
+	Convey("every leg needs all the preimages, each redeem reveals them to the next", t, func() {
+		ring, secrets := plan()
+		lockAll(ring)
+
+		for i := range ring.Legs {
+			details, err := hs[0].AuditLeg(ctx, ring, i)
+			So(err, ShouldBeNil)
+			So(details.Hashlocks, ShouldHaveLength, 3)
+		}
+
+		//a secret short
+		_, err := hs[0].RedeemLeg(ctx, ring, 2, secrets[:2])
+		So(err, ShouldNotBeNil)
+
+		//the leader redeems its incoming leg first
+		_, err = hs[0].RedeemLeg(ctx, ring, 2, secrets)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		for i := 1; i >= 0; i-- {
+			revealed, err := hs[i+1].LegSecrets(ctx, ring, i+1)
+			So(err, ShouldBeNil)
+			So(revealed, ShouldResemble, secrets)
+
+			_, err = hs[i+1].RedeemLeg(ctx, ring, i, revealed)
+			So(err, ShouldBeNil)
+			sim.Commit()
+		}
+
+		for i := range ring.Legs {
+			details, err := hs[0].AuditLeg(ctx, ring, i)
+			So(err, ShouldBeNil)
+			So(details.Withdrawn, ShouldBeTrue)
+		}
+	})
+
+	Convey("the senders refund the legs once expired", t, func() {
+		ring, _ := plan()
+		lockAll(ring)
+
+		_, err := hs[0].RefundLeg(ctx, ring, 0)
+		So(err, ShouldNotBeNil)
+
+		So(sim.AdjustTime(4*time.Hour), ShouldBeNil)
+		sim.Commit()
+
+		for i, h := range hs {
+			_, err := h.RefundLeg(ctx, ring, i)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			details, err := h.AuditLeg(ctx, ring, i)
+			So(err, ShouldBeNil)
+			So(details.Refunded, ShouldBeTrue)
+		}
+	})
+}
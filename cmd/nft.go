@@ -0,0 +1,447 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"math/big"
+	"strings"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// token standards of NFTAsset
+const (
+	AssetERC721  = "erc721"
+	AssetERC1155 = "erc1155"
+)
+
+// NFTAsset is the token a HashedTimelockERC721 or HashedTimelockERC1155
+// contract locks instead of ETH. Token and TokenID only matter to Lock and
+// ApproveNFT, the HTLC keeps them for the other calls.
+type NFTAsset struct {
+	Type    string
+	Token   common.Address
+	TokenID *big.Int
+}
+
+// NFTContractDetails is the getContract result of both NFT HTLCs, Amount
+// stays nil for HashedTimelockERC721.
+type NFTContractDetails struct {
+	Sender        common.Address
+	Receiver      common.Address
+	TokenContract common.Address
+	TokenId       *big.Int
+	Amount        *big.Int
+	Hashlock      [32]byte
+	Timelock      *big.Int
+	Withdrawn     bool
+	Refunded      bool
+	Preimage      [32]byte
+}
+
+// nftVariant is the HTLC contract and token interface of a token standard.
+type nftVariant struct {
+	name     string
+	bin      string
+	abi      string
+	tokenABI string
+}
+
+func nftVariantOf(assetType string) (*nftVariant, error) {
+	switch assetType {
+	case AssetERC721:
+		return &nftVariant{"HashedTimelockERC721", htlc.ERC721HTLCBIN, htlc.ERC721HTLCABI, htlc.ERC721ABI}, nil
+	case AssetERC1155:
+		return &nftVariant{"HashedTimelockERC1155", htlc.ERC1155HTLCBIN, htlc.ERC1155HTLCABI, htlc.ERC1155ABI}, nil
+	default:
+		return nil, errors.Errorf("unknown asset type: %v", assetType)
+	}
+}
+
+// nftContract returns the address of the assetType HTLC of the config.
+func (c *Config) nftContract(assetType string) (common.Address, error) {
+	var address string
+	switch assetType {
+	case AssetERC721:
+		address = c.ERC721Contract
+	case AssetERC1155:
+		address = c.ERC1155Contract
+	}
+
+	if address == "" {
+		return common.Address{}, errors.Errorf("no %v contract in the config, deploy it with aswap-admin deploy --variant %v", assetType, assetType)
+	}
+
+	if err := c.ValidateAddress(address); err != nil {
+		return common.Address{}, err
+	}
+
+	return common.HexToAddress(address), nil
+}
+
+// nftContractID mirrors the id derivation of newContract of the NFT HTLCs,
+// amount is only part of it for HashedTimelockERC1155.
+func nftContractID(asset *NFTAsset, sender, receiver common.Address, amount *big.Int, hashLock [32]byte, timeLock *big.Int) common.Hash {
+	var packed []byte
+	packed = append(packed, sender.Bytes()...)
+	packed = append(packed, receiver.Bytes()...)
+	packed = append(packed, asset.Token.Bytes()...)
+	packed = append(packed, common.LeftPadBytes(asset.TokenID.Bytes(), 32)...)
+	if asset.Type == AssetERC1155 {
+		packed = append(packed, common.LeftPadBytes(amount.Bytes(), 32)...)
+	}
+	packed = append(packed, hashLock[:]...)
+	packed = append(packed, common.LeftPadBytes(timeLock.Bytes(), 32)...)
+
+	return sha256.Sum256(packed)
+}
+
+// DeployNFTContract deploys the HTLC of the assetType tokens on the own chain.
+func (h *Handler) DeployNFTContract(ctx context.Context, assetType string) error {
+	variant, err := nftVariantOf(assetType)
+	if err != nil {
+		return err
+	}
+
+	if variant.bin == "" {
+		return errNotCompiled(variant.name)
+	}
+
+	address, err := h.deploy(ctx, variant.bin)
+	if err != nil {
+		return err
+	}
+
+	if assetType == AssetERC721 {
+		h.Config.ERC721Contract = address
+	} else {
+		h.Config.ERC1155Contract = address
+	}
+
+	return h.Config.rotate(h.ConfigPath)
+}
+
+// VerifyNFTContract is VerifyContract for the HTLC of the assetType tokens.
+func (h *Handler) VerifyNFTContract(ctx context.Context, assetType string, address common.Address) error {
+	variant, err := nftVariantOf(assetType)
+	if err != nil {
+		return err
+	}
+
+	return h.verifyBytecode(ctx, address, variant.bin, variant.name)
+}
+
+// AuditNFTContract returns the HTLC contractId of the assetType tokens.
+func (h *Handler) AuditNFTContract(ctx context.Context, assetType string, contractId common.Hash) (*NFTContractDetails, error) {
+	variant, err := nftVariantOf(assetType)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := h.Config.nftContract(assetType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.verifyBytecode(ctx, address, variant.bin, variant.name); err != nil {
+		return nil, err
+	}
+
+	details := new(NFTContractDetails)
+	if err := h.callTo(ctx, address, variant.abi, details, "getContract", contractId); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// NFTApproved tells if the HTLC of the config may move the asset of owner.
+func (h *Handler) NFTApproved(ctx context.Context, asset *NFTAsset, owner common.Address) (bool, error) {
+	variant, err := nftVariantOf(asset.Type)
+	if err != nil {
+		return false, err
+	}
+
+	address, err := h.Config.nftContract(asset.Type)
+	if err != nil {
+		return false, err
+	}
+
+	var all bool
+	if err := h.callTo(ctx, asset.Token, variant.tokenABI, &all, "isApprovedForAll", owner, address); err != nil {
+		return false, errors.Wrapf(err, "token %v", asset.Token.String())
+	}
+
+	if all || asset.Type == AssetERC1155 {
+		return all, nil
+	}
+
+	var approved common.Address
+	if err := h.callTo(ctx, asset.Token, variant.tokenABI, &approved, "getApproved", asset.TokenID); err != nil {
+		return false, errors.Wrapf(err, "token %v", asset.Token.String())
+	}
+
+	return approved == address, nil
+}
+
+// ApproveNFT lets the HTLC of the config move the asset of our account:
+// the single token for ERC721, all our tokens of the contract for ERC1155,
+// which has no finer approval.
+func (h *Handler) ApproveNFT(ctx context.Context, asset *NFTAsset) (Transaction, error) {
+	variant, err := nftVariantOf(asset.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := h.Config.nftContract(asset.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	if asset.Type == AssetERC721 {
+		return h.transactTo(ctx, asset.Token, variant.tokenABI, 0, "approve", address, asset.TokenID)
+	}
+
+	return h.transactTo(ctx, asset.Token, variant.tokenABI, 0, "setApprovalForAll", address, true)
+}
+
+// nftBackend drives the HashedTimelockERC721 and HashedTimelockERC1155
+// contracts, the amount of a swap being the number of tokens.
+type nftBackend struct {
+	h     *Handler
+	asset *NFTAsset
+}
+
+func (b *nftBackend) Lock(ctx context.Context, receiver string, amount *big.Int, hashLock [32]byte, timeLock *big.Int) (string, string, error) {
+	cfg := b.h.Config
+
+	if b.asset.Type == AssetERC721 && amount.Cmp(big.NewInt(1)) != 0 {
+		return "", "", errors.Errorf("an ERC721 token is locked whole, amount must be 1, not %v", amount)
+	}
+
+	if amount.Sign() <= 0 {
+		return "", "", errors.Errorf("amount %v must be > 0", amount)
+	}
+
+	if b.asset.TokenID == nil || b.asset.Token == (common.Address{}) {
+		return "", "", errors.New("the token contract and the token id to lock are not specified")
+	}
+
+	variant, err := nftVariantOf(b.asset.Type)
+	if err != nil {
+		return "", "", err
+	}
+
+	address, err := cfg.nftContract(b.asset.Type)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := cfg.ValidateAddress(receiver); err != nil {
+		return "", "", err
+	}
+
+	//make sure our own contract is the genuine HTLC
+	if err := b.h.VerifyNFTContract(ctx, b.asset.Type, address); err != nil {
+		return "", "", err
+	}
+
+	var (
+		sender = common.HexToAddress(cfg.Account)
+		to     = common.HexToAddress(receiver)
+		id     = nftContractID(b.asset, sender, to, amount, hashLock, timeLock)
+	)
+
+	//newContract reverts without the approval or on an id in use, say why
+	//before paying for it
+	approved, err := b.h.NFTApproved(ctx, b.asset, sender)
+	if err != nil {
+		return "", "", err
+	}
+	if !approved {
+		return "", "", errors.Errorf("%v is not approved to move token %v of %v, run approve first",
+			address.String(), b.asset.TokenID, b.asset.Token.String())
+	}
+
+	details, err := b.h.AuditNFTContract(ctx, b.asset.Type, id)
+	if err != nil {
+		return "", "", err
+	}
+	if details.Sender != (common.Address{}) {
+		return "", "", errors.Errorf("contract %v with the same receiver, token, hashlock and timelock already exists", id.Hex())
+	}
+
+	args := []interface{}{to, hashLock, timeLock, b.asset.Token, b.asset.TokenID}
+	if b.asset.Type == AssetERC1155 {
+		args = append(args, amount)
+	}
+
+	txSigned, err := b.h.transactTo(ctx, address, variant.abi, 0, "newContract", args...)
+	if err != nil {
+		return "", "", err
+	}
+
+	return id.Hex(), txSigned.Hash().Hex(), nil
+}
+
+func (b *nftBackend) Audit(ctx context.Context, contractId string) (*SwapContract, error) {
+	id, err := parseContractId(contractId)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := b.h.AuditNFTContract(ctx, b.asset.Type, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.Sender == (common.Address{}) {
+		return nil, errors.Errorf("contractId %v does not exist", contractId)
+	}
+
+	amount := details.Amount
+	if b.asset.Type == AssetERC721 {
+		amount = big.NewInt(1)
+	}
+
+	return &SwapContract{
+		ID:        id.Hex(),
+		Sender:    details.Sender.String(),
+		Receiver:  details.Receiver.String(),
+		Amount:    amount,
+		Hashlock:  details.Hashlock,
+		Timelock:  details.Timelock,
+		Withdrawn: details.Withdrawn,
+		Refunded:  details.Refunded,
+		Preimage:  details.Preimage,
+		Token:     details.TokenContract.String(),
+		TokenID:   details.TokenId,
+	}, nil
+}
+
+func (b *nftBackend) Redeem(ctx context.Context, contractId string, secret [32]byte) (string, error) {
+	return b.transact(ctx, contractId, "withdraw", secret)
+}
+
+func (b *nftBackend) Refund(ctx context.Context, contractId string) (string, error) {
+	return b.transact(ctx, contractId, "refund")
+}
+
+// transact calls method of the HTLC on contractId, checking the contract
+// first.
+func (b *nftBackend) transact(ctx context.Context, contractId string, method string, args ...interface{}) (string, error) {
+	variant, err := nftVariantOf(b.asset.Type)
+	if err != nil {
+		return "", err
+	}
+
+	address, err := b.h.Config.nftContract(b.asset.Type)
+	if err != nil {
+		return "", err
+	}
+
+	if err := b.h.VerifyNFTContract(ctx, b.asset.Type, address); err != nil {
+		return "", err
+	}
+
+	id, err := parseContractId(contractId)
+	if err != nil {
+		return "", err
+	}
+
+	txSigned, err := b.h.transactTo(ctx, address, variant.abi, 0, method, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return "", err
+	}
+
+	return txSigned.Hash().Hex(), nil
+}
+
+func (b *nftBackend) ExtractSecret(ctx context.Context, contractId string) ([32]byte, error) {
+	c, err := b.Audit(ctx, contractId)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	if !c.Withdrawn {
+		return [32]byte{}, errors.Errorf("contractId %v is not redeemed yet", contractId)
+	}
+
+	return c.Preimage, nil
+}
+
+func (b *nftBackend) WatchEvents(ctx context.Context, contractId string, sink chan<- *SwapEvent) error {
+	id, err := parseContractId(contractId)
+	if err != nil {
+		return err
+	}
+
+	variant, err := nftVariantOf(b.asset.Type)
+	if err != nil {
+		return err
+	}
+
+	address, err := b.h.Config.nftContract(b.asset.Type)
+	if err != nil {
+		return err
+	}
+
+	parsedABI, err := abi.JSON(strings.NewReader(variant.abi))
+	if err != nil {
+		return errors.Wrap(err, "parse ABI")
+	}
+
+	eventTypes := map[common.Hash]string{
+		parsedABI.Events["LogHTLCNew"].ID():      EventNew,
+		parsedABI.Events["LogHTLCWithdraw"].ID(): EventWithdraw,
+		parsedABI.Events["LogHTLCRefund"].ID():   EventRefund,
+	}
+
+	var topics []common.Hash
+	for topic := range eventTypes {
+		topics = append(topics, topic)
+	}
+
+	query := ethereum.FilterQuery{
+		FromBlock: new(big.Int),
+		Addresses: []common.Address{address},
+		Topics:    [][]common.Hash{topics, {id}},
+	}
+
+	return watchLogs(ctx, b.h.Config.client, query, sink, func(l types.Log) (*SwapEvent, error) {
+		event := &SwapEvent{Type: eventTypes[l.Topics[0]], ContractID: id.Hex(), TxID: l.TxHash.Hex()}
+
+		if event.Type == EventWithdraw {
+			var data struct{ Preimage [32]byte }
+			if err := parsedABI.Unpack(&data, "LogHTLCWithdraw", l.Data); err != nil {
+				return nil, errors.Wrapf(err, "decode withdraw log of %v", l.TxHash.Hex())
+			}
+			event.Secret = data.Preimage
+		}
+
+		return event, nil
+	})
+}
+
+func (b *nftBackend) Confirmations(ctx context.Context, txid string) (uint64, error) {
+	return (&evmBackend{h: b.h}).Confirmations(ctx, txid)
+}
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+	"github.com/icodezjb/atomicswap/contract/mock"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNFTContractID(t *testing.T) {
+	var (
+		sender   = common.HexToAddress("0x01")
+		receiver = common.HexToAddress("0x02")
+		hashLock = [32]byte{3}
+		timeLock = big.NewInt(1000)
+	)
+
+	erc721 := &NFTAsset{Type: AssetERC721, Token: common.HexToAddress("0xc1"), TokenID: big.NewInt(7)}
+	erc1155 := &NFTAsset{Type: AssetERC1155, Token: common.HexToAddress("0xc1"), TokenID: big.NewInt(7)}
+
+	Convey("the amount is part of the id for ERC1155 only", t, func() {
+		So(nftContractID(erc721, sender, receiver, big.NewInt(1), hashLock, timeLock),
+			ShouldEqual, nftContractID(erc721, sender, receiver, big.NewInt(2), hashLock, timeLock))
+
+		So(nftContractID(erc1155, sender, receiver, big.NewInt(1), hashLock, timeLock),
+			ShouldNotEqual, nftContractID(erc1155, sender, receiver, big.NewInt(2), hashLock, timeLock))
+	})
+
+	Convey("every token has its own id", t, func() {
+		other := *erc721
+		other.TokenID = big.NewInt(8)
+
+		So(nftContractID(erc721, sender, receiver, big.NewInt(1), hashLock, timeLock),
+			ShouldNotEqual, nftContractID(&other, sender, receiver, big.NewInt(1), hashLock, timeLock))
+	})
+}
+
+func TestNFTContractDetails(t *testing.T) {
+	token := common.HexToAddress("0xc1")
+
+	for _, c := range []struct {
+		abi    string
+		values []interface{}
+	}{
+		{htlc.ERC721HTLCABI, []interface{}{common.HexToAddress("0x01"), common.HexToAddress("0x02"), token, big.NewInt(7),
+			[32]byte{3}, big.NewInt(1000), true, false, [32]byte{4}}},
+		{htlc.ERC1155HTLCABI, []interface{}{common.HexToAddress("0x01"), common.HexToAddress("0x02"), token, big.NewInt(7),
+			big.NewInt(5), [32]byte{3}, big.NewInt(1000), true, false, [32]byte{4}}},
+	} {
+		Convey("unpack getContract of both NFT HTLCs", t, func() {
+			parsedABI, err := abi.JSON(strings.NewReader(c.abi))
+			So(err, ShouldBeNil)
+
+			output, err := parsedABI.Methods["getContract"].Outputs.Pack(c.values...)
+			So(err, ShouldBeNil)
+
+			details := new(NFTContractDetails)
+			So(parsedABI.Unpack(details, "getContract", output), ShouldBeNil)
+			So(details.TokenContract, ShouldEqual, token)
+			So(details.TokenId.Int64(), ShouldEqual, 7)
+			So(details.Hashlock, ShouldResemble, [32]byte{3})
+			So(details.Withdrawn, ShouldBeTrue)
+			So(details.Preimage, ShouldResemble, [32]byte{4})
+		})
+	}
+}
+
+func TestNFTBackend(t *testing.T) {
+	ctx := context.Background()
+	asset := &NFTAsset{Type: AssetERC721, Token: common.HexToAddress("0xc1"), TokenID: big.NewInt(7)}
+
+	newHandler := func(chainType string) *Handler {
+		return &Handler{
+			Config: &Config{
+				Account:        common.HexToAddress("0x01").String(),
+				ERC721Contract: "0x00000000000000000000000000000000000000d1",
+				Chain:          &chain{Type: chainType},
+			},
+			Asset: asset,
+		}
+	}
+
+	Convey("the asset selects the NFT backend of an EVM chain", t, func() {
+		backend, err := newHandler(ChainEVM).Backend()
+		So(err, ShouldBeNil)
+		So(backend, ShouldHaveSameTypeAs, &nftBackend{})
+
+		_, err = newHandler(ChainBTC).Backend()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("lock a whole ERC721 token", t, func() {
+		backend, _ := newHandler(ChainEVM).Backend()
+
+		_, _, err := backend.Lock(ctx, common.HexToAddress("0x02").String(), big.NewInt(2), [32]byte{}, big.NewInt(1000))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "amount must be 1")
+	})
+
+	Convey("refuse a missing token or contract", t, func() {
+		h := newHandler(ChainEVM)
+		h.Asset = &NFTAsset{Type: AssetERC721}
+		backend, _ := h.Backend()
+
+		_, _, err := backend.Lock(ctx, common.HexToAddress("0x02").String(), big.NewInt(1), [32]byte{}, big.NewInt(1000))
+		So(err, ShouldNotBeNil)
+
+		h = newHandler(ChainEVM)
+		h.Asset = &NFTAsset{Type: AssetERC1155, Token: asset.Token, TokenID: asset.TokenID}
+		backend, _ = h.Backend()
+
+		_, err = backend.Audit(ctx, common.HexToHash("0x01").Hex())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no erc1155 contract")
+	})
+
+	Convey("deploy needs the compiled contract", t, func() {
+		h := newHandler(ChainEVM)
+		So(h.DeployNFTContract(ctx, AssetERC721), ShouldNotBeNil)
+		So(h.DeployNFTContract(ctx, "erc20"), ShouldNotBeNil)
+	})
+}
+
+func TestNFTBackendSim(t *testing.T) {
+	skipUncompiled(t, htlc.ERC721HTLCBIN != "" && htlc.ERC1155HTLCBIN != "", "the NFT HTLCs are")
+	skipUncompiled(t, mock.ERC721BIN != "" && mock.ERC1155BIN != "", "the mock tokens are")
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	var (
+		ctx      = context.Background()
+		sender   = common.HexToAddress(hs[0].Config.Account)
+		receiver = common.HexToAddress(hs[1].Config.Account)
+	)
+
+	deploy := func(bin string) common.Address {
+		address, err := hs[0].deploy(ctx, bin)
+		TMust(t, err)
+		sim.Commit()
+		return common.HexToAddress(address)
+	}
+
+	erc721HTLC, erc1155HTLC := deploy(htlc.ERC721HTLCBIN), deploy(htlc.ERC1155HTLCBIN)
+	erc721Token, erc1155Token := deploy(mock.ERC721BIN), deploy(mock.ERC1155BIN)
+	for _, h := range hs {
+		h.Config.ERC721Contract = erc721HTLC.String()
+		h.Config.ERC1155Contract = erc1155HTLC.String()
+	}
+
+	_, err := hs[0].transactTo(ctx, erc721Token, mock.ERC721ABI, 0, "mint", sender, big.NewInt(7))
+	TMust(t, err)
+	_, err = hs[0].transactTo(ctx, erc1155Token, mock.ERC1155ABI, 0, "mint", sender, big.NewInt(7), big.NewInt(10))
+	TMust(t, err)
+	sim.Commit()
+
+	ownerOf := func(tokenID int64) common.Address {
+		var owner common.Address
+		TMust(t, hs[0].callTo(ctx, erc721Token, htlc.ERC721ABI, &owner, "ownerOf", big.NewInt(tokenID)))
+		return owner
+	}
+
+	balanceOf := func(owner common.Address, id int64) int64 {
+		balance := new(big.Int)
+		TMust(t, hs[0].callTo(ctx, erc1155Token, htlc.ERC1155ABI, &balance, "balanceOf", owner, big.NewInt(id)))
+		return balance.Int64()
+	}
+
+	for _, asset := range []*NFTAsset{
+		{Type: AssetERC721, Token: erc721Token, TokenID: big.NewInt(7)},
+		{Type: AssetERC1155, Token: erc1155Token, TokenID: big.NewInt(7)},
+	} {
+		for _, h := range hs {
+			h.Asset = asset
+		}
+
+		amount := big.NewInt(1)
+		if asset.Type == AssetERC1155 {
+			amount = big.NewInt(4)
+		}
+
+		senderBackend, err := hs[0].Backend()
+		TMust(t, err)
+		receiverBackend, err := hs[1].Backend()
+		TMust(t, err)
+
+		Convey("lock, redeem and refund a "+asset.Type+" token on a simulated chain", t, func() {
+			hashPair := testHashPair()
+			timeLock := big.NewInt(time.Now().Unix() + 3600)
+
+			//newContract needs the approval
+			_, _, err := senderBackend.Lock(ctx, receiver.String(), amount, hashPair.Hash, timeLock)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "run approve first")
+
+			_, err = hs[0].ApproveNFT(ctx, asset)
+			So(err, ShouldBeNil)
+			sim.Commit()
+			approved, err := hs[0].NFTApproved(ctx, asset, sender)
+			So(err, ShouldBeNil)
+			So(approved, ShouldBeTrue)
+
+			id, _, err := senderBackend.Lock(ctx, receiver.String(), amount, hashPair.Hash, timeLock)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			c, err := receiverBackend.Audit(ctx, id)
+			So(err, ShouldBeNil)
+			So(c.Receiver, ShouldEqual, receiver.String())
+			So(c.Token, ShouldEqual, asset.Token.String())
+			So(c.Amount.Int64(), ShouldEqual, amount.Int64())
+
+			_, err = receiverBackend.Redeem(ctx, id, hashPair.Secret)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			secret, err := senderBackend.ExtractSecret(ctx, id)
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, hashPair.Secret)
+
+			if asset.Type == AssetERC721 {
+				So(ownerOf(7), ShouldEqual, receiver)
+
+				//lock the next token to refund it
+				_, err = hs[0].transactTo(ctx, erc721Token, mock.ERC721ABI, 0, "mint", sender, big.NewInt(8))
+				So(err, ShouldBeNil)
+				sim.Commit()
+				asset.TokenID = big.NewInt(8)
+				_, err = hs[0].ApproveNFT(ctx, asset)
+				So(err, ShouldBeNil)
+				sim.Commit()
+			} else {
+				So(balanceOf(receiver, 7), ShouldEqual, 4)
+				So(balanceOf(sender, 7), ShouldEqual, 6)
+			}
+
+			hashPair = testHashPair()
+			id, _, err = senderBackend.Lock(ctx, receiver.String(), amount, hashPair.Hash, timeLock)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			//refund only after the timelock
+			_, err = senderBackend.Refund(ctx, id)
+			So(err, ShouldNotBeNil)
+
+			So(sim.AdjustTime(2*time.Hour), ShouldBeNil)
+			sim.Commit()
+			_, err = senderBackend.Refund(ctx, id)
+			So(err, ShouldBeNil)
+			sim.Commit()
+
+			c, err = senderBackend.Audit(ctx, id)
+			So(err, ShouldBeNil)
+			So(c.Refunded, ShouldBeTrue)
+
+			if asset.Type == AssetERC721 {
+				So(ownerOf(8), ShouldEqual, sender)
+			} else {
+				So(balanceOf(sender, 7), ShouldEqual, 6)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// store bucket of the nonce manager
+const nonceBucket = "nonces"
+
+// a nonce handed out and not sent within this long is handed out again
+const nonceReservation = 5 * time.Minute
+
+// nonceSlot is a nonce handed out by the nonce manager.
+type nonceSlot struct {
+	//txid once the transaction is sent
+	TxID string `json:"txid,omitempty"`
+	//unix time the nonce was handed out
+	Reserved int64 `json:"reserved"`
+}
+
+// accountNonces is what the nonce manager knows of an account on a chain:
+// the nonces handed out that the node may not know yet.
+type accountNonces struct {
+	//the nonce after the highest one handed out
+	Next     uint64                `json:"next"`
+	InFlight map[uint64]*nonceSlot `json:"inFlight,omitempty"`
+}
+
+// take hands out the lowest nonce from pending, the pending nonce of the
+// node, that no transaction holds: a gap left by a transaction that was
+// never sent or that the node dropped, or else the next one. A transaction
+// sent within nonceReservation holds its nonce even if the node does not
+// know it, the journal sends it again.
+func (a *accountNonces) take(pending uint64, now time.Time) uint64 {
+	if a.InFlight == nil {
+		a.InFlight = make(map[uint64]*nonceSlot)
+	}
+
+	//the node knows the transactions below pending, mined or not
+	for n := range a.InFlight {
+		if n < pending {
+			delete(a.InFlight, n)
+		}
+	}
+
+	if a.Next < pending {
+		a.Next = pending
+	}
+
+	nonce := a.Next
+	for n := pending; n < a.Next; n++ {
+		slot, ok := a.InFlight[n]
+		if ok && slot.TxID == "" && now.Sub(time.Unix(slot.Reserved, 0)) <= nonceReservation {
+			continue
+		}
+		//a sent transaction above pending waits in the queue of the node for
+		//the nonces below, one at pending was dropped if it is not recent
+		if ok && slot.TxID != "" && (n > pending || now.Sub(time.Unix(slot.Reserved, 0)) <= nonceReservation) {
+			continue
+		}
+
+		log.Printf("nonce gap at %v, pending nonce = %v, next = %v", n, pending, a.Next)
+		nonce = n
+		break
+	}
+
+	a.InFlight[nonce] = &nonceSlot{Reserved: now.Unix()}
+	if nonce == a.Next {
+		a.Next++
+	}
+
+	return nonce
+}
+
+// done records the txid sent with nonce, or frees nonce if txid is empty.
+func (a *accountNonces) done(nonce uint64, txid string) {
+	if a.InFlight == nil {
+		a.InFlight = make(map[uint64]*nonceSlot)
+	}
+
+	if txid != "" {
+		a.InFlight[nonce] = &nonceSlot{TxID: txid, Reserved: time.Now().Unix()}
+		return
+	}
+
+	delete(a.InFlight, nonce)
+	if nonce+1 == a.Next {
+		a.Next--
+	}
+}
+
+// localStore returns the store of the nonce manager and of the transaction
+// journal, nil if the handler has no store, e.g. when it is used as a
+// library without a config file.
+func (h *Handler) localStore() (*Store, error) {
+	if h.store == nil && h.Config.Store == "" && h.ConfigPath == "" {
+		return nil, nil
+	}
+
+	return h.Store()
+}
+
+func (h *Handler) nonceKey() string {
+	return h.Config.Chain.ID.String() + "/" + common.HexToAddress(h.Config.Account).Hex()
+}
+
+// reserveNonce sets the nonce of auth from the nonce manager, serialized per
+// chain and account through the store, across the goroutines and the aswap
+// processes sending from the account. The transaction must be reported with
+// the returned done: with its txid once sent, or "" to free the nonce.
+func (h *Handler) reserveNonce(ctx context.Context, auth *txAuth) (func(txid string), error) {
+	store, err := h.localStore()
+	if err != nil {
+		return nil, err
+	}
+
+	//the replaced transaction holds its nonce until the replacement is sent
+	if auth.keepNonce {
+		return func(txid string) {
+			if store != nil && txid != "" {
+				h.doneNonce(store, auth.Nonce.Uint64(), txid)
+			}
+		}, nil
+	}
+
+	//asked before taking the lock of the store, which the other processes
+	//sending from any account wait on
+	pending, err := h.Config.pendingNonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if store == nil {
+		auth.Nonce = pending
+		return func(string) {}, nil
+	}
+
+	nonces := new(accountNonces)
+	err = store.Update(nonceBucket, h.nonceKey(), nonces, func(bool) error {
+		auth.Nonce = new(big.Int).SetUint64(nonces.take(pending.Uint64(), time.Now()))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(txid string) {
+		h.doneNonce(store, auth.Nonce.Uint64(), txid)
+	}, nil
+}
+
+func (h *Handler) doneNonce(store *Store, nonce uint64, txid string) {
+	nonces := new(accountNonces)
+	err := store.Update(nonceBucket, h.nonceKey(), nonces, func(bool) error {
+		nonces.done(nonce, txid)
+		return nil
+	})
+	if err != nil {
+		log.Printf("nonce %v of %v: %v", nonce, h.Config.Account, err)
+	}
+}
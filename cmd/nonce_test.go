@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNonceManager(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	Convey("nonces are handed out once, from the pending nonce of the node", t, func() {
+		a := new(accountNonces)
+
+		So(a.take(5, now), ShouldEqual, 5)
+		So(a.take(5, now), ShouldEqual, 6)
+		So(a.take(5, now), ShouldEqual, 7)
+
+		a.done(5, "0x05")
+		a.done(6, "0x06")
+
+		//5 is mined, 6 waits in the mempool and 7 is not sent yet
+		So(a.take(7, now), ShouldEqual, 8)
+		So(a.InFlight, ShouldNotContainKey, uint64(5))
+		So(a.InFlight, ShouldNotContainKey, uint64(6))
+		So(a.InFlight, ShouldContainKey, uint64(7))
+
+		//the node resyncs a fresh account
+		So(new(accountNonces).take(9, now), ShouldEqual, 9)
+		a.Next = 3
+		So(a.take(9, now), ShouldEqual, 9)
+		So(a.Next, ShouldEqual, 10)
+	})
+
+	Convey("a freed nonce is handed out again", t, func() {
+		a := new(accountNonces)
+		So(a.take(0, now), ShouldEqual, 0)
+		So(a.take(0, now), ShouldEqual, 1)
+		So(a.take(0, now), ShouldEqual, 2)
+
+		a.done(2, "")
+		So(a.Next, ShouldEqual, 2)
+		So(a.take(0, now), ShouldEqual, 2)
+
+		a.done(1, "")
+		So(a.take(0, now), ShouldEqual, 1)
+	})
+
+	Convey("gaps are detected and filled", t, func() {
+		a := new(accountNonces)
+		So(a.take(0, now), ShouldEqual, 0)
+		So(a.take(0, now), ShouldEqual, 1)
+		a.done(1, "0x01")
+
+		//0 was never sent and its reservation expired
+		So(a.take(0, now.Add(nonceReservation+time.Second)), ShouldEqual, 0)
+
+		//the node dropped 0 after it was sent, 1 waits behind it; until the
+		//journal had the time to send 0 again, it holds its nonce
+		a.done(0, "0x00")
+		So(a.take(0, now), ShouldEqual, 2)
+		So(a.take(0, now.Add(nonceReservation+time.Second)), ShouldEqual, 0)
+		So(a.take(0, now), ShouldEqual, 3)
+	})
+
+	Convey("processes sharing the store serialize their changes", t, func() {
+		dir, err := ioutil.TempDir("", "aswap")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "aswap-store.json")
+
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			taken = make(map[uint64]bool)
+		)
+		for i := 0; i < 4; i++ {
+			//a store per process
+			store, err := OpenStore(path)
+			So(err, ShouldBeNil)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					nonces := new(accountNonces)
+					var nonce uint64
+					TMust(t, store.Update(nonceBucket, "1/0x01", nonces, func(bool) error {
+						nonce = nonces.take(0, time.Now())
+						return nil
+					}))
+
+					mu.Lock()
+					taken[nonce] = true
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		So(taken, ShouldHaveLength, 40)
+
+		store, err := OpenStore(path)
+		So(err, ShouldBeNil)
+		nonces := new(accountNonces)
+		ok, err := store.Get(nonceBucket, "1/0x01", nonces)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(nonces.Next, ShouldEqual, 40)
+	})
+
+	Convey("the handler sends with the nonces of the manager", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender := handlers[0]
+		receiver := crypto.PubkeyToAddress(receiverKey.PublicKey)
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		//the node is asked for the nonce once per transaction
+		counter := &nonceCounter{ethClient: simClient{sim}}
+		sender.Config.client = counter
+		tx, err := sender.NewContract(ctx, receiver, 1000, NewSecretHashPair().Hash, timeLock)
+		sender.Config.client = simClient{sim}
+		So(err, ShouldBeNil)
+		So(tx.Nonce(), ShouldEqual, 1)
+		So(counter.calls, ShouldEqual, 1)
+
+		nonces := new(accountNonces)
+		_, err = sender.store.Get(nonceBucket, sender.nonceKey(), nonces)
+		So(err, ShouldBeNil)
+		So(nonces.Next, ShouldEqual, 2)
+		So(nonces.InFlight[1].TxID, ShouldEqual, tx.Hash().Hex())
+
+		//a transaction that is not sent frees its nonce
+		key := sender.Config.key
+		sender.Config.key = nil
+		_, err = sender.NewContract(ctx, receiver, 1000, NewSecretHashPair().Hash, timeLock)
+		sender.Config.key = key
+		So(err, ShouldNotBeNil)
+		_, err = sender.store.Get(nonceBucket, sender.nonceKey(), nonces)
+		So(err, ShouldBeNil)
+		So(nonces.Next, ShouldEqual, 2)
+	})
+}
+
+// nonceCounter counts the pending nonces asked to the node.
+type nonceCounter struct {
+	ethClient
+	calls int
+}
+
+func (c *nonceCounter) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	c.calls++
+	return c.ethClient.PendingNonceAt(ctx, account)
+}
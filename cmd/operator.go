@@ -0,0 +1,125 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// OperatorHandler returns a handler on the connected chain that sends from
+// the "operator" account of the config, unlocked with privateKey or from the
+// keystore. HashedTimelockV2 lets it redeem and refund the contracts of any
+// owner, the funds still going to the receiver or back to the sender.
+func (h *Handler) OperatorHandler(privateKey string) (*Handler, error) {
+	if h.Config.Chain == nil {
+		return nil, errors.New("not connected")
+	}
+
+	if !h.Config.Chain.IsV2() {
+		return nil, errors.Errorf("contract %v is not a HashedTimelockV2, only its owners can redeem and refund", h.Config.Chain.Contract)
+	}
+
+	if err := h.Config.ValidateAddress(h.Config.Operator); err != nil {
+		return nil, errors.Wrap(err, "operator")
+	}
+
+	cfg := *h.Config
+	cfg.Account, cfg.key, cfg.ks = cfg.Operator, nil, nil
+
+	if err := cfg.Unlock(privateKey); err != nil {
+		return nil, err
+	}
+
+	return &Handler{ConfigPath: h.ConfigPath, Config: &cfg, store: h.store}, nil
+}
+
+// RedeemFor withdraws contractId to its receiver, whoever h sends from. It
+// checks the secret first so that the sender does not pay for a revert.
+func (h *Handler) RedeemFor(ctx context.Context, contractId common.Hash, secret common.Hash) (Transaction, error) {
+	details, err := h.checkRedeem(ctx, contractId, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Redeem %v to %v from %v", contractId.Hex(), details.Receiver.String(), h.Config.Account)
+
+	return h.Redeem(ctx, contractId, secret)
+}
+
+// RefundFor refunds contractId to its sender, whoever h sends from.
+func (h *Handler) RefundFor(ctx context.Context, contractId common.Hash) (Transaction, error) {
+	details, err := h.openContract(ctx, contractId)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := h.Config.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get head")
+	}
+	if head.Time < details.Timelock.Uint64() {
+		return nil, errors.Errorf("contract %v is locked until %v", contractId.Hex(), details.Timelock)
+	}
+
+	log.Printf("Refund %v to %v from %v", contractId.Hex(), details.Sender.String(), h.Config.Account)
+
+	return h.Refund(ctx, contractId)
+}
+
+// checkRedeem checks that secret withdraws contractId now.
+func (h *Handler) checkRedeem(ctx context.Context, contractId common.Hash, secret common.Hash) (*ContractDetails, error) {
+	details, err := h.openContract(ctx, contractId)
+	if err != nil {
+		return nil, err
+	}
+
+	if sha256.Sum256(secret[:]) != details.Hashlock {
+		return nil, errors.Errorf("secret does not match the hashlock of %v", contractId.Hex())
+	}
+
+	head, err := h.Config.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get head")
+	}
+	if head.Time >= details.Timelock.Uint64() {
+		return nil, errors.Errorf("contract %v expired at %v", contractId.Hex(), details.Timelock)
+	}
+
+	return details, nil
+}
+
+// openContract audits contractId and checks that it is neither withdrawn nor
+// refunded.
+func (h *Handler) openContract(ctx context.Context, contractId common.Hash) (*ContractDetails, error) {
+	details := new(ContractDetails)
+	if err := h.AuditContract(ctx, details, contractId); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case details.Sender == (common.Address{}):
+		return nil, errors.Errorf("contractId %v does not exist", contractId.Hex())
+	case details.Withdrawn:
+		return nil, errors.Errorf("contract %v is already withdrawn", contractId.Hex())
+	case details.Refunded:
+		return nil, errors.Errorf("contract %v is already refunded", contractId.Hex())
+	}
+
+	return details, nil
+}
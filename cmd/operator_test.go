@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOperatorHandler(t *testing.T) {
+	ownerKey, _ := crypto.GenerateKey()
+	operatorKey, _ := crypto.GenerateKey()
+	operatorHex := hexutil.Encode(crypto.FromECDSA(operatorKey))[2:]
+
+	newHandler := func(version int) *Handler {
+		return &Handler{Config: &Config{
+			Account:  crypto.PubkeyToAddress(ownerKey.PublicKey).String(),
+			Operator: crypto.PubkeyToAddress(operatorKey.PublicKey).String(),
+			Chain:    &chain{Contract: "0x00000000000000000000000000000000000000c1", Version: version},
+			key:      ownerKey,
+		}}
+	}
+
+	Convey("the operator sends from its own account", t, func() {
+		h := newHandler(HTLCVersion2)
+
+		op, err := h.OperatorHandler(operatorHex)
+		So(err, ShouldBeNil)
+		So(op.Config.Account, ShouldEqual, h.Config.Operator)
+		So(op.Config.key.D, ShouldResemble, operatorKey.D)
+		//the owner handler is untouched
+		So(h.Config.key, ShouldEqual, ownerKey)
+	})
+
+	Convey("refuse a HashedTimelock chain, a missing operator or a wrong key", t, func() {
+		_, err := newHandler(HTLCVersion1).OperatorHandler(operatorHex)
+		So(err, ShouldNotBeNil)
+
+		h := newHandler(HTLCVersion2)
+		h.Config.Operator = ""
+		_, err = h.OperatorHandler(operatorHex)
+		So(err, ShouldNotBeNil)
+
+		_, err = newHandler(HTLCVersion2).OperatorHandler(hexutil.Encode(crypto.FromECDSA(ownerKey))[2:])
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRedeemRefundFor(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey)
+	defer sim.Close()
+
+	var (
+		ctx      = context.Background()
+		hashPair = testHashPair()
+		timeLock = big.NewInt(time.Now().Unix() + 3600)
+	)
+
+	sender, err := hs[0].Backend()
+	TMust(t, err)
+
+	contractId, _, err := sender.Lock(ctx, hs[1].Config.Account, big.NewInt(1000), hashPair.Hash, timeLock)
+	TMust(t, err)
+	sim.Commit()
+
+	id := common.HexToHash(contractId)
+
+	//the HashedTimelock of the simulated chain still checks the caller, so
+	//the owners call in place of the operator, TestRedeemRefundForV2 has a
+	//third-party operator
+	Convey("check the contract before sending", t, func() {
+		_, err := hs[1].RedeemFor(ctx, id, common.HexToHash("0x01"))
+		So(err, ShouldNotBeNil)
+
+		_, err = hs[0].RefundFor(ctx, id)
+		So(err, ShouldNotBeNil)
+
+		_, err = hs[1].RedeemFor(ctx, common.HexToHash("0x01"), hashPair.Secret)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("redeem, then refuse to act on the closed contract", t, func() {
+		_, err := hs[1].RedeemFor(ctx, id, hashPair.Secret)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		_, err = hs[1].RedeemFor(ctx, id, hashPair.Secret)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRedeemRefundForV2(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	senderKey, _ := crypto.GenerateKey()
+	receiverKey, _ := crypto.GenerateKey()
+	operatorKey, _ := crypto.GenerateKey()
+	sim, hs := testSimHandlers(t, senderKey, receiverKey, operatorKey)
+	defer sim.Close()
+	testDeployV2(t, sim, hs)
+
+	var (
+		ctx      = context.Background()
+		hashPair = testHashPair()
+		timeLock = big.NewInt(time.Now().Unix() + 3600)
+		sender   = common.HexToAddress(hs[0].Config.Account)
+		receiver = common.HexToAddress(hs[1].Config.Account)
+	)
+
+	//the operator is a third party, neither sender nor receiver
+	hs[0].Config.Operator = hs[2].Config.Account
+	op, err := hs[0].OperatorHandler(hexutil.Encode(crypto.FromECDSA(operatorKey))[2:])
+	TMust(t, err)
+
+	backend, err := hs[0].Backend()
+	TMust(t, err)
+
+	balanceOf := func(account common.Address) *big.Int {
+		balance, err := sim.BalanceAt(ctx, account, nil)
+		TMust(t, err)
+		return balance
+	}
+
+	Convey("the operator redeems to the receiver", t, func() {
+		contractId, _, err := backend.Lock(ctx, receiver.String(), big.NewInt(1000), hashPair.Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+		id := common.HexToHash(contractId)
+
+		_, err = op.RedeemFor(ctx, id, common.HexToHash("0x01"))
+		So(err, ShouldNotBeNil)
+
+		before := balanceOf(receiver)
+		_, err = op.RedeemFor(ctx, id, hashPair.Secret)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		//the receiver gets the amount without paying any gas
+		So(new(big.Int).Sub(balanceOf(receiver), before).Int64(), ShouldEqual, 1000)
+
+		_, err = op.RedeemFor(ctx, id, hashPair.Secret)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("the operator refunds to the sender after the timelock", t, func() {
+		contractId, _, err := backend.Lock(ctx, receiver.String(), big.NewInt(1000), testHashPair().Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+		id := common.HexToHash(contractId)
+
+		_, err = op.RefundFor(ctx, id)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "is locked until")
+
+		So(sim.AdjustTime(2*time.Hour), ShouldBeNil)
+		sim.Commit()
+
+		before := balanceOf(sender)
+		_, err = op.RefundFor(ctx, id)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		So(new(big.Int).Sub(balanceOf(sender), before).Int64(), ShouldEqual, 1000)
+
+		_, err = op.RefundFor(ctx, id)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,359 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/pkg/errors"
+)
+
+// storage slot of the contracts mapping of the HashedTimelock contracts
+const contractsSlot = 0
+
+// storage slots of the fields of a LockContract, from the slot of the
+// contract in the mapping
+const (
+	slotSender = iota
+	slotReceiver
+	slotAmount
+	slotHashlock
+	slotTimelock
+	//withdrawn in the lowest byte, refunded in the next one
+	slotFlags
+	slotPreimage
+	lockContractSlots
+)
+
+// a checkpoint more blocks than this behind the head is refused, the
+// headers in between are checked one by one
+const maxCheckpointDepth = 256
+
+// ProvenBlock is the block a verified audit proves the contract at.
+type ProvenBlock struct {
+	Number *big.Int    `json:"number"`
+	Hash   common.Hash `json:"hash"`
+	Root   common.Hash `json:"stateRoot"`
+
+	parent common.Hash
+}
+
+// rpcHeader is a block header as eth_getBlockByNumber answers it, with the
+// fields of the forks the go-ethereum of aswap does not know.
+type rpcHeader struct {
+	ParentHash       common.Hash      `json:"parentHash"`
+	UncleHash        common.Hash      `json:"sha3Uncles"`
+	Coinbase         common.Address   `json:"miner"`
+	Root             common.Hash      `json:"stateRoot"`
+	TxHash           common.Hash      `json:"transactionsRoot"`
+	ReceiptHash      common.Hash      `json:"receiptsRoot"`
+	Bloom            types.Bloom      `json:"logsBloom"`
+	Difficulty       *hexutil.Big     `json:"difficulty"`
+	Number           *hexutil.Big     `json:"number"`
+	GasLimit         hexutil.Uint64   `json:"gasLimit"`
+	GasUsed          hexutil.Uint64   `json:"gasUsed"`
+	Time             hexutil.Uint64   `json:"timestamp"`
+	Extra            hexutil.Bytes    `json:"extraData"`
+	MixDigest        common.Hash      `json:"mixHash"`
+	Nonce            types.BlockNonce `json:"nonce"`
+	BaseFee          *hexutil.Big     `json:"baseFeePerGas"`
+	WithdrawalsRoot  *common.Hash     `json:"withdrawalsRoot"`
+	BlobGasUsed      *hexutil.Uint64  `json:"blobGasUsed"`
+	ExcessBlobGas    *hexutil.Uint64  `json:"excessBlobGas"`
+	ParentBeaconRoot *common.Hash     `json:"parentBeaconBlockRoot"`
+	RequestsHash     *common.Hash     `json:"requestsHash"`
+	Hash             common.Hash      `json:"hash"`
+}
+
+// hash returns the hash of the header, computed from its fields.
+func (hd *rpcHeader) hash() (common.Hash, error) {
+	if hd.Difficulty == nil || hd.Number == nil {
+		return common.Hash{}, errors.New("header without difficulty or number")
+	}
+
+	fields := []interface{}{
+		hd.ParentHash, hd.UncleHash, hd.Coinbase, hd.Root, hd.TxHash, hd.ReceiptHash, hd.Bloom,
+		hd.Difficulty.ToInt(), hd.Number.ToInt(), uint64(hd.GasLimit), uint64(hd.GasUsed), uint64(hd.Time),
+		[]byte(hd.Extra), hd.MixDigest, hd.Nonce,
+	}
+
+	//the fields of each fork follow those of the forks before
+	optional := []interface{}{}
+	if hd.BaseFee != nil {
+		optional = append(optional, hd.BaseFee.ToInt())
+	}
+	if hd.WithdrawalsRoot != nil {
+		optional = append(optional, *hd.WithdrawalsRoot)
+	}
+	if hd.BlobGasUsed != nil && hd.ExcessBlobGas != nil {
+		optional = append(optional, uint64(*hd.BlobGasUsed), uint64(*hd.ExcessBlobGas))
+	}
+	if hd.ParentBeaconRoot != nil {
+		optional = append(optional, *hd.ParentBeaconRoot)
+	}
+	if hd.RequestsHash != nil {
+		optional = append(optional, *hd.RequestsHash)
+	}
+
+	encoded, err := rlp.EncodeToBytes(append(fields, optional...))
+	if err != nil {
+		return common.Hash{}, errors.Wrap(err, "encode header")
+	}
+
+	return crypto.Keccak256Hash(encoded), nil
+}
+
+// fetchBlock gets the header of block, a block hash or number, and checks
+// that it hashes to the hash the node gives.
+func fetchBlock(ctx context.Context, client rpcClient, block interface{}) (*ProvenBlock, error) {
+	var (
+		hd  *rpcHeader
+		err error
+	)
+	switch block := block.(type) {
+	case common.Hash:
+		err = client.CallContext(ctx, &hd, "eth_getBlockByHash", block, false)
+	case *big.Int:
+		err = client.CallContext(ctx, &hd, "eth_getBlockByNumber", hexutil.EncodeBig(block), false)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "get block %v", block)
+	}
+	if hd == nil {
+		return nil, errors.Errorf("block %v not found", block)
+	}
+
+	hash, err := hd.hash()
+	if err != nil {
+		return nil, err
+	}
+	if hash != hd.Hash {
+		return nil, errors.Errorf("header of block %v hashes to %v, not to %v", hd.Number, hash.Hex(), hd.Hash.Hex())
+	}
+
+	return &ProvenBlock{Number: hd.Number.ToInt(), Hash: hash, Root: hd.Root, parent: hd.ParentHash}, nil
+}
+
+// trustedBlock returns the block to prove the contract at: the head block if
+// it descends from the checkpoint block when checkpoint is set, or else the
+// latest block a quorum of the endpoints agree on. The state of the
+// checkpoint itself would miss what happened to the contract since.
+func (c *Config) trustedBlock(ctx context.Context, checkpoint common.Hash) (*ProvenBlock, error) {
+	if checkpoint != (common.Hash{}) {
+		anchor, err := fetchBlock(ctx, c.rpc, checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		if anchor.Hash != checkpoint {
+			return nil, errors.Errorf("block %v is not the checkpoint %v", anchor.Hash.Hex(), checkpoint.Hex())
+		}
+		return c.descendant(ctx, anchor)
+	}
+
+	m, ok := c.client.(*multiClient)
+	if !ok || m.quorum < 2 {
+		return nil, errors.Errorf("no trusted block on %v: give a checkpoint block hash or set a quorum of rpc endpoints", c.Chain.Name)
+	}
+
+	number := m.pinned(ctx, nil)
+	block, err := m.quorumRead(ctx, "block "+number.String(), func(e *endpoint) (interface{}, error) {
+		return fetchBlock(ctx, e.rpc, number)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block.(*ProvenBlock), nil
+}
+
+// descendant returns the head block, checking that the headers link it back
+// to anchor.
+func (c *Config) descendant(ctx context.Context, anchor *ProvenBlock) (*ProvenBlock, error) {
+	head, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get head")
+	}
+
+	depth := new(big.Int).Sub(head.Number, anchor.Number)
+	switch {
+	case depth.Sign() <= 0:
+		return anchor, nil
+	case depth.Cmp(big.NewInt(maxCheckpointDepth)) > 0:
+		return nil, errors.Errorf("checkpoint %v is %v blocks behind the head, give one within %v blocks",
+			anchor.Number, depth, maxCheckpointDepth)
+	}
+
+	tip, err := fetchBlock(ctx, c.rpc, head.Number)
+	if err != nil {
+		return nil, err
+	}
+
+	block := tip
+	for block.Number.Cmp(anchor.Number) > 0 {
+		parent := anchor
+		if number := new(big.Int).Sub(block.Number, big.NewInt(1)); number.Cmp(anchor.Number) > 0 {
+			if parent, err = fetchBlock(ctx, c.rpc, number); err != nil {
+				return nil, err
+			}
+		}
+
+		if block.parent != parent.Hash {
+			return nil, errors.Errorf("block %v does not descend from the checkpoint %v", tip.Number, anchor.Hash.Hex())
+		}
+		block = parent
+	}
+
+	return tip, nil
+}
+
+// accountProof is the answer to eth_getProof.
+type accountProof struct {
+	AccountProof []hexutil.Bytes `json:"accountProof"`
+	StorageProof []struct {
+		Key   string          `json:"key"`
+		Proof []hexutil.Bytes `json:"proof"`
+	} `json:"storageProof"`
+}
+
+// provenAccount is an account in the state trie.
+type provenAccount struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash []byte
+}
+
+// verifyProof returns the value of key in the trie of root, nil if the
+// proof shows that key is not in the trie.
+func verifyProof(root common.Hash, key []byte, proof []hexutil.Bytes) ([]byte, error) {
+	db := memorydb.New()
+	for _, node := range proof {
+		if err := db.Put(crypto.Keccak256(node), node); err != nil {
+			return nil, err
+		}
+	}
+
+	value, _, err := trie.VerifyProof(root, crypto.Keccak256(key), db)
+
+	return value, err
+}
+
+// lockContractSlot returns the first storage slot of contractId in the
+// contracts mapping.
+func lockContractSlot(contractId common.Hash) *big.Int {
+	slot := crypto.Keccak256(contractId.Bytes(), common.BigToHash(big.NewInt(contractsSlot)).Bytes())
+
+	return new(big.Int).SetBytes(slot)
+}
+
+// VerifiedAudit is AuditContract without trusting the node: it proves the
+// code and the storage of contractId in the state of a trusted block with
+// the Merkle proofs of eth_getProof, the head block descending from the
+// checkpoint block hash if it is set, or else one a quorum of the rpc
+// endpoints agree on.
+func (h *Handler) VerifiedAudit(ctx context.Context, contractId common.Hash, checkpoint common.Hash) (*ContractDetails, *ProvenBlock, error) {
+	if h.Config.rpc == nil {
+		return nil, nil, errors.Errorf("no JSON-RPC client for %v", h.Config.Chain.Name)
+	}
+
+	bin, name := h.htlcBytecode()
+	if bin == "" {
+		return nil, nil, errNotCompiled(name)
+	}
+
+	block, err := h.Config.trustedBlock(ctx, checkpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contract := common.HexToAddress(h.Config.Chain.Contract)
+
+	base := lockContractSlot(contractId)
+	keys := make([]string, lockContractSlots)
+	for i := range keys {
+		keys[i] = common.BigToHash(new(big.Int).Add(base, big.NewInt(int64(i)))).Hex()
+	}
+
+	var proof accountProof
+	if err := h.Config.rpc.CallContext(ctx, &proof, "eth_getProof", contract, keys, hexutil.EncodeBig(block.Number)); err != nil {
+		return nil, nil, errors.Wrap(err, "eth_getProof")
+	}
+
+	value, err := verifyProof(block.Root, contract.Bytes(), proof.AccountProof)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "account proof of %v", contract.String())
+	}
+	if value == nil {
+		return nil, nil, errors.Errorf("no contract at %v in block %v", contract.String(), block.Number)
+	}
+
+	account := new(provenAccount)
+	if err := rlp.DecodeBytes(value, account); err != nil {
+		return nil, nil, errors.Wrapf(err, "decode account %v", contract.String())
+	}
+
+	//the node gives the code, the proof its hash; the HashedTimelock cannot
+	//change its code, any block has it
+	code, err := h.Config.client.CodeAt(ctx, contract, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "call CodeAt")
+	}
+	if !bytes.Equal(crypto.Keccak256(code), account.CodeHash) {
+		return nil, nil, errors.Errorf("code of %v does not match its proven hash", contract.String())
+	}
+	if err := h.checkBytecode(contract, code, bin, name); err != nil {
+		return nil, nil, err
+	}
+
+	if len(proof.StorageProof) != len(keys) {
+		return nil, nil, errors.Errorf("%v storage proofs for %v slots", len(proof.StorageProof), len(keys))
+	}
+
+	words := make([]common.Hash, len(keys))
+	for i, key := range keys {
+		value, err := verifyProof(account.Root, common.HexToHash(key).Bytes(), proof.StorageProof[i].Proof)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "storage proof of slot %v", key)
+		}
+		if value == nil {
+			continue
+		}
+
+		var content []byte
+		if err := rlp.DecodeBytes(value, &content); err != nil {
+			return nil, nil, errors.Wrapf(err, "decode slot %v", key)
+		}
+		words[i] = common.BytesToHash(content)
+	}
+
+	return &ContractDetails{
+		Sender:    common.BytesToAddress(words[slotSender].Bytes()),
+		Receiver:  common.BytesToAddress(words[slotReceiver].Bytes()),
+		Amount:    words[slotAmount].Big(),
+		Hashlock:  words[slotHashlock],
+		Timelock:  words[slotTimelock].Big(),
+		Withdrawn: words[slotFlags][common.HashLength-1] != 0,
+		Refunded:  words[slotFlags][common.HashLength-2] != 0,
+		Preimage:  words[slotPreimage],
+	}, block, nil
+}
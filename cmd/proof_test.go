@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// proofRPC answers the blocks and the proofs of a simulated chain, lying
+// about the state root if root is set, and the blocks by hash from another
+// chain if fork is set.
+type proofRPC struct {
+	sim  *backends.SimulatedBackend
+	root *common.Hash
+	fork *backends.SimulatedBackend
+}
+
+func (r *proofRPC) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	chain := r.sim.Blockchain()
+
+	var answer interface{}
+	switch method {
+	case "eth_getBlockByHash", "eth_getBlockByNumber":
+		var header *types.Header
+		if hash, ok := args[0].(common.Hash); ok && r.fork != nil {
+			header = r.fork.Blockchain().GetHeaderByHash(hash)
+		} else if ok {
+			header = chain.GetHeaderByHash(hash)
+		} else {
+			header = chain.GetHeaderByNumber(hexutil.MustDecodeBig(args[0].(string)).Uint64())
+		}
+		if header != nil && r.root != nil {
+			encoded, _ := json.Marshal(header)
+			var fields map[string]interface{}
+			_ = json.Unmarshal(encoded, &fields)
+			fields["stateRoot"] = r.root.Hex()
+			answer = fields
+		} else {
+			answer = header
+		}
+	case "eth_getProof":
+		address := args[0].(common.Address)
+		header := chain.GetHeaderByNumber(hexutil.MustDecodeBig(args[2].(string)).Uint64())
+		state, err := chain.StateAt(header.Root)
+		if err != nil {
+			return err
+		}
+
+		accountProof, err := state.GetProof(address)
+		if err != nil {
+			return err
+		}
+
+		type storageResult struct {
+			Key   string   `json:"key"`
+			Proof []string `json:"proof"`
+		}
+		var storage []storageResult
+		for _, key := range args[1].([]string) {
+			proof, err := state.GetStorageProof(address, common.HexToHash(key))
+			if err != nil {
+				return err
+			}
+			storage = append(storage, storageResult{key, common.ToHexArray(proof)})
+		}
+
+		answer = map[string]interface{}{
+			"accountProof": common.ToHexArray(accountProof),
+			"storageProof": storage,
+		}
+	default:
+		return errors.Errorf("unexpected %v", method)
+	}
+
+	encoded, err := json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, result)
+}
+
+func TestVerifiedAudit(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("the header hash covers the fields of the later forks", t, func() {
+		//a London header hashed by go-ethereum v1.10
+		encoded := `{"parentHash":"0x0000000000000000000000000000000000000000000000000000000000000001","sha3Uncles":"0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347","miner":"0x00000000000000000000000000000000000000c1","stateRoot":"0x0000000000000000000000000000000000000000000000000000000000000002","transactionsRoot":"0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421","receiptsRoot":"0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421","logsBloom":"0x` + strings.Repeat("0", 512) + `","difficulty":"0x0","number":"0xed14f2","gasLimit":"0x1c9c380","gasUsed":"0x5208","timestamp":"0x6322c973","extraData":"0x6173776170","mixHash":"0x0000000000000000000000000000000000000000000000000000000000000003","nonce":"0x0000000000000000","baseFeePerGas":"0x1a13b8600","hash":"0xc8ed0d92c761950c32dee6fb48fd0407cf8d4ead0d807afd66d1de8767e0bdfd"}`
+
+		hd := new(rpcHeader)
+		So(json.Unmarshal([]byte(encoded), hd), ShouldBeNil)
+		hash, err := hd.hash()
+		So(err, ShouldBeNil)
+		So(hash, ShouldEqual, hd.Hash)
+
+		hd.BaseFee = nil
+		hash, err = hd.hash()
+		So(err, ShouldBeNil)
+		So(hash, ShouldNotEqual, hd.Hash)
+	})
+
+	Convey("the contract is audited from proven storage", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender, receiver := handlers[0], handlers[1]
+		rpc := &proofRPC{sim: sim}
+		receiver.Config.rpc = rpc
+
+		pair := NewSecretHashPair()
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+		tx, err := sender.NewContract(ctx, crypto.PubkeyToAddress(receiverKey.PublicKey), 1000, pair.Hash, timeLock)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		event, err := sender.GetContractId(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+
+		//proven at the head
+		audit := func(checkpoint common.Hash) (*ContractDetails, error) {
+			details, block, err := receiver.VerifiedAudit(ctx, event.ContractId, checkpoint)
+			if err == nil {
+				So(block.Hash, ShouldEqual, sim.Blockchain().CurrentHeader().Hash())
+			}
+			return details, err
+		}
+
+		head := sim.Blockchain().CurrentHeader().Hash()
+		details, err := audit(head)
+		So(err, ShouldBeNil)
+
+		expected := new(ContractDetails)
+		So(receiver.AuditContract(ctx, expected, event.ContractId), ShouldBeNil)
+		So(details, ShouldResemble, expected)
+		So(details.Amount.Int64(), ShouldEqual, 1000)
+		So(details.Withdrawn, ShouldBeFalse)
+
+		_, err = receiver.Redeem(ctx, event.ContractId, pair.Secret)
+		So(err, ShouldBeNil)
+		sim.Commit()
+
+		details, err = audit(sim.Blockchain().CurrentHeader().Hash())
+		So(err, ShouldBeNil)
+		So(details.Withdrawn, ShouldBeTrue)
+		So(details.Refunded, ShouldBeFalse)
+		So(details.Preimage, ShouldEqual, pair.Secret)
+
+		//the head descends from an older checkpoint, and shows the redeem
+		details, err = audit(head)
+		So(err, ShouldBeNil)
+		So(details.Withdrawn, ShouldBeTrue)
+
+		//a contract that does not exist
+		missing, _, err := receiver.VerifiedAudit(ctx, common.HexToHash("0x01"), head)
+		So(err, ShouldBeNil)
+		So(missing.Sender, ShouldEqual, common.Address{})
+
+		//a block that is not the checkpoint
+		_, err = audit(common.HexToHash("0x01"))
+		So(err, ShouldNotBeNil)
+
+		//a node lying about the state root
+		root := common.HexToHash("0x02")
+		rpc.root = &root
+		_, err = audit(head)
+		So(err, ShouldNotBeNil)
+		rpc.root = nil
+
+		//a checkpoint on another chain
+		other, _ := testSimHandlers(t, receiverKey)
+		defer other.Close()
+		rpc.fork = other
+		_, err = audit(other.Blockchain().CurrentHeader().Hash())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "does not descend")
+		rpc.fork = nil
+
+		//a checkpoint too far behind the head
+		old := sim.Blockchain().CurrentHeader().Hash()
+		for i := 0; i <= maxCheckpointDepth; i++ {
+			sim.Commit()
+		}
+		_, err = audit(old)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "blocks behind the head")
+
+		//no checkpoint and a single endpoint
+		_, _, err = receiver.VerifiedAudit(ctx, event.ContractId, common.Hash{})
+		So(err, ShouldNotBeNil)
+
+		//the latest block two endpoints agree on
+		m := &multiClient{quorum: 2, maxLag: defaultMaxLag}
+		for i := 0; i < 2; i++ {
+			m.endpoints = append(m.endpoints, &endpoint{url: "sim", client: simClient{sim}, rpc: rpc, healthy: true})
+		}
+		receiver.Config.client, receiver.Config.rpc = m, m
+
+		details, block, err := receiver.VerifiedAudit(ctx, event.ContractId, common.Hash{})
+		So(err, ShouldBeNil)
+		So(details.Withdrawn, ShouldBeTrue)
+		So(block.Hash, ShouldEqual, sim.Blockchain().CurrentHeader().Hash())
+	})
+}
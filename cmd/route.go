@@ -0,0 +1,278 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// RouteHop is one HTLC of a multi-hop route, paying Amount to Receiver on
+// the chain of its backends.
+type RouteHop struct {
+	//locks the HTLC as its sender, nil if the sender is not ours
+	From ChainBackend
+	//redeems the HTLC as its receiver, nil if the receiver is not ours
+	To ChainBackend
+	//the account locking the HTLC, the receiver of the previous hop if empty
+	Sender   string
+	Receiver string
+	Amount   *big.Int
+	TimeLock *big.Int
+	//set once the HTLC is locked, or by the caller for a hop locked by a counterparty
+	ContractID string
+	TxID       string
+}
+
+func (hop *RouteHop) backend() ChainBackend {
+	if hop.From != nil {
+		return hop.From
+	}
+	return hop.To
+}
+
+// Route chains HTLCs with one hashlock: hop i pays the sender of hop i+1, and
+// the final receiver knows the secret. Timelocks strictly decrease along the
+// route, so once the last hop is redeemed every earlier receiver has time to
+// redeem its incoming hop with the revealed secret.
+//
+// Route is a library API: aswap connects to two chains only, which the
+// initiate and participate commands already cover.
+type Route struct {
+	HashLock [32]byte
+	Hops     []*RouteHop
+	//confirmations of each hop to wait for before locking the next
+	Confirmations uint64
+}
+
+// PlanRoute sets the timelocks of hops so that the last one expires at
+// finalTimeLock and every earlier one delta seconds after its successor.
+func PlanRoute(hashLock [32]byte, hops []*RouteHop, finalTimeLock *big.Int, delta time.Duration) (*Route, error) {
+	if len(hops) == 0 {
+		return nil, errors.New("empty route")
+	}
+
+	if delta < time.Minute {
+		return nil, errors.Errorf("timelock delta %v too short", delta)
+	}
+
+	for i, hop := range hops {
+		if hop.From == nil && hop.To == nil {
+			return nil, errors.Errorf("hop %d has no backend", i)
+		}
+
+		if hop.Receiver == "" {
+			return nil, errors.Errorf("hop %d has no receiver", i)
+		}
+
+		if hop.Sender == "" && i == 0 {
+			return nil, errors.New("hop 0 has no sender")
+		}
+		if hop.Sender == "" {
+			hop.Sender = hops[i-1].Receiver
+		}
+
+		if hop.Amount == nil || hop.Amount.Sign() <= 0 {
+			return nil, errors.Errorf("hop %d has invalid amount %v", i, hop.Amount)
+		}
+
+		steps := int64(len(hops) - 1 - i)
+		hop.TimeLock = new(big.Int).Add(finalTimeLock, big.NewInt(steps*int64(delta/time.Second)))
+	}
+
+	return &Route{
+		HashLock:      hashLock,
+		Hops:          hops,
+		Confirmations: 1,
+	}, nil
+}
+
+// AuditHop checks that the HTLC of hop i is on chain with the planned terms.
+func (r *Route) AuditHop(ctx context.Context, i int) error {
+	hop := r.Hops[i]
+	if hop.ContractID == "" {
+		return errors.Errorf("hop %d is not locked", i)
+	}
+
+	c, err := hop.backend().Audit(ctx, hop.ContractID)
+	if err != nil {
+		return errors.Wrapf(err, "audit hop %d", i)
+	}
+
+	switch {
+	case !strings.EqualFold(c.Sender, hop.Sender):
+		return errors.Errorf("hop %d is locked by %v, expect %v", i, c.Sender, hop.Sender)
+	case !strings.EqualFold(c.Receiver, hop.Receiver):
+		return errors.Errorf("hop %d pays %v, expect %v", i, c.Receiver, hop.Receiver)
+	case c.Amount.Cmp(hop.Amount) < 0:
+		return errors.Errorf("hop %d locks %v, expect %v", i, c.Amount, hop.Amount)
+	case c.Hashlock != r.HashLock:
+		return errors.Errorf("hop %d has hashlock %v, expect %v", i, hexutil.Encode(c.Hashlock[:]), hexutil.Encode(r.HashLock[:]))
+	case c.Timelock.Cmp(hop.TimeLock) < 0:
+		return errors.Errorf("hop %d expires at %v, expect %v", i, c.Timelock, hop.TimeLock)
+	case c.Withdrawn || c.Refunded:
+		return errors.Errorf("hop %d is already closed", i)
+	}
+
+	return nil
+}
+
+// LockHop locks the HTLC of hop i after auditing the incoming hop i-1.
+func (r *Route) LockHop(ctx context.Context, i int) error {
+	hop := r.Hops[i]
+	if hop.From == nil {
+		return errors.Errorf("hop %d is not ours to lock", i)
+	}
+
+	if hop.ContractID != "" {
+		return errors.Errorf("hop %d is already locked: %v", i, hop.ContractID)
+	}
+
+	if i > 0 {
+		if err := r.AuditHop(ctx, i-1); err != nil {
+			return err
+		}
+	}
+
+	contractId, txid, err := hop.From.Lock(ctx, hop.Receiver, hop.Amount, r.HashLock, hop.TimeLock)
+	if err != nil {
+		return errors.Wrapf(err, "lock hop %d", i)
+	}
+
+	hop.ContractID, hop.TxID = contractId, txid
+	log.Printf("hop %d locked, ContractId = %s, txid = %s", i, contractId, txid)
+
+	return nil
+}
+
+// Execute locks every hop of ours in route order, waiting for each to be
+// confirmed before locking the next. Hops of counterparties must have their
+// ContractID set before Execute reaches them.
+func (r *Route) Execute(ctx context.Context) error {
+	for i, hop := range r.Hops {
+		if hop.From == nil {
+			if hop.ContractID == "" {
+				return errors.Errorf("hop %d is not locked by its sender yet", i)
+			}
+			continue
+		}
+
+		if hop.ContractID == "" {
+			if err := r.LockHop(ctx, i); err != nil {
+				return err
+			}
+		}
+
+		if err := r.waitConfirmed(ctx, i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Route) waitConfirmed(ctx context.Context, i int) error {
+	hop := r.Hops[i]
+
+	for {
+		confirmations, err := hop.From.Confirmations(ctx, hop.TxID)
+		if err != nil {
+			return err
+		}
+
+		if confirmations >= r.Confirmations {
+			return nil
+		}
+
+		select {
+		case <-time.After(watchInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Settle waits for the last hop to be redeemed, then redeems each earlier
+// hop of ours with the revealed secret, from the end of the route back to
+// its start.
+func (r *Route) Settle(ctx context.Context) ([32]byte, error) {
+	last := len(r.Hops) - 1
+
+	secret, err := r.waitSecret(ctx, last)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	for i := last - 1; i >= 0; i-- {
+		hop := r.Hops[i]
+		if hop.To == nil {
+			continue
+		}
+
+		c, err := hop.To.Audit(ctx, hop.ContractID)
+		if err != nil {
+			return secret, errors.Wrapf(err, "audit hop %d", i)
+		}
+		if c.Withdrawn {
+			continue
+		}
+
+		txid, err := hop.To.Redeem(ctx, hop.ContractID, secret)
+		if err != nil {
+			return secret, errors.Wrapf(err, "redeem hop %d", i)
+		}
+
+		log.Printf("hop %d redeemed, txid = %s", i, txid)
+	}
+
+	return secret, nil
+}
+
+func (r *Route) waitSecret(ctx context.Context, i int) ([32]byte, error) {
+	hop := r.Hops[i]
+
+	//WatchEvents returns once it sent the redeem or refund, the events are
+	//read as they come so it never blocks on a full sink
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan *SwapEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- hop.backend().WatchEvents(ctx, hop.ContractID, events)
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			switch event.Type {
+			case EventWithdraw:
+				return event.Secret, nil
+			case EventRefund:
+				return [32]byte{}, errors.Errorf("hop %d is refunded", i)
+			}
+		case err := <-done:
+			if err != nil {
+				return [32]byte{}, err
+			}
+			return [32]byte{}, errors.Errorf("hop %d is not redeemed", i)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRoute(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	watchInterval = 10 * time.Millisecond
+
+	//A pays B on chain 0, B pays C on chain 1, C pays D on chain 2
+	var parties []*ecdsa.PrivateKey
+	for i := 0; i < 4; i++ {
+		key, _ := crypto.GenerateKey()
+		parties = append(parties, key)
+	}
+
+	var (
+		ctx      = context.Background()
+		hashPair = testHashPair()
+		sims     []*backends.SimulatedBackend
+		hops     []*RouteHop
+	)
+
+	for i := 0; i < 3; i++ {
+		sim, hs := testSimHandlers(t, parties[i], parties[i+1])
+		defer sim.Close()
+
+		from, err := hs[0].Backend()
+		TMust(t, err)
+		to, err := hs[1].Backend()
+		TMust(t, err)
+
+		sims = append(sims, sim)
+		hops = append(hops, &RouteHop{
+			From:     from,
+			To:       to,
+			Sender:   hs[0].Config.Account,
+			Receiver: hs[1].Config.Account,
+			Amount:   big.NewInt(int64(1000 - i)),
+		})
+	}
+
+	commit := func() {
+		for _, sim := range sims {
+			sim.Commit()
+		}
+	}
+
+	var route *Route
+
+	Convey("route A->B->C->D over three simulated chains", t, func() {
+		Convey("[1] plan strictly decreasing timelocks", func() {
+			_, err := PlanRoute(hashPair.Hash, hops, big.NewInt(time.Now().Unix()), time.Second)
+			So(err, ShouldNotBeNil)
+
+			unsent := []*RouteHop{{From: hops[0].From, Receiver: hops[0].Receiver, Amount: hops[0].Amount},
+				{From: hops[1].From, Receiver: hops[1].Receiver, Amount: hops[1].Amount}}
+			_, err = PlanRoute(hashPair.Hash, unsent, big.NewInt(time.Now().Unix()), time.Hour)
+			So(err, ShouldNotBeNil)
+
+			unsent[0].Sender = hops[0].Sender
+			_, err = PlanRoute(hashPair.Hash, unsent, big.NewInt(time.Now().Unix()), time.Hour)
+			So(err, ShouldBeNil)
+			So(unsent[1].Sender, ShouldEqual, hops[0].Receiver)
+
+			final := big.NewInt(time.Now().Unix() + 3600)
+			route, err = PlanRoute(hashPair.Hash, hops, final, time.Hour)
+			So(err, ShouldBeNil)
+			So(hops[2].TimeLock, ShouldResemble, final)
+			So(hops[1].TimeLock.Int64(), ShouldEqual, final.Int64()+3600)
+			So(hops[0].TimeLock.Int64(), ShouldEqual, final.Int64()+7200)
+		})
+
+		Convey("[2] a hop is not locked before the incoming one is confirmed", func() {
+			So(route.LockHop(ctx, 0), ShouldBeNil)
+			So(route.LockHop(ctx, 1), ShouldNotBeNil)
+			So(hops[1].ContractID, ShouldBeEmpty)
+		})
+
+		Convey("[3] execute locks the remaining hops", func() {
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				for {
+					select {
+					case <-time.After(5 * time.Millisecond):
+						commit()
+					case <-done:
+						return
+					}
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			So(route.Execute(ctx), ShouldBeNil)
+
+			for i := range hops {
+				So(route.AuditHop(ctx, i), ShouldBeNil)
+			}
+
+			//hop 1 must be locked by the receiver of hop 0
+			sender := hops[1].Sender
+			hops[1].Sender = hops[2].Receiver
+			So(route.AuditHop(ctx, 1), ShouldNotBeNil)
+			hops[1].Sender = sender
+		})
+
+		Convey("[4] D redeems the last hop and the secret propagates back", func() {
+			_, err := hops[2].To.Redeem(ctx, hops[2].ContractID, hashPair.Secret)
+			So(err, ShouldBeNil)
+			commit()
+
+			secret, err := route.Settle(ctx)
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, hashPair.Secret)
+			commit()
+
+			for _, hop := range hops {
+				c, err := hop.From.Audit(ctx, hop.ContractID)
+				So(err, ShouldBeNil)
+				So(c.Withdrawn, ShouldBeTrue)
+			}
+		})
+	})
+}
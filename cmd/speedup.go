@@ -0,0 +1,208 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+)
+
+// the fee bump in percent of a replacement transaction, above the 10% the
+// txpool of geth requires
+const feeBump = 12
+
+// pendingTx is a transaction of the account waiting in the mempool.
+type pendingTx struct {
+	from  common.Address
+	nonce uint64
+	to    *common.Address
+	value *big.Int
+	data  []byte
+	gas   uint64
+	//max fee per gas of a dynamic fee transaction
+	gasPrice *big.Int
+	//nil for a legacy transaction
+	gasTipCap *big.Int
+	mined     bool
+}
+
+// rpcTx is a transaction as eth_getTransactionByHash answers it, of any type.
+type rpcTx struct {
+	From                 common.Address  `json:"from"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	To                   *common.Address `json:"to"`
+	Value                *hexutil.Big    `json:"value"`
+	Input                hexutil.Bytes   `json:"input"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	BlockHash            *common.Hash    `json:"blockHash"`
+}
+
+// SpeedUp resends the pending transaction txid of the account with the same
+// nonce and a fee feeBump percent higher, or the current one of the gas
+// policy if that is higher still.
+func (h *Handler) SpeedUp(ctx context.Context, txid common.Hash) (Transaction, error) {
+	pending, err := h.pendingTx(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Speed up %v, nonce = %v", txid.Hex(), pending.nonce)
+
+	return h.replace(ctx, pending, pending.to, pending.value, pending.data, pending.gas)
+}
+
+// CancelTx replaces the pending transaction txid of the account with a
+// zero-value transfer to itself at a higher fee.
+func (h *Handler) CancelTx(ctx context.Context, txid common.Hash) (Transaction, error) {
+	pending, err := h.pendingTx(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Cancel %v, nonce = %v", txid.Hex(), pending.nonce)
+
+	self := common.HexToAddress(h.Config.Account)
+
+	return h.replace(ctx, pending, &self, new(big.Int), nil, params.TxGas)
+}
+
+// pendingTx looks up txid, a pending transaction of the account.
+func (h *Handler) pendingTx(ctx context.Context, txid common.Hash) (*pendingTx, error) {
+	pending, err := h.lookupTx(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case pending.mined:
+		return nil, errors.Errorf("tx %v is already mined", txid.Hex())
+	case pending.from != common.HexToAddress(h.Config.Account):
+		return nil, errors.Errorf("tx %v is sent by %v, not by %v", txid.Hex(), pending.from.String(), h.Config.Account)
+	}
+
+	return pending, nil
+}
+
+// lookupTx looks txid up, through the raw JSON-RPC of the node if it has
+// one, as the client only decodes legacy transactions.
+func (h *Handler) lookupTx(ctx context.Context, txid common.Hash) (*pendingTx, error) {
+	var pending *pendingTx
+
+	if h.Config.rpc != nil {
+		var tx *rpcTx
+		if err := h.Config.rpc.CallContext(ctx, &tx, "eth_getTransactionByHash", txid); err != nil {
+			return nil, errors.Wrapf(err, "get tx %v", txid.Hex())
+		}
+		if tx == nil {
+			return nil, errors.Wrapf(ethereum.NotFound, "tx %v", txid.Hex())
+		}
+
+		pending = &pendingTx{
+			from:     tx.From,
+			nonce:    uint64(tx.Nonce),
+			to:       tx.To,
+			value:    tx.Value.ToInt(),
+			data:     tx.Input,
+			gas:      uint64(tx.Gas),
+			gasPrice: tx.GasPrice.ToInt(),
+			mined:    tx.BlockHash != nil,
+		}
+		if tx.MaxFeePerGas != nil {
+			pending.gasPrice, pending.gasTipCap = tx.MaxFeePerGas.ToInt(), tx.MaxPriorityFeePerGas.ToInt()
+		}
+	} else {
+		tx, isPending, err := h.Config.client.TransactionByHash(ctx, txid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get tx %v", txid.Hex())
+		}
+
+		from, err := types.Sender(types.NewEIP155Signer(h.Config.Chain.ID), tx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sender of tx %v", txid.Hex())
+		}
+
+		pending = &pendingTx{
+			from:     from,
+			nonce:    tx.Nonce(),
+			to:       tx.To(),
+			value:    tx.Value(),
+			data:     tx.Data(),
+			gas:      tx.Gas(),
+			gasPrice: tx.GasPrice(),
+			mined:    !isPending,
+		}
+	}
+
+	return pending, nil
+}
+
+// replace sends the transaction to, value, data in place of pending.
+func (h *Handler) replace(ctx context.Context, pending *pendingTx, to *common.Address, value *big.Int, data []byte, gas uint64) (Transaction, error) {
+	auth, err := h.Config.makeAuth(ctx, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "make auth %v", h.Config.Account)
+	}
+
+	auth.Nonce, auth.keepNonce = new(big.Int).SetUint64(pending.nonce), true
+	auth.Value = value
+	auth.GasLimit = gas
+
+	//a replacement raises the max fee and the priority fee alike
+	auth.GasPrice = maxBig(bumpFee(pending.gasPrice), auth.GasPrice)
+	if auth.GasTipCap != nil {
+		tip := pending.gasTipCap
+		if tip == nil {
+			tip = pending.gasPrice
+		}
+		auth.GasTipCap = maxBig(bumpFee(tip), auth.GasTipCap)
+
+		if auth.GasTipCap.Cmp(auth.GasPrice) > 0 {
+			auth.GasPrice = auth.GasTipCap
+		}
+	}
+
+	if err := h.Config.Chain.Gas.checkMaxFee(auth.GasLimit, auth.GasPrice); err != nil {
+		return nil, errors.Wrap(err, "replace")
+	}
+
+	log.Printf("Replace nonce %v: gasPrice %v => %v", pending.nonce, pending.gasPrice, auth.GasPrice)
+
+	return h.sendTx(ctx, auth, data, to)
+}
+
+// bumpFee returns fee raised by feeBump percent, rounded up.
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+feeBump))
+	bumped.Add(bumped, big.NewInt(99))
+
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(x, y *big.Int) *big.Int {
+	if x.Cmp(y) >= 0 {
+		return x
+	}
+	return y
+}
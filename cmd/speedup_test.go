@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSpeedUp(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("a replacement raises the fee by feeBump percent, rounded up", t, func() {
+		So(bumpFee(big.NewInt(100)).Int64(), ShouldEqual, 112)
+		So(bumpFee(big.NewInt(1)).Int64(), ShouldEqual, 2)
+		So(bumpFee(big.NewInt(0)).Int64(), ShouldEqual, 0)
+	})
+
+	Convey("only a pending transaction of the account can be replaced", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		receiverKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey, receiverKey)
+		defer sim.Close()
+
+		sender, receiver := handlers[0], handlers[1]
+		timeLock := big.NewInt(int64(sim.Blockchain().CurrentHeader().Time) + 3600)
+
+		tx, err := sender.NewContract(ctx, common.HexToAddress(receiver.Config.Account), 1000, NewSecretHashPair().Hash, timeLock)
+		So(err, ShouldBeNil)
+
+		pending, err := sender.pendingTx(ctx, tx.Hash())
+		So(err, ShouldBeNil)
+		So(pending.nonce, ShouldEqual, tx.Nonce())
+		So(pending.gasTipCap, ShouldBeNil)
+
+		_, err = receiver.SpeedUp(ctx, tx.Hash())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "not by")
+
+		sim.Commit()
+
+		_, err = sender.CancelTx(ctx, tx.Hash())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "already mined")
+	})
+
+	Convey("speedup and canceltx resend the nonce at a higher fee", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey)
+		defer sim.Close()
+
+		sender := handlers[0]
+		rpc := &testRPC{
+			history: `{"oldestBlock":"0x1","baseFeePerGas":["0x1","0x1"],"gasUsedRatio":[0.1],"reward":[["0x2"]]}`,
+			tx: fmt.Sprintf(`{"from":"%v","nonce":"0x3","to":"%v","value":"0x3e8","input":"0xdead","gas":"0x186a0",
+				"gasPrice":"0x50","maxFeePerGas":"0x64","maxPriorityFeePerGas":"0xa","blockHash":null}`, sender.Config.Account, sender.Config.Chain.Contract),
+		}
+		sender.Config.rpc = rpc
+		sender.Config.Chain.Gas = &GasPolicy{TxType: TxDynamicFee}
+
+		tx, err := sender.SpeedUp(ctx, common.HexToHash("0x01"))
+		So(err, ShouldBeNil)
+		So(rpc.sent, ShouldHaveLength, 1)
+
+		replaced := tx.(*DynamicFeeTx)
+		So(replaced.Nonce(), ShouldEqual, 3)
+		So(replaced.GasFeeCap().Int64(), ShouldEqual, 112)
+		So(replaced.GasTipCap().Int64(), ShouldEqual, 12)
+		So(*replaced.To(), ShouldEqual, common.HexToAddress(sender.Config.Chain.Contract))
+		So(replaced.Value().Int64(), ShouldEqual, 1000)
+		So(replaced.Data(), ShouldResemble, []byte{0xde, 0xad})
+		So(replaced.Gas(), ShouldEqual, 100000)
+
+		tx, err = sender.CancelTx(ctx, common.HexToHash("0x01"))
+		So(err, ShouldBeNil)
+
+		cancelled := tx.(*DynamicFeeTx)
+		So(cancelled.Nonce(), ShouldEqual, 3)
+		So(*cancelled.To(), ShouldEqual, common.HexToAddress(sender.Config.Account))
+		So(cancelled.Value().Sign(), ShouldEqual, 0)
+		So(cancelled.Gas(), ShouldEqual, params.TxGas)
+		So(cancelled.GasFeeCap().Int64(), ShouldEqual, 112)
+
+		sender.Config.Chain.Gas.MaxFee = big.NewInt(100 * 100000)
+		_, err = sender.SpeedUp(ctx, common.HexToHash("0x01"))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "exceeds the max fee")
+	})
+
+	Convey("the watchtower bumps the operator transactions not mined in time", t, func() {
+		senderKey, _ := crypto.GenerateKey()
+		sim, handlers := testSimHandlers(t, senderKey)
+		defer sim.Close()
+
+		operator := handlers[0]
+		operator.Config.rpc = &testRPC{
+			history: `{"oldestBlock":"0x1","baseFeePerGas":["0x1","0x1"],"gasUsedRatio":[0.1],"reward":[["0x2"]]}`,
+			tx: fmt.Sprintf(`{"from":"%v","nonce":"0x0","to":"%v","value":"0x0","input":"0x","gas":"0x5208",
+				"gasPrice":"0x4","maxFeePerGas":"0x4","maxPriorityFeePerGas":"0x2","blockHash":null}`, operator.Config.Account, operator.Config.Chain.Contract),
+		}
+		operator.Config.Chain.Gas = &GasPolicy{TxType: TxDynamicFee}
+
+		sent := common.HexToHash("0x01").Hex()
+		leg := &TowerLeg{Status: LegSent, TxID: sent, SentBlock: 10}
+
+		(&Watchtower{}).bump(ctx, operator, leg, 20)
+		So(leg.TxID, ShouldEqual, sent)
+
+		w := &Watchtower{BumpBlocks: 3}
+		w.bump(ctx, operator, leg, 12)
+		So(leg.TxID, ShouldEqual, sent)
+
+		w.bump(ctx, operator, &TowerLeg{Status: LegSent, TxID: sent, SentBlock: 10, SignedTx: []byte{1}}, 13)
+		So(leg.TxID, ShouldEqual, sent)
+
+		w.bump(ctx, operator, leg, 13)
+		So(leg.Error, ShouldBeEmpty)
+		So(leg.TxID, ShouldNotEqual, sent)
+		So(leg.SentBlock, ShouldEqual, 13)
+	})
+}
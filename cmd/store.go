@@ -0,0 +1,400 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how long a change waits for another aswap process to release the store
+const storeLockTimeout = 10 * time.Second
+
+// Store is the local state of aswap: JSON values by key in named buckets,
+// kept in one file that is rewritten on every change, or once for all the
+// changes of a Batch. Reads and changes lock the file and re-read it if
+// another aswap process rewrote it, so that the processes sharing the store
+// see and do not undo the changes of each other. A store without path is
+// kept in memory only.
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	buckets map[string]map[string]json.RawMessage
+	//the file the buckets were read from
+	loaded os.FileInfo
+}
+
+// OpenStore loads the store at path, or starts an empty one if the file
+// does not exist yet.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		buckets: make(map[string]map[string]json.RawMessage),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the store file unless it is the one already read.
+func (s *Store) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		if s.loaded != nil {
+			s.buckets = make(map[string]map[string]json.RawMessage)
+			s.loaded = nil
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read store")
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "read store")
+	}
+
+	//every write renames a new file over the store
+	if s.loaded != nil && os.SameFile(info, s.loaded) &&
+		info.ModTime().Equal(s.loaded.ModTime()) && info.Size() == s.loaded.Size() {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return errors.Wrap(err, "read store")
+	}
+
+	buckets := make(map[string]map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return errors.Wrapf(err, "parse store (%s)", s.path)
+	}
+	s.buckets, s.loaded = buckets, info
+
+	return nil
+}
+
+// Put sets the value of key in bucket and writes the store.
+func (s *Store) Put(bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "encode %v/%v", bucket, key)
+	}
+
+	return s.change(func() error {
+		s.set(bucket, key, data)
+		return nil
+	})
+}
+
+// Update decodes the value of key in bucket into value, lets fn change it
+// and writes it back, all under the lock of the store. fn is told whether
+// the key exists; if it fails, the store is left as it was.
+func (s *Store) Update(bucket, key string, value interface{}, fn func(exists bool) error) error {
+	return s.change(func() error {
+		data, ok := s.buckets[bucket][key]
+		if ok {
+			if err := json.Unmarshal(data, value); err != nil {
+				return errors.Wrapf(err, "decode %v/%v", bucket, key)
+			}
+		}
+
+		if err := fn(ok); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return errors.Wrapf(err, "encode %v/%v", bucket, key)
+		}
+		s.set(bucket, key, data)
+
+		return nil
+	})
+}
+
+func (s *Store) set(bucket, key string, data json.RawMessage) {
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]json.RawMessage)
+	}
+	s.buckets[bucket][key] = data
+}
+
+// change applies fn to the latest store and writes it.
+func (s *Store) change(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	release, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	return s.flush()
+}
+
+// read applies fn to the latest store.
+func (s *Store) read(fn func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	release, err := s.lock(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	fn()
+
+	return nil
+}
+
+// lock takes the lock file of the store, shared to read or exclusive to
+// change it, waiting up to storeLockTimeout for other processes to release
+// it.
+func (s *Store) lock(exclusive bool) (func(), error) {
+	if s.path == "" {
+		return func() {}, nil
+	}
+
+	//before the lock file is created world-readable, the store holds secrets
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return nil, errors.Wrap(err, "create store directory")
+	}
+
+	deadline := time.Now().Add(storeLockTimeout)
+	for {
+		release, err := flock(s.path+".lock", exclusive)
+		if err == nil {
+			return release, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.Wrapf(err, "lock store (%s)", s.path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Get decodes the value of key in bucket into value and reports whether the
+// key exists.
+func (s *Store) Get(bucket, key string, value interface{}) (bool, error) {
+	var (
+		data json.RawMessage
+		ok   bool
+	)
+	if err := s.read(func() { data, ok = s.buckets[bucket][key] }); err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return true, errors.Wrapf(err, "decode %v/%v", bucket, key)
+	}
+
+	return true, nil
+}
+
+// Delete removes key from bucket and writes the store.
+func (s *Store) Delete(bucket, key string) error {
+	return s.change(func() error {
+		delete(s.buckets[bucket], key)
+		return nil
+	})
+}
+
+// Keys returns the sorted keys of bucket.
+func (s *Store) Keys(bucket string) ([]string, error) {
+	var keys []string
+	err := s.read(func() {
+		keys = make([]string, 0, len(s.buckets[bucket]))
+		for key := range s.buckets[bucket] {
+			keys = append(keys, key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Batch is a set of changes of the store, written at once.
+type Batch struct {
+	s       *Store
+	buckets map[string]map[string]json.RawMessage
+}
+
+// Batch lets fn change the latest store through a Batch and writes all its
+// changes at once, under the lock of the store. If fn fails, the store is
+// left as it was.
+func (s *Store) Batch(fn func(b *Batch) error) error {
+	return s.change(func() error {
+		//the changes go to copies of the buckets they touch
+		b := &Batch{s: s, buckets: make(map[string]map[string]json.RawMessage)}
+		if err := fn(b); err != nil {
+			return err
+		}
+
+		for name, bucket := range b.buckets {
+			s.buckets[name] = bucket
+		}
+
+		return nil
+	})
+}
+
+func (b *Batch) bucket(name string) map[string]json.RawMessage {
+	if bucket, ok := b.buckets[name]; ok {
+		return bucket
+	}
+
+	bucket := make(map[string]json.RawMessage, len(b.s.buckets[name]))
+	for key, data := range b.s.buckets[name] {
+		bucket[key] = data
+	}
+	b.buckets[name] = bucket
+
+	return bucket
+}
+
+// Get decodes the value of key in bucket into value and reports whether the
+// key exists, with the changes of the batch.
+func (b *Batch) Get(bucket, key string, value interface{}) (bool, error) {
+	data, ok := b.bucket(bucket)[key]
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return true, errors.Wrapf(err, "decode %v/%v", bucket, key)
+	}
+
+	return true, nil
+}
+
+// Put sets the value of key in bucket.
+func (b *Batch) Put(bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "encode %v/%v", bucket, key)
+	}
+	b.bucket(bucket)[key] = data
+
+	return nil
+}
+
+// Delete removes key from bucket.
+func (b *Batch) Delete(bucket, key string) {
+	delete(b.bucket(bucket), key)
+}
+
+// Keys returns the sorted keys of bucket, with the changes of the batch.
+func (b *Batch) Keys(bucket string) []string {
+	keys := make([]string, 0, len(b.bucket(bucket)))
+	for key := range b.bucket(bucket) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (s *Store) flush() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.buckets, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "encode store")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return errors.Wrap(err, "create store directory")
+	}
+
+	//the store holds secrets
+	if err := ioutil.WriteFile(s.path+".new", data, 0600); err != nil {
+		return errors.Wrap(err, "write store")
+	}
+
+	// Replace the live store with the newly written one
+	if err := os.Rename(s.path+".new", s.path); err != nil {
+		return errors.Wrap(err, "write store")
+	}
+
+	//the file just written is the one in memory
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return errors.Wrap(err, "write store")
+	}
+	s.loaded = info
+
+	return nil
+}
+
+// Store opens the store of the handler, "store" in the config or
+// aswap-store.json next to the config file.
+func (h *Handler) Store() (*Store, error) {
+	if h.store != nil {
+		return h.store, nil
+	}
+
+	path := h.Config.Store
+	if path == "" {
+		path = filepath.Join(filepath.Dir(h.ConfigPath), "aswap-store.json")
+	}
+
+	store, err := OpenStore(path)
+	if err != nil {
+		return nil, err
+	}
+	h.store = store
+
+	return store, nil
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aswap")
+	TMust(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store", "aswap-store.json")
+
+	Convey("put, reopen, get and delete", t, func() {
+		store, err := OpenStore(path)
+		So(err, ShouldBeNil)
+		keys, err := store.Keys("swaps")
+		So(err, ShouldBeNil)
+		So(keys, ShouldBeEmpty)
+
+		So(store.Put("swaps", "b", big.NewInt(2)), ShouldBeNil)
+		So(store.Put("swaps", "a", big.NewInt(1)), ShouldBeNil)
+
+		info, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+		store, err = OpenStore(path)
+		So(err, ShouldBeNil)
+		keys, err = store.Keys("swaps")
+		So(err, ShouldBeNil)
+		So(keys, ShouldResemble, []string{"a", "b"})
+
+		value := new(big.Int)
+		ok, err := store.Get("swaps", "b", value)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(value.Int64(), ShouldEqual, 2)
+
+		So(store.Delete("swaps", "b"), ShouldBeNil)
+		ok, err = store.Get("swaps", "b", value)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		ok, err = store.Get("other", "a", value)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+	})
+	Convey("a store sees the changes of another one on the same file", t, func() {
+		first, err := OpenStore(path)
+		So(err, ShouldBeNil)
+		second, err := OpenStore(path)
+		So(err, ShouldBeNil)
+
+		So(first.Put("swaps", "c", big.NewInt(3)), ShouldBeNil)
+
+		value := new(big.Int)
+		ok, err := second.Get("swaps", "c", value)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(value.Int64(), ShouldEqual, 3)
+
+		So(second.Delete("swaps", "c"), ShouldBeNil)
+		keys, err := first.Keys("swaps")
+		So(err, ShouldBeNil)
+		So(keys, ShouldNotContain, "c")
+	})
+
+	Convey("a batch writes its changes at once, or none of them", t, func() {
+		store, err := OpenStore(path)
+		So(err, ShouldBeNil)
+
+		err = store.Batch(func(b *Batch) error {
+			for i := int64(0); i < 3; i++ {
+				if err := b.Put("batch", big.NewInt(i).String(), big.NewInt(i)); err != nil {
+					return err
+				}
+			}
+			b.Delete("batch", "0")
+
+			value := new(big.Int)
+			ok, err := b.Get("batch", "2", value)
+			So(ok, ShouldBeTrue)
+			So(value.Int64(), ShouldEqual, 2)
+			So(b.Keys("batch"), ShouldResemble, []string{"1", "2"})
+			return err
+		})
+		So(err, ShouldBeNil)
+
+		reopened, err := OpenStore(path)
+		So(err, ShouldBeNil)
+		keys, err := reopened.Keys("batch")
+		So(err, ShouldBeNil)
+		So(keys, ShouldResemble, []string{"1", "2"})
+
+		err = store.Batch(func(b *Batch) error {
+			b.Delete("batch", "1")
+			So(b.Put("batch", "3", big.NewInt(3)), ShouldBeNil)
+			return os.ErrInvalid
+		})
+		So(err, ShouldEqual, os.ErrInvalid)
+
+		keys, err = store.Keys("batch")
+		So(err, ShouldBeNil)
+		So(keys, ShouldResemble, []string{"1", "2"})
+	})
+}
@@ -0,0 +1,453 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// store bucket of the tranched swaps
+const trancheBucket = "tranches"
+
+// status of a tranched swap
+const (
+	SwapOpen    = "open"
+	SwapDone    = "done"
+	SwapAborted = "aborted"
+)
+
+// status of a tranche, in the order the initiator moves through them
+const (
+	TrancheNew = "new"
+	//the secret is saved and the lock may be sent
+	TrancheLocking      = "locking"
+	TrancheLocked       = "locked"
+	TrancheParticipated = "participated"
+	TrancheRedeemed     = "redeemed"
+	TrancheSettled      = "settled"
+	TrancheAborting     = "aborting"
+	TrancheRefunded     = "refunded"
+)
+
+// Tranche is one sub-swap of a TrancheSwap with its own secret and contract
+// pair.
+type Tranche struct {
+	Amount      *big.Int    `json:"amount"`
+	OtherAmount *big.Int    `json:"otherAmount"`
+	Secret      common.Hash `json:"secret"`
+	Hash        common.Hash `json:"hash"`
+	TimeLock    *big.Int    `json:"timelock,omitempty"`
+	ContractID  string      `json:"contractId,omitempty"`
+	TxID        string      `json:"txid,omitempty"`
+	//unix time the own contract was locked
+	LockedAt        int64  `json:"lockedAt,omitempty"`
+	OtherContractID string `json:"otherContractId,omitempty"`
+	Status          string `json:"status"`
+}
+
+// TrancheSwap is an agreed swap split into sequential tranches, kept in the
+// store so that an interrupted initiator can resume it.
+type TrancheSwap struct {
+	ID string `json:"id"`
+	//the participant address on the own chain
+	Participant string `json:"participant"`
+	//our address on the other chain, paid by the participant contracts
+	Receiver string `json:"receiver"`
+	//the contract address or the name of the other chain
+	OtherContract string   `json:"otherContract"`
+	Amount        *big.Int `json:"amount"`
+	OtherAmount   *big.Int `json:"otherAmount"`
+	//seconds each tranche stays locked
+	LockTime int64      `json:"lockTime"`
+	Status   string     `json:"status"`
+	Current  int        `json:"current"`
+	Tranches []*Tranche `json:"tranches"`
+}
+
+// NewTrancheSwap splits amount and otherAmount into n tranches, the last one
+// taking the remainders.
+func NewTrancheSwap(participant, receiver string, amount, otherAmount *big.Int, n int, lockTime time.Duration) (*TrancheSwap, error) {
+	if n < 1 {
+		return nil, errors.Errorf("invalid tranches %d", n)
+	}
+
+	count := big.NewInt(int64(n))
+	part := new(big.Int).Div(amount, count)
+	otherPart := new(big.Int).Div(otherAmount, count)
+	if part.Sign() <= 0 || otherPart.Sign() <= 0 {
+		return nil, errors.Errorf("amounts %v and %v are too small for %d tranches", amount, otherAmount, n)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "generate swap id")
+	}
+
+	s := &TrancheSwap{
+		ID:          hexutil.Encode(id),
+		Participant: participant,
+		Receiver:    receiver,
+		Amount:      amount,
+		OtherAmount: otherAmount,
+		LockTime:    int64(lockTime / time.Second),
+		Status:      SwapOpen,
+	}
+
+	for i := 0; i < n; i++ {
+		tranche := &Tranche{
+			Amount:      new(big.Int).Set(part),
+			OtherAmount: new(big.Int).Set(otherPart),
+			Status:      TrancheNew,
+		}
+
+		if i == n-1 {
+			tranche.Amount.Add(tranche.Amount, new(big.Int).Sub(amount, new(big.Int).Mul(part, count)))
+			tranche.OtherAmount.Add(tranche.OtherAmount, new(big.Int).Sub(otherAmount, new(big.Int).Mul(otherPart, count)))
+		}
+
+		s.Tranches = append(s.Tranches, tranche)
+	}
+
+	return s, nil
+}
+
+// Settled returns the amounts of the settled tranches.
+func (s *TrancheSwap) Settled() (amount, otherAmount *big.Int) {
+	amount, otherAmount = new(big.Int), new(big.Int)
+	for _, tranche := range s.Tranches {
+		if tranche.Status == TrancheSettled {
+			amount.Add(amount, tranche.Amount)
+			otherAmount.Add(otherAmount, tranche.OtherAmount)
+		}
+	}
+	return amount, otherAmount
+}
+
+// LoadTrancheSwap reads the swap id from the store.
+func LoadTrancheSwap(store *Store, id string) (*TrancheSwap, error) {
+	s := new(TrancheSwap)
+
+	ok, err := store.Get(trancheBucket, id, s)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.Errorf("swap %v not found", id)
+	}
+
+	return s, nil
+}
+
+// TrancheSwaps returns the tranched swaps of the store.
+func TrancheSwaps(store *Store) ([]*TrancheSwap, error) {
+	ids, err := store.Keys(trancheBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var swaps []*TrancheSwap
+	for _, id := range ids {
+		s, err := LoadTrancheSwap(store, id)
+		if err != nil {
+			return nil, err
+		}
+		swaps = append(swaps, s)
+	}
+
+	return swaps, nil
+}
+
+// TrancheDriver runs a TrancheSwap as the initiator: the own backend locks
+// the initiator contracts, the other backend redeems the participant ones.
+type TrancheDriver struct {
+	Own   ChainBackend
+	Other ChainBackend
+	Store *Store
+	Swap  *TrancheSwap
+	//how long to wait for the participant contract of a tranche
+	ParticipateTimeout time.Duration
+	//the participant contract must leave us this long to redeem it
+	RedeemMargin time.Duration
+}
+
+// Run drives the swap until every tranche settled or the swap is aborted.
+func (d *TrancheDriver) Run(ctx context.Context) error {
+	for d.Swap.Status == SwapOpen {
+		progressed, err := d.Step(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if progressed {
+			continue
+		}
+
+		select {
+		case <-time.After(watchInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Step moves the current tranche at most one status forward and saves the
+// swap. It reports false if it is waiting for the participant or a timelock.
+func (d *TrancheDriver) Step(ctx context.Context, now time.Time) (bool, error) {
+	if d.Swap.Status != SwapOpen {
+		return false, nil
+	}
+
+	tranche := d.Swap.Tranches[d.Swap.Current]
+
+	progressed, err := d.step(ctx, tranche, now)
+	if err != nil || !progressed {
+		return false, err
+	}
+
+	switch tranche.Status {
+	case TrancheSettled:
+		if d.Swap.Current == len(d.Swap.Tranches)-1 {
+			d.Swap.Status = SwapDone
+		} else {
+			d.Swap.Current++
+		}
+	case TrancheRefunded:
+		d.Swap.Status = SwapAborted
+	}
+
+	return true, d.Store.Put(trancheBucket, d.Swap.ID, d.Swap)
+}
+
+func (d *TrancheDriver) step(ctx context.Context, tranche *Tranche, now time.Time) (bool, error) {
+	index := d.Swap.Current
+
+	switch tranche.Status {
+	case TrancheNew:
+		//keep the secret and the terms before the hash goes on chain
+		pair := NewSecretHashPair()
+		tranche.Secret, tranche.Hash = pair.Secret, pair.Hash
+		tranche.TimeLock = big.NewInt(now.Unix() + d.Swap.LockTime)
+		tranche.Status = TrancheLocking
+		if err := d.Store.Put(trancheBucket, d.Swap.ID, d.Swap); err != nil {
+			return false, err
+		}
+
+		return d.lock(ctx, tranche, now)
+
+	case TrancheLocking:
+		//interrupted between the lock and its save: find the lock we sent
+		finder, ok := d.Own.(LockFinder)
+		if !ok {
+			return false, errors.Errorf("tranche %d may be locked already, check the own chain for Secret Hash = %s",
+				index, tranche.Hash.Hex())
+		}
+
+		contractId, txid, err := finder.FindLock(ctx, d.Swap.Participant, tranche.Hash)
+		if err != nil {
+			return false, errors.Wrapf(err, "find the lock of tranche %d", index)
+		}
+
+		if contractId == "" {
+			return d.lock(ctx, tranche, now)
+		}
+
+		tranche.ContractID, tranche.TxID, tranche.LockedAt = contractId, txid, now.Unix()
+		tranche.Status = TrancheLocked
+		log.Printf("tranche %d found locked, Secret Hash = %s, ContractId = %s", index, tranche.Hash.Hex(), contractId)
+
+		return true, nil
+
+	case TrancheLocked:
+		if now.Sub(time.Unix(tranche.LockedAt, 0)) > d.ParticipateTimeout {
+			log.Printf("tranche %d: the participant did not lock in %v, abort", index, d.ParticipateTimeout)
+			tranche.Status = TrancheAborting
+			return true, nil
+		}
+
+		finder, ok := d.Other.(ContractFinder)
+		if !ok {
+			return false, errors.New("the other chain can not find the participant contract")
+		}
+
+		otherIds, err := finder.FindContracts(ctx, d.Swap.Receiver, tranche.Hash)
+		if err != nil {
+			return false, err
+		}
+
+		otherId := ""
+		for _, id := range otherIds {
+			if err := d.auditOther(ctx, tranche, id, now); err != nil {
+				//a wrong contract is as good as none, keep waiting for the right one
+				log.Printf("tranche %d: reject participant contract %v: %v", index, id, err)
+				continue
+			}
+			otherId = id
+			break
+		}
+		if otherId == "" {
+			return false, nil
+		}
+
+		tranche.OtherContractID = otherId
+		tranche.Status = TrancheParticipated
+		return true, nil
+
+	case TrancheParticipated:
+		txid, err := d.Other.Redeem(ctx, tranche.OtherContractID, tranche.Secret)
+		if err != nil {
+			return false, errors.Wrapf(err, "redeem tranche %d", index)
+		}
+
+		log.Printf("tranche %d redeemed, txid = %s", index, txid)
+		tranche.Status = TrancheRedeemed
+		return true, nil
+
+	case TrancheRedeemed:
+		c, err := d.Own.Audit(ctx, tranche.ContractID)
+		if err != nil {
+			return false, err
+		}
+
+		if c.Withdrawn {
+			tranche.Status = TrancheSettled
+			settled, otherSettled := d.Swap.Settled()
+			log.Printf("tranche %d settled, %v/%v for %v/%v", index,
+				new(big.Int).Add(settled, tranche.Amount), d.Swap.Amount, new(big.Int).Add(otherSettled, tranche.OtherAmount), d.Swap.OtherAmount)
+			return true, nil
+		}
+
+		//we have the participant funds, but the participant stalls: take ours back too
+		if now.Unix() < tranche.TimeLock.Int64() {
+			return false, nil
+		}
+		return d.refund(ctx, tranche)
+
+	case TrancheAborting:
+		if now.Unix() < tranche.TimeLock.Int64() {
+			return false, nil
+		}
+		return d.refund(ctx, tranche)
+	}
+
+	return false, errors.Errorf("tranche %d in unexpected status %v", index, tranche.Status)
+}
+
+// lock locks the own contract of the tranche with its saved terms.
+func (d *TrancheDriver) lock(ctx context.Context, tranche *Tranche, now time.Time) (bool, error) {
+	index := d.Swap.Current
+
+	contractId, txid, err := d.Own.Lock(ctx, d.Swap.Participant, tranche.Amount, tranche.Hash, tranche.TimeLock)
+	if err != nil {
+		return false, errors.Wrapf(err, "lock tranche %d", index)
+	}
+
+	tranche.ContractID, tranche.TxID, tranche.LockedAt = contractId, txid, now.Unix()
+	tranche.Status = TrancheLocked
+	log.Printf("tranche %d locked, Secret Hash = %s, ContractId = %s, Timelock = %v",
+		index, tranche.Hash.Hex(), contractId, tranche.TimeLock)
+
+	return true, nil
+}
+
+// auditOther checks the participant contract of the tranche.
+func (d *TrancheDriver) auditOther(ctx context.Context, tranche *Tranche, otherId string, now time.Time) error {
+	c, err := d.Other.Audit(ctx, otherId)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !strings.EqualFold(c.Receiver, d.Swap.Receiver):
+		return errors.Errorf("pays %v", c.Receiver)
+	case c.Amount.Cmp(tranche.OtherAmount) < 0:
+		return errors.Errorf("locks %v, expect %v", c.Amount, tranche.OtherAmount)
+	case c.Hashlock != tranche.Hash:
+		return errors.New("hashlock mismatch")
+	case c.Timelock.Cmp(tranche.TimeLock) >= 0:
+		return errors.Errorf("expires at %v, not before ours %v", c.Timelock, tranche.TimeLock)
+	case c.Timelock.Int64() < now.Add(d.RedeemMargin).Unix():
+		return errors.Errorf("expires at %v, too soon to redeem", c.Timelock)
+	case c.Withdrawn || c.Refunded:
+		return errors.New("already closed")
+	}
+
+	return nil
+}
+
+func (d *TrancheDriver) refund(ctx context.Context, tranche *Tranche) (bool, error) {
+	txid, err := d.Own.Refund(ctx, tranche.ContractID)
+	if err != nil {
+		return false, errors.Wrapf(err, "refund tranche %d", d.Swap.Current)
+	}
+
+	log.Printf("tranche %d refunded, txid = %s", d.Swap.Current, txid)
+	tranche.Status = TrancheRefunded
+	return true, nil
+}
+
+// OtherHandler returns a handler connected to the other chain that shares
+// the account and the store of h, which must already be unlocked.
+func (h *Handler) OtherHandler(otherContract string) (*Handler, error) {
+	if otherContract == "" {
+		return nil, errors.New("other contract is not specified")
+	}
+
+	cfg := *h.Config
+	if err := cfg.Connect(otherContract); err != nil {
+		return nil, err
+	}
+
+	return &Handler{ConfigPath: h.ConfigPath, Config: &cfg, store: h.store}, nil
+}
+
+// NewTrancheDriver returns the driver of swap between the connected chain of
+// h and swap.OtherContract, saving swap to the store first.
+func (h *Handler) NewTrancheDriver(swap *TrancheSwap) (*TrancheDriver, error) {
+	store, err := h.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Put(trancheBucket, swap.ID, swap); err != nil {
+		return nil, err
+	}
+
+	own, err := h.Backend()
+	if err != nil {
+		return nil, err
+	}
+
+	otherHandler, err := h.OtherHandler(swap.OtherContract)
+	if err != nil {
+		return nil, err
+	}
+
+	other, err := otherHandler.Backend()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrancheDriver{Own: own, Other: other, Store: store, Swap: swap}, nil
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewTrancheSwap(t *testing.T) {
+	Convey("split the amounts, the last tranche takes the remainders", t, func() {
+		s, err := NewTrancheSwap("0x01", "0x02", big.NewInt(1000), big.NewInt(50), 3, time.Hour)
+		So(err, ShouldBeNil)
+		So(s.Tranches, ShouldHaveLength, 3)
+		So(s.Tranches[0].Amount.Int64(), ShouldEqual, 333)
+		So(s.Tranches[2].Amount.Int64(), ShouldEqual, 334)
+		So(s.Tranches[0].OtherAmount.Int64(), ShouldEqual, 16)
+		So(s.Tranches[2].OtherAmount.Int64(), ShouldEqual, 18)
+		So(s.LockTime, ShouldEqual, 3600)
+	})
+
+	Convey("reject bad splits", t, func() {
+		_, err := NewTrancheSwap("0x01", "0x02", big.NewInt(1000), big.NewInt(50), 0, time.Hour)
+		So(err, ShouldNotBeNil)
+
+		_, err = NewTrancheSwap("0x01", "0x02", big.NewInt(2), big.NewInt(50), 3, time.Hour)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestTrancheDriver(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir, err := ioutil.TempDir("", "aswap")
+	TMust(t, err)
+	defer os.RemoveAll(dir)
+
+	initiatorKey, _ := crypto.GenerateKey()
+	participantKey, _ := crypto.GenerateKey()
+
+	//the initiator pays on chain A, the participant on chain B
+	simA, hsA := testSimHandlers(t, initiatorKey, participantKey)
+	defer simA.Close()
+	simB, hsB := testSimHandlers(t, initiatorKey, participantKey)
+	defer simB.Close()
+
+	own, err := hsA[0].Backend()
+	TMust(t, err)
+	other, err := hsB[0].Backend()
+	TMust(t, err)
+	participantA, err := hsA[1].Backend()
+	TMust(t, err)
+	participantB, err := hsB[1].Backend()
+	TMust(t, err)
+
+	store, err := OpenStore(filepath.Join(dir, "aswap-store.json"))
+	TMust(t, err)
+
+	var (
+		ctx = context.Background()
+		//the simulated blocks start at time 0
+		now = time.Unix(1000, 0)
+	)
+
+	newDriver := func(tranches int) *TrancheDriver {
+		s, err := NewTrancheSwap(hsA[1].Config.Account, hsB[0].Config.Account, big.NewInt(1000), big.NewInt(100), tranches, time.Hour)
+		TMust(t, err)
+
+		return &TrancheDriver{
+			Own:                own,
+			Other:              other,
+			Store:              store,
+			Swap:               s,
+			ParticipateTimeout: 10 * time.Minute,
+			RedeemMargin:       10 * time.Minute,
+		}
+	}
+
+	//participate locks the counterpart of the current tranche on chain B
+	participate := func(d *TrancheDriver, amount int64, timeLock int64) {
+		tranche := d.Swap.Tranches[d.Swap.Current]
+		_, _, err := participantB.Lock(ctx, d.Swap.Receiver, big.NewInt(amount), tranche.Hash, big.NewInt(timeLock))
+		TMust(t, err)
+		simB.Commit()
+	}
+
+	step := func(d *TrancheDriver) bool {
+		progressed, err := d.Step(ctx, now)
+		TMust(t, err)
+		simA.Commit()
+		simB.Commit()
+		return progressed
+	}
+
+	Convey("settle every tranche in turn", t, func() {
+		d := newDriver(2)
+
+		for i := 0; i < 2; i++ {
+			So(step(d), ShouldBeTrue)
+			So(d.Swap.Current, ShouldEqual, i)
+			tranche := d.Swap.Tranches[i]
+			So(tranche.Status, ShouldEqual, TrancheLocked)
+
+			//nothing to redeem yet
+			So(step(d), ShouldBeFalse)
+
+			//a participant contract expiring after ours is rejected
+			participate(d, 50, tranche.TimeLock.Int64()+1)
+			So(step(d), ShouldBeFalse)
+
+			participate(d, 50, tranche.TimeLock.Int64()-1800)
+			So(step(d), ShouldBeTrue)
+			So(tranche.Status, ShouldEqual, TrancheParticipated)
+
+			So(step(d), ShouldBeTrue)
+			So(tranche.Status, ShouldEqual, TrancheRedeemed)
+
+			//the participant stalls until it redeems with the revealed secret
+			So(step(d), ShouldBeFalse)
+			secret, err := participantB.ExtractSecret(ctx, tranche.OtherContractID)
+			So(err, ShouldBeNil)
+			_, err = participantA.Redeem(ctx, tranche.ContractID, secret)
+			So(err, ShouldBeNil)
+			simA.Commit()
+
+			So(step(d), ShouldBeTrue)
+			So(tranche.Status, ShouldEqual, TrancheSettled)
+		}
+
+		So(d.Swap.Status, ShouldEqual, SwapDone)
+		amount, otherAmount := d.Swap.Settled()
+		So(amount.Int64(), ShouldEqual, 1000)
+		So(otherAmount.Int64(), ShouldEqual, 100)
+
+		loaded, err := LoadTrancheSwap(store, d.Swap.ID)
+		So(err, ShouldBeNil)
+		So(loaded, ShouldResemble, d.Swap)
+	})
+
+	Convey("resume a tranche interrupted between its lock and its save", t, func() {
+		d := newDriver(1)
+		tranche := d.Swap.Tranches[0]
+
+		pair := NewSecretHashPair()
+		tranche.Secret, tranche.Hash = pair.Secret, pair.Hash
+		tranche.TimeLock = big.NewInt(now.Unix() + 3600)
+		tranche.Status = TrancheLocking
+
+		//sent before the crash, not mined yet
+		contractId, txid, err := own.Lock(ctx, d.Swap.Participant, tranche.Amount, tranche.Hash, tranche.TimeLock)
+		So(err, ShouldBeNil)
+
+		So(step(d), ShouldBeTrue)
+		So(tranche.Status, ShouldEqual, TrancheLocked)
+		So(tranche.ContractID, ShouldEqual, contractId)
+		So(tranche.TxID, ShouldEqual, txid)
+		So(tranche.Secret, ShouldEqual, common.Hash(pair.Secret))
+
+		ids, err := own.(ContractFinder).FindContracts(ctx, d.Swap.Participant, tranche.Hash)
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []string{contractId})
+
+		//nothing sent before the crash: lock with the saved terms
+		d = newDriver(1)
+		tranche = d.Swap.Tranches[0]
+		pair = NewSecretHashPair()
+		tranche.Secret, tranche.Hash = pair.Secret, pair.Hash
+		tranche.TimeLock = big.NewInt(now.Unix() + 3600)
+		tranche.Status = TrancheLocking
+
+		So(step(d), ShouldBeTrue)
+		So(tranche.Status, ShouldEqual, TrancheLocked)
+		So(tranche.Hash, ShouldEqual, common.Hash(pair.Hash))
+
+		c, err := own.Audit(ctx, tranche.ContractID)
+		So(err, ShouldBeNil)
+		So(c.Hashlock, ShouldEqual, pair.Hash)
+	})
+
+	Convey("abort and refund if the participant does not lock", t, func() {
+		d := newDriver(2)
+
+		So(step(d), ShouldBeTrue)
+		tranche := d.Swap.Tranches[0]
+
+		now = now.Add(11 * time.Minute)
+		So(step(d), ShouldBeTrue)
+		So(tranche.Status, ShouldEqual, TrancheAborting)
+
+		//wait for our timelock
+		So(step(d), ShouldBeFalse)
+
+		now = time.Unix(tranche.TimeLock.Int64(), 0)
+		So(simA.AdjustTime(2*time.Hour), ShouldBeNil)
+		simA.Commit()
+		So(step(d), ShouldBeTrue)
+		So(tranche.Status, ShouldEqual, TrancheRefunded)
+		So(d.Swap.Status, ShouldEqual, SwapAborted)
+
+		c, err := own.Audit(ctx, tranche.ContractID)
+		So(err, ShouldBeNil)
+		So(c.Refunded, ShouldBeTrue)
+
+		//the next tranche is never locked
+		So(step(d), ShouldBeFalse)
+		So(d.Swap.Tranches[1].Status, ShouldEqual, TrancheNew)
+	})
+}
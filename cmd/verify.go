@@ -0,0 +1,143 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"bytes"
+	"context"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// runtimeCode extracts the code a deployment of the creation bytecode leaves
+// on chain. solc ends the constructor with "PUSH2 <len> DUP1 PUSH2 <offset>
+// PUSH1 0 CODECOPY PUSH1 0 RETURN", so the runtime code is
+// creation[offset:offset+len].
+func runtimeCode(creation []byte) ([]byte, error) {
+	epilogue := []byte{0x60, 0x00, 0x39, 0x60, 0x00, 0xf3}
+
+	for i := 0; i+len(epilogue) <= len(creation); i++ {
+		if !bytes.Equal(creation[i:i+len(epilogue)], epilogue) || i < 7 {
+			continue
+		}
+
+		head := creation[i-7 : i]
+		if head[0] != 0x61 || head[3] != 0x80 || head[4] != 0x61 {
+			continue
+		}
+
+		size := int(head[1])<<8 | int(head[2])
+		offset := int(head[5])<<8 | int(head[6])
+		if offset+size > len(creation) {
+			return nil, errors.New("runtime code out of range")
+		}
+
+		return creation[offset : offset+size], nil
+	}
+
+	return nil, errors.New("constructor epilogue not found")
+}
+
+// stripMetadata removes the CBOR metadata solc appends to the runtime code.
+// Its length is stored big-endian in the last two bytes.
+func stripMetadata(code []byte) []byte {
+	if len(code) < 2 {
+		return code
+	}
+
+	size := int(code[len(code)-2])<<8 | int(code[len(code)-1])
+	if size+2 > len(code) {
+		return code
+	}
+
+	return code[:len(code)-size-2]
+}
+
+// VerifyContract checks that the contract at address runs the runtime
+// bytecode of the HashedTimelock version of the chain and, if the chain has an
+// allowlist in the config, that the address is on it.
+func (h *Handler) VerifyContract(ctx context.Context, address common.Address) error {
+	bin, name := h.htlcBytecode()
+
+	return h.verifyBytecode(ctx, address, bin, name)
+}
+
+// htlcBytecode returns the bytecode and the name of the HashedTimelock
+// version of the chain.
+func (h *Handler) htlcBytecode() (string, string) {
+	var version int
+	if h.Config.Chain != nil {
+		version = h.Config.Chain.Version
+	}
+
+	switch version {
+	case HTLCVersion2:
+		return htlc.HTLCV2BIN, "HashedTimelockV2"
+	case HTLCVersionFee:
+		return htlc.HTLCFeeBIN, "HashedTimelockFee"
+	default:
+		return htlc.HTLCBIN, "HashedTimelock"
+	}
+}
+
+// VerifyMultiContract is VerifyContract for HashedTimelockMulti.
+func (h *Handler) VerifyMultiContract(ctx context.Context, address common.Address) error {
+	return h.verifyBytecode(ctx, address, htlc.MultiHTLCBIN, "HashedTimelockMulti")
+}
+
+func (h *Handler) verifyBytecode(ctx context.Context, address common.Address, bin string, name string) error {
+	if bin == "" {
+		return errNotCompiled(name)
+	}
+
+	code, err := h.Config.client.CodeAt(ctx, address, nil)
+	if err != nil {
+		return errors.Wrap(err, "call CodeAt")
+	}
+
+	return h.checkBytecode(address, code, bin, name)
+}
+
+// checkBytecode checks the runtime code of the contract at address against
+// bin, and the address against the allowlist.
+func (h *Handler) checkBytecode(address common.Address, code []byte, bin string, name string) error {
+	if len(code) == 0 {
+		return errors.Errorf("no contract code at %v", address.String())
+	}
+
+	expect, err := runtimeCode(common.FromHex(bin))
+	if err != nil {
+		return errors.Wrapf(err, "parse %v bytecode", name)
+	}
+
+	if !bytes.Equal(stripMetadata(code), stripMetadata(expect)) {
+		return errors.Errorf("contract %v does not run the %v bytecode", address.String(), name)
+	}
+
+	if !h.Config.trusted(address) {
+		return errors.Errorf("contract %v is not in the trusted contracts of %v(chainID = %v)",
+			address.String(), h.Config.Chain.Name, h.Config.Chain.ID)
+	}
+
+	return nil
+}
+
+// errNotCompiled is returned for a contract variant whose bytecode has not
+// been generated into the contract package yet.
+func errNotCompiled(name string) error {
+	return errors.Errorf("%v bytecode is not built in, compile it with solcjs (see script/cmd.txt) first", name)
+}
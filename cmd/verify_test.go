@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"testing"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/common"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRuntimeCode(t *testing.T) {
+	Convey("runtime code of HTLCBIN", t, func() {
+		creation := common.FromHex(htlc.HTLCBIN)
+
+		code, err := runtimeCode(creation)
+		So(err, ShouldBeNil)
+		So(len(code), ShouldEqual, 0x10ea)
+		So(code, ShouldResemble, creation[0x20:])
+
+		Convey("strip the solc metadata", func() {
+			stripped := stripMetadata(code)
+			So(len(stripped), ShouldEqual, len(code)-0x34)
+			So(common.Bytes2Hex(code[len(stripped):len(stripped)+2]), ShouldEqual, "a265")
+		})
+
+		Convey("different metadata hash should still match", func() {
+			other := common.CopyBytes(code)
+			other[len(other)-10] ^= 0xff
+			So(stripMetadata(other), ShouldResemble, stripMetadata(code))
+		})
+
+		Convey("creation code without constructor epilogue", func() {
+			_, err := runtimeCode(code[:32])
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,769 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	htlc "github.com/icodezjb/atomicswap/contract"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/pkg/errors"
+)
+
+// store buckets of the watchtower
+const (
+	towerBucket    = "watchtower"
+	towerKeyBucket = "watchtowerKey"
+)
+
+// maxRegistrationSize bounds the body of a registration request.
+const maxRegistrationSize = 64 << 10
+
+// status of a TowerLeg
+const (
+	LegWatching = "watching"
+	LegSent     = "sent"
+	LegClosed   = "closed"
+	LegFailed   = "failed"
+)
+
+// TowerLeg is a contract guarded by the watchtower.
+type TowerLeg struct {
+	//chain name in the config of the watchtower
+	Chain      string `json:"chain"`
+	ContractID string `json:"contractId"`
+	//the pre-signed withdraw or refund, encrypted with EncryptForTower. It may
+	//be left out on a HashedTimelockV2 chain, where the tower sends from its
+	//operator account
+	SignedTx hexutil.Bytes `json:"signedTx,omitempty"`
+	//signature of TowerLegHash by the owner of the contract: its receiver on
+	//the redeem leg, its sender on the refund leg
+	Signature hexutil.Bytes `json:"signature,omitempty"`
+
+	Status string `json:"status,omitempty"`
+	TxID   string `json:"txid,omitempty"`
+	//head block number when TxID was sent
+	SentBlock uint64 `json:"sentBlock,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Registration asks the watchtower to guard the swap of an offline client.
+// Redeem is the contract paying the client: it is redeemed as soon as the
+// Refund leg reveals the secret, or Margin before it expires if the client
+// left a pre-signed redeem. Refund is the contract of the client: it is
+// refunded once it expires.
+type Registration struct {
+	ID     string    `json:"id"`
+	Redeem *TowerLeg `json:"redeem,omitempty"`
+	Refund *TowerLeg `json:"refund,omitempty"`
+}
+
+func (r *Registration) legs() []*TowerLeg {
+	var legs []*TowerLeg
+	for _, leg := range []*TowerLeg{r.Redeem, r.Refund} {
+		if leg != nil {
+			legs = append(legs, leg)
+		}
+	}
+	return legs
+}
+
+// Watchtower guards registered swaps on EVM chains.
+type Watchtower struct {
+	//chain name => handler of the chain, which sends from the operator account
+	//on a HashedTimelockV2 chain
+	Handlers map[string]*Handler
+	Store    *Store
+	//decrypts the pre-signed transactions
+	Key *ecdsa.PrivateKey
+	//a pre-signed redeem is sent this long before the contract expires
+	Margin time.Duration
+	//a transaction sent from the operator account and not mined after this
+	//many blocks is resent at a higher fee, never if 0
+	BumpBlocks uint64
+	//the bearer token of the registration API, which is open if empty and
+	//then only listens on localhost
+	Token string
+
+	//mu guards the registrations in the store, pass the passes of Check
+	mu   sync.Mutex
+	pass sync.Mutex
+	//chain name => index synced by the current pass
+	indexed map[string]*Indexer
+}
+
+// WatchtowerKey returns the key of the watchtower kept in the store,
+// generating it on the first run.
+func WatchtowerKey(store *Store) (*ecdsa.PrivateKey, error) {
+	var encoded hexutil.Bytes
+
+	ok, err := store.Get(towerKeyBucket, "key", &encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		return crypto.ToECDSA(encoded)
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "generate watchtower key")
+	}
+
+	if err := store.Put(towerKeyBucket, "key", hexutil.Bytes(crypto.FromECDSA(key))); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// WatchtowerToken returns the token of the registration API kept in the
+// store, generating it on the first run.
+func WatchtowerToken(store *Store) (string, error) {
+	var token string
+
+	ok, err := store.Get(towerKeyBucket, "token", &token)
+	if err != nil || ok {
+		return token, err
+	}
+
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", errors.Wrap(err, "generate watchtower token")
+	}
+	token = hexutil.Encode(data)[2:]
+
+	return token, store.Put(towerKeyBucket, "token", token)
+}
+
+// TowerLegHash is the hash the owner of a contract signs to register it at a
+// watchtower, over the HTLC contract, the contractId and the encrypted
+// pre-signed transaction.
+func TowerLegHash(contract common.Address, leg *TowerLeg) []byte {
+	return crypto.Keccak256([]byte("aswap watchtower"), contract.Bytes(), common.HexToHash(leg.ContractID).Bytes(), leg.SignedTx)
+}
+
+// SignTowerLeg signs leg, a contract of the account on the connected chain,
+// for a watchtower.
+func (h *Handler) SignTowerLeg(leg *TowerLeg) error {
+	sig, err := h.signHash(TowerLegHash(common.HexToAddress(h.Config.Chain.Contract), leg))
+	if err != nil {
+		return errors.Wrapf(err, "account=%v sign contract %v", h.Config.Account, leg.ContractID)
+	}
+
+	leg.Signature = sig
+	return nil
+}
+
+// EncryptForTower encrypts a pre-signed transaction to the public key of the
+// watchtower, so that neither the network nor the tower's disk sees it before
+// it is due.
+func EncryptForTower(pub *ecdsa.PublicKey, tx *types.Transaction) ([]byte, error) {
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode tx")
+	}
+
+	encrypted, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(pub), data, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypt tx")
+	}
+
+	return encrypted, nil
+}
+
+func (w *Watchtower) decryptTx(encrypted []byte) (*types.Transaction, error) {
+	data, err := ecies.ImportECDSA(w.Key).Decrypt(encrypted, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt tx")
+	}
+
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(data, tx); err != nil {
+		return nil, errors.Wrap(err, "decode tx")
+	}
+
+	return tx, nil
+}
+
+// Register checks and saves the registration, and returns its id.
+func (w *Watchtower) Register(ctx context.Context, r *Registration) (string, error) {
+	if len(r.legs()) == 0 {
+		return "", errors.New("nothing to watch")
+	}
+
+	//a redeem is sent once the refund leg reveals the secret, or as signed
+	if r.Redeem != nil && r.Refund == nil && len(r.Redeem.SignedTx) == 0 {
+		return "", errors.New("the redeem leg needs a pre-signed redeem or the refund leg that reveals its secret")
+	}
+
+	for _, leg := range r.legs() {
+		if err := w.checkLeg(ctx, leg, leg == r.Refund); err != nil {
+			return "", errors.Wrapf(err, "contract %v", leg.ContractID)
+		}
+		leg.Status, leg.TxID, leg.Error = LegWatching, "", ""
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "generate registration id")
+	}
+	r.ID = hexutil.Encode(id)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return r.ID, w.Store.Put(towerBucket, r.ID, r)
+}
+
+// checkLeg checks that leg is signed by the owner of its contract, the sender
+// of a refund leg or else the receiver, and so is its pre-signed transaction.
+func (w *Watchtower) checkLeg(ctx context.Context, leg *TowerLeg, refund bool) error {
+	h, ok := w.Handlers[leg.Chain]
+	if !ok {
+		return errors.Errorf("unknown chain %v", leg.Chain)
+	}
+
+	id, err := parseContractId(leg.ContractID)
+	if err != nil {
+		return err
+	}
+
+	details, err := h.openContract(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	owner := details.Receiver
+	if refund {
+		owner = details.Sender
+	}
+
+	pub, err := crypto.SigToPub(TowerLegHash(common.HexToAddress(h.Config.Chain.Contract), leg), leg.Signature)
+	if err != nil {
+		return errors.Wrap(err, "recover the signer")
+	}
+	if signer := crypto.PubkeyToAddress(*pub); signer != owner {
+		return errors.Errorf("signed by %v, not by the owner %v", signer.Hex(), owner.Hex())
+	}
+
+	if len(leg.SignedTx) == 0 {
+		if !h.Config.Chain.IsV2() {
+			return errors.New("a pre-signed transaction is needed on a HashedTimelock chain")
+		}
+		return nil
+	}
+
+	tx, err := w.decryptTx(leg.SignedTx)
+	if err != nil {
+		return err
+	}
+
+	if tx.To() == nil || *tx.To() != common.HexToAddress(h.Config.Chain.Contract) {
+		return errors.Errorf("pre-signed tx %v does not call %v", tx.Hash().Hex(), h.Config.Chain.Contract)
+	}
+
+	from, err := types.Sender(types.NewEIP155Signer(h.Config.Chain.ID), tx)
+	if err != nil {
+		return errors.Wrapf(err, "pre-signed tx %v sender", tx.Hash().Hex())
+	}
+	if from != owner {
+		return errors.Errorf("pre-signed tx %v is from %v, not from the owner %v", tx.Hash().Hex(), from.Hex(), owner.Hex())
+	}
+
+	return checkLegCall(tx, id, details, refund)
+}
+
+// checkLegCall checks that the pre-signed tx calls refund of the contract id,
+// or withdraw with its preimage. withdraw and refund are the same in both
+// HashedTimelock versions.
+func checkLegCall(tx *types.Transaction, id common.Hash, details *ContractDetails, refund bool) error {
+	method, args, err := decodeCall(htlc.HTLCABI, tx.Data())
+	if err != nil {
+		return errors.Wrapf(err, "pre-signed tx %v", tx.Hash().Hex())
+	}
+
+	want := "withdraw"
+	if refund {
+		want = "refund"
+	}
+	if method != want {
+		return errors.Errorf("pre-signed tx %v calls %v, not %v", tx.Hash().Hex(), method, want)
+	}
+
+	if common.Hash(args[0].([32]byte)) != id {
+		return errors.Errorf("pre-signed tx %v is for contract %v", tx.Hash().Hex(), common.Hash(args[0].([32]byte)).Hex())
+	}
+
+	if !refund {
+		preimage := args[1].([32]byte)
+		if sha256.Sum256(preimage[:]) != details.Hashlock {
+			return errors.Errorf("pre-signed tx %v has a wrong preimage", tx.Hash().Hex())
+		}
+	}
+
+	return nil
+}
+
+// Registration returns the registration id.
+func (w *Watchtower) Registration(id string) (*Registration, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	r := new(Registration)
+
+	ok, err := w.Store.Get(towerBucket, id, r)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.Errorf("registration %v not found", id)
+	}
+
+	return r, nil
+}
+
+// Run checks the registrations every watchInterval until ctx is done.
+func (w *Watchtower) Run(ctx context.Context) error {
+	for {
+		w.Check(ctx)
+
+		select {
+		case <-time.After(watchInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Check makes one pass over the registrations and sends the transactions
+// that are due. The failures of a leg are kept in its Error. The nodes are
+// called without holding the registrations, which Register only adds to.
+func (w *Watchtower) Check(ctx context.Context) {
+	w.pass.Lock()
+	defer w.pass.Unlock()
+
+	w.mu.Lock()
+	ids, err := w.Store.Keys(towerBucket)
+	w.mu.Unlock()
+	if err != nil {
+		log.Printf("watchtower: %v", err)
+		return
+	}
+
+	w.indexed = w.syncIndexes(ctx)
+
+	for _, id := range ids {
+		r, err := w.Registration(id)
+		if err != nil {
+			log.Printf("watchtower: %v", err)
+			continue
+		}
+
+		for _, leg := range r.legs() {
+			w.step(ctx, r, leg)
+		}
+
+		w.mu.Lock()
+		err = w.Store.Put(towerBucket, id, r)
+		w.mu.Unlock()
+		if err != nil {
+			log.Printf("watchtower: %v", err)
+		}
+	}
+
+	//the transactions dropped by the nodes are sent again
+	for name, h := range w.Handlers {
+		if store, _ := h.localStore(); store == nil {
+			continue
+		}
+		if _, err := h.JournalTxs(ctx, true); err != nil {
+			log.Printf("watchtower: %v journal: %v", name, err)
+		}
+	}
+}
+
+// syncIndexes syncs the indexed chains once for the pass, their legs are read
+// from the index.
+func (w *Watchtower) syncIndexes(ctx context.Context) map[string]*Indexer {
+	indexed := make(map[string]*Indexer)
+	for name, h := range w.Handlers {
+		ix, err := h.syncedIndexer()
+		if err == nil && ix != nil {
+			_, err = ix.Sync(ctx)
+		}
+		if err != nil {
+			log.Printf("watchtower: %v index: %v", name, err)
+			continue
+		}
+		if ix != nil {
+			indexed[name] = ix
+		}
+	}
+
+	return indexed
+}
+
+// contract returns the state of the contract of leg, from the index of its
+// chain if the index has it.
+func (w *Watchtower) contract(ctx context.Context, h *Handler, leg *TowerLeg) (*ContractDetails, error) {
+	id := common.HexToHash(leg.ContractID)
+
+	if ix := w.indexed[leg.Chain]; ix != nil {
+		htlc, ok, err := ix.Lookup(id)
+		if err != nil {
+			return nil, err
+		}
+		//HashedTimelock does not log the preimage, the contract has it
+		if ok && (htlc.Status != HTLCWithdrawn || htlc.Preimage != (common.Hash{})) {
+			return htlc.details(), nil
+		}
+	}
+
+	details := new(ContractDetails)
+	if err := h.AuditContract(ctx, details, id); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// revealed returns the secret if the leg was withdrawn.
+func (w *Watchtower) revealed(ctx context.Context, leg *TowerLeg) ([32]byte, bool) {
+	if leg == nil {
+		return [32]byte{}, false
+	}
+
+	details, err := w.contract(ctx, w.Handlers[leg.Chain], leg)
+	if err != nil || !details.Withdrawn {
+		return [32]byte{}, false
+	}
+
+	return details.Preimage, true
+}
+
+// step moves a leg of r forward, sending its transaction when it is due.
+func (w *Watchtower) step(ctx context.Context, r *Registration, leg *TowerLeg) {
+	if leg.Status == LegClosed || leg.Status == LegFailed {
+		return
+	}
+
+	h := w.Handlers[leg.Chain]
+	if h == nil {
+		leg.Error = "unknown chain " + leg.Chain
+		return
+	}
+
+	id := common.HexToHash(leg.ContractID)
+
+	details, err := w.contract(ctx, h, leg)
+	if err != nil {
+		leg.Error = err.Error()
+		return
+	}
+
+	if details.Withdrawn || details.Refunded {
+		leg.Status, leg.Error = LegClosed, ""
+		return
+	}
+
+	head, err := h.Config.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		leg.Error = err.Error()
+		return
+	}
+
+	if leg.Status == LegSent {
+		receipt, err := h.Config.client.TransactionReceipt(ctx, common.HexToHash(leg.TxID))
+		switch {
+		case err == nil && receipt != nil && receipt.Status == types.ReceiptStatusFailed:
+			leg.Status, leg.Error = LegFailed, "transaction "+leg.TxID+" reverted"
+		case err != nil || receipt == nil:
+			w.bump(ctx, h, leg, head.Number.Uint64())
+		}
+		return
+	}
+
+	var (
+		now      = head.Time
+		timeLock = details.Timelock.Uint64()
+		secret   [32]byte
+		due      bool
+	)
+	if leg == r.Refund {
+		due = now >= timeLock
+	} else {
+		if now >= timeLock {
+			leg.Status, leg.Error = LegFailed, "expired before it could be redeemed"
+			return
+		}
+
+		var revealed bool
+		secret, revealed = w.revealed(ctx, r.Refund)
+		due = revealed || (len(leg.SignedTx) > 0 && now+uint64(w.Margin/time.Second) >= timeLock)
+	}
+	if !due {
+		return
+	}
+
+	tx, err := w.send(ctx, h, leg, id, leg == r.Redeem, secret)
+	if err != nil {
+		leg.Error = err.Error()
+		return
+	}
+
+	log.Printf("watchtower: %v(%v) contract %v txid: %v", h.Config.Chain.Name, h.Config.Chain.ID, leg.ContractID, tx.Hash().Hex())
+	leg.Status, leg.TxID, leg.SentBlock, leg.Error = LegSent, tx.Hash().Hex(), head.Number.Uint64(), ""
+}
+
+// bump speeds up the transaction of leg once it is BumpBlocks blocks old and
+// still not mined. Only the transactions of the operator account can be
+// bumped, the pre-signed ones are sent again as they are.
+func (w *Watchtower) bump(ctx context.Context, h *Handler, leg *TowerLeg, head uint64) {
+	if len(leg.SignedTx) > 0 {
+		w.resend(ctx, h, leg)
+		return
+	}
+
+	if w.BumpBlocks == 0 || head < leg.SentBlock+w.BumpBlocks {
+		return
+	}
+
+	tx, err := h.SpeedUp(ctx, common.HexToHash(leg.TxID))
+	if err != nil {
+		leg.Error = err.Error()
+		return
+	}
+
+	log.Printf("watchtower: %v(%v) contract %v txid %v => %v", h.Config.Chain.Name, h.Config.Chain.ID, leg.ContractID, leg.TxID, tx.Hash().Hex())
+	leg.TxID, leg.SentBlock, leg.Error = tx.Hash().Hex(), head, ""
+}
+
+// resend checks the pre-signed transaction of leg through the journal, which
+// sends it again if the nodes dropped it. The leg fails once another
+// transaction took its nonce.
+func (w *Watchtower) resend(ctx context.Context, h *Handler, leg *TowerLeg) {
+	e, err := h.TxStatus(ctx, common.HexToHash(leg.TxID))
+	if err != nil {
+		leg.Error = err.Error()
+		return
+	}
+
+	switch e.Status {
+	case TxReplaced, TxRejected:
+		leg.Status, leg.Error = LegFailed, "pre-signed tx "+leg.TxID+" "+e.Status
+	default:
+		leg.Error = e.Error
+	}
+}
+
+// send broadcasts the pre-signed transaction of the leg, or redeems with
+// secret or refunds from the operator account.
+func (w *Watchtower) send(ctx context.Context, h *Handler, leg *TowerLeg, id common.Hash, redeem bool, secret [32]byte) (Transaction, error) {
+	if len(leg.SignedTx) > 0 {
+		return w.sendSigned(ctx, h, leg)
+	}
+
+	if redeem {
+		return h.RedeemFor(ctx, id, secret)
+	}
+	return h.RefundFor(ctx, id)
+}
+
+// sendSigned journals and broadcasts the pre-signed transaction of leg, so
+// that the journal sends it again if it is lost.
+func (w *Watchtower) sendSigned(ctx context.Context, h *Handler, leg *TowerLeg) (Transaction, error) {
+	tx, err := w.decryptTx(leg.SignedTx)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := types.Sender(types.NewEIP155Signer(h.Config.Chain.ID), tx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pre-signed tx %v sender", tx.Hash().Hex())
+	}
+
+	raw, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode tx")
+	}
+
+	if err := h.journalTx(tx, from, raw); err != nil {
+		return nil, err
+	}
+
+	if err := h.Config.broadcast(ctx, raw); err != nil {
+		h.journalSendFailed(tx.Hash(), err)
+		if !sendUncertain(err) {
+			return nil, errors.Wrap(err, "send pre-signed tx")
+		}
+
+		log.Printf("watchtower: send pre-signed tx %v: %v, kept pending in the journal", tx.Hash().Hex(), err)
+	}
+
+	return tx, nil
+}
+
+// ServeHTTP serves the registration API of the watchtower. All but /pubkey
+// want the Token as "Authorization: Bearer <token>".
+//
+//	GET  /pubkey             the public key to encrypt the pre-signed txs to
+//	POST /registrations      register a Registration, answers its id
+//	GET  /registrations/<id> the registration with the status of its legs
+func (w *Watchtower) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/pubkey" && !w.authorized(req) {
+		writeJSON(rw, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == "/pubkey":
+		writeJSON(rw, http.StatusOK, map[string]string{"pubkey": hexutil.Encode(crypto.FromECDSAPub(&w.Key.PublicKey))})
+
+	case req.Method == http.MethodPost && req.URL.Path == "/registrations":
+		r := new(Registration)
+		if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxRegistrationSize)).Decode(r); err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		id, err := w.Register(req.Context(), r)
+		if err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(rw, http.StatusCreated, map[string]string{"id": id})
+
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/registrations/"):
+		r, err := w.Registration(strings.TrimPrefix(req.URL.Path, "/registrations/"))
+		if err != nil {
+			writeJSON(rw, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+
+		//the client already has its pre-signed txs
+		for _, leg := range r.legs() {
+			leg.SignedTx = nil
+		}
+
+		writeJSON(rw, http.StatusOK, r)
+
+	default:
+		writeJSON(rw, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+// ListenAndServe serves the registration API on addr. Without a Token it
+// only listens on the loopback interface.
+func (w *Watchtower) ListenAndServe(addr string) error {
+	if w.Token == "" && !loopback(addr) {
+		return errors.Errorf("the registration API has no token, it may only listen on localhost, not %v", addr)
+	}
+
+	return http.ListenAndServe(addr, w)
+}
+
+// loopback tells if addr only listens on the loopback interface.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (w *Watchtower) authorized(req *http.Request) bool {
+	if w.Token == "" {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+w.Token)) == 1
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+// PresignRedeem signs, without sending, the withdraw of contractId with
+// secret for a watchtower.
+func (h *Handler) PresignRedeem(ctx context.Context, contractId common.Hash, secret common.Hash) (*types.Transaction, error) {
+	return h.presign(ctx, "withdraw", contractId, secret)
+}
+
+// PresignRefund signs, without sending, the refund of contractId for a
+// watchtower to send after its timelock. It takes the next nonce of the
+// account, so it is void once the account sends anything else first.
+func (h *Handler) PresignRefund(ctx context.Context, contractId common.Hash) (*types.Transaction, error) {
+	return h.presign(ctx, "refund", contractId)
+}
+
+func (h *Handler) presign(ctx context.Context, method string, args ...interface{}) (*types.Transaction, error) {
+	auth, err := h.Config.makeAuth(ctx, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "make auth %v", h.Config.Account)
+	}
+
+	if auth.Nonce, err = h.Config.pendingNonce(ctx); err != nil {
+		return nil, err
+	}
+
+	parsedABI, err := abi.JSON(strings.NewReader(htlc.HTLCABI))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse HTLCABI")
+	}
+
+	input, err := parsedABI.Pack(method, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pack %v", method)
+	}
+
+	//a refund can not be estimated before the timelock, keep the default gas limit;
+	//the watchtower sends legacy transactions, at the max fee per gas on a
+	//dynamic fee chain
+	if err := h.Config.Chain.Gas.checkMaxFee(auth.GasLimit, auth.GasPrice); err != nil {
+		return nil, errors.Wrapf(err, "presign %v", method)
+	}
+
+	contract := common.HexToAddress(h.Config.Chain.Contract)
+
+	return h.signTx(auth, input, &contract)
+}
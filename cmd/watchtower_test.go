@@ -0,0 +1,354 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWatchtower(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir, err := ioutil.TempDir("", "aswap")
+	TMust(t, err)
+	defer os.RemoveAll(dir)
+
+	aliceKey, _ := crypto.GenerateKey()
+	bobKey, _ := crypto.GenerateKey()
+	towerKey, _ := crypto.GenerateKey()
+
+	//alice initiates on chain1, bob participates on chain2
+	sim1, hs1 := testSimHandlers(t, aliceKey, bobKey, towerKey)
+	defer sim1.Close()
+	sim2, hs2 := testSimHandlers(t, aliceKey, bobKey, towerKey)
+	defer sim2.Close()
+	hs1[2].Config.Chain.Name = "chain1"
+	hs2[2].Config.Chain.Name = "chain2"
+
+	store, err := OpenStore(filepath.Join(dir, "aswap-store.json"))
+	TMust(t, err)
+	key, err := WatchtowerKey(store)
+	TMust(t, err)
+
+	tower := &Watchtower{
+		Handlers: map[string]*Handler{"chain1": hs1[2], "chain2": hs2[2]},
+		Store:    store,
+		Key:      key,
+		Margin:   30 * time.Minute,
+		Token:    "token",
+	}
+
+	server := httptest.NewServer(tower)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	headTime := func(sim *backends.SimulatedBackend) int64 {
+		return int64(sim.Blockchain().CurrentHeader().Time)
+	}
+
+	lock := func(sim *backends.SimulatedBackend, h *Handler, receiver string, hash [32]byte, lockTime int64) common.Hash {
+		backend, err := h.Backend()
+		TMust(t, err)
+
+		contractId, _, err := backend.Lock(ctx, receiver, big.NewInt(1000), hash, big.NewInt(headTime(sim)+lockTime))
+		TMust(t, err)
+		sim.Commit()
+
+		return common.HexToHash(contractId)
+	}
+
+	encrypt := func(pub []byte, tx *types.Transaction) hexutil.Bytes {
+		pubKey, err := crypto.UnmarshalPubkey(pub)
+		TMust(t, err)
+		encrypted, err := EncryptForTower(pubKey, tx)
+		TMust(t, err)
+		return encrypted
+	}
+
+	request := func(method string, path string, body []byte, token string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+		TMust(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		TMust(t, err)
+		return resp
+	}
+
+	sign := func(h *Handler, leg *TowerLeg) *TowerLeg {
+		TMust(t, h.SignTowerLeg(leg))
+		return leg
+	}
+
+	post := func(r *Registration) (*http.Response, map[string]string) {
+		body, _ := json.Marshal(r)
+		resp := request(http.MethodPost, "/registrations", body, "token")
+		defer resp.Body.Close()
+
+		answer := make(map[string]string)
+		TMust(t, json.NewDecoder(resp.Body).Decode(&answer))
+		return resp, answer
+	}
+
+	status := func(id string) *Registration {
+		resp := request(http.MethodGet, "/registrations/"+id, nil, "token")
+		defer resp.Body.Close()
+
+		r := new(Registration)
+		TMust(t, json.NewDecoder(resp.Body).Decode(r))
+		return r
+	}
+
+	Convey("redeem for an offline initiator, who is then paid out", t, func() {
+		pair := NewSecretHashPair()
+		aliceId := lock(sim1, hs1[0], hs1[1].Config.Account, pair.Hash, 7200)
+		bobId := lock(sim2, hs2[1], hs2[0].Config.Account, pair.Hash, 3600)
+
+		resp, err := http.Get(server.URL + "/pubkey")
+		So(err, ShouldBeNil)
+		var pubkey map[string]string
+		So(json.NewDecoder(resp.Body).Decode(&pubkey), ShouldBeNil)
+		resp.Body.Close()
+		pub := hexutil.MustDecode(pubkey["pubkey"])
+
+		refundTx, err := hs1[0].PresignRefund(ctx, aliceId)
+		So(err, ShouldBeNil)
+		redeemTx, err := hs2[0].PresignRedeem(ctx, bobId, pair.Secret)
+		So(err, ShouldBeNil)
+
+		//a leg on a HashedTimelock chain needs a pre-signed tx
+		resp, answer := post(&Registration{Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex()})})
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+		So(answer["error"], ShouldNotBeEmpty)
+
+		resp, answer = post(&Registration{
+			Redeem: sign(hs2[0], &TowerLeg{Chain: "chain2", ContractID: bobId.Hex(), SignedTx: encrypt(pub, redeemTx)}),
+			Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: encrypt(pub, refundTx)}),
+		})
+		So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+		id := answer["id"]
+
+		//too early
+		tower.Check(ctx)
+		So(status(id).Redeem.Status, ShouldEqual, LegWatching)
+
+		//within the margin of bob's timelock
+		So(sim2.AdjustTime(40*time.Minute), ShouldBeNil)
+		sim2.Commit()
+		tower.Check(ctx)
+		r := status(id)
+		So(r.Redeem.Status, ShouldEqual, LegSent)
+		So(r.Redeem.TxID, ShouldEqual, redeemTx.Hash().Hex())
+		So(r.Redeem.SignedTx, ShouldBeEmpty)
+
+		sim2.Commit()
+		tower.Check(ctx)
+		So(status(id).Redeem.Status, ShouldEqual, LegClosed)
+
+		//bob redeems alice's contract with the revealed secret
+		bobOnChain2, err := hs2[1].Backend()
+		So(err, ShouldBeNil)
+		secret, err := bobOnChain2.ExtractSecret(ctx, bobId.Hex())
+		So(err, ShouldBeNil)
+		_, err = hs1[1].Redeem(ctx, aliceId, secret)
+		So(err, ShouldBeNil)
+		sim1.Commit()
+
+		tower.Check(ctx)
+		r = status(id)
+		So(r.Refund.Status, ShouldEqual, LegClosed)
+		So(r.Refund.TxID, ShouldBeEmpty)
+	})
+
+	Convey("refund for an offline initiator whose participant never locked", t, func() {
+		pair := NewSecretHashPair()
+		aliceId := lock(sim1, hs1[0], hs1[1].Config.Account, pair.Hash, 7200)
+
+		refundTx, err := hs1[0].PresignRefund(ctx, aliceId)
+		So(err, ShouldBeNil)
+
+		id, err := tower.Register(ctx, &Registration{
+			Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: encrypt(crypto.FromECDSAPub(&key.PublicKey), refundTx)}),
+		})
+		So(err, ShouldBeNil)
+
+		tower.Check(ctx)
+		So(status(id).Refund.Status, ShouldEqual, LegWatching)
+
+		So(sim1.AdjustTime(3*time.Hour), ShouldBeNil)
+		sim1.Commit()
+		tower.Check(ctx)
+		So(status(id).Refund.Status, ShouldEqual, LegSent)
+
+		sim1.Commit()
+		tower.Check(ctx)
+		So(status(id).Refund.Status, ShouldEqual, LegClosed)
+
+		details := new(ContractDetails)
+		So(hs1[0].AuditContract(ctx, details, aliceId), ShouldBeNil)
+		So(details.Refunded, ShouldBeTrue)
+	})
+
+	Convey("a pre-signed tx lost on the way is sent again", t, func() {
+		pair := NewSecretHashPair()
+		aliceId := lock(sim1, hs1[0], hs1[1].Config.Account, pair.Hash, 7200)
+
+		refundTx, err := hs1[0].PresignRefund(ctx, aliceId)
+		So(err, ShouldBeNil)
+
+		id, err := tower.Register(ctx, &Registration{
+			Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: encrypt(crypto.FromECDSAPub(&key.PublicKey), refundTx)}),
+		})
+		So(err, ShouldBeNil)
+
+		So(sim1.AdjustTime(3*time.Hour), ShouldBeNil)
+		sim1.Commit()
+
+		hs1[2].Config.client = offlineSend{simClient{sim1}}
+		tower.Check(ctx)
+		hs1[2].Config.client = simClient{sim1}
+		So(status(id).Refund.Status, ShouldEqual, LegSent)
+
+		e := new(JournalTx)
+		ok, err := hs1[2].store.Get(journalBucket, refundTx.Hash().Hex(), e)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(e.Status, ShouldEqual, TxDropped)
+		So(e.From, ShouldEqual, common.HexToAddress(hs1[0].Config.Account).Hex())
+
+		//never reached the pool, so nothing is mined
+		sim1.Commit()
+		tower.Check(ctx)
+		So(status(id).Refund.Status, ShouldEqual, LegSent)
+
+		sim1.Commit()
+		tower.Check(ctx)
+		So(status(id).Refund.Status, ShouldEqual, LegClosed)
+
+		e, err = hs1[2].TxStatus(ctx, refundTx.Hash())
+		So(err, ShouldBeNil)
+		So(e.Status, ShouldEqual, TxMined)
+		So(e.Rebroadcasts, ShouldBeGreaterThan, 0)
+	})
+
+	Convey("the registrations are refused", t, func() {
+		pair := NewSecretHashPair()
+		aliceId := lock(sim1, hs1[0], hs1[1].Config.Account, pair.Hash, 7200)
+
+		refundTx, err := hs1[0].PresignRefund(ctx, aliceId)
+		So(err, ShouldBeNil)
+		signedTx := encrypt(crypto.FromECDSAPub(&key.PublicKey), refundTx)
+
+		Convey("without the token", func() {
+			body, _ := json.Marshal(&Registration{Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: signedTx})})
+			resp := request(http.MethodPost, "/registrations", body, "wrong")
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			resp = request(http.MethodGet, "/pubkey", nil, "")
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("with a body over the limit", func() {
+			leg := sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: signedTx})
+			leg.Error = string(make([]byte, maxRegistrationSize))
+			resp, answer := post(&Registration{Refund: leg})
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(answer["error"], ShouldContainSubstring, "too large")
+		})
+
+		Convey("signed by someone else than the sender", func() {
+			resp, answer := post(&Registration{Refund: sign(hs1[1], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: signedTx})})
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(answer["error"], ShouldContainSubstring, "not by the owner")
+
+			resp, answer = post(&Registration{Refund: &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: signedTx}})
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("with a pre-signed tx of someone else", func() {
+			bobTx, err := hs1[1].PresignRefund(ctx, aliceId)
+			So(err, ShouldBeNil)
+
+			resp, answer := post(&Registration{Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: encrypt(crypto.FromECDSAPub(&key.PublicKey), bobTx)})})
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(answer["error"], ShouldContainSubstring, "not from the owner")
+		})
+
+		Convey("with a pre-signed tx of another call", func() {
+			pub := crypto.FromECDSAPub(&key.PublicKey)
+
+			withdrawTx, err := hs1[0].presign(ctx, "withdraw", aliceId, pair.Secret)
+			So(err, ShouldBeNil)
+			resp, answer := post(&Registration{Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: encrypt(pub, withdrawTx)})})
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(answer["error"], ShouldContainSubstring, "calls withdraw, not refund")
+
+			otherId := lock(sim1, hs1[0], hs1[1].Config.Account, pair.Hash, 7200)
+			otherTx, err := hs1[0].PresignRefund(ctx, otherId)
+			So(err, ShouldBeNil)
+			resp, answer = post(&Registration{Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: encrypt(pub, otherTx)})})
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(answer["error"], ShouldContainSubstring, "is for contract "+otherId.Hex())
+
+			bobId := lock(sim1, hs1[1], hs1[0].Config.Account, pair.Hash, 3600)
+			wrongTx, err := hs1[0].PresignRedeem(ctx, bobId, common.Hash{1})
+			So(err, ShouldBeNil)
+			resp, answer = post(&Registration{
+				Redeem: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: bobId.Hex(), SignedTx: encrypt(pub, wrongTx)}),
+				Refund: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: aliceId.Hex(), SignedTx: signedTx}),
+			})
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(answer["error"], ShouldContainSubstring, "wrong preimage")
+		})
+
+		Convey("with a redeem leg that can never be due", func() {
+			bobId := lock(sim1, hs1[1], hs1[0].Config.Account, pair.Hash, 3600)
+			resp, answer := post(&Registration{Redeem: sign(hs1[0], &TowerLeg{Chain: "chain1", ContractID: bobId.Hex()})})
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(answer["error"], ShouldContainSubstring, "reveals its secret")
+		})
+	})
+
+	Convey("the registration API without a token only listens on localhost", t, func() {
+		open := &Watchtower{}
+		So(open.ListenAndServe(":0"), ShouldNotBeNil)
+		So(open.ListenAndServe("0.0.0.0:0"), ShouldNotBeNil)
+
+		So(loopback("127.0.0.1:8080"), ShouldBeTrue)
+		So(loopback("[::1]:8080"), ShouldBeTrue)
+		So(loopback("localhost:8080"), ShouldBeTrue)
+		So(loopback(":8080"), ShouldBeFalse)
+		So(loopback("192.168.1.2:8080"), ShouldBeFalse)
+	})
+
+	Convey("the state and the key survive a restart", t, func() {
+		reopened, err := OpenStore(filepath.Join(dir, "aswap-store.json"))
+		So(err, ShouldBeNil)
+		ids, err := reopened.Keys(towerBucket)
+		So(err, ShouldBeNil)
+		So(ids, ShouldHaveLength, 3)
+
+		again, err := WatchtowerKey(reopened)
+		So(err, ShouldBeNil)
+		So(again.D, ShouldResemble, key.D)
+	})
+}
@@ -0,0 +1,230 @@
+pragma solidity ^0.5.0;
+
+interface IERC721 {
+    function safeTransferFrom(address from, address to, uint256 tokenId) external;
+}
+
+/**
+ * @title Hashed Timelock Contracts (HTLCs) on Ethereum ERC721 tokens.
+ *
+ * Same as HashedTimelock.sol, but a contract locks one ERC721 token instead of
+ * ETH. The sender approves this contract for the token, newContract moves it
+ * into escrow with safeTransferFrom, and withdraw or refund move it out to the
+ * receiver or back to the sender.
+ *
+ * Protocol:
+ *
+ *  1) newContract(receiver, hashlock, timelock, tokenContract, tokenId) - a
+ *      sender calls this to create a new HTLC and gets back a 32 byte contract id
+ *  2) withdraw(contractId, preimage) - once the receiver knows the preimage of
+ *      the hashlock hash they can claim the token with this function
+ *  3) refund(contractId) - after timelock has expired and if the receiver did not
+ *      withdraw the token the sender / creator of the HTLC can get it back
+ *      with this function.
+ */
+contract HashedTimelockERC721 {
+
+    event LogHTLCNew(
+        bytes32 indexed contractId,
+        address indexed sender,
+        address indexed receiver,
+        address tokenContract,
+        uint tokenId,
+        bytes32 hashlock,
+        uint timelock
+    );
+    event LogHTLCWithdraw(bytes32 indexed contractId, bytes32 preimage);
+    event LogHTLCRefund(bytes32 indexed contractId);
+
+    struct LockContract {
+        address sender;
+        address receiver;
+        address tokenContract;
+        uint tokenId;
+        bytes32 hashlock; // sha-2 sha256 hash
+        uint timelock; // UNIX timestamp seconds - locked UNTIL this time
+        bool withdrawn;
+        bool refunded;
+        bytes32 preimage;
+    }
+
+    modifier futureTimelock(uint _time) {
+        require(_time > now, "timelock time must be in the future");
+        _;
+    }
+    modifier contractExists(bytes32 _contractId) {
+        require(haveContract(_contractId), "contractId does not exist");
+        _;
+    }
+    modifier hashlockMatches(bytes32 _contractId, bytes32 _x) {
+        require(
+            contracts[_contractId].hashlock == sha256(abi.encodePacked(_x)),
+            "hashlock hash does not match"
+        );
+        _;
+    }
+    modifier withdrawable(bytes32 _contractId) {
+        require(contracts[_contractId].receiver == msg.sender, "withdrawable: not receiver");
+        require(contracts[_contractId].withdrawn == false, "withdrawable: already withdrawn");
+        require(contracts[_contractId].timelock > now, "withdrawable: timelock time must be in the future");
+        _;
+    }
+    modifier refundable(bytes32 _contractId) {
+        require(contracts[_contractId].sender == msg.sender, "refundable: not sender");
+        require(contracts[_contractId].refunded == false, "refundable: already refunded");
+        require(contracts[_contractId].withdrawn == false, "refundable: already withdrawn");
+        require(contracts[_contractId].timelock <= now, "refundable: timelock not yet passed");
+        _;
+    }
+
+    mapping (bytes32 => LockContract) contracts;
+
+    /**
+     * @dev Sender sets up a new hash time lock contract depositing the token,
+     * which this contract must be approved for, and providing the reciever
+     * lock terms.
+     *
+     * @param _receiver Receiver of the token.
+     * @param _hashlock A sha-2 sha256 hash hashlock.
+     * @param _timelock UNIX epoch seconds time that the lock expires at.
+     *                  Refunds can be made after this time.
+     * @param _tokenContract The ERC721 token contract.
+     * @param _tokenId Id of the token to lock.
+     * @return contractId Id of the new HTLC. This is needed for subsequent
+     *                    calls.
+     */
+    function newContract(address _receiver, bytes32 _hashlock, uint _timelock, address _tokenContract, uint _tokenId)
+        external
+        futureTimelock(_timelock)
+        returns (bytes32 contractId)
+    {
+        contractId = sha256(
+            abi.encodePacked(
+                msg.sender,
+                _receiver,
+                _tokenContract,
+                _tokenId,
+                _hashlock,
+                _timelock
+            )
+        );
+
+        require(!haveContract(contractId), "contractId already exists");
+
+        LockContract storage c = contracts[contractId];
+        c.sender = msg.sender;
+        c.receiver = _receiver;
+        c.tokenContract = _tokenContract;
+        c.tokenId = _tokenId;
+        c.hashlock = _hashlock;
+        c.timelock = _timelock;
+
+        IERC721(_tokenContract).safeTransferFrom(msg.sender, address(this), _tokenId);
+
+        emit LogHTLCNew(
+            contractId,
+            msg.sender,
+            _receiver,
+            _tokenContract,
+            _tokenId,
+            _hashlock,
+            _timelock
+        );
+    }
+
+    /**
+     * @dev Accepts the tokens newContract moves into escrow, and nothing else,
+     * so that no token can be stuck here outside of a HTLC.
+     */
+    function onERC721Received(address _operator, address, uint256, bytes calldata)
+        external
+        view
+        returns (bytes4)
+    {
+        require(_operator == address(this), "onERC721Received: lock tokens with newContract");
+        return this.onERC721Received.selector;
+    }
+
+    /**
+     * @dev Called by the receiver once they know the preimage of the hashlock.
+     * This will transfer the locked token to their address.
+     *
+     * @param _contractId Id of the HTLC.
+     * @param _preimage sha256(_preimage) should equal the contract hashlock.
+     * @return bool true on success
+     */
+    function withdraw(bytes32 _contractId, bytes32 _preimage)
+        external
+        contractExists(_contractId)
+        hashlockMatches(_contractId, _preimage)
+        withdrawable(_contractId)
+        returns (bool)
+    {
+        LockContract storage c = contracts[_contractId];
+        c.preimage = _preimage;
+        c.withdrawn = true;
+        IERC721(c.tokenContract).safeTransferFrom(address(this), c.receiver, c.tokenId);
+        emit LogHTLCWithdraw(_contractId, _preimage);
+        return true;
+    }
+
+    /**
+     * @dev Called by the sender if there was no withdraw AND the time lock has
+     * expired. This will refund the locked token to the sender.
+     *
+     * @param _contractId Id of HTLC to refund from.
+     * @return bool true on success
+     */
+    function refund(bytes32 _contractId)
+        external
+        contractExists(_contractId)
+        refundable(_contractId)
+        returns (bool)
+    {
+        LockContract storage c = contracts[_contractId];
+        c.refunded = true;
+        IERC721(c.tokenContract).safeTransferFrom(address(this), c.sender, c.tokenId);
+        emit LogHTLCRefund(_contractId);
+        return true;
+    }
+
+    /**
+     * @dev Get contract details.
+     * @param _contractId HTLC contract id
+     * @return All parameters in struct LockContract for _contractId HTLC
+     */
+    function getContract(bytes32 _contractId)
+        public
+        view
+        returns (
+            address sender,
+            address receiver,
+            address tokenContract,
+            uint tokenId,
+            bytes32 hashlock,
+            uint timelock,
+            bool withdrawn,
+            bool refunded,
+            bytes32 preimage
+        )
+    {
+        if (haveContract(_contractId) == false)
+            return (address(0), address(0), address(0), 0, 0, 0, false, false, 0);
+        LockContract storage c = contracts[_contractId];
+        return (c.sender, c.receiver, c.tokenContract, c.tokenId, c.hashlock, c.timelock,
+                c.withdrawn, c.refunded, c.preimage);
+    }
+
+    /**
+     * @dev Is there a contract with id _contractId.
+     * @param _contractId Id into contracts mapping.
+     */
+    function haveContract(bytes32 _contractId)
+        internal
+        view
+        returns (bool exists)
+    {
+        exists = (contracts[_contractId].sender != address(0));
+    }
+
+}
@@ -0,0 +1,115 @@
+pragma solidity ^0.5.0;
+
+import "./HashedTimeLockV2.sol";
+
+/**
+ * @title Hashed Timelock Contracts (HTLCs) on Ethereum ETH, version 2, with a
+ * protocol fee.
+ *
+ * HashedTimelockV2 whose withdraw keeps a fee of feeBps basis points of the
+ * amount for the operator of the contract, which the owner sets with setFee.
+ * The fee of a HTLC is fixed when it is created, so that a later setFee does
+ * not change the terms the counterparty audited; refund and cancel return the
+ * full amount. LogHTLCWithdraw logs the amount the receiver got.
+ */
+contract HashedTimelockFee is HashedTimelockV2 {
+
+    event LogFeeChanged(
+        address indexed recipient,
+        uint feeBps
+    );
+
+    uint constant MAX_FEE_BPS = 1000; // 10%
+
+    address public owner;
+    address payable public feeRecipient;
+    uint public feeBps;
+    // fees withdrawn and not yet collected by feeRecipient
+    uint public accruedFees;
+    // contractId => fee kept by withdraw
+    mapping (bytes32 => uint) fees;
+
+    modifier onlyOwner() {
+        require(msg.sender == owner, "not owner");
+        _;
+    }
+
+    constructor(address payable _feeRecipient, uint _feeBps) public {
+        owner = msg.sender;
+        setFeeTo(_feeRecipient, _feeBps);
+    }
+
+    /**
+     * @dev Called by the owner to change the fee of the HTLCs created from now
+     * on.
+     *
+     * @param _feeRecipient Receiver of the collected fees.
+     * @param _feeBps Fee in basis points of the amount, at most MAX_FEE_BPS.
+     */
+    function setFee(address payable _feeRecipient, uint _feeBps)
+        external
+        onlyOwner
+    {
+        setFeeTo(_feeRecipient, _feeBps);
+    }
+
+    function setFeeTo(address payable _feeRecipient, uint _feeBps) internal {
+        require(_feeRecipient != address(0) || _feeBps == 0, "setFee: no fee recipient");
+        require(_feeBps <= MAX_FEE_BPS, "setFee: fee above MAX_FEE_BPS");
+        feeRecipient = _feeRecipient;
+        feeBps = _feeBps;
+        emit LogFeeChanged(_feeRecipient, _feeBps);
+    }
+
+    /**
+     * @dev Sends the accrued fees to feeRecipient, called by anyone.
+     */
+    function collectFees()
+        external
+        returns (uint amount)
+    {
+        amount = accruedFees;
+        accruedFees = 0;
+        feeRecipient.transfer(amount);
+    }
+
+    /**
+     * @dev The fee withdraw keeps from the amount of _contractId, the receiver
+     * gets the rest.
+     */
+    function feeOf(bytes32 _contractId)
+        external
+        view
+        returns (uint fee)
+    {
+        return fees[_contractId];
+    }
+
+    /**
+     * @dev HashedTimelockV2.lock, fixing the fee of the new HTLC.
+     */
+    function lock(address payable _receiver, uint _amount, bytes32 _hashlock, uint _timelock, bytes32 _salt)
+        internal
+        returns (bytes32 contractId)
+    {
+        contractId = super.lock(_receiver, _amount, _hashlock, _timelock, _salt);
+
+        uint product = _amount * feeBps;
+        require(feeBps == 0 || product / feeBps == _amount, "newContract: amount * feeBps overflows");
+        fees[contractId] = product / 10000;
+    }
+
+    /**
+     * @dev Keeps the fee of _contractId, the receiver gets the rest.
+     */
+    function withdrawAmount(bytes32 _contractId)
+        internal
+        returns (uint)
+    {
+        uint fee = fees[_contractId];
+        require(accruedFees + fee >= accruedFees, "withdraw: accrued fees overflow");
+        accruedFees += fee;
+        return contracts[_contractId].amount - fee;
+    }
+
+}
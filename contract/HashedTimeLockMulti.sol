@@ -0,0 +1,215 @@
+pragma solidity ^0.5.0;
+
+/**
+ * @title Hashed Timelock Contracts (HTLCs) with several hashlocks on Ethereum ETH.
+ *
+ * Same as HashedTimelock.sol, but a contract is locked by a set of hashlocks,
+ * one per participant of a N-party swap, and the receiver must reveal the
+ * preimages of all of them to withdraw. Every leg of a ring trade (A pays B,
+ * B pays C, C pays A) is locked by the same set, so no leg can be withdrawn
+ * before every participant has given up its secret.
+ *
+ * Protocol:
+ *
+ *  1) newContract(receiver, hashlocks, timelock) - a sender calls this to create
+ *      a new HTLC and gets back a 32 byte contract id
+ *  2) withdraw(contractId, preimages) - once the receiver knows the preimages of
+ *      all the hashlocks they can claim the ETH with this function
+ *  3) refund(contractId) - after timelock has expired and if the receiver did not
+ *      withdraw funds the sender / creator of the HTLC can get their ETH
+ *      back with this function.
+ */
+contract HashedTimelockMulti {
+
+    event LogHTLCNew(
+        bytes32 indexed contractId,
+        address indexed sender,
+        address indexed receiver,
+        uint amount,
+        bytes32[] hashlocks,
+        uint timelock
+    );
+    event LogHTLCWithdraw(bytes32 indexed contractId);
+    event LogHTLCRefund(bytes32 indexed contractId);
+
+    struct LockContract {
+        address payable sender;
+        address payable receiver;
+        uint amount;
+        bytes32[] hashlocks; // sha-2 sha256 hashes
+        uint timelock; // UNIX timestamp seconds - locked UNTIL this time
+        bool withdrawn;
+        bool refunded;
+        bytes32[] preimages;
+    }
+
+    uint constant MAX_HASHLOCKS = 16;
+
+    modifier fundsSent() {
+        require(msg.value > 0, "msg.value must be > 0");
+        _;
+    }
+    modifier futureTimelock(uint _time) {
+        require(_time > now, "timelock time must be in the future");
+        _;
+    }
+    modifier validHashlocks(uint _count) {
+        require(_count > 0 && _count <= MAX_HASHLOCKS, "hashlocks count must be 1 to 16");
+        _;
+    }
+    modifier contractExists(bytes32 _contractId) {
+        require(haveContract(_contractId), "contractId does not exist");
+        _;
+    }
+    modifier hashlocksMatch(bytes32 _contractId, bytes32[] memory _x) {
+        bytes32[] storage hashlocks = contracts[_contractId].hashlocks;
+        require(hashlocks.length == _x.length, "preimages count does not match");
+        for (uint i = 0; i < _x.length; i++) {
+            require(hashlocks[i] == sha256(abi.encodePacked(_x[i])), "hashlock hash does not match");
+        }
+        _;
+    }
+    modifier withdrawable(bytes32 _contractId) {
+        require(contracts[_contractId].receiver == msg.sender, "withdrawable: not receiver");
+        require(contracts[_contractId].withdrawn == false, "withdrawable: already withdrawn");
+        require(contracts[_contractId].timelock > now, "withdrawable: timelock time must be in the future");
+        _;
+    }
+    modifier refundable(bytes32 _contractId) {
+        require(contracts[_contractId].sender == msg.sender, "refundable: not sender");
+        require(contracts[_contractId].refunded == false, "refundable: already refunded");
+        require(contracts[_contractId].withdrawn == false, "refundable: already withdrawn");
+        require(contracts[_contractId].timelock <= now, "refundable: timelock not yet passed");
+        _;
+    }
+
+    mapping (bytes32 => LockContract) contracts;
+
+    /**
+     * @dev Sender sets up a new hash time lock contract depositing the ETH and
+     * providing the reciever lock terms.
+     *
+     * @param _receiver Receiver of the ETH.
+     * @param _hashlocks sha-2 sha256 hashlocks, one per swap participant.
+     * @param _timelock UNIX epoch seconds time that the lock expires at.
+     *                  Refunds can be made after this time.
+     * @return contractId Id of the new HTLC. This is needed for subsequent
+     *                    calls.
+     */
+    function newContract(address payable _receiver, bytes32[] calldata _hashlocks, uint _timelock)
+        external
+        payable
+        fundsSent
+        futureTimelock(_timelock)
+        validHashlocks(_hashlocks.length)
+        returns (bytes32 contractId)
+    {
+        contractId = sha256(
+            abi.encodePacked(
+                msg.sender,
+                _receiver,
+                msg.value,
+                _hashlocks,
+                _timelock
+            )
+        );
+
+        require(!haveContract(contractId), "contractId already exists");
+
+        LockContract storage c = contracts[contractId];
+        c.sender = msg.sender;
+        c.receiver = _receiver;
+        c.amount = msg.value;
+        c.hashlocks = _hashlocks;
+        c.timelock = _timelock;
+
+        emit LogHTLCNew(
+            contractId,
+            msg.sender,
+            _receiver,
+            msg.value,
+            _hashlocks,
+            _timelock
+        );
+    }
+
+    /**
+     * @dev Called by the receiver once they know the preimages of all the
+     * hashlocks. This will transfer the locked funds to their address.
+     *
+     * @param _contractId Id of the HTLC.
+     * @param _preimages sha256(_preimages[i]) should equal the contract hashlocks[i].
+     * @return bool true on success
+     */
+    function withdraw(bytes32 _contractId, bytes32[] calldata _preimages)
+        external
+        contractExists(_contractId)
+        hashlocksMatch(_contractId, _preimages)
+        withdrawable(_contractId)
+        returns (bool)
+    {
+        LockContract storage c = contracts[_contractId];
+        c.preimages = _preimages;
+        c.withdrawn = true;
+        c.receiver.transfer(c.amount);
+        emit LogHTLCWithdraw(_contractId);
+        return true;
+    }
+
+    /**
+     * @dev Called by the sender if there was no withdraw AND the time lock has
+     * expired. This will refund the contract amount.
+     *
+     * @param _contractId Id of HTLC to refund from.
+     * @return bool true on success
+     */
+    function refund(bytes32 _contractId)
+        external
+        contractExists(_contractId)
+        refundable(_contractId)
+        returns (bool)
+    {
+        LockContract storage c = contracts[_contractId];
+        c.refunded = true;
+        c.sender.transfer(c.amount);
+        emit LogHTLCRefund(_contractId);
+        return true;
+    }
+
+    /**
+     * @dev Get contract details.
+     * @param _contractId HTLC contract id
+     * @return All parameters in struct LockContract for _contractId HTLC
+     */
+    function getContract(bytes32 _contractId)
+        public
+        view
+        returns (
+            address sender,
+            address receiver,
+            uint amount,
+            bytes32[] memory hashlocks,
+            uint timelock,
+            bool withdrawn,
+            bool refunded,
+            bytes32[] memory preimages
+        )
+    {
+        LockContract storage c = contracts[_contractId];
+        return (c.sender, c.receiver, c.amount, c.hashlocks, c.timelock,
+                c.withdrawn, c.refunded, c.preimages);
+    }
+
+    /**
+     * @dev Is there a contract with id _contractId.
+     * @param _contractId Id into contracts mapping.
+     */
+    function haveContract(bytes32 _contractId)
+        internal
+        view
+        returns (bool exists)
+    {
+        exists = (contracts[_contractId].sender != address(0));
+    }
+
+}
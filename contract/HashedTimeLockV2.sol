@@ -0,0 +1,418 @@
+pragma solidity ^0.5.0;
+
+/**
+ * @title Hashed Timelock Contracts (HTLCs) on Ethereum ETH, version 2.
+ *
+ * HashedTimelock with a caller-supplied salt in the contract id, so that a
+ * sender can open any number of HTLCs with the same terms, with a revert
+ * reason on every failure, and with withdraw and refund events that carry the
+ * preimage and the amounts, so that watchers need nothing but the logs.
+ *
+ * Anyone may call withdraw with the preimage or refund after the timelock:
+ * the funds still go to the receiver or back to the sender, so an operator
+ * account can act for owners whose keys are kept offline.
+ *
+ * Protocol:
+ *
+ *  1) newContract(receiver, hashlock, timelock, salt) - a sender calls this to
+ *      create a new HTLC and gets back a 32 byte contract id
+ *  2) withdraw(contractId, preimage) - once the preimage of the hashlock hash
+ *      is known, anyone can send the ETH to the receiver with this function
+ *  3) refund(contractId) - after timelock has expired and if the receiver did not
+ *      withdraw funds, anyone can send the ETH back to the sender / creator of
+ *      the HTLC with this function.
+ *  4) cancel(contractId, v, r, s) - before the timelock, anyone can send the
+ *      ETH back to the sender with a signature of the receiver agreeing to
+ *      call off the swap.
+ *  5) extend(contractId, timelock) - the sender can push the timelock back,
+ *      never forward, to give a stalled swap more time.
+ *
+ * newContracts, withdrawMany and refundMany do 1), 2) and 3) for several
+ * HTLCs in one transaction.
+ */
+contract HashedTimelockV2 {
+
+    event LogHTLCNew(
+        bytes32 indexed contractId,
+        address indexed sender,
+        address indexed receiver,
+        uint amount,
+        bytes32 hashlock,
+        uint timelock
+    );
+    event LogHTLCWithdraw(
+        bytes32 indexed contractId,
+        address indexed receiver,
+        uint amount,
+        bytes32 preimage
+    );
+    event LogHTLCRefund(
+        bytes32 indexed contractId,
+        address indexed sender,
+        uint amount
+    );
+    event LogHTLCExtend(
+        bytes32 indexed contractId,
+        uint timelock
+    );
+
+    struct LockContract {
+        address payable sender;
+        address payable receiver;
+        uint amount;
+        bytes32 hashlock; // sha-2 sha256 hash
+        uint timelock; // UNIX timestamp seconds - locked UNTIL this time
+        bool withdrawn;
+        bool refunded;
+        bytes32 preimage;
+    }
+
+    modifier fundsSent(uint _amount) {
+        require(_amount > 0, "amount must be > 0");
+        _;
+    }
+    modifier futureTimelock(uint _time) {
+        // only requirement is the timelock time is after the last blocktime (now).
+        // probably want something a bit further in the future then this.
+        // but this is still a useful sanity check:
+        require(_time > now, "timelock time must be in the future");
+        _;
+    }
+    modifier contractExists(bytes32 _contractId) {
+        require(haveContract(_contractId), "contractId does not exist");
+        _;
+    }
+    modifier hashlockMatches(bytes32 _contractId, bytes32 _x) {
+        require(
+            contracts[_contractId].hashlock == sha256(abi.encodePacked(_x)),
+            "hashlock hash does not match"
+        );
+        _;
+    }
+    modifier withdrawable(bytes32 _contractId) {
+        require(contracts[_contractId].withdrawn == false, "withdrawable: already withdrawn");
+        // cancel refunds before the timelock, the funds are gone
+        require(contracts[_contractId].refunded == false, "withdrawable: already refunded");
+        require(contracts[_contractId].timelock > now, "withdrawable: timelock time must be in the future");
+        _;
+    }
+    modifier cancellable(bytes32 _contractId) {
+        require(contracts[_contractId].refunded == false, "cancellable: already refunded");
+        require(contracts[_contractId].withdrawn == false, "cancellable: already withdrawn");
+        _;
+    }
+    modifier extendable(bytes32 _contractId, uint _timelock) {
+        require(contracts[_contractId].sender == msg.sender, "extendable: not sender");
+        require(contracts[_contractId].withdrawn == false, "extendable: already withdrawn");
+        require(contracts[_contractId].refunded == false, "extendable: already refunded");
+        require(_timelock > contracts[_contractId].timelock, "extendable: timelock can only be extended");
+        _;
+    }
+    modifier refundable(bytes32 _contractId) {
+        require(contracts[_contractId].refunded == false, "refundable: already refunded");
+        require(contracts[_contractId].withdrawn == false, "refundable: already withdrawn");
+        require(contracts[_contractId].timelock <= now, "refundable: timelock not yet passed");
+        _;
+    }
+
+    mapping (bytes32 => LockContract) contracts;
+
+    /**
+     * @dev Sender sets up a new hash time lock contract depositing the ETH and 
+     * providing the reciever lock terms.
+     *
+     * @param _receiver Receiver of the ETH.
+     * @param _hashlock A sha-2 sha256 hash hashlock.
+     * @param _timelock UNIX epoch seconds time that the lock expires at. 
+     *                  Refunds can be made after this time.
+     * @param _salt Any value making the contract id unique among the HTLCs
+     *              of the sender with the same terms.
+     * @return contractId Id of the new HTLC. This is needed for subsequent 
+     *                    calls.
+     */
+    function newContract(address payable _receiver, bytes32 _hashlock, uint _timelock, bytes32 _salt)
+        external
+        payable
+        returns (bytes32 contractId)
+    {
+        return lock(_receiver, msg.value, _hashlock, _timelock, _salt);
+    }
+
+    /**
+     * @dev newContract for several HTLCs at once, msg.value being the sum of
+     * their amounts. Either all of them are created or none.
+     *
+     * @param _receivers Receivers of the ETH.
+     * @param _amounts Amounts of the HTLCs in wei.
+     * @param _hashlocks sha-2 sha256 hashlocks.
+     * @param _timelocks UNIX epoch seconds times that the locks expire at.
+     * @param _salts Salts of the contract ids.
+     * @return contractIds Ids of the new HTLCs, in order.
+     */
+    function newContracts(
+        address payable[] calldata _receivers,
+        uint[] calldata _amounts,
+        bytes32[] calldata _hashlocks,
+        uint[] calldata _timelocks,
+        bytes32[] calldata _salts
+    )
+        external
+        payable
+        returns (bytes32[] memory contractIds)
+    {
+        uint n = _receivers.length;
+        require(
+            _amounts.length == n && _hashlocks.length == n && _timelocks.length == n && _salts.length == n,
+            "newContracts: length mismatch"
+        );
+
+        contractIds = new bytes32[](n);
+        uint total = 0;
+        for (uint i = 0; i < n; i++) {
+            // amounts wrapping around 2^256 would lock more than msg.value
+            require(total + _amounts[i] >= total, "newContracts: the sum of the amounts overflows");
+            total += _amounts[i];
+            contractIds[i] = lock(_receivers[i], _amounts[i], _hashlocks[i], _timelocks[i], _salts[i]);
+        }
+        require(total == msg.value, "newContracts: msg.value is not the sum of the amounts");
+    }
+
+    function lock(address payable _receiver, uint _amount, bytes32 _hashlock, uint _timelock, bytes32 _salt)
+        internal
+        fundsSent(_amount)
+        futureTimelock(_timelock)
+        returns (bytes32 contractId)
+    {
+        // ecrecover returns 0 on a bad signature, anyone could cancel a
+        // contract of the zero receiver
+        require(_receiver != address(0), "newContract: receiver must not be the zero address");
+
+        contractId = sha256(
+            abi.encodePacked(
+                msg.sender,
+                _receiver,
+                _amount,
+                _hashlock,
+                _timelock,
+                _salt
+            )
+        );
+
+        // Reject if a contract already exists with the same parameters. The
+        // sender must pick another salt to create a new distinct contract.
+        require(!haveContract(contractId), "newContract: contract already exists, use another salt");
+
+        contracts[contractId] = LockContract(
+            msg.sender,
+            _receiver,
+            _amount,
+            _hashlock,
+            _timelock,
+            false,
+            false,
+            0x0
+        );
+
+        emit LogHTLCNew(
+            contractId,
+            msg.sender,
+            _receiver,
+            _amount,
+            _hashlock,
+            _timelock
+        );
+    }
+
+    /**
+     * @dev Called by anyone who knows the preimage of the hashlock.
+     * This will transfer the locked funds to the receiver.
+     *
+     * @param _contractId Id of the HTLC.
+     * @param _preimage sha256(_preimage) should equal the contract hashlock.
+     * @return bool true on success
+     */
+    function withdraw(bytes32 _contractId, bytes32 _preimage)
+        external
+        returns (bool)
+    {
+        claim(_contractId, _preimage);
+        return true;
+    }
+
+    /**
+     * @dev withdraw for several HTLCs at once. Either all of them are
+     * withdrawn or none.
+     *
+     * @param _contractIds Ids of the HTLCs.
+     * @param _preimages The preimages of their hashlocks, in order.
+     * @return bool true on success
+     */
+    function withdrawMany(bytes32[] calldata _contractIds, bytes32[] calldata _preimages)
+        external
+        returns (bool)
+    {
+        require(_contractIds.length == _preimages.length, "withdrawMany: length mismatch");
+        for (uint i = 0; i < _contractIds.length; i++) {
+            claim(_contractIds[i], _preimages[i]);
+        }
+        return true;
+    }
+
+    function claim(bytes32 _contractId, bytes32 _preimage)
+        internal
+        contractExists(_contractId)
+        hashlockMatches(_contractId, _preimage)
+        withdrawable(_contractId)
+    {
+        LockContract storage c = contracts[_contractId];
+        c.preimage = _preimage;
+        c.withdrawn = true;
+        uint amount = withdrawAmount(_contractId);
+        c.receiver.transfer(amount);
+        emit LogHTLCWithdraw(_contractId, c.receiver, amount, _preimage);
+    }
+
+    /**
+     * @dev The part of the amount of _contractId withdraw sends to the
+     * receiver: all of it, HashedTimelockFee keeps its fee.
+     */
+    function withdrawAmount(bytes32 _contractId)
+        internal
+        returns (uint)
+    {
+        return contracts[_contractId].amount;
+    }
+
+    /**
+     * @dev Called by anyone if there was no withdraw AND the time lock has
+     * expired. This will refund the contract amount to the sender.
+     *
+     * @param _contractId Id of HTLC to refund from.
+     * @return bool true on success
+     */
+    function refund(bytes32 _contractId)
+        external
+        returns (bool)
+    {
+        reclaim(_contractId);
+        return true;
+    }
+
+    /**
+     * @dev refund for several HTLCs at once. Either all of them are refunded
+     * or none.
+     *
+     * @param _contractIds Ids of the HTLCs to refund from.
+     * @return bool true on success
+     */
+    function refundMany(bytes32[] calldata _contractIds)
+        external
+        returns (bool)
+    {
+        for (uint i = 0; i < _contractIds.length; i++) {
+            reclaim(_contractIds[i]);
+        }
+        return true;
+    }
+
+    function reclaim(bytes32 _contractId)
+        internal
+        contractExists(_contractId)
+        refundable(_contractId)
+    {
+        LockContract storage c = contracts[_contractId];
+        c.refunded = true;
+        c.sender.transfer(c.amount);
+        emit LogHTLCRefund(_contractId, c.sender, c.amount);
+    }
+
+    /**
+     * @dev Called by anyone with the consent of the receiver, at any time
+     * before a withdraw. This will refund the contract amount to the sender.
+     * The receiver signs, as an eth_sign message, the keccak256 of this
+     * contract address, _contractId and "cancel".
+     *
+     * @param _contractId Id of HTLC to cancel.
+     * @param _v Recovery id of the receiver signature, 27 or 28.
+     * @param _r R of the receiver signature.
+     * @param _s S of the receiver signature.
+     * @return bool true on success
+     */
+    function cancel(bytes32 _contractId, uint8 _v, bytes32 _r, bytes32 _s)
+        external
+        contractExists(_contractId)
+        cancellable(_contractId)
+        returns (bool)
+    {
+        LockContract storage c = contracts[_contractId];
+        bytes32 message = keccak256(abi.encodePacked(address(this), _contractId, "cancel"));
+        bytes32 digest = keccak256(abi.encodePacked("\x19Ethereum Signed Message:\n32", message));
+        require(ecrecover(digest, _v, _r, _s) == c.receiver, "cancel: not signed by the receiver");
+
+        c.refunded = true;
+        c.sender.transfer(c.amount);
+        emit LogHTLCRefund(_contractId, c.sender, c.amount);
+        return true;
+    }
+
+    /**
+     * @dev Called by the sender to move the timelock later. It only gives the
+     * receiver more time to withdraw, so it needs no receiver signature. The
+     * contract id keeps the timelock it was created with.
+     *
+     * @param _contractId Id of HTLC to extend.
+     * @param _timelock The new UNIX epoch seconds time, after the current one.
+     * @return bool true on success
+     */
+    function extend(bytes32 _contractId, uint _timelock)
+        external
+        contractExists(_contractId)
+        futureTimelock(_timelock)
+        extendable(_contractId, _timelock)
+        returns (bool)
+    {
+        contracts[_contractId].timelock = _timelock;
+        emit LogHTLCExtend(_contractId, _timelock);
+        return true;
+    }
+
+    /**
+     * @dev Get contract details.
+     * @param _contractId HTLC contract id
+     * @return All parameters in struct LockContract for _contractId HTLC
+     */
+    function getContract(bytes32 _contractId)
+        public
+        view
+        returns (
+            address sender,
+            address receiver,
+            uint amount,
+            bytes32 hashlock,
+            uint timelock,
+            bool withdrawn,
+            bool refunded,
+            bytes32 preimage
+        )
+    {
+        if (haveContract(_contractId) == false)
+            return (address(0), address(0), 0, 0, 0, false, false, 0);
+        LockContract storage c = contracts[_contractId];
+        return (c.sender, c.receiver, c.amount, c.hashlock, c.timelock,
+                c.withdrawn, c.refunded, c.preimage);
+    }
+
+    /**
+     * @dev Is there a contract with id _contractId.
+     * @param _contractId Id into contracts mapping.
+     */
+    function haveContract(bytes32 _contractId)
+        internal
+        view
+        returns (bool exists)
+    {
+        exists = (contracts[_contractId].sender != address(0));
+    }
+
+}
+
@@ -0,0 +1,48 @@
+pragma solidity ^0.5.0;
+
+interface IERC1155Receiver {
+    function onERC1155Received(address operator, address from, uint256 id, uint256 value, bytes calldata data) external returns (bytes4);
+}
+
+/**
+ * @title A minimal ERC1155 token for the tests of HashedTimelockERC1155.
+ *
+ * Anyone can mint. It has only what the HTLC and aswap call.
+ */
+contract MockERC1155 {
+
+    // id => owner => balance
+    mapping (uint256 => mapping (address => uint256)) balances;
+    mapping (address => mapping (address => bool)) public isApprovedForAll;
+
+    function mint(address _to, uint256 _id, uint256 _value) external {
+        require(balances[_id][_to] + _value >= _value, "mint: balance overflows");
+        balances[_id][_to] += _value;
+    }
+
+    function balanceOf(address _owner, uint256 _id) external view returns (uint256) {
+        return balances[_id][_owner];
+    }
+
+    function setApprovalForAll(address _operator, bool _approved) external {
+        isApprovedForAll[msg.sender][_operator] = _approved;
+    }
+
+    function safeTransferFrom(address _from, address _to, uint256 _id, uint256 _value, bytes calldata _data) external {
+        require(msg.sender == _from || isApprovedForAll[_from][msg.sender], "transfer: not approved");
+        require(balances[_id][_from] >= _value, "transfer: balance too low");
+
+        balances[_id][_from] -= _value;
+        balances[_id][_to] += _value;
+
+        uint size;
+        assembly { size := extcodesize(_to) }
+        if (size > 0) {
+            require(
+                IERC1155Receiver(_to).onERC1155Received(msg.sender, _from, _id, _value, _data) == 0xf23a6e61,
+                "transfer: refused by the receiver"
+            );
+        }
+    }
+
+}
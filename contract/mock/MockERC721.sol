@@ -0,0 +1,52 @@
+pragma solidity ^0.5.0;
+
+interface IERC721Receiver {
+    function onERC721Received(address operator, address from, uint256 tokenId, bytes calldata data) external returns (bytes4);
+}
+
+/**
+ * @title A minimal ERC721 token for the tests of HashedTimelockERC721.
+ *
+ * Anyone can mint. It has only what the HTLC and aswap call.
+ */
+contract MockERC721 {
+
+    mapping (uint256 => address) public ownerOf;
+    mapping (uint256 => address) public getApproved;
+    mapping (address => mapping (address => bool)) public isApprovedForAll;
+
+    function mint(address _to, uint256 _tokenId) external {
+        require(ownerOf[_tokenId] == address(0), "mint: token exists");
+        ownerOf[_tokenId] = _to;
+    }
+
+    function approve(address _to, uint256 _tokenId) external {
+        require(ownerOf[_tokenId] == msg.sender, "approve: not the owner");
+        getApproved[_tokenId] = _to;
+    }
+
+    function setApprovalForAll(address _operator, bool _approved) external {
+        isApprovedForAll[msg.sender][_operator] = _approved;
+    }
+
+    function safeTransferFrom(address _from, address _to, uint256 _tokenId) external {
+        require(ownerOf[_tokenId] == _from, "transfer: not the owner");
+        require(
+            msg.sender == _from || getApproved[_tokenId] == msg.sender || isApprovedForAll[_from][msg.sender],
+            "transfer: not approved"
+        );
+
+        ownerOf[_tokenId] = _to;
+        getApproved[_tokenId] = address(0);
+
+        uint size;
+        assembly { size := extcodesize(_to) }
+        if (size > 0) {
+            require(
+                IERC721Receiver(_to).onERC721Received(msg.sender, _from, _tokenId, "") == 0x150b7a02,
+                "transfer: refused by the receiver"
+            );
+        }
+    }
+
+}
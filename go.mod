@@ -7,4 +7,5 @@ require (
 	github.com/pkg/errors v0.8.1
 	github.com/smartystreets/goconvey v1.6.4
 	github.com/spf13/cobra v0.0.5
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 )
@@ -0,0 +1,296 @@
+// Copyright 2019 icodezjb
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package lightning
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icodezjb/atomicswap/btc"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+// BOLT11 defaults for the fields an invoice may omit
+const (
+	DefaultExpiry             = time.Hour
+	DefaultMinFinalCLTVExpiry = 18
+)
+
+// BlockInterval is the bitcoin block time used to turn cltv deltas into time.
+const BlockInterval = 10 * time.Minute
+
+// tagged field types
+const (
+	fieldPaymentHash        = 1
+	fieldDescription        = 13
+	fieldExpiry             = 6
+	fieldMinFinalCLTVExpiry = 24
+	fieldPayee              = 19
+	fieldPaymentSecret      = 16
+	fieldDescriptionHash    = 23
+)
+
+const (
+	timestampLen = 7   //35 bits
+	signatureLen = 104 //520 bits
+)
+
+// msat per unit of the hrp amount multipliers
+var multipliers = map[byte]int64{
+	'm': 100000000,
+	'u': 100000,
+	'n': 100,
+}
+
+// Invoice is the part of a BOLT11 payment request a swap depends on.
+type Invoice struct {
+	//bc, tb, bcrt or sb
+	Currency string
+	//0 if the invoice does not ask for a specific amount
+	MilliSat        int64
+	Timestamp       time.Time
+	PaymentHash     [32]byte
+	PaymentSecret   []byte
+	Description     string
+	DescriptionHash []byte
+	Expiry          time.Duration
+	//in blocks
+	MinFinalCLTVExpiry uint64
+	//compressed public key of the payee node
+	Payee []byte
+}
+
+// ExpiresAt is the time after which the invoice must not be paid.
+func (i *Invoice) ExpiresAt() time.Time {
+	return i.Timestamp.Add(i.Expiry)
+}
+
+// SettleDeadline is the latest time the payee may still settle a payment of
+// the invoice: a payment started just before the expiry can be held for
+// MinFinalCLTVExpiry blocks by the last hop.
+func (i *Invoice) SettleDeadline() time.Time {
+	return i.ExpiresAt().Add(time.Duration(i.MinFinalCLTVExpiry) * BlockInterval)
+}
+
+// Decode parses a BOLT11 payment request and checks its signature. It does
+// not talk to any Lightning node.
+func Decode(s string) (*Invoice, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "lightning:"), "LIGHTNING:")
+
+	hrp, data, err := btc.Bech32Decode(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode bech32")
+	}
+
+	if len(data) < timestampLen+signatureLen {
+		return nil, errors.New("invoice too short")
+	}
+
+	invoice := &Invoice{
+		Expiry:             DefaultExpiry,
+		MinFinalCLTVExpiry: DefaultMinFinalCLTVExpiry,
+	}
+
+	if invoice.Currency, invoice.MilliSat, err = parseHRP(hrp); err != nil {
+		return nil, err
+	}
+
+	invoice.Timestamp = time.Unix(int64(parseUint(data[:timestampLen])), 0)
+
+	fields := data[timestampLen : len(data)-signatureLen]
+	if err := invoice.parseFields(fields); err != nil {
+		return nil, err
+	}
+
+	if err := invoice.verify(hrp, data); err != nil {
+		return nil, err
+	}
+
+	return invoice, nil
+}
+
+// parseHRP splits "ln" + currency + optional amount and multiplier.
+func parseHRP(hrp string) (string, int64, error) {
+	if !strings.HasPrefix(hrp, "ln") {
+		return "", 0, errors.Errorf("invalid invoice prefix %v", hrp)
+	}
+	hrp = hrp[2:]
+
+	pos := strings.IndexAny(hrp, "0123456789")
+	if pos < 0 {
+		return hrp, 0, nil
+	}
+	if pos == 0 {
+		return "", 0, errors.New("missing currency")
+	}
+
+	currency, amount := hrp[:pos], hrp[pos:]
+
+	multiplier := byte(0)
+	if last := amount[len(amount)-1]; last < '0' || last > '9' {
+		multiplier, amount = last, amount[:len(amount)-1]
+	}
+
+	value, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || value <= 0 {
+		return "", 0, errors.Errorf("invalid amount %v", hrp[pos:])
+	}
+
+	unit := int64(100000000000)
+	switch multiplier {
+	case 0:
+	case 'p':
+		//1 pico-bitcoin is a tenth of a msat
+		if value%10 != 0 {
+			return "", 0, errors.Errorf("sub-millisatoshi amount %v", hrp[pos:])
+		}
+		return currency, value / 10, nil
+	default:
+		var ok bool
+		if unit, ok = multipliers[multiplier]; !ok {
+			return "", 0, errors.Errorf("invalid amount multiplier %q", multiplier)
+		}
+	}
+
+	if value > math.MaxInt64/unit {
+		return "", 0, errors.Errorf("amount %v overflows", hrp[pos:])
+	}
+
+	return currency, value * unit, nil
+}
+
+func (i *Invoice) parseFields(fields []byte) error {
+	hasPaymentHash := false
+
+	for len(fields) > 0 {
+		if len(fields) < 3 {
+			return errors.New("truncated tagged field")
+		}
+
+		typ, size := fields[0], int(fields[1])<<5|int(fields[2])
+		if len(fields) < 3+size {
+			return errors.New("truncated tagged field")
+		}
+		value := fields[3 : 3+size]
+		fields = fields[3+size:]
+
+		switch typ {
+		case fieldPaymentHash:
+			//readers must skip a p field without the expected length
+			if size != 52 || hasPaymentHash {
+				continue
+			}
+			b, err := btc.ConvertBits(value, 5, 8, false)
+			if err != nil {
+				return errors.Wrap(err, "decode payment hash")
+			}
+			copy(i.PaymentHash[:], b)
+			hasPaymentHash = true
+		case fieldPaymentSecret:
+			if size != 52 {
+				continue
+			}
+			b, err := btc.ConvertBits(value, 5, 8, false)
+			if err != nil {
+				return errors.Wrap(err, "decode payment secret")
+			}
+			i.PaymentSecret = b
+		case fieldDescription:
+			b, err := btc.ConvertBits(value, 5, 8, false)
+			if err != nil {
+				return errors.Wrap(err, "decode description")
+			}
+			i.Description = string(b)
+		case fieldDescriptionHash:
+			if size != 52 {
+				continue
+			}
+			b, err := btc.ConvertBits(value, 5, 8, false)
+			if err != nil {
+				return errors.Wrap(err, "decode description hash")
+			}
+			i.DescriptionHash = b
+		case fieldExpiry:
+			i.Expiry = time.Duration(parseUint(value)) * time.Second
+		case fieldMinFinalCLTVExpiry:
+			i.MinFinalCLTVExpiry = parseUint(value)
+		case fieldPayee:
+			if size != 53 {
+				continue
+			}
+			b, err := btc.ConvertBits(value, 5, 8, false)
+			if err != nil {
+				return errors.Wrap(err, "decode payee")
+			}
+			i.Payee = b
+		}
+	}
+
+	if !hasPaymentHash {
+		return errors.New("invoice has no payment hash")
+	}
+
+	return nil
+}
+
+// verify checks the signature over the hrp and the data without the
+// signature, recovering the payee key if the invoice does not carry one.
+func (i *Invoice) verify(hrp string, data []byte) error {
+	signed, err := btc.ConvertBits(data[:len(data)-signatureLen], 5, 8, true)
+	if err != nil {
+		return errors.Wrap(err, "regroup signed data")
+	}
+	hash := sha256.Sum256(append([]byte(hrp), signed...))
+
+	sig, err := btc.ConvertBits(data[len(data)-signatureLen:], 5, 8, false)
+	if err != nil {
+		return errors.Wrap(err, "decode signature")
+	}
+	if sig[64] > 3 {
+		return errors.New("invalid signature recovery id")
+	}
+
+	pub, err := crypto.SigToPub(hash[:], sig)
+	if err != nil {
+		return errors.Wrap(err, "recover payee")
+	}
+	recovered := crypto.CompressPubkey(pub)
+
+	if i.Payee == nil {
+		i.Payee = recovered
+	} else if !bytes.Equal(i.Payee, recovered) {
+		return errors.New("invoice signature does not match the payee")
+	}
+
+	if !crypto.VerifySignature(recovered, hash[:], sig[:64]) {
+		return errors.New("invalid invoice signature")
+	}
+
+	return nil
+}
+
+// parseUint reads big-endian 5-bit groups.
+func parseUint(groups []byte) uint64 {
+	var v uint64
+	for _, g := range groups {
+		v = v<<5 | uint64(g)
+	}
+	return v
+}
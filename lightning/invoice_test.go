@@ -0,0 +1,95 @@
+package lightning
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// BOLT11 examples, signed by 03e7156ae33b0a208d0744199163177e909e80176e55d97a2f221ede0f934dd9ad
+const (
+	donationInvoice = "lnbc1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq8rkx3yf5tcsyz3d73gafnh3cax9rn449d9p5uxz9ezhhypd0elx87sjle52x86fux2ypatgddc6k63n7erqz25le42c4u4ecky03ylcqca784w"
+	coffeeInvoice   = "lnbc2500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpuaztrnwngzn3kdzw5hydlzf03qdgm2hdq27cqv3agm2awhz5se903vruatfhq77w3ls4evs3ch9zw97j25emudupq63nyw24cg27h2rspfj9srp"
+
+	//regtest invoice of 1000 sat, expiry 3600, min_final_cltv_expiry 40
+	regtestInvoice = "lnbcrt10u1p0qhcgqpp5430p5x56upsv04a0tddyfsnynha839afwr332hrcxxqp4l9f8x6sdqsv9ehwctsyp6x2um5xqrrsscqzpgqc85zdn92fy4yhnzcmr4v69nlhzaqsuzqapx6j2up9mr4ja443k9rew78k4qtj5qu08zglg8uc57fzu59tp7j24t98f5vjvudd80hugpcqwn4v"
+
+	testPayee = "03e7156ae33b0a208d0744199163177e909e80176e55d97a2f221ede0f934dd9ad"
+)
+
+func TestDecode(t *testing.T) {
+	Convey("invoice without amount", t, func() {
+		invoice, err := Decode(donationInvoice)
+		So(err, ShouldBeNil)
+		So(invoice.Currency, ShouldEqual, "bc")
+		So(invoice.MilliSat, ShouldEqual, 0)
+		So(invoice.Timestamp.Unix(), ShouldEqual, 1496314658)
+		So(hex.EncodeToString(invoice.PaymentHash[:]), ShouldEqual, "0001020304050607080900010203040506070809000102030405060708090102")
+		So(invoice.Description, ShouldEqual, "Please consider supporting this project")
+		So(invoice.Expiry, ShouldEqual, DefaultExpiry)
+		So(invoice.MinFinalCLTVExpiry, ShouldEqual, DefaultMinFinalCLTVExpiry)
+		So(hex.EncodeToString(invoice.Payee), ShouldEqual, testPayee)
+	})
+
+	Convey("invoice with amount and expiry", t, func() {
+		invoice, err := Decode(coffeeInvoice)
+		So(err, ShouldBeNil)
+		So(invoice.MilliSat, ShouldEqual, 250000000)
+		So(invoice.Description, ShouldEqual, "1 cup coffee")
+		So(invoice.Expiry, ShouldEqual, time.Minute)
+		So(invoice.ExpiresAt().Unix(), ShouldEqual, 1496314658+60)
+		So(hex.EncodeToString(invoice.Payee), ShouldEqual, testPayee)
+	})
+
+	Convey("regtest invoice with min_final_cltv_expiry", t, func() {
+		invoice, err := Decode("lightning:" + regtestInvoice)
+		So(err, ShouldBeNil)
+		So(invoice.Currency, ShouldEqual, "bcrt")
+		So(invoice.MilliSat, ShouldEqual, 1000000)
+		So(hex.EncodeToString(invoice.PaymentHash[:]), ShouldEqual, "ac5e1a1a9ae060c7d7af5b5a44c2649dfa7897a970e3155c7831801afca939b5")
+		So(invoice.MinFinalCLTVExpiry, ShouldEqual, 40)
+		So(invoice.SettleDeadline().Sub(invoice.ExpiresAt()), ShouldEqual, 40*BlockInterval)
+	})
+
+	Convey("invalid invoices", t, func() {
+		//bad checksum
+		_, err := Decode(coffeeInvoice[:len(coffeeInvoice)-1] + "q")
+		So(err, ShouldNotBeNil)
+
+		_, err = Decode("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseHRP(t *testing.T) {
+	Convey("amount multipliers", t, func() {
+		vectors := []struct {
+			hrp      string
+			currency string
+			msat     int64
+		}{
+			{"lnbc", "bc", 0},
+			{"lnbc1", "bc", 100000000000},
+			{"lnbc20m", "bc", 2000000000},
+			{"lntb2500u", "tb", 250000000},
+			{"lnbcrt10n", "bcrt", 1000},
+			{"lnbc10p", "bc", 1},
+			{"lnbc92233720", "bc", 9223372000000000000},
+		}
+
+		for _, v := range vectors {
+			currency, msat, err := parseHRP(v.hrp)
+			So(err, ShouldBeNil)
+			So(currency, ShouldEqual, v.currency)
+			So(msat, ShouldEqual, v.msat)
+		}
+
+		//the overflowing amounts of both paths, without and with a multiplier
+		for _, hrp := range []string{"bc10u", "ln10u", "lnbc1p", "lnbc10x", "lnbc92233721", "lnbc92233720369m"} {
+			_, _, err := parseHRP(hrp)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
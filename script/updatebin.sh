@@ -0,0 +1,36 @@
+#!/bin/sh
+# Copies the solcjs output of script/cmd.txt into contract/contract.go,
+# contract/mock/mock.go and the .abi/.bin files next to them. Run it from the repository after the solcjs
+# commands, then commit contract/.
+set -e
+cd "$(dirname "$0")/../contract"
+
+# update <variable prefix> <source file> <contract name> [go file]
+update() {
+	out="$(basename "$2" .sol)_sol_$3"
+	if [ ! -f "$out.bin" ] || [ ! -f "$out.abi" ]; then
+		echo "$out.bin or $out.abi missing, run script/cmd.txt first" >&2
+		exit 1
+	fi
+
+	bin=$(cat "$out.bin")
+	abi=$(cat "$out.abi")
+	sed -i \
+		-e "/\/\/empty until $2 is compiled/d" \
+		-e "s|^\(	$1BIN\) = .*|\1 = \"$bin\"|" \
+		-e "s|^\(	$1ABI\) = .*|\1 = \`$abi\`|" \
+		"${4:-contract.go}"
+
+	mv "$out.bin" "$(basename "$2" .sol).bin"
+	mv "$out.abi" "$(basename "$2" .sol).abi"
+}
+
+update MultiHTLC HashedTimeLockMulti.sol HashedTimelockMulti
+update HTLCV2 HashedTimeLockV2.sol HashedTimelockV2
+update HTLCFee HashedTimeLockFee.sol HashedTimelockFee
+update ERC721HTLC HashedTimeLockERC721.sol HashedTimelockERC721
+update ERC1155HTLC HashedTimeLockERC1155.sol HashedTimelockERC1155
+
+cd mock
+update ERC721 MockERC721.sol MockERC721 mock.go
+update ERC1155 MockERC1155.sol MockERC1155 mock.go